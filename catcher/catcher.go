@@ -0,0 +1,412 @@
+// Package catcher embeds the webhook catcher in other Go programs, such as test
+// harnesses. A Catcher is an http.Handler that captures every request it serves
+// and hands the captures to a Formatter, a Store and subscribers. Each Catcher
+// keeps its own state, so several can run side by side in one process.
+package catcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/0xReLogic/webhook-catcher-cli/internal/app"
+)
+
+type (
+	// Capture is a received request.
+	Capture = app.Capture
+	// Event is a capture and the status it was answered with.
+	Event = app.CaptureEvent
+	// Response is an answer to a captured request; a zero Status means 200.
+	Response = app.Response
+	// RedactOptions choose what is masked before formatting and storing.
+	RedactOptions = app.RedactOptions
+	// ScriptResult is what a script decided for a capture.
+	ScriptResult = app.ScriptResult
+	// Stats counts the requests a Catcher has served.
+	Stats = app.Stats
+	// FileStore appends captures to captures.jsonl in a directory, as the CLI's
+	// -capture-dir does.
+	FileStore = app.Store
+)
+
+// Formatter writes a capture for people to read.
+type Formatter interface {
+	Format(w io.Writer, ev Event) error
+}
+
+// FormatterFunc adapts a function to Formatter.
+type FormatterFunc func(w io.Writer, ev Event) error
+
+func (f FormatterFunc) Format(w io.Writer, ev Event) error { return f(w, ev) }
+
+// Store keeps captures.
+type Store interface {
+	Add(c *Capture) error
+}
+
+// Verifier authenticates a capture before it is answered. An error turns the
+// request away with 401; it is counted in Stats but not published.
+type Verifier interface {
+	Verify(c *Capture) error
+}
+
+// VerifierFunc adapts a function to Verifier.
+type VerifierFunc func(c *Capture) error
+
+func (f VerifierFunc) Verify(c *Capture) error { return f(c) }
+
+// Responder answers a capture. A nil Response answers 200 "ok".
+type Responder interface {
+	Respond(c *Capture) *Response
+}
+
+// ResponderFunc adapts a function to Responder.
+type ResponderFunc func(c *Capture) *Response
+
+func (f ResponderFunc) Respond(c *Capture) *Response { return f(c) }
+
+// TextFormatter writes the CLI's colored console block.
+var TextFormatter Formatter = FormatterFunc(func(w io.Writer, ev Event) error {
+	var out bytes.Buffer
+	app.FormatCapture(&out, ev, nil)
+	_, err := w.Write(out.Bytes())
+	return err
+})
+
+// JSONFormatter writes each capture as a JSON line, as the capture store does.
+var JSONFormatter Formatter = FormatterFunc(func(w io.Writer, ev Event) error {
+	return json.NewEncoder(w).Encode(ev.Capture)
+})
+
+// SignatureVerifier checks a provider's webhook signature (github, stripe,
+// slack or shopify) with secret. An empty provider is detected from the headers.
+// Spooled bodies are streamed from SpoolDir.
+func SignatureVerifier(provider, secret string) Verifier {
+	return VerifierFunc(func(c *Capture) error {
+		p := provider
+		if p == "" {
+			if p = app.DetectProvider(c.Header); p == "" {
+				return errors.New("no webhook signature headers")
+			}
+		}
+		body, err := c.OpenBody()
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		return app.VerifyWebhookReader(p, secret, c.Header, body, time.Now())
+	})
+}
+
+// OpenFileStore opens, creating it if needed, a capture directory. Close it once
+// the Catcher using it is closed.
+func OpenFileStore(dir string) (*FileStore, error) {
+	return app.OpenStore(dir)
+}
+
+// MemoryStore keeps captures in memory.
+type MemoryStore struct {
+	mu       sync.Mutex
+	captures []*Capture
+}
+
+// Add keeps c.
+func (s *MemoryStore) Add(c *Capture) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.captures = append(s.captures, c)
+	return nil
+}
+
+// Captures returns the kept captures, oldest first.
+func (s *MemoryStore) Captures() []*Capture {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Capture(nil), s.captures...)
+}
+
+// Options configure a Catcher. The zero value captures everything up to 10MB,
+// answers "ok" and prints nothing.
+type Options struct {
+	// Addr is where Start listens; the default 127.0.0.1:0 picks a free port.
+	Addr string
+	// MaxBody bounds bodies (default 10MB); bigger ones are answered 413. Bodies
+	// over SpoolThreshold (default 1MB) are written to SpoolDir when it is set.
+	MaxBody        int64
+	SpoolThreshold int64
+	SpoolDir       string
+	// Schemas are JSON Schema rules, "PATTERN=FILE[:STATUS]", as for -schema.
+	Schemas []string
+	// Scripts are Starlark scripts, "PATTERN=FILE", as for -script. They run
+	// before the Responder, which answers the requests they leave alone.
+	Scripts       []string
+	ScriptTimeout time.Duration
+	// Redact masks secrets before formatting (by default) and storing. Stored
+	// captures with spooled bodies point at redacted copies in SpoolDir/redacted.
+	Redact RedactOptions
+	// Formatter writes each capture to Output (default os.Stdout), except those a
+	// script marked quiet; nil prints nothing.
+	Formatter Formatter
+	Output    io.Writer
+	// Store keeps each capture; the Catcher does not close it.
+	Store     Store
+	Verifier  Verifier
+	Responder Responder
+	// QueueSize bounds how far each consumer may fall behind (default 1024). The
+	// formatter then drops its oldest captures; the store and subscribers hold up
+	// requests until they catch up.
+	QueueSize int
+	// Logger reports refused requests, consumer errors and script reloads; nil
+	// discards them.
+	Logger *log.Logger
+}
+
+// Catcher captures the requests it serves. Create one with New and Close it when done.
+type Catcher struct {
+	pipeline *app.Pipeline
+	bus      *app.Bus
+	stats    *Stats
+	size     int
+	logger   *log.Logger
+	addr     string
+
+	mu      sync.Mutex
+	closed  bool
+	done    chan struct{}
+	srv     *http.Server
+	ln      net.Listener
+	served  chan struct{}
+	streams []chan Event
+}
+
+// New validates o and returns a Catcher ready to serve.
+func New(o Options) (*Catcher, error) {
+	limits, err := app.BodyOptions{MaxBody: o.MaxBody, SpoolThreshold: o.SpoolThreshold}.Limits()
+	if err != nil {
+		return nil, err
+	}
+	limits.SpoolDir = o.SpoolDir
+	var schemas []app.SchemaRule
+	for _, spec := range o.Schemas {
+		rule, err := app.ParseSchemaRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, rule)
+	}
+	logger := o.Logger
+	if logger == nil {
+		logger = log.New(io.Discard, "", 0)
+	}
+	scripts, err := app.NewScriptRunner(app.ScriptOptions{Rules: o.Scripts, Timeout: o.ScriptTimeout, Logf: logger.Printf})
+	if err != nil {
+		return nil, err
+	}
+	console, store, _, err := o.Redact.Redactors()
+	if err != nil {
+		return nil, err
+	}
+	if o.QueueSize < 0 {
+		return nil, fmt.Errorf("queue size %d must be positive", o.QueueSize)
+	}
+
+	c := &Catcher{
+		bus:    app.NewBus(),
+		stats:  app.NewStats(),
+		size:   o.QueueSize,
+		logger: logger,
+		addr:   o.Addr,
+		done:   make(chan struct{}),
+	}
+	if c.size == 0 {
+		c.size = app.DefaultQueueSize
+	}
+	if c.addr == "" {
+		c.addr = "127.0.0.1:0"
+	}
+	c.pipeline = &app.Pipeline{Stats: c.stats, Body: limits, Schemas: schemas, Scripts: scripts, Publish: c.bus.Publish, Logf: c.logger.Printf}
+	if o.Verifier != nil {
+		c.pipeline.Verify = o.Verifier.Verify
+	}
+	if o.Responder != nil {
+		c.pipeline.Respond = o.Responder.Respond
+	}
+
+	if o.Store != nil {
+		c.bus.Subscribe("store", c.size, app.Block, func(ev Event, missed int) {
+			cp := store.Redact(ev.Capture)
+			if cp.BodyFile != "" && store != nil {
+				var err error
+				if cp, err = app.RedactSpooledBody(cp, store, filepath.Join(o.SpoolDir, "redacted")); err != nil {
+					c.logger.Printf("failed to store capture %s: redact spooled body: %v", ev.Capture.ID, err)
+					return
+				}
+			}
+			if err := o.Store.Add(cp); err != nil {
+				c.logger.Printf("failed to store capture %s: %v", ev.Capture.ID, err)
+			}
+		})
+	}
+	if o.Formatter != nil {
+		out := o.Output
+		if out == nil {
+			out = os.Stdout
+		}
+		c.bus.Subscribe("formatter", c.size, app.DropOldest, func(ev Event, missed int) {
+			if missed > 0 {
+				c.logger.Printf("%d capture(s) not formatted: the formatter fell behind", missed)
+			}
+			if ev.Script != nil && ev.Script.Quiet {
+				return
+			}
+			ev.Capture = console.Redact(ev.Capture)
+			if err := o.Formatter.Format(out, ev); err != nil {
+				c.logger.Printf("failed to format capture %s: %v", ev.Capture.ID, err)
+			}
+		})
+	}
+	return c, nil
+}
+
+// ServeHTTP captures r.
+func (c *Catcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.pipeline.ServeHTTP(w, r)
+}
+
+// Subscribe calls fn with every capture from now on, in order, from its own
+// goroutine. A slow fn holds up requests once QueueSize captures are waiting.
+func (c *Catcher) Subscribe(fn func(Event)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.bus.Subscribe("subscriber", c.size, app.Block, func(ev Event, _ int) { fn(ev) })
+}
+
+// Events returns a channel of every capture from now on. It is closed by Close;
+// like Subscribe, an unread channel holds up requests once QueueSize are waiting.
+func (c *Catcher) Events() <-chan Event {
+	ch := make(chan Event)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		close(ch)
+		return ch
+	}
+	c.streams = append(c.streams, ch)
+	c.bus.Subscribe("events", c.size, app.Block, func(ev Event, _ int) {
+		select {
+		case ch <- ev:
+		case <-c.done:
+		}
+	})
+	return ch
+}
+
+// Stats returns the Catcher's request counters.
+func (c *Catcher) Stats() *Stats {
+	return c.stats
+}
+
+// Start listens on Options.Addr and serves in the background until ctx is done
+// or Close is called.
+func (c *Catcher) Start(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errors.New("catcher is closed")
+	}
+	if c.srv != nil {
+		return errors.New("catcher already started")
+	}
+	ln, err := net.Listen("tcp", c.addr)
+	if err != nil {
+		return err
+	}
+	c.ln, c.served = ln, make(chan struct{})
+	c.srv = &http.Server{Handler: c, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		defer close(c.served)
+		if err := c.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			c.logger.Printf("serve %s: %v", ln.Addr(), err)
+		}
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = c.Close()
+		case <-c.done:
+		}
+	}()
+	return nil
+}
+
+// URL is the base URL Start listens on, or "" before Start.
+func (c *Catcher) URL() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ln == nil {
+		return ""
+	}
+	return "http://" + c.ln.Addr().String()
+}
+
+// closeTimeout bounds how long Close waits for in-flight requests, and then for
+// Events channels to be read.
+var closeTimeout = 5 * time.Second
+
+// Close stops the server, if started, waits for the formatter, store and
+// subscribers to catch up and closes the Events channels. It is safe to call
+// more than once.
+func (c *Catcher) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	srv, served := c.srv, c.served
+	c.mu.Unlock()
+
+	var err error
+	if srv != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+		defer cancel()
+		err = srv.Shutdown(ctx)
+		<-served
+	}
+	// Let queued captures reach consumers that are still reading, then release
+	// senders stuck on unread Events channels
+	closing := make(chan struct{})
+	go func() {
+		c.bus.Close()
+		close(closing)
+	}()
+	select {
+	case <-closing:
+	case <-time.After(closeTimeout):
+		close(c.done)
+		<-closing
+	}
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+	}
+	for _, ch := range c.streams {
+		close(ch)
+	}
+	return err
+}
@@ -0,0 +1,375 @@
+package catcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0xReLogic/webhook-catcher-cli/internal/app"
+)
+
+// syncBuffer is a bytes.Buffer safe for a formatter goroutine and the test.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// testClient does not keep connections open, so Close never waits on idle or
+// spare connections the client dialed.
+var testClient = &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+func post(t *testing.T, url, body string, header http.Header) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest("POST", url, strings.NewReader(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := testClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp
+}
+
+func TestCatcher_StartEventsStoreAndFormatter(t *testing.T) {
+	store := &MemoryStore{}
+	var out syncBuffer
+	c, err := New(Options{Store: store, Formatter: JSONFormatter, Output: &out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := c.Events()
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(c.URL(), "http://127.0.0.1:") {
+		t.Fatalf("URL() = %q", c.URL())
+	}
+
+	resp := post(t, c.URL()+"/hook", `{"n":1}`, http.Header{"Authorization": {"Bearer s3cret"}})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	select {
+	case ev := <-events:
+		if ev.Capture.Path != "/hook" || string(ev.Capture.Body) != `{"n":1}` || ev.Status != 200 {
+			t.Fatalf("unexpected event %+v", ev)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event")
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-events; ok {
+		t.Error("Close should close the Events channel")
+	}
+
+	if got := store.Captures(); len(got) != 1 || got[0].Path != "/hook" {
+		t.Fatalf("store: %v", got)
+	}
+	var printed Capture
+	if err := json.Unmarshal([]byte(out.String()), &printed); err != nil || printed.Path != "/hook" {
+		t.Fatalf("formatter output %q: %v", out.String(), err)
+	}
+	// The formatter gets redacted captures, the store the captures as received
+	if printed.Header.Get("Authorization") != app.RedactedValue || store.Captures()[0].Header.Get("Authorization") != "Bearer s3cret" {
+		t.Errorf("redaction: printed %q stored %q", printed.Header.Get("Authorization"), store.Captures()[0].Header.Get("Authorization"))
+	}
+	if c.Stats().Total() != 1 {
+		t.Errorf("Stats().Total() = %d", c.Stats().Total())
+	}
+	if err := c.Start(context.Background()); err == nil {
+		t.Error("Start after Close should fail")
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
+
+func TestCatcher_IndependentInstances(t *testing.T) {
+	const perCatcher = 25
+	var catchers []*Catcher
+	var stores []*MemoryStore
+	for i := 0; i < 2; i++ {
+		status := 201 + i
+		store := &MemoryStore{}
+		c, err := New(Options{
+			Store: store,
+			Responder: ResponderFunc(func(*Capture) *Response {
+				return &Response{Status: status}
+			}),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		catchers, stores = append(catchers, c), append(stores, store)
+	}
+
+	var wg sync.WaitGroup
+	for i, c := range catchers {
+		for n := 0; n < perCatcher; n++ {
+			wg.Add(1)
+			go func(i, n int, url string) {
+				defer wg.Done()
+				if resp := post(t, fmt.Sprintf("%s/c%d", url, i), fmt.Sprint(n), nil); resp.StatusCode != 201+i {
+					t.Errorf("catcher %d answered %d", i, resp.StatusCode)
+				}
+			}(i, n, c.URL())
+		}
+	}
+	wg.Wait()
+	for i, c := range catchers {
+		_ = c.Close()
+		captures := stores[i].Captures()
+		if len(captures) != perCatcher || c.Stats().Total() != perCatcher {
+			t.Fatalf("catcher %d kept %d captures and counted %d", i, len(captures), c.Stats().Total())
+		}
+		for _, cp := range captures {
+			if cp.Path != fmt.Sprintf("/c%d", i) {
+				t.Fatalf("catcher %d captured a request for %s", i, cp.Path)
+			}
+		}
+	}
+}
+
+func TestCatcher_Verifier(t *testing.T) {
+	c, _ := New(Options{Verifier: SignatureVerifier("", "whsec")})
+	var seen []string
+	var mu sync.Mutex
+	c.Subscribe(func(ev Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, string(ev.Capture.Body))
+	})
+
+	body := []byte(`{"action":"opened"}`)
+	signed, _ := app.SignWebhook("github", "whsec", body, time.Now())
+	forged, _ := app.SignWebhook("github", "wrong", body, time.Now())
+	for _, tc := range []struct {
+		header http.Header
+		want   int
+	}{{signed, 200}, {forged, 401}, {http.Header{}, 401}} {
+		r := httptest.NewRequest("POST", "/gh", bytes.NewReader(body))
+		for k, v := range tc.header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("headers %v: status %d, want %d", tc.header, w.Code, tc.want)
+		}
+	}
+	_ = c.Close()
+	if len(seen) != 1 || c.Stats().Rejected() != 2 {
+		t.Errorf("only the signed request should be published: %v, rejected %d", seen, c.Stats().Rejected())
+	}
+}
+
+func TestCatcher_VerifierSpooledBodies(t *testing.T) {
+	dir := t.TempDir()
+	c, _ := New(Options{SpoolThreshold: 16, SpoolDir: dir, Verifier: SignatureVerifier("github", "whsec")})
+	defer c.Close()
+
+	body := bytes.Repeat([]byte(`{"action":"x"}`), 10)
+	signed, _ := app.SignWebhook("github", "whsec", body, time.Now())
+	forged, _ := app.SignWebhook("github", "wrong", body, time.Now())
+	for _, tc := range []struct {
+		header http.Header
+		want   int
+	}{{signed, 200}, {forged, 401}} {
+		r := httptest.NewRequest("POST", "/gh", bytes.NewReader(body))
+		for k, v := range tc.header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("status %d, want %d: %s", w.Code, tc.want, w.Body.String())
+		}
+	}
+	// Only the accepted capture keeps its spooled body
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 1 {
+		t.Errorf("spool dir holds %v", files)
+	}
+}
+
+func TestCatcher_StoresRedactedSpooledBodies(t *testing.T) {
+	dir := t.TempDir()
+	store := &MemoryStore{}
+	c, _ := New(Options{SpoolThreshold: 16, SpoolDir: dir, Store: store, Redact: RedactOptions{Targets: []string{"store"}}})
+
+	body := `{"email":"a@b.io","pad":"` + strings.Repeat("x", 100) + `"}`
+	r := httptest.NewRequest("POST", "/hook", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	c.ServeHTTP(httptest.NewRecorder(), r)
+	_ = c.Close()
+
+	got := store.Captures()
+	if len(got) != 1 || filepath.Dir(got[0].BodyFile) != filepath.Join(dir, "redacted") {
+		t.Fatalf("store: %+v", got)
+	}
+	if raw, _ := os.ReadFile(got[0].BodyFile); strings.Contains(string(raw), "a@b.io") || !strings.Contains(string(raw), "[REDACTED:email]") {
+		t.Errorf("the stored body file should be redacted: %s", raw)
+	}
+}
+
+func TestCatcher_ResponderAndLimits(t *testing.T) {
+	c, err := New(Options{
+		MaxBody: 8,
+		Responder: ResponderFunc(func(cp *Capture) *Response {
+			if cp.Path != "/custom" {
+				return nil
+			}
+			return &Response{Status: 202, Header: http.Header{"X-Id": {cp.ID}}, Body: []byte("accepted")}
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest("POST", "/custom", strings.NewReader("x")))
+	if w.Code != 202 || w.Body.String() != "accepted" || w.Header().Get("X-Id") == "" {
+		t.Errorf("custom response: %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+	w = httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest("POST", "/other", strings.NewReader("x")))
+	if w.Code != 200 || w.Body.String() != "ok" {
+		t.Errorf("default response: %d %q", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest("POST", "/big", strings.NewReader("123456789")))
+	if w.Code != 413 {
+		t.Errorf("over MaxBody: %d", w.Code)
+	}
+}
+
+func TestCatcher_StartStopsWithContext(t *testing.T) {
+	c, _ := New(Options{})
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start(ctx); err == nil {
+		t.Error("a second Start should fail")
+	}
+	url := c.URL()
+	events := c.Events()
+	cancel()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("unexpected event")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("cancelling the context should close the catcher")
+	}
+	if _, err := http.Post(url, "text/plain", nil); err == nil {
+		t.Error("the listener should be closed")
+	}
+}
+
+func TestCatcher_UnreadEventsDoNotBlockClose(t *testing.T) {
+	old := closeTimeout
+	closeTimeout = 100 * time.Millisecond
+	defer func() { closeTimeout = old }()
+	c, _ := New(Options{QueueSize: 1})
+	c.Events() // never read
+	// One capture waits on the channel and one in the queue
+	for i := 0; i < 2; i++ {
+		c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+	}
+	closed := make(chan struct{})
+	go func() {
+		_ = c.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close hung on an unread Events channel")
+	}
+}
+
+func TestCatcher_Scripts(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hook.star")
+	_ = os.WriteFile(file, []byte(`
+def handle(req, state):
+    if req.path == "/scripted":
+        return {"status": 418, "tags": "teapot"}
+    if req.path == "/quiet":
+        return {"quiet": True}
+`), 0600)
+	var out syncBuffer
+	c, err := New(Options{
+		Scripts:   []string{"/*=" + file},
+		Formatter: FormatterFunc(func(w io.Writer, ev Event) error { _, err := fmt.Fprintln(w, ev.Capture.Path, ev.Status); return err }),
+		Output:    &out,
+		Responder: ResponderFunc(func(*Capture) *Response { return &Response{Status: 201} }),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]int{"/scripted": 418, "/quiet": 201, "/other": 201} {
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+		if w.Code != want {
+			t.Errorf("%s: status %d, want %d", path, w.Code, want)
+		}
+	}
+	_ = c.Close()
+	if got := out.String(); !strings.Contains(got, "/scripted 418") || !strings.Contains(got, "/other 201") || strings.Contains(got, "/quiet") {
+		t.Errorf("formatter output:\n%s", got)
+	}
+}
+
+func TestNew_Errors(t *testing.T) {
+	for _, o := range []Options{
+		{MaxBody: -1},
+		{Schemas: []string{"no-file"}},
+		{Redact: RedactOptions{Targets: []string{"everywhere"}}},
+		{QueueSize: -1},
+		{Scripts: []string{"/*=no-file.star"}},
+	} {
+		if _, err := New(o); err == nil {
+			t.Errorf("expected error for %+v", o)
+		}
+	}
+}
+
+func TestTextFormatter(t *testing.T) {
+	var out bytes.Buffer
+	ev := Event{Capture: &Capture{ID: "abc", Method: "POST", Path: "/t", Proto: "HTTP/1.1", Body: []byte(`{"a":1}`)}, Status: 200}
+	if err := TextFormatter.Format(&out, ev); err != nil || !strings.Contains(out.String(), "#abc") || !strings.Contains(out.String(), `"a": 1`) {
+		t.Errorf("TextFormatter wrote %q (%v)", out.String(), err)
+	}
+}
@@ -1,19 +1,22 @@
 package app
 
 import (
-	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"golang.ngrok.com/ngrok"
@@ -33,9 +36,18 @@ const (
 
 var printMu sync.Mutex
 
-// Indirections for easier testing (do not change runtime behavior)
-var HTTPListenAndServe = http.ListenAndServe
-var HTTPServe = http.Serve
+// Per-session state shared with WebhookHandler. Run resets it on start.
+var (
+	sessionStats = NewStats()
+	captureStore *Store
+	captureBus   *Bus
+	// Redactors applied before printing and storing; nil leaves captures as received.
+	consoleRedactor, storeRedactor *Redactor
+)
+
+// Indirections for easier testing. The defaults track servers so Run can shut them down.
+var HTTPListenAndServe = listenAndServe
+var HTTPServe = serve
 
 type ListenerWithURL interface {
 	net.Listener
@@ -46,20 +58,25 @@ var NgrokListen = func(ctx context.Context, epOpts []config.HTTPEndpointOption,
 	return ngrok.Listen(ctx, config.HTTPEndpoint(epOpts...), connectOpts...)
 }
 
-// serveLocalFunc starts a local HTTP server. Overridable in tests.
+// serveLocalFunc starts a local HTTP server on a TCP or unix:// address. Overridable in tests.
 var ServeLocalFunc = func(addr string, mux http.Handler) error {
-	return HTTPListenAndServe(addr, mux)
+	return serveAddr(addr, mux)
 }
 
-// serveNgrokFunc starts an ngrok listener and serves HTTP on it. Overridable in tests.
-var ServeNgrokFunc = func(ctx context.Context, epOpts []config.HTTPEndpointOption, connectOpts []ngrok.ConnectOption, mux http.Handler) error {
-	ln, err := NgrokListen(ctx, epOpts, connectOpts)
+// ServeLocalTLSFunc starts a local HTTPS server with the given TLS config. Overridable in tests.
+var ServeLocalTLSFunc = func(addr string, mux http.Handler, tlsCfg *tls.Config) error {
+	ln, err := Listen(addr)
 	if err != nil {
 		return err
 	}
-	log.Printf("%s[INFO]%s Public URL: %s", colorGreen, colorReset, ln.URL())
-	log.Printf("%s[INFO]%s All incoming requests will be printed below. Press Ctrl+C to stop.", colorGreen, colorReset)
-	return HTTPServe(ln, mux)
+	srv := &http.Server{Handler: mux, TLSConfig: tlsCfg}
+	activeServers.add(srv)
+	return srv.ServeTLS(ln, "", "")
+}
+
+// serveNgrokFunc starts an ngrok listener and serves HTTP on it. Overridable in tests.
+var ServeNgrokFunc = func(ctx context.Context, epOpts []config.HTTPEndpointOption, connectOpts []ngrok.ConnectOption, mux http.Handler) error {
+	return ServeTunnelFunc(ctx, NgrokProvider{EndpointOptions: epOpts, ConnectOptions: connectOpts}, mux)
 }
 
 // Options contains runtime options for Run.
@@ -70,6 +87,54 @@ type Options struct {
 	NgrokToken  string
 	NgrokRegion string
 	NgrokDomain string
+	// NgrokSecurity configures the protections ngrok enforces on the public endpoint.
+	NgrokSecurity EndpointSecurity
+
+	// TunnelProvider is "ngrok" (default) or "relay". Setting RelayAddr selects the relay.
+	TunnelProvider string
+	// RelayAddr is the control address of a `webhook-catcher relay` (host:port, tcp://host:port or tls://host:port).
+	RelayAddr  string
+	RelayToken string
+	// OnURLChange is a shell command run with the new public URL whenever it changes.
+	OnURLChange string
+	// Register points provider webhooks at the tunnel URL and undoes it on shutdown.
+	Register RegisterOptions
+
+	// TLS for the local listener. TLS is enabled when TLSCert and TLSKey are set.
+	TLSCert       string
+	TLSKey        string
+	TLSClientCA   string
+	TLSClientAuth string
+
+	// Extra protocols for the local listener: HTTP/2 cleartext, and HTTP/3 over QUIC (requires TLS).
+	H2C   bool
+	HTTP3 bool
+
+	// Listen holds extra listen addresses (tcp://host:port, unix:///path.sock, [::1]:port).
+	// When set it replaces Host and Port. SocketMode applies to Unix sockets without ?mode=.
+	Listen     []string
+	SocketMode os.FileMode
+
+	// DrainTimeout bounds graceful shutdown on SIGINT/SIGTERM (default DefaultDrainTimeout).
+	DrainTimeout time.Duration
+	// CaptureDir, when set, stores every capture as a JSON line in CaptureDir/captures.jsonl.
+	CaptureDir string
+	// Schemas are JSON Schema rules, "PATTERN=FILE[:STATUS]"; see ParseSchemaRule.
+	Schemas []string
+	// Redact masks secrets and personal data on the console (by default) and in the store.
+	Redact RedactOptions
+	// Guard requires credentials or an allowed address before a request is captured.
+	Guard GuardOptions
+	// RateLimit turns away requests over per-IP and global rates.
+	RateLimit RateLimitOptions
+	// Body limits request bodies and spools large ones to disk.
+	Body BodyOptions
+	// Script answers requests with sandboxed Starlark scripts.
+	Script ScriptOptions
+	// Exec runs local commands for captures and can answer requests with their output.
+	Exec ExecOptions
+	// Output tunes the queues between the handler and the console and store.
+	Output OutputOptions
 }
 
 // Run contains the main logic, extracted for testability.
@@ -80,35 +145,162 @@ func Run(opts Options) error {
 	// Load .env if present to populate environment (for NGROK_AUTHTOKEN etc.)
 	LoadDotEnv(".env")
 
+	// Stop gracefully on Ctrl+C or SIGTERM
+	ctx, stop := NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Fresh session counters and optional capture store
+	sessionStats = NewStats()
+	tunnelHooks = TunnelHooks{OnURLChange: opts.OnURLChange}
+	if opts.CaptureDir != "" {
+		store, err := OpenStore(opts.CaptureDir)
+		if err != nil {
+			return fmt.Errorf("capture store error: %w", err)
+		}
+		captureStore = store
+		defer closeStore(store)
+	}
+	var err error
+	if consoleRedactor, storeRedactor, _, err = opts.Redact.Redactors(); err != nil {
+		return fmt.Errorf("redaction error: %w", err)
+	}
+	if storeRedactor != nil {
+		log.Printf("%s[INFO]%s Redacting captures before they are stored, spooled bodies included", colorGreen, colorReset)
+	}
+	if consoleRedactor == nil {
+		log.Printf("%s[WARN]%s Redaction is off: secrets and personal data will be printed as received.", colorYellow, colorReset)
+	}
+	if err := setBodyPolicy(opts); err != nil {
+		return fmt.Errorf("body limit error: %w", err)
+	}
+	defer clearBodyPolicy()
+	bus, err := newCaptureBus(opts.Output)
+	if err != nil {
+		return fmt.Errorf("output error: %w", err)
+	}
+	captureBus = bus
+	defer closeBus()
+	schemaRules = nil
+	for _, spec := range opts.Schemas {
+		rule, err := ParseSchemaRule(spec)
+		if err != nil {
+			return fmt.Errorf("schema rule error: %w", err)
+		}
+		schemaRules = append(schemaRules, rule)
+		mode := "report only"
+		if rule.Reject != 0 {
+			mode = fmt.Sprintf("reject with %d", rule.Reject)
+		}
+		log.Printf("%s[INFO]%s Validating %s against %s (%s)", colorGreen, colorReset, rule.Pattern, rule.File, mode)
+	}
+
+	guard, err := NewGuard(opts.Guard)
+	if err != nil {
+		return fmt.Errorf("guard error: %w", err)
+	}
+	if guard != nil {
+		for _, d := range guard.Describe() {
+			log.Printf("%s[INFO]%s Guard: %s", colorGreen, colorReset, d)
+		}
+	}
+
+	limiter, err := NewRateLimiter(opts.RateLimit)
+	if err != nil {
+		return fmt.Errorf("rate limit error: %w", err)
+	}
+	if limiter != nil {
+		for _, d := range limiter.Describe() {
+			log.Printf("%s[INFO]%s Rate limit: %s", colorGreen, colorReset, d)
+		}
+		limitCtx, stopLimit := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			limiter.Run(limitCtx)
+		}()
+		defer func() {
+			stopLimit()
+			<-done
+		}()
+	}
+
+	scripts, err := NewScriptRunner(opts.Script)
+	if err != nil {
+		return fmt.Errorf("script error: %w", err)
+	}
+	scriptHooks = scripts
+	if scripts != nil {
+		for _, d := range scripts.Describe() {
+			log.Printf("%s[INFO]%s Script: %s", colorGreen, colorReset, d)
+		}
+		defer func() { scriptHooks = nil }()
+	}
+
+	hooks, err := NewExecRunner(opts.Exec)
+	if err != nil {
+		return fmt.Errorf("exec hook error: %w", err)
+	}
+	execHooks = hooks
+	if hooks != nil {
+		for _, d := range hooks.Describe() {
+			log.Printf("%s[INFO]%s Exec: %s", colorGreen, colorReset, d)
+		}
+		defer func() {
+			waitExecHooks()
+			execHooks = nil
+		}()
+	}
+
 	// Handler mux
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", WebhookHandler)
+	mux.Handle("/", WithRateLimit(WithGuard(http.HandlerFunc(WebhookHandler), guard), limiter))
+
+	if len(opts.Register.Specs) > 0 {
+		if !opts.Tunnel {
+			return errors.New("webhook registration needs -tunnel: a local listener has no public URL")
+		}
+		regs, err := NewRegistrations(opts.Register)
+		if err != nil {
+			return err
+		}
+		tunnelHooks.Registrations = regs
+		defer cleanupRegistrations(regs)
+	}
 
 	if opts.Tunnel {
+		if opts.TLSCert != "" || opts.H2C || opts.HTTP3 {
+			log.Printf("%s[WARN]%s TLS, h2c and HTTP/3 options apply to the local listener only; the tunnel terminates them at its edge.", colorYellow, colorReset)
+		}
+		switch opts.TunnelProvider {
+		case "relay":
+			return runRelayTunnel(ctx, opts, mux)
+		case "":
+			if opts.RelayAddr != "" {
+				return runRelayTunnel(ctx, opts, mux)
+			}
+		case "ngrok":
+		default:
+			return fmt.Errorf("unknown tunnel provider %q (want ngrok or relay)", opts.TunnelProvider)
+		}
 		// Start ngrok tunnel
-		ctx := context.Background()
-		// Resolve authtoken (flag -> env -> prompt)
+		// Resolve authtoken (flag -> env -> credential store -> prompt)
 		token := strings.TrimSpace(opts.NgrokToken)
 		if token == "" {
 			token = strings.TrimSpace(os.Getenv("NGROK_AUTHTOKEN"))
 		}
 		if token == "" {
-			fmt.Printf("%s[INFO]%s Welcome to Webhook Catcher!\n", colorGreen, colorReset)
-			fmt.Printf("%s[INFO]%s It looks like this is your first time enabling tunneling.\n", colorGreen, colorReset)
-			fmt.Println("[PROMPT] Open https://dashboard.ngrok.com/get-started/your-authtoken")
-			fmt.Print("[PROMPT] Paste your ngrok Authtoken here: ")
-			reader := bufio.NewReader(os.Stdin)
-			line, _ := reader.ReadString('\n')
-			token = strings.TrimSpace(line)
-			if token == "" {
-				return fmt.Errorf("missing ngrok Authtoken. Get one at https://dashboard.ngrok.com/get-started/your-authtoken")
+			secret, from, err := LookupCredential(NgrokCredential)
+			if err == nil {
+				token = secret
+				log.Printf("%s[INFO]%s Using ngrok Authtoken %s from the %s.", colorGreen, colorReset, MaskSecret(token), from)
+			} else if !errors.Is(err, ErrCredentialNotFound) {
+				log.Printf("%s[WARN]%s failed to read stored ngrok Authtoken: %v", colorYellow, colorReset, err)
 			}
-			// Persist to environment for this process and future runs
-			_ = os.Setenv("NGROK_AUTHTOKEN", token)
-			if err := SaveEnvVar(".env", "NGROK_AUTHTOKEN", token); err != nil {
-				log.Printf("[WARN] failed to persist token to .env: %v", err)
-			} else {
-				log.Printf("%s[INFO]%s Saved NGROK_AUTHTOKEN to .env for future runs.", colorGreen, colorReset)
+		}
+		if token == "" {
+			var err error
+			if token, err = promptNgrokToken(); err != nil {
+				return err
 			}
 		}
 
@@ -127,85 +319,276 @@ func Run(opts Options) error {
 		if opts.NgrokDomain != "" {
 			epOpts = append(epOpts, config.WithDomain(opts.NgrokDomain))
 		}
+		security := opts.NgrokSecurity
+		if security.WebhookProvider != "" && security.WebhookSecret == "" {
+			if secret, _, err := LookupCredential(WebhookSecretName(security.WebhookProvider)); err == nil {
+				security.WebhookSecret = secret
+			}
+		}
+		secOpts, protections, err := security.EndpointOptions()
+		if err != nil {
+			return fmt.Errorf("tunnel security error: %w", err)
+		}
+		epOpts = append(epOpts, secOpts...)
 
 		log.Printf("%s[INFO]%s Webhook Catcher is running!", colorGreen, colorReset)
 		log.Printf("%s[INFO]%s Starting ngrok tunnel...", colorGreen, colorReset)
+		for _, p := range protections {
+			log.Printf("%s[INFO]%s Tunnel protection: %s", colorGreen, colorReset, p)
+		}
+		if len(protections) == 0 {
+			log.Printf("%s[WARN]%s The tunnel URL is open to anyone; see -ngrok-basic-auth, -ngrok-oauth and -ngrok-allow-cidr.", colorYellow, colorReset)
+		}
 
-		if err := ServeNgrokFunc(ctx, epOpts, connectOpts, mux); err != nil {
+		_, err = runServers(ctx, opts.DrainTimeout, func() error { return ServeNgrokFunc(ctx, epOpts, connectOpts, mux) })
+		if err != nil {
 			return fmt.Errorf("ngrok listen error: %w\n[HINT] Ensure your ngrok Authtoken is valid: https://dashboard.ngrok.com/get-started/your-authtoken", err)
 		}
+		printSummary()
 		return nil
 	}
 
-	// Local listener mode
-	addr := fmt.Sprintf("%s:%d", opts.Host, opts.Port)
+	// Local listener mode: every address shares the same mux
+	addrs, err := localAddrs(opts)
+	if err != nil {
+		return err
+	}
+	var tlsCfg *tls.Config
+	if opts.TLSCert != "" || opts.TLSKey != "" {
+		if tlsCfg, err = BuildTLSConfig(opts.TLSCert, opts.TLSKey, opts.TLSClientCA, opts.TLSClientAuth); err != nil {
+			return fmt.Errorf("tls config error: %w", err)
+		}
+	}
+	if opts.HTTP3 && tlsCfg == nil {
+		return fmt.Errorf("HTTP/3 requires TLS; set -tls-cert and -tls-key")
+	}
+	var handler http.Handler = mux
+	if opts.H2C && tlsCfg == nil {
+		handler = WithH2C(mux)
+	}
+
 	log.Printf("%s[INFO]%s Webhook Catcher is running!", colorGreen, colorReset)
-	log.Printf("%s[INFO]%s Listening on http://%s", colorGreen, colorReset, addr)
+	var servers []func() error
+	for _, addr := range addrs {
+		addr := addr
+		log.Printf("%s[INFO]%s Listening on %s", colorGreen, colorReset, ListenerURL(addr, tlsCfg != nil))
+		network, hostport, _, _ := ParseListenAddr(addr)
+		switch {
+		case tlsCfg == nil:
+			servers = append(servers, func() error { return ServeLocalFunc(addr, handler) })
+		case opts.HTTP3 && network == "tcp":
+			_, portStr, _ := net.SplitHostPort(hostport)
+			port, _ := strconv.Atoi(portStr)
+			log.Printf("%s[INFO]%s Listening for HTTP/3 (QUIC) on udp %s", colorGreen, colorReset, hostport)
+			servers = append(servers,
+				func() error { return ServeLocalTLSFunc(addr, WithAltSvc(mux, port), tlsCfg) },
+				func() error { return ServeHTTP3Func(hostport, mux, tlsCfg) },
+			)
+		default:
+			servers = append(servers, func() error { return ServeLocalTLSFunc(addr, mux, tlsCfg) })
+		}
+	}
+	if opts.H2C && tlsCfg == nil {
+		log.Printf("%s[INFO]%s Accepting HTTP/2 cleartext (h2c) alongside HTTP/1.1", colorGreen, colorReset)
+	}
 	log.Printf("%s[INFO]%s All incoming requests will be printed below. Press Ctrl+C to stop.", colorGreen, colorReset)
-	if err := ServeLocalFunc(addr, mux); err != nil {
+	if _, err := runServers(ctx, opts.DrainTimeout, func() error { return serveAll(servers...) }); err != nil {
 		return fmt.Errorf("server error: %w", err)
 	}
+	printSummary()
 	return nil
 }
 
-func WebhookHandler(w http.ResponseWriter, r *http.Request) {
-	// Always respond 200 OK quickly to not block senders
-	defer r.Body.Close()
+// runRelayTunnel serves mux through a self-hosted relay until ctx is done.
+func runRelayTunnel(ctx context.Context, opts Options, mux http.Handler) error {
+	if opts.RelayAddr == "" {
+		return errors.New("the relay tunnel needs -relay host:port")
+	}
+	token := opts.RelayToken
+	if token == "" {
+		secret, _, err := LookupCredential(RelayCredential)
+		if err != nil {
+			return fmt.Errorf("missing relay token: set -relay-token or run `auth set %s`", RelayCredential)
+		}
+		token = secret
+	}
 
-	// Read body (limit to avoid excessive memory use)
-	body, err := io.ReadAll(io.LimitReader(r.Body, 10<<20)) // 10 MB
+	log.Printf("%s[INFO]%s Webhook Catcher is running!", colorGreen, colorReset)
+	log.Printf("%s[INFO]%s Connecting to relay %s...", colorGreen, colorReset, opts.RelayAddr)
+	provider := RelayProvider{Addr: opts.RelayAddr, Token: token}
+	_, err := runServers(ctx, opts.DrainTimeout, func() error { return ServeTunnelFunc(ctx, provider, mux) })
 	if err != nil {
-		log.Printf("failed to read request body: %v", err)
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte("failed to read body"))
-		return
+		return fmt.Errorf("relay tunnel error: %w", err)
 	}
+	printSummary()
+	return nil
+}
 
-	// Respond first, then print to console
-	w.Header().Set("Content-Type", "text/plain")
-	_, _ = w.Write([]byte("ok"))
+// closeStore flushes and closes the session's capture store.
+func closeStore(store *Store) {
+	captureStore = nil
+	if err := store.Close(); err != nil {
+		log.Printf("%s[WARN]%s failed to flush captures to %s: %v", colorYellow, colorReset, store.Dir, err)
+		return
+	}
+	log.Printf("%s[INFO]%s Captures saved to %s", colorGreen, colorReset, filepath.Join(store.Dir, CaptureFile))
+}
 
-	// Build output atomically to avoid interleaving
+// printSummary prints the session counters once serving has stopped, after the
+// -on-url-change hook, the consumers and exec hooks have caught up.
+func printSummary() {
+	urlHookWG.Wait()
+	waitExecHooks()
+	closeBus()
 	printMu.Lock()
 	defer printMu.Unlock()
+	fmt.Print(sessionStats.Summary())
+}
+
+// WebhookHandler captures a request with the pipeline of the session Run set up.
+func WebhookHandler(w http.ResponseWriter, r *http.Request) {
+	sessionPipeline().ServeHTTP(w, r)
+}
+
+// sessionPipeline is the Pipeline made of the session's state.
+func sessionPipeline() *Pipeline {
+	return &Pipeline{Stats: sessionStats, Body: bodyPolicy, Schemas: schemaRules, Scripts: scriptHooks, Exec: execHooks, Publish: publishCapture}
+}
+
+// publishCapture hands a capture to the session's consumers: through the capture
+// bus while Run is serving, or directly when the handler is used on its own.
+func publishCapture(ev CaptureEvent) {
+	if bus := captureBus; bus != nil {
+		bus.Publish(ev)
+		return
+	}
+	storeCapture(ev, 0)
+	printCapture(ev, 0)
+}
 
+// storeCapture is the store's consumer.
+func storeCapture(ev CaptureEvent, missed int) {
+	store := captureStore
+	if store == nil || ev.Background != nil {
+		return
+	}
+	if missed > 0 {
+		log.Printf("%s[WARN]%s %d capture(s) were not stored: the store fell behind", colorYellow, colorReset, missed)
+	}
+	c := storeRedactor.Redact(ev.Capture)
+	if c.BodyFile != "" && storeRedactor != nil {
+		var err error
+		if c, err = RedactSpooledBody(c, storeRedactor, filepath.Join(store.Dir, SpoolDir)); err != nil {
+			log.Printf("%s[WARN]%s failed to store capture %s: redact spooled body: %v", colorYellow, colorReset, ev.Capture.ID, err)
+			return
+		}
+	}
+	if err := store.Add(c); err != nil {
+		log.Printf("%s[WARN]%s failed to store capture %s: %v", colorYellow, colorReset, ev.Capture.ID, err)
+	}
+}
+
+// printCapture is the console's consumer. The block is built first and printed
+// in one write so it never interleaves with other output. Captures a script
+// marked quiet are left out; background hook results print as EXEC blocks.
+func printCapture(ev CaptureEvent, missed int) {
 	var out bytes.Buffer
+	if missed > 0 {
+		fmt.Fprintf(&out, "\n%s[WARN]%s %d capture(s) not shown: the console fell behind\n", colorYellow, colorReset, missed)
+	}
+	switch {
+	case ev.Background != nil:
+		fmt.Fprintf(&out, "\n%s--- EXEC #%s ---%s\n", colorBold, ev.Capture.ID, colorReset)
+		writeExecResult(&out, ev.Background, consoleRedactor)
+		out.WriteString(strings.Repeat("-", 50) + "\n")
+	case ev.Script == nil || !ev.Script.Quiet:
+		FormatCapture(&out, ev, consoleRedactor)
+	}
+	if out.Len() == 0 {
+		return
+	}
+
+	// Single print to stdout
+	printMu.Lock()
+	defer printMu.Unlock()
+	fmt.Print(out.String())
+}
+
+// FormatCapture writes the console block for a capture, redacted by r (which may be nil).
+func FormatCapture(out *bytes.Buffer, ev CaptureEvent, r *Redactor) {
+	c := r.Redact(ev.Capture)
 
 	// Timestamp
-	ts := time.Now().Format("2006-01-02 15:04:05")
+	ts := c.Time.Format("2006-01-02 15:04:05")
 
-	fmt.Fprintf(&out, "\n%s--- WEBHOOK RECEIVED (%s) ---%s\n\n", colorBold, ts, colorReset)
+	tags := ""
+	if ev.Script != nil && len(ev.Script.Tags) > 0 {
+		tags = " [" + strings.Join(ev.Script.Tags, ", ") + "]"
+	}
+	fmt.Fprintf(out, "\n%s--- WEBHOOK RECEIVED (%s) #%s%s ---%s\n\n", colorBold, ts, c.ID, tags, colorReset)
 
-	// Method and path
-	mColored := ColorMethod(r.Method)
-	fmt.Fprintf(&out, "%sMethod:%s %s %s%s%s\n\n", colorCyan, colorReset, mColored, colorYellow, r.URL.Path, colorReset)
+	// Method, path and protocol version
+	mColored := ColorMethod(c.Method)
+	fmt.Fprintf(out, "%sMethod:%s %s %s%s%s %s\n\n", colorCyan, colorReset, mColored, colorYellow, c.Path, colorReset, c.Proto)
 
 	// Headers
 	out.WriteString("Headers:\n")
-	keys := make([]string, 0, len(r.Header))
-	for k := range r.Header {
+	keys := make([]string, 0, len(c.Header))
+	for k := range c.Header {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
 	for _, k := range keys {
-		fmt.Fprintf(&out, "  %s%s%s: %s\n", colorBlue, k, colorReset, strings.Join(r.Header[k], ", "))
+		fmt.Fprintf(out, "  %s%s%s: %s\n", colorBlue, k, colorReset, strings.Join(c.Header[k], ", "))
 	}
 	out.WriteString("\n")
 
+	// TLS session and client certificate chain
+	if c.TLS != nil {
+		writeTLSInfo(out, c.TLS)
+	}
+
 	// Body
 	out.WriteString("Body:\n")
-	if pretty, ok := TryPrettyJSON(body); ok {
-		fmt.Fprintf(&out, "%s%s%s\n", colorGreen, pretty, colorReset)
-	} else if len(body) > 0 {
-		out.WriteString(string(body) + "\n")
+	if c.BodyFile != "" {
+		writeSpooledBody(out, c, r)
+	} else if pretty, ok := TryPrettyJSON(c.Body); ok {
+		fmt.Fprintf(out, "%s%s%s\n", colorGreen, pretty, colorReset)
+	} else if len(c.Body) > 0 {
+		out.WriteString(string(c.Body) + "\n")
 	} else {
 		out.WriteString("<empty>\n")
 	}
 
+	if c.Schema != "" {
+		writeSchemaResult(out, c, ev.Status)
+	}
+	if ev.Script != nil {
+		out.WriteString("\n")
+		writeScriptResult(out, ev.Script, r)
+	}
+	if ev.Exec != nil {
+		out.WriteString("\n")
+		writeExecResult(out, ev.Exec, r)
+	}
+
 	out.WriteString(strings.Repeat("-", 50) + "\n")
+}
 
-	// Single print to stdout
-	fmt.Print(out.String())
+// writeSchemaResult prints the body's schema validation outcome.
+func writeSchemaResult(out *bytes.Buffer, c *Capture, status int) {
+	if len(c.SchemaErrors) == 0 {
+		fmt.Fprintf(out, "\n%sSchema:%s valid against %s\n", colorGreen, colorReset, c.Schema)
+		return
+	}
+	fmt.Fprintf(out, "\n%sSchema: %d violation(s) of %s", colorRed, len(c.SchemaErrors), c.Schema)
+	if status != http.StatusOK {
+		fmt.Fprintf(out, ", answered %d", status)
+	}
+	fmt.Fprintf(out, "%s\n", colorReset)
+	for _, v := range c.SchemaErrors {
+		fmt.Fprintf(out, "  %s%s%s\n", colorRed, v, colorReset)
+	}
 }
 
 func TryPrettyJSON(b []byte) (string, bool) {
@@ -238,53 +621,3 @@ func ColorMethod(m string) string {
 		return colorCyan + m + colorReset
 	}
 }
-
-func LoadDotEnv(path string) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return
-	}
-	// Normalize newlines and parse
-	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
-	for _, raw := range lines {
-		line := strings.TrimSpace(raw)
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		if eq := strings.Index(line, "="); eq > 0 {
-			key := strings.TrimSpace(line[:eq])
-			val := strings.TrimSpace(line[eq+1:])
-			val = strings.Trim(val, "\"'")
-			_ = os.Setenv(key, val)
-		}
-	}
-}
-
-// saveEnvVar upserts a KEY=VALUE line into the given .env file.
-func SaveEnvVar(path, key, value string) error {
-	var lines []string
-	if b, err := os.ReadFile(path); err == nil {
-		lines = strings.Split(strings.ReplaceAll(string(b), "\r\n", "\n"), "\n")
-		updated := false
-		for i, raw := range lines {
-			s := strings.TrimSpace(raw)
-			if s == "" || strings.HasPrefix(s, "#") {
-				continue
-			}
-			if idx := strings.Index(s, "="); idx > 0 {
-				k := strings.TrimSpace(s[:idx])
-				if k == key {
-					lines[i] = fmt.Sprintf("%s=%s", key, value)
-					updated = true
-				}
-			}
-		}
-		if !updated {
-			lines = append(lines, fmt.Sprintf("%s=%s", key, value))
-		}
-	} else {
-		lines = []string{fmt.Sprintf("%s=%s", key, value)}
-	}
-	content := strings.Join(lines, "\n")
-	return os.WriteFile(path, []byte(content), 0600)
-}
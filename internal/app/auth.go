@@ -0,0 +1,93 @@
+package app
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// authUsage is printed for `auth` without a valid action.
+const authUsage = `usage: webhook-catcher auth <set|get|remove> [-store keyring|file] [-reveal] [NAME]
+
+NAME defaults to ngrok-authtoken. Provider signing secrets can be stored under any
+name, e.g. "stripe-signing-secret".`
+
+// AuthCommand implements `auth set|get|remove`. set reads the secret from stdin
+// without echo; get prints it masked unless -reveal is given.
+func AuthCommand(args []string) error {
+	return authCommand(args, os.Stdout)
+}
+
+func authCommand(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(authUsage)
+	}
+	action := args[0]
+	fs := flag.NewFlagSet("auth "+action, flag.ContinueOnError)
+	fs.SetOutput(out)
+	storeKind := fs.String("store", "", "credential store: keyring or file (get searches both by default)")
+	reveal := fs.Bool("reveal", false, "print the secret in full")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	name := NgrokCredential
+	if fs.NArg() > 1 {
+		return errors.New(authUsage)
+	} else if fs.NArg() == 1 {
+		name = fs.Arg(0)
+	}
+
+	var store CredentialStore
+	if *storeKind != "" || action != "get" {
+		kind := *storeKind
+		if kind == "" {
+			kind = "keyring"
+		}
+		var err error
+		if store, err = OpenCredentialStore(kind); err != nil {
+			return err
+		}
+	}
+
+	switch action {
+	case "set":
+		fmt.Fprintf(out, "[PROMPT] Secret for %s: ", name)
+		secret, err := readSecret(nil)
+		if err != nil && secret == "" {
+			return fmt.Errorf("read secret: %w", err)
+		}
+		if secret == "" {
+			return errors.New("empty secret")
+		}
+		if err := store.Set(name, secret); err != nil {
+			return fmt.Errorf("save to %s: %w", store.Name(), err)
+		}
+		fmt.Fprintf(out, "Saved %s (%s) to the %s.\n", name, MaskSecret(secret), store.Name())
+	case "get":
+		var secret, from string
+		var err error
+		if store != nil {
+			secret, err = store.Get(name)
+			from = store.Name()
+		} else {
+			secret, from, err = LookupCredential(name)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if !*reveal {
+			secret = MaskSecret(secret)
+		}
+		fmt.Fprintf(out, "%s (%s): %s\n", name, from, secret)
+	case "remove":
+		if err := store.Remove(name); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		fmt.Fprintf(out, "Removed %s from the %s.\n", name, store.Name())
+	default:
+		return fmt.Errorf("unknown auth action %q\n%s", action, authUsage)
+	}
+	return nil
+}
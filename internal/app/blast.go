@@ -0,0 +1,408 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// BlastOptions configures a load run against a webhook receiver.
+type BlastOptions struct {
+	Target string
+	// Sources are cycled in order, one request each.
+	Sources     []*Replayer
+	Requests    int
+	Concurrency int
+	// Rate is the steady request rate per second; 0 sends as fast as Concurrency allows.
+	Rate float64
+	// Burst releases Burst requests at once every BurstEvery, replacing Rate.
+	Burst      int
+	BurstEvery time.Duration
+	// Jitter delays each request by a random duration up to Jitter.
+	Jitter time.Duration
+	// Duplicates is the fraction of requests that resend an earlier request
+	// unchanged, the way a provider retries.
+	Duplicates float64
+	Timeout    time.Duration
+}
+
+// BlastReport summarizes a load run.
+type BlastReport struct {
+	Sent       int
+	Duplicates int
+	Elapsed    time.Duration
+	// Status counts responses by status code; Errors counts failed requests by cause.
+	Status    map[int]int
+	Errors    map[string]int
+	Latencies []time.Duration
+}
+
+// blastRecent bounds how far back a duplicate may reach.
+const blastRecent = 256
+
+// Blast sends o.Requests replays to o.Target and reports how the receiver coped.
+// When ctx is done it stops early and reports what was sent.
+func Blast(ctx context.Context, o BlastOptions) (*BlastReport, error) {
+	if len(o.Sources) == 0 {
+		return nil, errors.New("blast needs at least one fixture or capture")
+	}
+	if o.Requests <= 0 || o.Concurrency <= 0 {
+		return nil, errors.New("requests and concurrency must be positive")
+	}
+	if o.Burst > 0 && o.BurstEvery <= 0 {
+		return nil, errors.New("a burst needs a positive burst interval")
+	}
+	// Fail on a broken fixture before sending anything
+	for _, src := range o.Sources {
+		if _, err := src.Next(time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = o.Concurrency
+	client := &http.Client{Timeout: o.Timeout, Transport: transport}
+	defer transport.CloseIdleConnections()
+
+	report := &BlastReport{Status: map[int]int{}, Errors: map[string]int{}}
+	var mu sync.Mutex
+	jobs := make(chan *Replay, o.Concurrency)
+	start := time.Now()
+
+	go func() {
+		defer close(jobs)
+		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+		var recent []*Replay
+		for i := 0; i < o.Requests; i++ {
+			if !blastWait(ctx, start, i, o) {
+				return
+			}
+			var p *Replay
+			if len(recent) > 0 && rng.Float64() < o.Duplicates {
+				p = recent[rng.Intn(len(recent))]
+				mu.Lock()
+				report.Duplicates++
+				mu.Unlock()
+			} else {
+				var err error
+				if p, err = o.Sources[i%len(o.Sources)].Next(time.Now()); err != nil {
+					mu.Lock()
+					report.Errors[err.Error()]++
+					mu.Unlock()
+					continue
+				}
+				if len(recent) == blastRecent {
+					recent = recent[1:]
+				}
+				recent = append(recent, p)
+			}
+			select {
+			case jobs <- p:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < o.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range jobs {
+				if o.Jitter > 0 {
+					time.Sleep(time.Duration(rand.Int63n(int64(o.Jitter))))
+				}
+				status, latency, err := blastOne(ctx, client, p, o.Target)
+				mu.Lock()
+				report.Sent++
+				if err != nil {
+					report.Errors[blastErrorKind(err)]++
+				} else {
+					report.Status[status]++
+					report.Latencies = append(report.Latencies, latency)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	report.Elapsed = time.Since(start)
+	sort.Slice(report.Latencies, func(i, j int) bool { return report.Latencies[i] < report.Latencies[j] })
+	return report, nil
+}
+
+// blastWait holds request i back until the rate or burst schedule allows it. It
+// reports false when ctx is done first.
+func blastWait(ctx context.Context, start time.Time, i int, o BlastOptions) bool {
+	var at time.Time
+	switch {
+	case o.Burst > 0:
+		at = start.Add(time.Duration(i/o.Burst) * o.BurstEvery)
+	case o.Rate > 0:
+		at = start.Add(time.Duration(float64(i) / o.Rate * float64(time.Second)))
+	default:
+		return ctx.Err() == nil
+	}
+	d := time.Until(at)
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func blastOne(ctx context.Context, client *http.Client, p *Replay, target string) (int, time.Duration, error) {
+	req, err := p.Request(ctx, target)
+	if err != nil {
+		return 0, 0, err
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	return resp.StatusCode, time.Since(start), nil
+}
+
+// blastErrorKind groups errors by cause rather than by connection.
+func blastErrorKind(err error) string {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return "timeout"
+	}
+	var op *net.OpError
+	if errors.As(err, &op) {
+		return op.Op + ": " + op.Err.Error()
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return "connection closed by receiver"
+	}
+	return err.Error()
+}
+
+// Percentile returns the latency below which fraction p of responses fell.
+func (r *BlastReport) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	i := int(p * float64(len(r.Latencies)))
+	if i >= len(r.Latencies) {
+		i = len(r.Latencies) - 1
+	}
+	return r.Latencies[i]
+}
+
+// blastBuckets are the latency histogram's upper bounds.
+var blastBuckets = []time.Duration{
+	5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second,
+}
+
+// Print writes the report: throughput, status codes, errors and a latency histogram.
+func (r *BlastReport) Print(w io.Writer) {
+	rate := 0.0
+	if r.Elapsed > 0 {
+		rate = float64(r.Sent) / r.Elapsed.Seconds()
+	}
+	fmt.Fprintf(w, "Sent %d requests in %s (%.1f req/s), %d duplicates\n", r.Sent, r.Elapsed.Round(time.Millisecond), rate, r.Duplicates)
+
+	if len(r.Status) > 0 {
+		fmt.Fprintln(w, "\nStatus codes:")
+		codes := make([]int, 0, len(r.Status))
+		for code := range r.Status {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			fmt.Fprintf(w, "  %d  %8d\n", code, r.Status[code])
+		}
+	}
+	if len(r.Errors) > 0 {
+		fmt.Fprintln(w, "\nErrors:")
+		kinds := make([]string, 0, len(r.Errors))
+		for kind := range r.Errors {
+			kinds = append(kinds, kind)
+		}
+		sort.Slice(kinds, func(i, j int) bool { return r.Errors[kinds[i]] > r.Errors[kinds[j]] })
+		for _, kind := range kinds {
+			fmt.Fprintf(w, "  %8d  %s\n", r.Errors[kind], kind)
+		}
+	}
+	if len(r.Latencies) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "\nLatency: p50 %s  p90 %s  p99 %s  max %s\n",
+		r.Percentile(0.5).Round(time.Microsecond*100), r.Percentile(0.9).Round(time.Microsecond*100),
+		r.Percentile(0.99).Round(time.Microsecond*100), r.Latencies[len(r.Latencies)-1].Round(time.Microsecond*100))
+	counts := make([]int, len(blastBuckets)+1)
+	for _, l := range r.Latencies {
+		i := sort.Search(len(blastBuckets), func(i int) bool { return l <= blastBuckets[i] })
+		counts[i]++
+	}
+	first, last, peak := -1, 0, 0
+	for i, n := range counts {
+		if n > 0 {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+		if n > peak {
+			peak = n
+		}
+	}
+	for i := first; i <= last; i++ {
+		label := "     > " + blastBuckets[len(blastBuckets)-1].String()
+		if i < len(blastBuckets) {
+			label = fmt.Sprintf("%8s", "<= "+blastBuckets[i].String())
+		}
+		bar := strings.Repeat("#", (counts[i]*40+peak-1)/peak)
+		fmt.Fprintf(w, "  %-10s %-40s %d\n", label, bar, counts[i])
+	}
+}
+
+// blastUsage is printed for `blast` without anything to send.
+const blastUsage = `usage: webhook-catcher blast [flags] [URL]
+
+Sends -fixture NAME and/or the captures in -captures DIR to URL (default
+http://localhost:8080/), regenerating delivery IDs and signatures per request.`
+
+// BlastCommand implements `blast`: load-test a receiver with fixtures or captures.
+func BlastCommand(args []string) error {
+	return blastCommand(args, os.Stdout)
+}
+
+func blastCommand(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("blast", flag.ContinueOnError)
+	fs.SetOutput(out)
+	var fixtureNames, dirs, ids, headers ListFlag
+	fs.Var(&fixtureNames, "fixture", "fixture to send (repeatable)")
+	fs.Var(&dirs, "fixtures", "directory of user fixtures (repeatable)")
+	captureDir := fs.String("captures", "", "capture directory whose requests are sent")
+	fs.Var(&ids, "id", "only send this capture (repeatable)")
+	secret := fs.String("secret", "", "signing secret (default: the stored <provider>-signing-secret)")
+	fs.Var(&headers, "header", `extra "Name: value" header (repeatable)`)
+	o := BlastOptions{}
+	fs.IntVar(&o.Requests, "n", 1000, "total requests")
+	fs.IntVar(&o.Concurrency, "c", 10, "concurrent requests")
+	fs.Float64Var(&o.Rate, "rate", 0, "requests per second (0 = as fast as possible)")
+	fs.IntVar(&o.Burst, "burst", 0, "send requests in bursts of this size (replaces -rate)")
+	fs.DurationVar(&o.BurstEvery, "burst-every", time.Second, "interval between bursts")
+	fs.DurationVar(&o.Jitter, "jitter", 0, "random delay up to this before each request")
+	fs.Float64Var(&o.Duplicates, "duplicates", 0, "fraction of requests resent unchanged, like provider retries (0-1)")
+	fs.DurationVar(&o.Timeout, "timeout", 30*time.Second, "per-request timeout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 || (len(fixtureNames) == 0 && *captureDir == "") {
+		return errors.New(blastUsage)
+	}
+	if o.Duplicates < 0 || o.Duplicates > 1 {
+		return errors.New("-duplicates must be between 0 and 1")
+	}
+	o.Target = DefaultSendURL
+	if fs.NArg() == 1 {
+		o.Target = fs.Arg(0)
+	}
+	extra := http.Header{}
+	for _, kv := range headers {
+		name, value, ok := strings.Cut(kv, ":")
+		if !ok {
+			return fmt.Errorf("header %q: want Name: value", kv)
+		}
+		extra.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	secrets := map[string]string{}
+	secretFor := func(provider string) string {
+		s, ok := secrets[provider]
+		if !ok {
+			s = SigningSecret(provider, *secret)
+			secrets[provider] = s
+		}
+		return s
+	}
+	if len(fixtureNames) > 0 {
+		if dir, err := DefaultFixtureDir(); err == nil {
+			if _, err := os.Stat(dir); err == nil {
+				dirs = append(ListFlag{dir}, dirs...)
+			}
+		}
+		fixtures, err := LoadFixtures(dirs...)
+		if err != nil {
+			return err
+		}
+		for _, name := range fixtureNames {
+			f, ok := fixtures[name]
+			if !ok {
+				return fmt.Errorf("unknown fixture %q; see `webhook-catcher send -list`", name)
+			}
+			o.Sources = append(o.Sources, FixtureReplayer(f, secretFor(f.Provider)))
+		}
+	}
+	if *captureDir != "" {
+		captures, err := ReadCaptures(*captureDir)
+		if err != nil {
+			return err
+		}
+		want := map[string]bool{}
+		for _, id := range ids {
+			want[id] = true
+		}
+		for _, c := range captures {
+			if len(want) == 0 || want[c.ID] {
+				r := CaptureReplayer(c, "")
+				r.Secret = secretFor(r.Provider)
+				o.Sources = append(o.Sources, r)
+			}
+		}
+		if len(o.Sources) == 0 {
+			return fmt.Errorf("no matching captures in %s", *captureDir)
+		}
+	}
+	for _, src := range o.Sources {
+		src.Header = extra
+	}
+
+	ctx, stop := NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	pace := "as fast as possible"
+	if o.Burst > 0 {
+		pace = fmt.Sprintf("in bursts of %d every %s", o.Burst, o.BurstEvery)
+	} else if o.Rate > 0 {
+		pace = fmt.Sprintf("at %g/s", o.Rate)
+	}
+	fmt.Fprintf(out, "Sending %d requests from %d sources to %s %s with %d workers...\n", o.Requests, len(o.Sources), o.Target, pace, o.Concurrency)
+	report, err := Blast(ctx, o)
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		fmt.Fprintln(out, "Interrupted.")
+	}
+	fmt.Fprintln(out)
+	report.Print(out)
+	return nil
+}
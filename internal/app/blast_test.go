@@ -0,0 +1,155 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blastReceiver counts deliveries by GitHub delivery ID and answers 500 for every
+// tenth request.
+type blastReceiver struct {
+	mu         sync.Mutex
+	n          int
+	deliveries map[string]int
+	badSig     int
+}
+
+func (b *blastReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.n++
+	b.deliveries[r.Header.Get("X-GitHub-Delivery")]++
+	if VerifyWebhook("github", "s", r.Header, body, time.Now()) != nil {
+		b.badSig++
+	}
+	if b.n%10 == 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func githubReplayer(t *testing.T) *Replayer {
+	t.Helper()
+	fixtures, err := LoadFixtures()
+	if err != nil {
+		t.Fatalf("load fixtures: %v", err)
+	}
+	return FixtureReplayer(fixtures["github/push"], "s")
+}
+
+func TestBlast_StatusAndDuplicates(t *testing.T) {
+	recv := &blastReceiver{deliveries: map[string]int{}}
+	srv := httptest.NewServer(recv)
+	defer srv.Close()
+
+	report, err := Blast(context.Background(), BlastOptions{
+		Target: srv.URL, Sources: []*Replayer{githubReplayer(t)},
+		Requests: 200, Concurrency: 8, Duplicates: 0.25, Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("blast: %v", err)
+	}
+	if report.Sent != 200 || report.Status[200] != 180 || report.Status[500] != 20 || len(report.Latencies) != 200 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if recv.badSig != 0 {
+		t.Fatalf("%d requests had a bad signature", recv.badSig)
+	}
+	if report.Duplicates == 0 || len(recv.deliveries) != 200-report.Duplicates {
+		t.Fatalf("expected %d distinct deliveries, got %d", 200-report.Duplicates, len(recv.deliveries))
+	}
+
+	var out bytes.Buffer
+	report.Print(&out)
+	for _, want := range []string{"Sent 200 requests", "duplicates", "200       180", "500        20", "Latency: p50", "<= 5ms"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected report to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestBlast_RateAndBurst(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	src := []*Replayer{githubReplayer(t)}
+
+	report, err := Blast(context.Background(), BlastOptions{Target: srv.URL, Sources: src, Requests: 11, Concurrency: 4, Rate: 100})
+	if err != nil || report.Elapsed < 100*time.Millisecond {
+		t.Fatalf("expected 11 requests at 100/s to take at least 100ms, got %v err=%v", report.Elapsed, err)
+	}
+	report, err = Blast(context.Background(), BlastOptions{Target: srv.URL, Sources: src, Requests: 9, Concurrency: 9, Burst: 3, BurstEvery: 50 * time.Millisecond})
+	if err != nil || report.Elapsed < 100*time.Millisecond || report.Sent != 9 {
+		t.Fatalf("expected three bursts 50ms apart, got %v sent=%d err=%v", report.Elapsed, report.Sent, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	report, err = Blast(ctx, BlastOptions{Target: srv.URL, Sources: src, Requests: 1000, Concurrency: 1, Rate: 20})
+	if err != nil || report.Sent == 0 || report.Sent > 5 {
+		t.Fatalf("expected cancellation to stop the run early, sent=%d err=%v", report.Sent, err)
+	}
+}
+
+func TestBlast_ErrorsAndValidation(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := srv.URL
+	srv.Close()
+	report, err := Blast(context.Background(), BlastOptions{Target: url, Sources: []*Replayer{githubReplayer(t)}, Requests: 5, Concurrency: 2})
+	if err != nil || report.Errors["dial: connect: connection refused"] != 5 {
+		t.Fatalf("expected refused connections grouped together, got %v err=%v", report.Errors, err)
+	}
+
+	for _, o := range []BlastOptions{
+		{Requests: 1, Concurrency: 1},
+		{Sources: []*Replayer{githubReplayer(t)}, Requests: 0, Concurrency: 1},
+		{Sources: []*Replayer{githubReplayer(t)}, Requests: 1, Concurrency: 1, Burst: 5},
+	} {
+		if _, err := Blast(context.Background(), o); err == nil {
+			t.Fatalf("expected validation error for %+v", o)
+		}
+	}
+}
+
+func TestBlastCommand_Captures(t *testing.T) {
+	isolateCredentials(t)
+	var mu sync.Mutex
+	paths := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths[r.URL.Path]++
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	storedCapture(t, dir, &Capture{ID: "a1", Method: "POST", Path: "/one", Header: http.Header{}, Body: []byte(`{}`)})
+	storedCapture(t, dir, &Capture{ID: "b2", Method: "POST", Path: "/two", Header: http.Header{}, Body: []byte(`{}`)})
+
+	var out bytes.Buffer
+	if err := blastCommand([]string{"-captures", dir, "-n", "6", "-c", "2", srv.URL}, &out); err != nil {
+		t.Fatalf("blast: %v", err)
+	}
+	if paths["/one"] != 3 || paths["/two"] != 3 {
+		t.Fatalf("expected captures to be cycled, got %v", paths)
+	}
+	if !strings.Contains(out.String(), "from 2 sources") || !strings.Contains(out.String(), "200         6") {
+		t.Fatalf("unexpected output: %s", out.String())
+	}
+
+	for args, want := range map[string]string{
+		"":                                   "usage:",
+		"-fixture nope":                      "unknown fixture",
+		"-captures " + dir + " -id zz":       "no matching captures",
+		"-fixture github/push -duplicates 2": "between 0 and 1",
+	} {
+		if err := blastCommand(strings.Fields(args), io.Discard); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("blast %q: expected error containing %q, got %v", args, want, err)
+		}
+	}
+}
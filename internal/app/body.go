@@ -0,0 +1,261 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultMaxBody is the largest body accepted; bigger ones are answered 413.
+	DefaultMaxBody = 10 << 20
+	// DefaultSpoolThreshold is the body size above which bodies are written to a
+	// file instead of being held in memory.
+	DefaultSpoolThreshold = 1 << 20
+)
+
+// BodyOptions bound the request bodies the catcher accepts and holds in memory.
+type BodyOptions struct {
+	// MaxBody is the largest accepted body; 0 means DefaultMaxBody.
+	MaxBody int64
+	// SpoolThreshold is the size above which bodies are spooled to a file under
+	// CaptureDir/bodies (or a temporary directory without one); 0 means
+	// DefaultSpoolThreshold.
+	SpoolThreshold int64
+}
+
+// SpoolDir is the directory inside a capture directory that holds spooled bodies.
+const SpoolDir = "bodies"
+
+// BodyLimits is how a Pipeline treats bodies: over Max they are refused, over
+// SpoolThreshold they are written to SpoolDir. Nothing is spooled without SpoolDir.
+type BodyLimits struct {
+	Max, SpoolThreshold int64
+	SpoolDir            string
+}
+
+// bodyPolicy applies to WebhookHandler; Run sets it from Options.Body. bodyTempDir
+// is the session's own spool directory, removed when the session ends.
+var (
+	bodyPolicy  = BodyLimits{Max: DefaultMaxBody}
+	bodyTempDir string
+)
+
+// Limits validates o and resolves its defaults; SpoolDir is left to the caller.
+func (o BodyOptions) Limits() (BodyLimits, error) {
+	l := BodyLimits{Max: o.MaxBody, SpoolThreshold: o.SpoolThreshold}
+	if l.Max == 0 {
+		l.Max = DefaultMaxBody
+	}
+	if l.SpoolThreshold == 0 {
+		l.SpoolThreshold = DefaultSpoolThreshold
+	}
+	if l.Max < 0 || l.SpoolThreshold < 0 {
+		return l, errors.New("body sizes must be positive")
+	}
+	return l, nil
+}
+
+// errBodyTooLarge is returned by readBody for bodies over the limit.
+var errBodyTooLarge = errors.New("body too large")
+
+// readBody reads r into c. Bodies up to the spool threshold are kept in c.Body;
+// bigger ones are streamed to a file in p.SpoolDir and described by c.BodyFile,
+// c.BodySize and c.BodySHA256. Nothing is kept when the body exceeds p.Max.
+func readBody(r io.Reader, c *Capture, p BodyLimits) error {
+	inMemory := p.Max
+	if p.SpoolDir != "" && p.SpoolThreshold < inMemory {
+		inMemory = p.SpoolThreshold
+	}
+	head, err := io.ReadAll(io.LimitReader(r, inMemory+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(head)) <= inMemory {
+		c.Body = head
+		return nil
+	}
+	if p.SpoolDir == "" {
+		return errBodyTooLarge
+	}
+
+	if err := os.MkdirAll(p.SpoolDir, 0700); err != nil {
+		return fmt.Errorf("create spool dir: %w", err)
+	}
+	path := filepath.Join(p.SpoolDir, c.ID+".body")
+	f, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("spool body: %w", err)
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), io.MultiReader(bytes.NewReader(head), io.LimitReader(r, p.Max+1-int64(len(head)))))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n > p.Max {
+		err = errBodyTooLarge
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		_ = os.Remove(path + ".tmp")
+		return err
+	}
+	c.BodyFile, c.BodySize, c.BodySHA256 = path, n, hex.EncodeToString(h.Sum(nil))
+	return nil
+}
+
+// bodyPreview is how much of each end of a spooled body is printed.
+const bodyPreview = 1024
+
+// writeSpooledBody prints a spooled body's size, digest and location followed by
+// its first and last bodyPreview bytes.
+func writeSpooledBody(out *bytes.Buffer, c *Capture, redact *Redactor) {
+	fmt.Fprintf(out, "%s%s bytes, sha256 %s%s\nSpooled to %s\n", colorCyan, groupThousands(int(c.BodySize)), c.BodySHA256, colorReset, c.BodyFile)
+	f, err := os.Open(c.BodyFile)
+	if err != nil {
+		fmt.Fprintf(out, "%s<preview unavailable: %v>%s\n", colorYellow, err, colorReset)
+		return
+	}
+	defer f.Close()
+	head := make([]byte, min(bodyPreview, c.BodySize))
+	tail := make([]byte, min(bodyPreview, c.BodySize-int64(len(head))))
+	if _, err := io.ReadFull(f, head); err == nil && len(tail) > 0 {
+		_, err = f.ReadAt(tail, c.BodySize-int64(len(tail)))
+	}
+	if err != nil {
+		fmt.Fprintf(out, "%s<preview unavailable: %v>%s\n", colorYellow, err, colorReset)
+		return
+	}
+	out.WriteString(redact.Text(previewText(head)) + "\n")
+	if skipped := c.BodySize - int64(len(head)+len(tail)); skipped > 0 {
+		fmt.Fprintf(out, "%s... %s bytes not shown ...%s\n", colorYellow, groupThousands(int(skipped)), colorReset)
+	}
+	if len(tail) > 0 {
+		out.WriteString(redact.Text(previewText(tail)) + "\n")
+	}
+}
+
+// previewText shows text as is, less runes cut at the edges, and binary data as
+// a placeholder.
+func previewText(b []byte) string {
+	s := strings.ToValidUTF8(string(b), "")
+	if len(b)-len(s) > 2*utf8.UTFMax {
+		return "<binary data>"
+	}
+	for _, r := range s {
+		if unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t' {
+			return "<binary data>"
+		}
+	}
+	return s
+}
+
+// sizeUnits are the suffixes ParseByteSize accepts, largest first.
+var sizeUnits = []struct {
+	name string
+	size int64
+}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+
+// ParseByteSize parses a size such as 512, 64KB, 10MB or 1GB. Units are binary
+// and case-insensitive; K, M, G and KiB, MiB, GiB are accepted too.
+func ParseByteSize(s string) (int64, error) {
+	t := strings.ToUpper(strings.TrimSpace(s))
+	t = strings.Replace(t, "IB", "B", 1)
+	mult := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(t, u.name) {
+			t, mult = t[:len(t)-len(u.name)], u.size
+			break
+		}
+		if short := u.name[:1]; u.size > 1 && strings.HasSuffix(t, short) {
+			t, mult = t[:len(t)-1], u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("size %q: want a number of bytes with an optional KB, MB or GB unit", s)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+// FormatByteSize formats n with the largest unit that keeps it a whole number.
+func FormatByteSize(n int64) string {
+	for _, u := range sizeUnits {
+		if n != 0 && n%u.size == 0 {
+			return strconv.FormatInt(n/u.size, 10) + u.name
+		}
+	}
+	return strconv.FormatInt(n, 10) + "B"
+}
+
+// setBodyPolicy applies opts.Body to WebhookHandler. Large bodies are spooled
+// next to the stored captures, or to a temporary directory that clearBodyPolicy
+// removes when nothing is stored or stored captures are redacted; the store then
+// gets a redacted copy (see RedactSpooledBody).
+func setBodyPolicy(opts Options) error {
+	l, err := opts.Body.Limits()
+	if err != nil {
+		return err
+	}
+	if opts.CaptureDir != "" && storeRedactor == nil {
+		l.SpoolDir = filepath.Join(opts.CaptureDir, SpoolDir)
+	} else if l.SpoolDir, err = os.MkdirTemp("", "webhook-catcher-bodies-"); err != nil {
+		return err
+	} else {
+		bodyTempDir = l.SpoolDir
+	}
+	bodyPolicy = l
+	if l.Max > DefaultMaxBody {
+		log.Printf("%s[INFO]%s Accepting bodies up to %s", colorGreen, colorReset, FormatByteSize(l.Max))
+	}
+	return nil
+}
+
+// RedactSpooledBody writes a redacted copy of c's spooled body to dir and returns
+// a copy of c pointing at it. The body is redacted in memory, so it costs up to the body
+// limit; the received file is left for the console and exec hooks.
+func RedactSpooledBody(c *Capture, r *Redactor, dir string) (*Capture, error) {
+	body, err := os.ReadFile(c.BodyFile)
+	if err != nil {
+		return nil, err
+	}
+	body = r.body(c.Header.Get("Content-Type"), body)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create spool dir: %w", err)
+	}
+	path := filepath.Join(dir, c.ID+".body")
+	if err := os.WriteFile(path+".tmp", body, 0600); err != nil {
+		_ = os.Remove(path + ".tmp")
+		return nil, err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		_ = os.Remove(path + ".tmp")
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	cp := *c
+	cp.BodyFile, cp.BodySize, cp.BodySHA256 = path, int64(len(body)), hex.EncodeToString(sum[:])
+	return &cp, nil
+}
+
+// clearBodyPolicy restores the default limits once the session's consumers are
+// done, removing a temporary spool directory.
+func clearBodyPolicy() {
+	if bodyTempDir != "" {
+		_ = os.RemoveAll(bodyTempDir)
+	}
+	bodyPolicy, bodyTempDir = BodyLimits{Max: DefaultMaxBody}, ""
+}
@@ -0,0 +1,200 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	for s, want := range map[string]int64{
+		"512": 512, "0": 0, "64KB": 64 << 10, "64k": 64 << 10, "10MB": 10 << 20, "10 MiB": 10 << 20,
+		"1.5M": 3 << 19, "2g": 2 << 30, "100B": 100,
+	} {
+		if got, err := ParseByteSize(s); err != nil || got != want {
+			t.Errorf("ParseByteSize(%q) = %d, %v; want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "MB", "ten", "-1KB", "5TB"} {
+		if _, err := ParseByteSize(s); err == nil {
+			t.Errorf("ParseByteSize(%q): expected error", s)
+		}
+	}
+	for n, want := range map[int64]string{0: "0B", 1000: "1000B", 1536: "1536B", 64 << 10: "64KB", 10 << 20: "10MB", 1 << 30: "1GB"} {
+		if got := FormatByteSize(n); got != want {
+			t.Errorf("FormatByteSize(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestReadBody(t *testing.T) {
+	dir := t.TempDir()
+	p := BodyLimits{Max: 100, SpoolThreshold: 10, SpoolDir: dir}
+
+	c := &Capture{ID: "small"}
+	if err := readBody(strings.NewReader("0123456789"), c, p); err != nil || string(c.Body) != "0123456789" || c.BodyFile != "" {
+		t.Fatalf("small body: %q %q %v", c.Body, c.BodyFile, err)
+	}
+
+	body := strings.Repeat("x", 100)
+	c = &Capture{ID: "big"}
+	if err := readBody(strings.NewReader(body), c, p); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(body))
+	if c.Body != nil || c.BodySize != 100 || c.BodySHA256 != hex.EncodeToString(sum[:]) || c.BodyFile != filepath.Join(dir, "big.body") {
+		t.Fatalf("spooled body: %+v", c)
+	}
+	if got, _ := os.ReadFile(c.BodyFile); string(got) != body {
+		t.Fatalf("spool file holds %d bytes", len(got))
+	}
+
+	c = &Capture{ID: "huge"}
+	if err := readBody(strings.NewReader(body+"!"), c, p); !errors.Is(err, errBodyTooLarge) || c.BodyFile != "" {
+		t.Fatalf("over the limit: %v %+v", err, c)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("the rejected body should leave no file behind, got %d entries", len(entries))
+	}
+
+	// Without a spool directory everything up to the limit stays in memory
+	c = &Capture{ID: "mem"}
+	if err := readBody(strings.NewReader(body), c, BodyLimits{Max: 100, SpoolThreshold: 10}); err != nil || len(c.Body) != 100 {
+		t.Fatalf("in memory: %d %v", len(c.Body), err)
+	}
+	if err := readBody(strings.NewReader(body+"!"), c, BodyLimits{Max: 100}); !errors.Is(err, errBodyTooLarge) {
+		t.Fatalf("expected errBodyTooLarge, got %v", err)
+	}
+}
+
+func TestWebhookHandler_RejectsLargeBody(t *testing.T) {
+	sessionStats = NewStats()
+	bodyPolicy = BodyLimits{Max: 16}
+	defer clearBodyPolicy()
+
+	for name, announce := range map[string]bool{"content-length": true, "chunked": false} {
+		r := httptest.NewRequest("POST", "/big", strings.NewReader(strings.Repeat("a", 17)))
+		if !announce {
+			r.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		out := captureStdout(func() {
+			log.SetOutput(os.Stdout)
+			defer log.SetOutput(os.Stderr)
+			WebhookHandler(w, r)
+		})
+		if w.Code != 413 || !strings.Contains(w.Body.String(), "request body exceeds 16B") {
+			t.Errorf("%s: status %d body %q", name, w.Code, w.Body.String())
+		}
+		if strings.Contains(out, "WEBHOOK RECEIVED") || !strings.Contains(out, "Rejected POST /big") {
+			t.Errorf("%s: unexpected output %q", name, out)
+		}
+	}
+	if s := stripANSI(sessionStats.Summary()); !strings.Contains(s, "413") {
+		t.Errorf("rejections should be counted, summary:\n%s", s)
+	}
+}
+
+func TestWebhookHandler_SpoolsLargeBody(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := OpenStore(dir)
+	captureStore = store
+	defer func() { captureStore = nil }()
+	bodyPolicy = BodyLimits{Max: 1 << 20, SpoolThreshold: 64, SpoolDir: filepath.Join(dir, SpoolDir)}
+	defer clearBodyPolicy()
+
+	body := `{"start":true,"data":"` + strings.Repeat("0123456789", 500) + `","end":true}`
+	w := httptest.NewRecorder()
+	out := stripANSI(captureStdout(func() {
+		WebhookHandler(w, httptest.NewRequest("POST", "/upload", strings.NewReader(body)))
+	}))
+	sum := sha256.Sum256([]byte(body))
+	if w.Code != 200 {
+		t.Fatalf("status %d", w.Code)
+	}
+	for _, want := range []string{
+		fmt.Sprintf("%s bytes, sha256 %x", groupThousands(len(body)), sum),
+		"Spooled to " + filepath.Join(dir, SpoolDir),
+		`{"start":true,"data":"0123`,
+		fmt.Sprintf("... %s bytes not shown ...", groupThousands(len(body)-2*bodyPreview)),
+		`789","end":true}`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+
+	_ = store.Close()
+	raw, _ := os.ReadFile(filepath.Join(dir, CaptureFile))
+	if !bytes.Contains(raw, []byte(`"body_file":"bodies/`)) {
+		t.Errorf("the stored body file should be relative to the capture dir: %s", raw)
+	}
+	captures, err := ReadCaptures(dir)
+	if err != nil || len(captures) != 1 || string(captures[0].Body) != body {
+		t.Fatalf("ReadCaptures should load the spooled body: %v", err)
+	}
+}
+
+func TestWebhookHandler_ValidatesSpooledBody(t *testing.T) {
+	schema := filepath.Join(t.TempDir(), "s.json")
+	_ = os.WriteFile(schema, []byte(`{"type":"object","required":["id"]}`), 0600)
+	rule, err := ParseSchemaRule("/*=" + schema + ":422")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemaRules = []SchemaRule{rule}
+	defer func() { schemaRules = nil }()
+	bodyPolicy = BodyLimits{Max: 1 << 20, SpoolThreshold: 8, SpoolDir: t.TempDir()}
+	defer clearBodyPolicy()
+
+	w := httptest.NewRecorder()
+	captureStdout(func() {
+		WebhookHandler(w, httptest.NewRequest("POST", "/x", strings.NewReader(`{"name":"`+strings.Repeat("n", 100)+`"}`)))
+	})
+	if w.Code != 422 || !strings.Contains(w.Body.String(), "id") {
+		t.Errorf("status %d body %q", w.Code, w.Body.String())
+	}
+}
+
+func TestPreviewText(t *testing.T) {
+	if got := previewText([]byte("héllo\n")[:2]); got != "h" {
+		t.Errorf("a rune cut at the edge should be dropped, got %q", got)
+	}
+	if got := previewText([]byte{0x89, 'P', 'N', 'G', 0, 0, 0, 13}); got != "<binary data>" {
+		t.Errorf("binary preview: %q", got)
+	}
+}
+
+func TestSetBodyPolicy(t *testing.T) {
+	defer clearBodyPolicy()
+	if err := setBodyPolicy(Options{Body: BodyOptions{MaxBody: -1}}); err == nil {
+		t.Error("expected error for a negative limit")
+	}
+	if err := setBodyPolicy(Options{}); err != nil || bodyTempDir != bodyPolicy.SpoolDir || bodyPolicy.Max != DefaultMaxBody {
+		t.Fatalf("%+v %v", bodyPolicy, err)
+	}
+	tmp := bodyTempDir
+	clearBodyPolicy()
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("temporary spool dir should be removed: %v", err)
+	}
+	dir := t.TempDir()
+	if err := setBodyPolicy(Options{CaptureDir: dir}); err != nil || bodyTempDir != "" || bodyPolicy.SpoolDir != filepath.Join(dir, SpoolDir) {
+		t.Fatalf("%+v %v", bodyPolicy, err)
+	}
+	// Received bodies stay out of the capture dir when stored captures are redacted
+	clearBodyPolicy()
+	storeRedactor = mustRedactor(t, RedactOptions{})
+	defer func() { storeRedactor = nil }()
+	if err := setBodyPolicy(Options{CaptureDir: dir}); err != nil || bodyTempDir == "" || bodyPolicy.SpoolDir != bodyTempDir {
+		t.Fatalf("%+v %v", bodyPolicy, err)
+	}
+}
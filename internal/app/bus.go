@@ -0,0 +1,227 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// BackPressure decides what a subscriber's full queue does with a new event.
+type BackPressure int
+
+const (
+	// Block makes the publisher wait for room; nothing is lost but a slow consumer
+	// slows every sender.
+	Block BackPressure = iota
+	// DropOldest discards the oldest queued event to make room.
+	DropOldest
+	// DropNewest discards the new event.
+	DropNewest
+)
+
+var backPressureNames = map[BackPressure]string{Block: "block", DropOldest: "drop-oldest", DropNewest: "drop-newest"}
+
+func (p BackPressure) String() string { return backPressureNames[p] }
+
+// ParseBackPressure parses block, drop-oldest or drop-newest.
+func ParseBackPressure(s string) (BackPressure, error) {
+	for p, name := range backPressureNames {
+		if s == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown back-pressure policy %q (want block, drop-oldest or drop-newest)", s)
+}
+
+// CaptureEvent is published once a request has been captured and answered, and
+// again for each background hook that runs for it.
+type CaptureEvent struct {
+	Capture *Capture
+	// Status is the status the sender was answered with.
+	Status int
+	// Script is the script that ran for the capture, if any.
+	Script *ScriptResult
+	// Exec is the hook that produced the response, with -exec-respond.
+	Exec *ExecResult
+	// Background is set on the events reporting a background hook's result. They
+	// follow the capture's own event and carry the same Capture.
+	Background *ExecResult
+}
+
+// Bus fans capture events out to subscribers. Each subscriber has its own bounded
+// queue and goroutine, so a slow one only affects others if it uses Block.
+// Every subscriber sees events in publish order.
+type Bus struct {
+	mu     sync.Mutex
+	subs   []*Subscription
+	closed bool
+}
+
+// NewBus returns a bus without subscribers.
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscription is one consumer of a Bus.
+type Subscription struct {
+	Name   string
+	Policy BackPressure
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []CaptureEvent
+	size    int
+	missed  int // dropped since the last delivery
+	dropped int
+	closed  bool
+	done    chan struct{}
+}
+
+// Subscribe starts delivering events to fn from a queue of size events. missed is
+// how many events this subscriber dropped since the previous call.
+func (b *Bus) Subscribe(name string, size int, policy BackPressure, fn func(ev CaptureEvent, missed int)) *Subscription {
+	if size < 1 {
+		size = 1
+	}
+	s := &Subscription{Name: name, Policy: policy, size: size, done: make(chan struct{})}
+	s.cond = sync.NewCond(&s.mu)
+	b.mu.Lock()
+	b.subs = append(b.subs, s)
+	b.mu.Unlock()
+	go s.run(fn)
+	return s
+}
+
+func (s *Subscription) run(fn func(CaptureEvent, int)) {
+	defer close(s.done)
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if len(s.queue) == 0 {
+			s.mu.Unlock()
+			return
+		}
+		ev, missed := s.queue[0], s.missed
+		s.queue[0] = CaptureEvent{}
+		s.queue = s.queue[1:]
+		s.missed = 0
+		s.cond.Broadcast() // room for a blocked publisher
+		s.mu.Unlock()
+		fn(ev, missed)
+	}
+}
+
+func (s *Subscription) push(ev CaptureEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.queue) >= s.size && s.Policy == Block && !s.closed {
+		s.cond.Wait()
+	}
+	if s.closed {
+		return
+	}
+	if len(s.queue) >= s.size {
+		s.dropped++
+		s.missed++
+		if s.Policy == DropNewest {
+			return
+		}
+		s.queue[0] = CaptureEvent{}
+		s.queue = s.queue[1:]
+	}
+	s.queue = append(s.queue, ev)
+	s.cond.Broadcast()
+}
+
+// Dropped returns how many events the subscriber has discarded.
+func (s *Subscription) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// Publish queues ev for every subscriber. Publishes are serialised so all
+// subscribers see the same order; a full Block subscriber holds up the publisher.
+func (b *Bus) Publish(ev CaptureEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	for _, s := range b.subs {
+		s.push(ev)
+	}
+}
+
+// Close stops accepting events, lets every subscriber drain its queue and waits
+// for them to finish. It returns the subscriptions so callers can report drops.
+func (b *Bus) Close() []*Subscription {
+	b.mu.Lock()
+	b.closed = true
+	subs := b.subs
+	b.mu.Unlock()
+	for _, s := range subs {
+		s.mu.Lock()
+		s.closed = true
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	}
+	for _, s := range subs {
+		<-s.done
+	}
+	return subs
+}
+
+// DefaultQueueSize is how many captures each consumer may fall behind by.
+const DefaultQueueSize = 1024
+
+// OutputOptions tune the queues between WebhookHandler and its consumers.
+type OutputOptions struct {
+	// QueueSize bounds each consumer's queue; 0 means DefaultQueueSize.
+	QueueSize int
+	// ConsolePolicy and StorePolicy are back-pressure policies. By default the
+	// console drops its oldest queued capture and the store blocks.
+	ConsolePolicy string
+	StorePolicy   string
+}
+
+// newCaptureBus subscribes the console and, when one is open, the capture store.
+func newCaptureBus(o OutputOptions) (*Bus, error) {
+	size := o.QueueSize
+	if size == 0 {
+		size = DefaultQueueSize
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("queue size %d must be positive", size)
+	}
+	console, err := ParseBackPressure(orDefault(o.ConsolePolicy, "drop-oldest"))
+	if err != nil {
+		return nil, err
+	}
+	store, err := ParseBackPressure(orDefault(o.StorePolicy, "block"))
+	if err != nil {
+		return nil, err
+	}
+	bus := NewBus()
+	if captureStore != nil {
+		bus.Subscribe("store", size, store, storeCapture)
+	}
+	bus.Subscribe("console", size, console, printCapture)
+	return bus, nil
+}
+
+// closeBus drains the session's capture bus and reports what its consumers dropped.
+func closeBus() {
+	bus := captureBus
+	if bus == nil {
+		return
+	}
+	captureBus = nil
+	for _, s := range bus.Close() {
+		if n := s.Dropped(); n > 0 {
+			log.Printf("%s[WARN]%s The %s dropped %d capture(s) (%s policy)", colorYellow, colorReset, s.Name, n, s.Policy)
+		}
+	}
+}
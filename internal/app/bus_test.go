@@ -0,0 +1,208 @@
+package app
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedConsumer records event IDs and holds each delivery until released.
+type gatedConsumer struct {
+	mu     sync.Mutex
+	ids    []string
+	missed []int
+	gate   chan struct{}
+}
+
+func newGatedConsumer() *gatedConsumer { return &gatedConsumer{gate: make(chan struct{})} }
+
+func (g *gatedConsumer) consume(ev CaptureEvent, missed int) {
+	<-g.gate
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.ids = append(g.ids, ev.Capture.ID)
+	g.missed = append(g.missed, missed)
+}
+
+func (g *gatedConsumer) seen() ([]string, []int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.ids...), append([]int(nil), g.missed...)
+}
+
+func event(id string) CaptureEvent { return CaptureEvent{Capture: &Capture{ID: id}} }
+
+// waitQueued waits until s holds n queued events.
+func waitQueued(t *testing.T, s *Subscription, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		s.mu.Lock()
+		l := len(s.queue)
+		s.mu.Unlock()
+		if l == n {
+			return
+		}
+	}
+	t.Fatalf("queue never reached %d events", n)
+}
+
+func TestBus_DropPolicies(t *testing.T) {
+	for _, tc := range []struct {
+		policy BackPressure
+		want   []string
+	}{
+		{DropOldest, []string{"e0", "e3", "e4"}},
+		{DropNewest, []string{"e0", "e1", "e2"}},
+	} {
+		bus := NewBus()
+		c := newGatedConsumer()
+		sub := bus.Subscribe("test", 2, tc.policy, c.consume)
+		bus.Publish(event("e0"))
+		waitQueued(t, sub, 0) // e0 is with the consumer, waiting on the gate
+		for i := 1; i <= 4; i++ {
+			bus.Publish(event(fmt.Sprintf("e%d", i)))
+		}
+		close(c.gate)
+		bus.Close()
+		ids, missed := c.seen()
+		if !reflect.DeepEqual(ids, tc.want) || !reflect.DeepEqual(missed, []int{0, 2, 0}) || sub.Dropped() != 2 {
+			t.Errorf("%s: ids=%v missed=%v dropped=%d, want %v", tc.policy, ids, missed, sub.Dropped(), tc.want)
+		}
+	}
+}
+
+func TestBus_BlockWaitsForRoom(t *testing.T) {
+	bus := NewBus()
+	c := newGatedConsumer()
+	sub := bus.Subscribe("store", 1, Block, c.consume)
+	bus.Publish(event("a"))
+	waitQueued(t, sub, 0)
+	bus.Publish(event("b"))
+
+	published := make(chan struct{})
+	go func() {
+		bus.Publish(event("c"))
+		close(published)
+	}()
+	select {
+	case <-published:
+		t.Fatal("publish should block while the queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(c.gate)
+	<-published
+	bus.Close()
+	if ids, _ := c.seen(); !reflect.DeepEqual(ids, []string{"a", "b", "c"}) || sub.Dropped() != 0 {
+		t.Errorf("ids=%v dropped=%d", ids, sub.Dropped())
+	}
+}
+
+func TestBus_OrderPerConsumerAndSlowConsumerIsolation(t *testing.T) {
+	bus := NewBus()
+	slow := newGatedConsumer()
+	fast := newGatedConsumer()
+	close(fast.gate)
+	bus.Subscribe("slow", 3, DropOldest, slow.consume)
+	bus.Subscribe("fast", 1000, Block, fast.consume)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var order []string
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				id := fmt.Sprintf("%d-%d", w, i)
+				// Publish under mu so order records the publish order
+				mu.Lock()
+				bus.Publish(event(id))
+				order = append(order, id)
+				mu.Unlock()
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(slow.gate)
+	bus.Close()
+
+	fastIDs, _ := fast.seen()
+	if !reflect.DeepEqual(fastIDs, order) {
+		t.Fatalf("fast consumer saw %d events out of publish order", len(fastIDs))
+	}
+	slowIDs, _ := slow.seen()
+	// The slow consumer keeps its first delivery and the newest queued events, in order
+	pos := map[string]int{}
+	for i, id := range order {
+		pos[id] = i
+	}
+	for i := 1; i < len(slowIDs); i++ {
+		if pos[slowIDs[i]] <= pos[slowIDs[i-1]] {
+			t.Fatalf("slow consumer out of order: %v", slowIDs)
+		}
+	}
+	if got := slowIDs[len(slowIDs)-1]; got != order[len(order)-1] {
+		t.Errorf("slow consumer should end with the newest event, got %s", got)
+	}
+}
+
+func TestBus_CloseDrainsAndStopsPublishing(t *testing.T) {
+	bus := NewBus()
+	c := newGatedConsumer()
+	close(c.gate)
+	bus.Subscribe("x", 10, Block, c.consume)
+	for i := 0; i < 5; i++ {
+		bus.Publish(event(fmt.Sprint(i)))
+	}
+	bus.Close()
+	bus.Publish(event("late"))
+	if ids, _ := c.seen(); len(ids) != 5 {
+		t.Errorf("expected the 5 queued events and nothing after Close, got %v", ids)
+	}
+}
+
+func TestParseBackPressure(t *testing.T) {
+	for _, p := range []BackPressure{Block, DropOldest, DropNewest} {
+		if got, err := ParseBackPressure(p.String()); err != nil || got != p {
+			t.Errorf("round trip %s: %v %v", p, got, err)
+		}
+	}
+	if _, err := ParseBackPressure("drop"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestWebhookHandler_PublishesToBus(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := OpenStore(dir)
+	captureStore = store
+	bus, err := newCaptureBus(OutputOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	captureBus = bus
+	defer func() { captureBus, captureStore = nil, nil }()
+
+	w := httptest.NewRecorder()
+	out := captureStdout(func() {
+		WebhookHandler(w, httptest.NewRequest("POST", "/bus", strings.NewReader(`{"n":1}`)))
+		closeBus()
+	})
+	if w.Code != 200 || !strings.Contains(stripANSI(out), "/bus HTTP/1.1") || captureBus != nil {
+		t.Fatalf("status %d, output %q", w.Code, out)
+	}
+	_ = store.Close()
+	if captures, err := ReadCaptures(dir); err != nil || len(captures) != 1 || captures[0].Path != "/bus" {
+		t.Fatalf("store consumer: %v %v", captures, err)
+	}
+
+	for _, o := range []OutputOptions{{QueueSize: -1}, {ConsolePolicy: "later"}, {StorePolicy: "never"}} {
+		if _, err := newCaptureBus(o); err == nil {
+			t.Errorf("expected error for %+v", o)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package app
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Capture is a single received webhook as seen by WebhookHandler.
+type Capture struct {
+	ID         string      `json:"id"`
+	Time       time.Time   `json:"time"`
+	Method     string      `json:"method"`
+	Path       string      `json:"path"`
+	Proto      string      `json:"proto"`
+	RemoteAddr string      `json:"remote_addr,omitempty"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body,omitempty"`
+	// BodyFile holds bodies over the spool threshold instead of Body; BodySize and
+	// BodySHA256 describe it.
+	BodyFile   string   `json:"body_file,omitempty"`
+	BodySize   int64    `json:"body_size,omitempty"`
+	BodySHA256 string   `json:"body_sha256,omitempty"`
+	TLS        *TLSInfo `json:"tls,omitempty"`
+	// Schema is the file the body was validated against, if any, and SchemaErrors
+	// what it found.
+	Schema       string            `json:"schema,omitempty"`
+	SchemaErrors []SchemaViolation `json:"schema_errors,omitempty"`
+}
+
+// NewCapture builds a Capture from an incoming request and its already-read body.
+func NewCapture(r *http.Request, body []byte) *Capture {
+	return &Capture{
+		ID:         newCaptureID(),
+		Time:       time.Now(),
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Proto:      r.Proto,
+		RemoteAddr: r.RemoteAddr,
+		Header:     r.Header.Clone(),
+		Body:       body,
+		TLS:        NewTLSInfo(r.TLS),
+	}
+}
+
+// BodyLen is the size of the body, spooled or not.
+func (c *Capture) BodyLen() int64 {
+	if c.BodyFile != "" {
+		return c.BodySize
+	}
+	return int64(len(c.Body))
+}
+
+// OpenBody reads the body, spooled or not.
+func (c *Capture) OpenBody() (io.ReadCloser, error) {
+	if c.BodyFile != "" {
+		return os.Open(c.BodyFile)
+	}
+	return io.NopCloser(bytes.NewReader(c.Body)), nil
+}
+
+// newCaptureID returns a random identifier for a capture, 16 hex digits so IDs
+// stay unique across the sessions that share a capture directory.
+func newCaptureID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
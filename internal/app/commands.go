@@ -0,0 +1,12 @@
+package app
+
+// Commands are subcommands dispatched by main before flag parsing, keyed by os.Args[1].
+var Commands = map[string]func(args []string) error{
+	"auth":         AuthCommand,
+	"blast":        BlastCommand,
+	"diff":         DiffCommand,
+	"infer-schema": InferSchemaCommand,
+	"send":         SendCommand,
+	"relay":        RelayCommand,
+	"replay":       ReplayCommand,
+}
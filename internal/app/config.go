@@ -0,0 +1,437 @@
+package app
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileName is looked up in the working directory, then in the user config dir.
+const ConfigFileName = "webhook-catcher.yaml"
+
+// EnvPrefix prefixes the environment variable of every flag, e.g. WEBHOOK_CATCHER_PORT.
+const EnvPrefix = "WEBHOOK_CATCHER_"
+
+// ConfigFile is the on-disk layout of webhook-catcher.yaml:
+//
+//	default_profile: stripe-dev
+//	profiles:
+//	  stripe-dev:
+//	    listener: {port: 4242}
+//	    tunnel: {enabled: true, region: eu}
+type ConfigFile struct {
+	DefaultProfile string                               `yaml:"default_profile"`
+	Profiles       map[string]map[string]map[string]any `yaml:"profiles"`
+}
+
+// profileSetting maps a profile key (section.key) to the flag it sets.
+type profileSetting struct {
+	key  string
+	flag string
+	list bool
+}
+
+// profileSettings lists every setting a profile may contain.
+var profileSettings = []profileSetting{
+	{key: "listener.host", flag: "host"},
+	{key: "listener.port", flag: "port"},
+	{key: "listener.listen", flag: "listen", list: true},
+	{key: "listener.socket_mode", flag: "socket-mode"},
+	{key: "listener.tls_cert", flag: "tls-cert"},
+	{key: "listener.tls_key", flag: "tls-key"},
+	{key: "listener.tls_client_ca", flag: "tls-client-ca"},
+	{key: "listener.tls_client_auth", flag: "tls-client-auth"},
+	{key: "listener.h2c", flag: "h2c"},
+	{key: "listener.http3", flag: "http3"},
+	{key: "listener.drain_timeout", flag: "drain-timeout"},
+	{key: "tunnel.enabled", flag: "tunnel"},
+	{key: "tunnel.authtoken", flag: "ngrok-authtoken"},
+	{key: "tunnel.region", flag: "ngrok-region"},
+	{key: "tunnel.domain", flag: "ngrok-domain"},
+	{key: "tunnel.provider", flag: "tunnel-provider"},
+	{key: "tunnel.relay", flag: "relay"},
+	{key: "tunnel.relay_token", flag: "relay-token"},
+	{key: "tunnel.on_url_change", flag: "on-url-change"},
+	{key: "tunnel.basic_auth", flag: "ngrok-basic-auth", list: true},
+	{key: "tunnel.oauth", flag: "ngrok-oauth"},
+	{key: "tunnel.oidc_issuer", flag: "ngrok-oidc-issuer"},
+	{key: "tunnel.oidc_client_id", flag: "ngrok-oidc-client-id"},
+	{key: "tunnel.oidc_client_secret", flag: "ngrok-oidc-client-secret"},
+	{key: "tunnel.allow_emails", flag: "ngrok-allow-email", list: true},
+	{key: "tunnel.allow_domains", flag: "ngrok-allow-domain", list: true},
+	{key: "tunnel.allow_cidrs", flag: "ngrok-allow-cidr", list: true},
+	{key: "tunnel.deny_cidrs", flag: "ngrok-deny-cidr", list: true},
+	{key: "tunnel.verify_webhook", flag: "ngrok-verify-webhook"},
+	{key: "tunnel.verify_webhook_secret", flag: "ngrok-verify-webhook-secret"},
+	{key: "register.targets", flag: "register", list: true},
+	{key: "register.events", flag: "register-events", list: true},
+	{key: "register.secret", flag: "register-secret"},
+	{key: "register.body", flag: "register-body"},
+	{key: "register.cleanup_body", flag: "register-cleanup-body"},
+	{key: "register.headers", flag: "register-header", list: true},
+	{key: "register.github_api", flag: "github-api-url"},
+	{key: "register.stripe_api", flag: "stripe-api-url"},
+	{key: "capture.dir", flag: "capture-dir"},
+	{key: "capture.schemas", flag: "schema", list: true},
+	{key: "capture.max_body", flag: "max-body"},
+	{key: "capture.spool_threshold", flag: "spool-threshold"},
+	{key: "redact.targets", flag: "redact", list: true},
+	{key: "redact.headers", flag: "redact-header", list: true},
+	{key: "redact.paths", flag: "redact-path", list: true},
+	{key: "redact.fields", flag: "redact-field", list: true},
+	{key: "redact.detectors", flag: "redact-detector", list: true},
+	{key: "guard.tokens", flag: "guard-token", list: true},
+	{key: "guard.basic_auth", flag: "guard-basic-auth", list: true},
+	{key: "guard.query", flag: "guard-query"},
+	{key: "guard.allow", flag: "guard-allow", list: true},
+	{key: "guard.status", flag: "guard-status"},
+	{key: "rate_limit.per_ip", flag: "rate-limit"},
+	{key: "rate_limit.global", flag: "rate-limit-global"},
+	{key: "rate_limit.mode", flag: "rate-limit-mode"},
+	{key: "script.rules", flag: "script", list: true},
+	{key: "script.timeout", flag: "script-timeout"},
+	{key: "exec.commands", flag: "exec", list: true},
+	{key: "exec.rules", flag: "exec-rule", list: true},
+	{key: "exec.timeout", flag: "exec-timeout"},
+	{key: "exec.concurrency", flag: "exec-concurrency"},
+	{key: "exec.respond", flag: "exec-respond"},
+	{key: "output.queue_size", flag: "queue-size"},
+	{key: "output.console_policy", flag: "console-policy"},
+	{key: "output.store_policy", flag: "store-policy"},
+}
+
+// flagEnv overrides the environment variable name for flags with an established one.
+var flagEnv = map[string]string{
+	"ngrok-authtoken": "NGROK_AUTHTOKEN",
+}
+
+// secretFlags are masked by PrintConfig.
+var secretFlags = map[string]bool{
+	"guard-basic-auth":            true,
+	"guard-query":                 true,
+	"guard-token":                 true,
+	"ngrok-authtoken":             true,
+	"ngrok-basic-auth":            true,
+	"ngrok-oidc-client-secret":    true,
+	"ngrok-verify-webhook-secret": true,
+	"relay-token":                 true,
+	"register-secret":             true,
+}
+
+// headerFlags hold "Name: value" headers, often Authorization tokens; PrintConfig
+// masks each value and keeps the names.
+var headerFlags = map[string]bool{
+	"register-header": true,
+}
+
+// EnvName returns the environment variable consulted for a flag.
+func EnvName(flagName string) string {
+	if name, ok := flagEnv[flagName]; ok {
+		return name
+	}
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// ConfigReport describes where the effective configuration came from.
+type ConfigReport struct {
+	Path    string            // config file used, "" if none
+	Profile string            // selected profile, "" if none
+	Sources map[string]string // flag name -> "flag", "env NAME", "profile NAME" or "default"
+}
+
+// FindConfigFile returns the first existing config file: ./webhook-catcher.yaml, then
+// <user config dir>/webhook-catcher/webhook-catcher.yaml. Returns "" when none exists.
+func FindConfigFile() string {
+	candidates := []string{ConfigFileName}
+	if dir, err := os.UserConfigDir(); err == nil {
+		candidates = append(candidates, filepath.Join(dir, "webhook-catcher", ConfigFileName))
+	}
+	for _, p := range candidates {
+		if fi, err := os.Stat(p); err == nil && !fi.IsDir() {
+			return p
+		}
+	}
+	return ""
+}
+
+// LoadConfigFile parses a config file.
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg ConfigFile
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// ApplyConfig fills every flag in fs that was not set on the command line, with
+// precedence flags > env > profile > defaults. configPath and profile may be empty to
+// use FindConfigFile and the WEBHOOK_CATCHER_PROFILE or default_profile selection.
+func ApplyConfig(fs *flag.FlagSet, configPath, profile string) (*ConfigReport, error) {
+	// .env counts as environment, so load it before reading variables
+	LoadDotEnv(".env")
+
+	report := &ConfigReport{Sources: map[string]string{}}
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	if profile == "" {
+		profile = os.Getenv(EnvPrefix + "PROFILE")
+	}
+	if configPath == "" {
+		configPath = os.Getenv(EnvPrefix + "CONFIG")
+	}
+	if configPath == "" {
+		configPath = FindConfigFile()
+	}
+	var cfg *ConfigFile
+	if configPath != "" {
+		var err error
+		if cfg, err = LoadConfigFile(configPath); err != nil {
+			return nil, fmt.Errorf("config file error: %w", err)
+		}
+		report.Path = configPath
+		if profile == "" {
+			profile = cfg.DefaultProfile
+		}
+	}
+
+	// Profile layer
+	applied := map[string]bool{}
+	if profile != "" {
+		if cfg == nil {
+			return nil, fmt.Errorf("profile %q requested but no %s found", profile, ConfigFileName)
+		}
+		sections, ok := cfg.Profiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("profile %q not found in %s", profile, configPath)
+		}
+		report.Profile = profile
+		values, err := flattenProfile(sections)
+		if err != nil {
+			return nil, fmt.Errorf("profile %q: %w", profile, err)
+		}
+		for _, s := range profileSettings {
+			v, ok := values[s.key]
+			if !ok || explicit[s.flag] || fs.Lookup(s.flag) == nil {
+				continue
+			}
+			if err := setFlag(fs, s.flag, v, s.list); err != nil {
+				return nil, fmt.Errorf("profile %q: %s: %w", profile, s.key, err)
+			}
+			applied[s.flag] = true
+			report.Sources[s.flag] = "profile " + profile
+		}
+	}
+
+	// Environment layer
+	lists := map[string]bool{}
+	for _, s := range profileSettings {
+		lists[s.flag] = s.list
+	}
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if envErr != nil || explicit[f.Name] {
+			return
+		}
+		name := EnvName(f.Name)
+		v, ok := os.LookupEnv(name)
+		if !ok || v == "" {
+			return
+		}
+		if applied[f.Name] && lists[f.Name] {
+			// Repeatable flags append, so drop the profile's values first
+			l, ok := f.Value.(*ListFlag)
+			if !ok {
+				envErr = fmt.Errorf("%s: cannot combine with profile setting for -%s", name, f.Name)
+				return
+			}
+			*l = nil
+		}
+		var val any = v
+		if lists[f.Name] {
+			val = strings.Split(v, ",")
+		}
+		if err := setFlag(fs, f.Name, val, lists[f.Name]); err != nil {
+			envErr = fmt.Errorf("%s: %w", name, err)
+			return
+		}
+		report.Sources[f.Name] = "env " + name
+	})
+	if envErr != nil {
+		return nil, envErr
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if explicit[f.Name] {
+			report.Sources[f.Name] = "flag"
+		} else if report.Sources[f.Name] == "" {
+			report.Sources[f.Name] = "default"
+		}
+	})
+	return report, nil
+}
+
+// flattenProfile turns profile sections into section.key values and rejects unknown keys.
+func flattenProfile(sections map[string]map[string]any) (map[string]any, error) {
+	known := map[string]bool{}
+	for _, s := range profileSettings {
+		known[s.key] = true
+	}
+	values := map[string]any{}
+	for section, kv := range sections {
+		for k, v := range kv {
+			key := section + "." + k
+			if !known[key] {
+				return nil, fmt.Errorf("unknown setting %q", key)
+			}
+			values[key] = v
+		}
+	}
+	return values, nil
+}
+
+// setFlag sets a flag from a YAML or environment value.
+func setFlag(fs *flag.FlagSet, name string, v any, list bool) error {
+	if list {
+		items, ok := v.([]any)
+		if !ok {
+			if ss, isStrings := v.([]string); isStrings {
+				for _, s := range ss {
+					items = append(items, s)
+				}
+			} else {
+				items = []any{v}
+			}
+		}
+		for _, item := range items {
+			if err := fs.Set(name, strings.TrimSpace(fmt.Sprint(item))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if _, isMap := v.(map[string]any); isMap {
+		return errors.New("expected a scalar value")
+	}
+	// YAML reads an unquoted 0660 as the integer 432
+	if n, isInt := v.(int); isInt {
+		if _, isMode := fs.Lookup(name).Value.(*FileModeFlag); isMode {
+			v = fmt.Sprintf("%o", n)
+		}
+	}
+	return fs.Set(name, fmt.Sprint(v))
+}
+
+// PrintConfig writes the effective configuration for `config show`.
+func PrintConfig(w io.Writer, fs *flag.FlagSet, report *ConfigReport) {
+	path, profile := report.Path, report.Profile
+	if path == "" {
+		path = "<none>"
+	}
+	if profile == "" {
+		profile = "<none>"
+	}
+	fmt.Fprintf(w, "Config file: %s\n", path)
+	fmt.Fprintf(w, "Profile: %s\n\n", profile)
+
+	var names []string
+	width := 0
+	fs.VisitAll(func(f *flag.Flag) {
+		names = append(names, f.Name)
+		if len(f.Name) > width {
+			width = len(f.Name)
+		}
+	})
+	sort.Strings(names)
+	for _, name := range names {
+		val := fs.Lookup(name).Value.String()
+		if secretFlags[name] && val != "" {
+			val = MaskSecret(val)
+		}
+		if headers, ok := fs.Lookup(name).Value.(*ListFlag); ok && headerFlags[name] {
+			val = maskHeaders(*headers)
+		}
+		fmt.Fprintf(w, "  %-*s = %-24s (%s)\n", width, name, val, report.Sources[name])
+	}
+}
+
+// maskHeaders masks the value of each "Name: value" header.
+func maskHeaders(headers []string) string {
+	masked := make([]string, len(headers))
+	for i, h := range headers {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok {
+			masked[i] = MaskSecret(h)
+			continue
+		}
+		masked[i] = name + ": " + MaskSecret(strings.TrimSpace(value))
+	}
+	return strings.Join(masked, ",")
+}
+
+// ListFlag is a repeatable string flag.
+type ListFlag []string
+
+func (l *ListFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *ListFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// ByteSizeFlag is a size flag such as 512KB or 10MB; see ParseByteSize.
+type ByteSizeFlag int64
+
+func (b *ByteSizeFlag) String() string {
+	if *b == 0 {
+		return ""
+	}
+	return FormatByteSize(int64(*b))
+}
+func (b *ByteSizeFlag) Set(s string) error {
+	v, err := ParseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*b = ByteSizeFlag(v)
+	return nil
+}
+
+// FileModeFlag is an octal file mode flag such as 0660.
+type FileModeFlag os.FileMode
+
+func (m *FileModeFlag) String() string {
+	if *m == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%04o", uint32(*m))
+}
+
+func (m *FileModeFlag) Set(s string) error {
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return err
+	}
+	*m = FileModeFlag(v)
+	return nil
+}
+
+// MaskSecret hides all but the last four characters of a secret.
+func MaskSecret(s string) string {
+	if len(s) <= 4 {
+		return strings.Repeat("*", len(s))
+	}
+	return strings.Repeat("*", 8) + s[len(s)-4:]
+}
@@ -0,0 +1,239 @@
+package app
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+default_profile: stripe-dev
+profiles:
+  stripe-dev:
+    listener:
+      port: 4242
+      listen: ["tcp://127.0.0.1:4242", "unix:///tmp/stripe.sock"]
+      socket_mode: 0660
+      drain_timeout: 10s
+    tunnel:
+      enabled: true
+      region: eu
+      authtoken: profile-token-1234
+  github-staging:
+    listener:
+      host: 0.0.0.0
+  broken:
+    listener:
+      colour: blue
+`
+
+func newTestFlagSet() (*flag.FlagSet, *Options) {
+	var o Options
+	var listen ListFlag
+	var mode FileModeFlag
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.StringVar(&o.Host, "host", "127.0.0.1", "")
+	fs.IntVar(&o.Port, "port", 3000, "")
+	fs.BoolVar(&o.Tunnel, "tunnel", false, "")
+	fs.StringVar(&o.NgrokToken, "ngrok-authtoken", "", "")
+	fs.StringVar(&o.NgrokRegion, "ngrok-region", "", "")
+	fs.DurationVar(&o.DrainTimeout, "drain-timeout", DefaultDrainTimeout, "")
+	fs.Var(&listen, "listen", "")
+	fs.Var(&mode, "socket-mode", "")
+	return fs, &o
+}
+
+func writeTestConfig(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), ConfigFileName)
+	if err := os.WriteFile(path, []byte(testConfig), 0600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+// isolateEnv clears variables ApplyConfig reads and runs in a temp dir without .env.
+func isolateEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{"NGROK_AUTHTOKEN", EnvPrefix + "PORT", EnvPrefix + "PROFILE", EnvPrefix + "CONFIG", EnvPrefix + "NGROK_REGION", EnvPrefix + "LISTEN"} {
+		t.Setenv(name, "")
+		_ = os.Unsetenv(name)
+	}
+	origWD, _ := os.Getwd()
+	_ = os.Chdir(t.TempDir())
+	t.Cleanup(func() { _ = os.Chdir(origWD) })
+}
+
+func TestApplyConfig_DefaultProfile(t *testing.T) {
+	isolateEnv(t)
+	path := writeTestConfig(t)
+	fs, o := newTestFlagSet()
+	_ = fs.Parse(nil)
+
+	report, err := ApplyConfig(fs, path, "")
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if report.Profile != "stripe-dev" || report.Path != path {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if o.Port != 4242 || !o.Tunnel || o.NgrokRegion != "eu" || o.DrainTimeout != 10*time.Second {
+		t.Fatalf("profile not applied: %+v", o)
+	}
+	if got := fs.Lookup("listen").Value.String(); got != "tcp://127.0.0.1:4242,unix:///tmp/stripe.sock" {
+		t.Fatalf("unexpected listen %q", got)
+	}
+	if got := fs.Lookup("socket-mode").Value.String(); got != "0660" {
+		t.Fatalf("expected unquoted YAML mode to be read as octal, got %q", got)
+	}
+	if report.Sources["port"] != "profile stripe-dev" || report.Sources["host"] != "default" {
+		t.Fatalf("unexpected sources %v", report.Sources)
+	}
+}
+
+func TestApplyConfig_Precedence(t *testing.T) {
+	isolateEnv(t)
+	path := writeTestConfig(t)
+	t.Setenv(EnvPrefix+"PORT", "5000")
+	t.Setenv(EnvPrefix+"NGROK_REGION", "ap")
+	t.Setenv("NGROK_AUTHTOKEN", "env-token")
+
+	fs, o := newTestFlagSet()
+	_ = fs.Parse([]string{"-port", "6000"})
+
+	report, err := ApplyConfig(fs, path, "stripe-dev")
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if o.Port != 6000 || report.Sources["port"] != "flag" {
+		t.Fatalf("flag should win over env and profile, got port=%d source=%s", o.Port, report.Sources["port"])
+	}
+	if o.NgrokRegion != "ap" || report.Sources["ngrok-region"] != "env "+EnvPrefix+"NGROK_REGION" {
+		t.Fatalf("env should win over profile, got region=%s source=%s", o.NgrokRegion, report.Sources["ngrok-region"])
+	}
+	if o.NgrokToken != "env-token" || report.Sources["ngrok-authtoken"] != "env NGROK_AUTHTOKEN" {
+		t.Fatalf("NGROK_AUTHTOKEN should win over profile, got %q", o.NgrokToken)
+	}
+	if !o.Tunnel {
+		t.Fatalf("profile should still apply to remaining flags")
+	}
+}
+
+func TestApplyConfig_EnvReplacesProfileList(t *testing.T) {
+	isolateEnv(t)
+	path := writeTestConfig(t)
+	t.Setenv(EnvPrefix+"LISTEN", "tcp://127.0.0.1:7000,[::1]:7001")
+
+	fs, _ := newTestFlagSet()
+	_ = fs.Parse(nil)
+	report, err := ApplyConfig(fs, path, "stripe-dev")
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if got := fs.Lookup("listen").Value.String(); got != "tcp://127.0.0.1:7000,[::1]:7001" || report.Sources["listen"] != "env "+EnvPrefix+"LISTEN" {
+		t.Fatalf("env should replace the profile's list, got %q from %s", got, report.Sources["listen"])
+	}
+}
+
+func TestApplyConfig_Errors(t *testing.T) {
+	isolateEnv(t)
+	path := writeTestConfig(t)
+
+	fs, _ := newTestFlagSet()
+	if _, err := ApplyConfig(fs, path, "missing"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected missing profile error, got %v", err)
+	}
+	fs, _ = newTestFlagSet()
+	if _, err := ApplyConfig(fs, path, "broken"); err == nil || !strings.Contains(err.Error(), `unknown setting "listener.colour"`) {
+		t.Fatalf("expected unknown setting error, got %v", err)
+	}
+	fs, _ = newTestFlagSet()
+	if _, err := ApplyConfig(fs, "", "stripe-dev"); err == nil || !strings.Contains(err.Error(), "no "+ConfigFileName) {
+		t.Fatalf("expected missing config file error, got %v", err)
+	}
+	bad := filepath.Join(t.TempDir(), "bad.yaml")
+	_ = os.WriteFile(bad, []byte("profiles: [oops"), 0600)
+	fs, _ = newTestFlagSet()
+	if _, err := ApplyConfig(fs, bad, ""); err == nil || !strings.Contains(err.Error(), "config file error") {
+		t.Fatalf("expected parse error, got %v", err)
+	}
+}
+
+func TestApplyConfig_NoConfigFile(t *testing.T) {
+	isolateEnv(t)
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	fs, o := newTestFlagSet()
+	report, err := ApplyConfig(fs, "", "")
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if report.Path != "" || report.Profile != "" || o.Port != 3000 {
+		t.Fatalf("expected defaults without a config file, got %+v %+v", report, o)
+	}
+}
+
+func TestPrintConfig_MasksSecrets(t *testing.T) {
+	isolateEnv(t)
+	path := writeTestConfig(t)
+	fs, _ := newTestFlagSet()
+	report, err := ApplyConfig(fs, path, "")
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	var buf bytes.Buffer
+	PrintConfig(&buf, fs, report)
+	out := buf.String()
+	if strings.Contains(out, "profile-token-1234") || !strings.Contains(out, "********1234") {
+		t.Fatalf("expected masked token, got: %s", out)
+	}
+	if !strings.Contains(out, "Profile: stripe-dev") || !strings.Contains(out, "(profile stripe-dev)") {
+		t.Fatalf("expected profile details, got: %s", out)
+	}
+}
+
+func TestApplyConfig_TunnelSecurity(t *testing.T) {
+	isolateEnv(t)
+	path := filepath.Join(t.TempDir(), ConfigFileName)
+	cfg := "profiles:\n  locked:\n    tunnel:\n      basic_auth: [\"dev:password1\"]\n      allow_cidrs: [10.0.0.0/8, 192.168.0.0/16]\n      verify_webhook: stripe\n"
+	if err := os.WriteFile(path, []byte(cfg), 0600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	var basic, cidrs ListFlag
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(&basic, "ngrok-basic-auth", "")
+	fs.Var(&cidrs, "ngrok-allow-cidr", "")
+	verify := fs.String("ngrok-verify-webhook", "", "")
+	_ = fs.Parse(nil)
+
+	report, err := ApplyConfig(fs, path, "locked")
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if len(basic) != 1 || len(cidrs) != 2 || *verify != "stripe" {
+		t.Fatalf("profile not applied: basic=%v cidrs=%v verify=%q", basic, cidrs, *verify)
+	}
+	var out bytes.Buffer
+	PrintConfig(&out, fs, report)
+	if strings.Contains(out.String(), "password1") {
+		t.Fatalf("basic auth not masked: %s", out.String())
+	}
+}
+
+func TestPrintConfig_MasksRegisterHeaders(t *testing.T) {
+	isolateEnv(t)
+	var headers ListFlag
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(&headers, "register-header", "")
+	_ = fs.Parse([]string{"-register-header", "Authorization: Bearer tok-abcdefgh", "-register-header", "X-Team: platform"})
+
+	var out bytes.Buffer
+	PrintConfig(&out, fs, &ConfigReport{Sources: map[string]string{}})
+	if strings.Contains(out.String(), "tok-abcdefgh") || !strings.Contains(out.String(), "Authorization: ********efgh,X-Team: ********form") {
+		t.Fatalf("register headers not masked: %s", out.String())
+	}
+}
@@ -0,0 +1,355 @@
+package app
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/zalando/go-keyring"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// NgrokCredential is the credential name the ngrok authtoken is stored under.
+const NgrokCredential = "ngrok-authtoken"
+
+// credentialService namespaces entries in the OS keyring.
+const credentialService = "webhook-catcher"
+
+// PassphraseEnv supplies the encrypted file passphrase without prompting.
+const PassphraseEnv = EnvPrefix + "PASSPHRASE"
+
+// ErrCredentialNotFound is returned by CredentialStore.Get for unknown names.
+var ErrCredentialNotFound = errors.New("credential not found")
+
+// CredentialStore keeps named secrets such as the ngrok authtoken or provider signing secrets.
+type CredentialStore interface {
+	Name() string
+	Get(name string) (string, error)
+	Set(name, secret string) error
+	Remove(name string) error
+}
+
+// OpenCredentialStore returns the store for kind: "keyring" or "file".
+func OpenCredentialStore(kind string) (CredentialStore, error) {
+	switch kind {
+	case "keyring":
+		return KeyringStore{Service: credentialService}, nil
+	case "file":
+		path, err := DefaultCredentialFile()
+		if err != nil {
+			return nil, err
+		}
+		return &FileStore{Path: path, Passphrase: PromptPassphrase}, nil
+	default:
+		return nil, fmt.Errorf("unknown credential store %q (want keyring or file)", kind)
+	}
+}
+
+// LookupCredential searches the OS keyring, then the encrypted file if it exists.
+// Stores that are unavailable on this machine are skipped.
+func LookupCredential(name string) (secret, from string, err error) {
+	if s, err := (KeyringStore{Service: credentialService}).Get(name); err == nil {
+		return s, "keyring", nil
+	}
+	path, err := DefaultCredentialFile()
+	if err != nil {
+		return "", "", ErrCredentialNotFound
+	}
+	if _, statErr := os.Stat(path); statErr != nil {
+		return "", "", ErrCredentialNotFound
+	}
+	s, err := (&FileStore{Path: path, Passphrase: PromptPassphrase}).Get(name)
+	if err != nil {
+		return "", "", err
+	}
+	return s, "encrypted file", nil
+}
+
+// KeyringStore keeps secrets in the OS keyring: Secret Service on Linux, Keychain on
+// macOS and Credential Manager on Windows.
+type KeyringStore struct {
+	Service string
+}
+
+func (k KeyringStore) Name() string { return "keyring" }
+
+func (k KeyringStore) Get(name string) (string, error) {
+	s, err := keyring.Get(k.Service, name)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", ErrCredentialNotFound
+	}
+	return s, err
+}
+
+func (k KeyringStore) Set(name, secret string) error {
+	return keyring.Set(k.Service, name, secret)
+}
+
+func (k KeyringStore) Remove(name string) error {
+	err := keyring.Delete(k.Service, name)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return ErrCredentialNotFound
+	}
+	return err
+}
+
+// DefaultCredentialFile is <user config dir>/webhook-catcher/credentials.enc.
+func DefaultCredentialFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "webhook-catcher", "credentials.enc"), nil
+}
+
+// FileStore keeps secrets in a file encrypted with AES-256-GCM under a key derived
+// from a passphrase with scrypt. Passphrase is called at most once per store.
+type FileStore struct {
+	Path       string
+	Passphrase func() (string, error)
+
+	once sync.Once
+	pass string
+	err  error
+}
+
+// encryptedFile is the on-disk JSON layout of a FileStore.
+type encryptedFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// scrypt cost parameters; N=2^15 keeps unlocking under a second on a laptop.
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+func (f *FileStore) Name() string { return "encrypted file" }
+
+func (f *FileStore) passphrase() (string, error) {
+	f.once.Do(func() {
+		f.pass, f.err = f.Passphrase()
+		if f.err == nil && f.pass == "" {
+			f.err = errors.New("empty passphrase")
+		}
+	})
+	return f.pass, f.err
+}
+
+func (f *FileStore) Get(name string) (string, error) {
+	secrets, err := f.load()
+	if err != nil {
+		return "", err
+	}
+	s, ok := secrets[name]
+	if !ok {
+		return "", ErrCredentialNotFound
+	}
+	return s, nil
+}
+
+func (f *FileStore) Set(name, secret string) error {
+	secrets, err := f.load()
+	if err != nil {
+		return err
+	}
+	secrets[name] = secret
+	return f.save(secrets)
+}
+
+func (f *FileStore) Remove(name string) error {
+	secrets, err := f.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := secrets[name]; !ok {
+		return ErrCredentialNotFound
+	}
+	delete(secrets, name)
+	return f.save(secrets)
+}
+
+// load decrypts the file. A missing file is an empty store.
+func (f *FileStore) load() (map[string]string, error) {
+	b, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ef encryptedFile
+	if err := json.Unmarshal(b, &ef); err != nil {
+		return nil, fmt.Errorf("read %s: %w", f.Path, err)
+	}
+	if ef.Version != 1 || ef.KDF != "scrypt" {
+		return nil, fmt.Errorf("read %s: unsupported format version %d (%s)", f.Path, ef.Version, ef.KDF)
+	}
+	pass, err := f.passphrase()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newFileAEAD(pass, ef.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, ef.Nonce, ef.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt %s: wrong passphrase or corrupted file", f.Path)
+	}
+	secrets := map[string]string{}
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		return nil, fmt.Errorf("decrypt %s: %w", f.Path, err)
+	}
+	return secrets, nil
+}
+
+// save encrypts secrets with a fresh salt and nonce and replaces the file atomically.
+func (f *FileStore) save(secrets map[string]string) error {
+	pass, err := f.passphrase()
+	if err != nil {
+		return err
+	}
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	ef := encryptedFile{Version: 1, KDF: "scrypt", Salt: make([]byte, 16)}
+	if _, err := rand.Read(ef.Salt); err != nil {
+		return err
+	}
+	aead, err := newFileAEAD(pass, ef.Salt)
+	if err != nil {
+		return err
+	}
+	ef.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(ef.Nonce); err != nil {
+		return err
+	}
+	ef.Ciphertext = aead.Seal(nil, ef.Nonce, plain, nil)
+	out, err := json.MarshalIndent(ef, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0700); err != nil {
+		return err
+	}
+	tmp := f.Path + ".tmp"
+	if err := os.WriteFile(tmp, out, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.Path)
+}
+
+func newFileAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// PromptPassphrase reads the encrypted file passphrase from PassphraseEnv or the terminal.
+func PromptPassphrase() (string, error) {
+	if p := os.Getenv(PassphraseEnv); p != "" {
+		return p, nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("no terminal to prompt for a passphrase; set %s", PassphraseEnv)
+	}
+	return readPassphrase(nil)
+}
+
+// readPassphrase prompts for the passphrase unless PassphraseEnv is set.
+func readPassphrase(reader *bufio.Reader) (string, error) {
+	if p := os.Getenv(PassphraseEnv); p != "" {
+		return p, nil
+	}
+	fmt.Print("[PROMPT] Passphrase for the credential file: ")
+	return readSecret(reader)
+}
+
+// promptNgrokToken asks for the ngrok authtoken on first use and offers where to keep it.
+func promptNgrokToken() (string, error) {
+	fmt.Printf("%s[INFO]%s Welcome to Webhook Catcher!\n", colorGreen, colorReset)
+	fmt.Printf("%s[INFO]%s It looks like this is your first time enabling tunneling.\n", colorGreen, colorReset)
+	fmt.Println("[PROMPT] Open https://dashboard.ngrok.com/get-started/your-authtoken")
+	fmt.Print("[PROMPT] Paste your ngrok Authtoken here: ")
+	reader := bufio.NewReader(os.Stdin)
+	token, _ := readSecret(reader)
+	if token == "" {
+		return "", fmt.Errorf("missing ngrok Authtoken. Get one at https://dashboard.ngrok.com/get-started/your-authtoken")
+	}
+	fmt.Printf("%s[INFO]%s Authtoken: %s\n", colorGreen, colorReset, MaskSecret(token))
+	_ = os.Setenv("NGROK_AUTHTOKEN", token)
+
+	fmt.Println("[PROMPT] Save it for future runs? [1] OS keyring (default)  [2] Encrypted file  [3] .env (plaintext)  [4] Don't save")
+	fmt.Print("Enter 1-4 (default 1): ")
+	line, _ := reader.ReadString('\n')
+	var store CredentialStore
+	switch strings.TrimSpace(line) {
+	case "", "1":
+		store = KeyringStore{Service: credentialService}
+	case "2":
+		path, err := DefaultCredentialFile()
+		if err != nil {
+			log.Printf("%s[WARN]%s failed to locate the credential file: %v", colorYellow, colorReset, err)
+			return token, nil
+		}
+		store = &FileStore{Path: path, Passphrase: func() (string, error) { return readPassphrase(reader) }}
+	case "3":
+		if err := SaveEnvVar(".env", "NGROK_AUTHTOKEN", token); err != nil {
+			log.Printf("%s[WARN]%s failed to persist token to .env: %v", colorYellow, colorReset, err)
+		} else {
+			log.Printf("%s[INFO]%s Saved NGROK_AUTHTOKEN to .env for future runs. Keep .env out of version control.", colorGreen, colorReset)
+		}
+		return token, nil
+	default:
+		return token, nil
+	}
+	if err := store.Set(NgrokCredential, token); err != nil {
+		log.Printf("%s[WARN]%s failed to save token to the %s: %v", colorYellow, colorReset, store.Name(), err)
+	} else {
+		log.Printf("%s[INFO]%s Saved ngrok Authtoken to the %s for future runs.", colorGreen, colorReset, store.Name())
+	}
+	return token, nil
+}
+
+// readSecret reads one line without echo when stdin is a terminal, otherwise from reader
+// (or a fresh reader on os.Stdin when nil).
+func readSecret(reader *bufio.Reader) (string, error) {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		b, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println()
+		return strings.TrimSpace(string(b)), err
+	}
+	if reader == nil {
+		reader = bufio.NewReader(os.Stdin)
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
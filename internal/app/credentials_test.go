@@ -0,0 +1,246 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zalando/go-keyring"
+	"golang.ngrok.com/ngrok"
+	"golang.ngrok.com/ngrok/config"
+)
+
+// Tests never touch the real OS keyring
+func init() { keyring.MockInit() }
+
+// isolateCredentials gives the test an empty keyring, its own user config dir and a
+// known file passphrase.
+func isolateCredentials(t *testing.T) {
+	t.Helper()
+	keyring.MockInit()
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+	t.Setenv("AppData", dir)
+	t.Setenv(PassphraseEnv, "correct horse")
+}
+
+// feedStdin replaces os.Stdin with a pipe containing input for the rest of the test.
+func feedStdin(t *testing.T, input string) {
+	t.Helper()
+	orig := os.Stdin
+	r, w, _ := os.Pipe()
+	os.Stdin = r
+	_, _ = w.Write([]byte(input))
+	_ = w.Close()
+	t.Cleanup(func() { os.Stdin = orig; _ = r.Close() })
+}
+
+func TestFileStore_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "credentials.enc")
+	pass := func() (string, error) { return "s3cret", nil }
+	fs := &FileStore{Path: path, Passphrase: pass}
+	if _, err := fs.Get("x"); !errors.Is(err, ErrCredentialNotFound) {
+		t.Fatalf("expected not found on missing file, got %v", err)
+	}
+	if err := fs.Set("ngrok-authtoken", "tok-plaintext-value"); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := fs.Set("stripe-signing-secret", "whsec_abc"); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	b, _ := os.ReadFile(path)
+	if bytes.Contains(b, []byte("tok-plaintext-value")) || bytes.Contains(b, []byte("whsec_abc")) {
+		t.Fatalf("secret stored in plaintext: %s", b)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("expected 0600 file, got %v err=%v", fi.Mode(), err)
+	}
+
+	reopened := &FileStore{Path: path, Passphrase: pass}
+	if s, err := reopened.Get("stripe-signing-secret"); err != nil || s != "whsec_abc" {
+		t.Fatalf("get = %q, %v", s, err)
+	}
+	if err := reopened.Remove("ngrok-authtoken"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := reopened.Remove("ngrok-authtoken"); !errors.Is(err, ErrCredentialNotFound) {
+		t.Fatalf("expected not found on second remove, got %v", err)
+	}
+
+	wrong := &FileStore{Path: path, Passphrase: func() (string, error) { return "nope", nil }}
+	if _, err := wrong.Get("stripe-signing-secret"); err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+		t.Fatalf("expected wrong passphrase error, got %v", err)
+	}
+}
+
+func TestKeyringStore(t *testing.T) {
+	isolateCredentials(t)
+	k := KeyringStore{Service: credentialService}
+	if _, err := k.Get("a"); !errors.Is(err, ErrCredentialNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if err := k.Set("a", "1"); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if s, err := k.Get("a"); err != nil || s != "1" {
+		t.Fatalf("get = %q, %v", s, err)
+	}
+	if err := k.Remove("a"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := k.Remove("a"); !errors.Is(err, ErrCredentialNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestLookupCredential_KeyringThenFile(t *testing.T) {
+	isolateCredentials(t)
+	if _, _, err := LookupCredential("k"); !errors.Is(err, ErrCredentialNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	store, _ := OpenCredentialStore("file")
+	if err := store.Set("k", "from-file"); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if s, from, err := LookupCredential("k"); err != nil || s != "from-file" || from != "encrypted file" {
+		t.Fatalf("lookup = %q %q %v", s, from, err)
+	}
+	_ = KeyringStore{Service: credentialService}.Set("k", "from-keyring")
+	if s, from, err := LookupCredential("k"); err != nil || s != "from-keyring" || from != "keyring" {
+		t.Fatalf("lookup = %q %q %v", s, from, err)
+	}
+}
+
+func TestOpenCredentialStore_Unknown(t *testing.T) {
+	if _, err := OpenCredentialStore("vault"); err == nil {
+		t.Fatalf("expected error for unknown store")
+	}
+}
+
+func TestAuthCommand_SetGetRemove(t *testing.T) {
+	isolateCredentials(t)
+	var out bytes.Buffer
+
+	feedStdin(t, "whsec_1234567890\n")
+	if err := authCommand([]string{"set", "-store", "file", "stripe-signing-secret"}, &out); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if strings.Contains(out.String(), "whsec_1234567890") {
+		t.Fatalf("set echoed the secret: %s", out.String())
+	}
+
+	out.Reset()
+	if err := authCommand([]string{"get", "stripe-signing-secret"}, &out); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if got := out.String(); !strings.Contains(got, "********7890") || strings.Contains(got, "whsec_") || !strings.Contains(got, "encrypted file") {
+		t.Fatalf("expected masked secret from the file, got %q", got)
+	}
+
+	out.Reset()
+	if err := authCommand([]string{"get", "-reveal", "stripe-signing-secret"}, &out); err != nil || !strings.Contains(out.String(), "whsec_1234567890") {
+		t.Fatalf("expected revealed secret, got %q err=%v", out.String(), err)
+	}
+
+	if err := authCommand([]string{"remove", "-store", "file", "stripe-signing-secret"}, &out); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := authCommand([]string{"get", "stripe-signing-secret"}, &out); !errors.Is(err, ErrCredentialNotFound) {
+		t.Fatalf("expected not found after remove, got %v", err)
+	}
+}
+
+func TestAuthCommand_DefaultsToNgrokInKeyring(t *testing.T) {
+	isolateCredentials(t)
+	feedStdin(t, "tok-abcdef\n")
+	var out bytes.Buffer
+	if err := authCommand([]string{"set"}, &out); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if s, err := (KeyringStore{Service: credentialService}).Get(NgrokCredential); err != nil || s != "tok-abcdef" {
+		t.Fatalf("keyring = %q, %v", s, err)
+	}
+	for _, args := range [][]string{nil, {"list"}, {"get", "a", "b"}} {
+		if err := authCommand(args, &out); err == nil {
+			t.Fatalf("expected usage error for %v", args)
+		}
+	}
+}
+
+func stubNgrok(t *testing.T) {
+	t.Helper()
+	orig := ServeNgrokFunc
+	t.Cleanup(func() { ServeNgrokFunc = orig })
+	ServeNgrokFunc = func(ctx context.Context, epOpts []config.HTTPEndpointOption, connectOpts []ngrok.ConnectOption, mux http.Handler) error {
+		return nil
+	}
+}
+
+func TestRun_Ngrok_Prompt_SaveToKeyring(t *testing.T) {
+	isolateEnv(t)
+	isolateCredentials(t)
+	stubNgrok(t)
+	feedStdin(t, "tok-keyring-1234\n\n")
+
+	var err error
+	out := captureStdout(func() { err = Run(Options{Tunnel: true}) })
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if strings.Contains(out, "tok-keyring-1234") || !strings.Contains(out, "********1234") {
+		t.Fatalf("expected the token to be echoed masked, got: %s", out)
+	}
+	if s, err := (KeyringStore{Service: credentialService}).Get(NgrokCredential); err != nil || s != "tok-keyring-1234" {
+		t.Fatalf("keyring = %q, %v", s, err)
+	}
+	if _, err := os.Stat(".env"); !os.IsNotExist(err) {
+		t.Fatalf("expected no .env to be written, got %v", err)
+	}
+
+	// The next run picks the token up without prompting
+	_ = os.Unsetenv("NGROK_AUTHTOKEN")
+	feedStdin(t, "")
+	out = captureStdout(func() { err = Run(Options{Tunnel: true}) })
+	if err != nil || !strings.Contains(out, "from the keyring") || strings.Contains(out, "Paste your ngrok Authtoken") {
+		t.Fatalf("expected stored token to be used, err=%v out=%s", err, out)
+	}
+}
+
+func TestRun_Ngrok_Prompt_SaveToFile(t *testing.T) {
+	isolateEnv(t)
+	isolateCredentials(t)
+	stubNgrok(t)
+	feedStdin(t, "tok-file-5678\n2\n")
+
+	if err := Run(Options{Tunnel: true}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	store, _ := OpenCredentialStore("file")
+	if s, err := store.Get(NgrokCredential); err != nil || s != "tok-file-5678" {
+		t.Fatalf("file store = %q, %v", s, err)
+	}
+}
+
+func TestRun_Ngrok_Prompt_DontSave(t *testing.T) {
+	isolateEnv(t)
+	isolateCredentials(t)
+	stubNgrok(t)
+	feedStdin(t, "tok-none\n4\n")
+
+	if err := Run(Options{Tunnel: true}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if _, _, err := LookupCredential(NgrokCredential); !errors.Is(err, ErrCredentialNotFound) {
+		t.Fatalf("expected nothing stored, got %v", err)
+	}
+	if _, err := os.Stat(".env"); !os.IsNotExist(err) {
+		t.Fatalf("expected no .env to be written, got %v", err)
+	}
+}
@@ -0,0 +1,386 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Change kinds in a CaptureDiff.
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// Change is one difference between two captures. Path is "request.method",
+// "header.<Name>" or "body.<json path>"; Old and New are display values.
+type Change struct {
+	Path string
+	Kind string
+	Old  string
+	New  string
+}
+
+// CaptureDiff compares two captures. JSON bodies are compared structurally; other
+// bodies line by line in BodyLines ("-", "+" prefixed).
+type CaptureDiff struct {
+	Changes   []Change
+	BodyLines []string
+	// Ignored counts differences hidden by ignore patterns.
+	Ignored int
+}
+
+// DefaultDiffIgnores match fields that differ between any two deliveries.
+var DefaultDiffIgnores = []string{
+	"header.Date", "header.Content-Length", "header.X-Forwarded-For",
+	"header.X-GitHub-Delivery", "header.X-Hub-Signature", "header.X-Hub-Signature-256",
+	"header.Stripe-Signature",
+	"header.X-Slack-Signature", "header.X-Slack-Request-Timestamp",
+	"header.X-Shopify-Hmac-Sha256", "header.X-Shopify-Webhook-Id", "header.X-Shopify-Event-Id", "header.X-Shopify-Triggered-At",
+	"body.**.created", "body.**.created_at", "body.**.updated_at", "body.**.timestamp",
+	"body.**.event_id", "body.**.event_time", "body.**.event_ts",
+}
+
+// DiffCaptures compares a with b, hiding changes whose path matches an ignore
+// pattern. Patterns are dotted paths where * matches one segment and ** any number;
+// array elements are segments too, so "body.items.*.price" matches items[0].price.
+func DiffCaptures(a, b *Capture, ignore []string) *CaptureDiff {
+	d := &CaptureDiff{}
+	add := func(c Change) {
+		for _, p := range ignore {
+			if matchDiffPath(p, c.Path) {
+				d.Ignored++
+				return
+			}
+		}
+		d.Changes = append(d.Changes, c)
+	}
+
+	if a.Method != b.Method {
+		add(Change{Path: "request.method", Kind: ChangeChanged, Old: a.Method, New: b.Method})
+	}
+	if a.Path != b.Path {
+		add(Change{Path: "request.path", Kind: ChangeChanged, Old: a.Path, New: b.Path})
+	}
+	for _, c := range diffHeaders(a.Header, b.Header) {
+		add(c)
+	}
+
+	var ja, jb any
+	if errA, errB := decodeJSONBody(a.Body, &ja), decodeJSONBody(b.Body, &jb); errA == nil && errB == nil {
+		diffJSON("body", ja, jb, add)
+	} else if !bytes.Equal(a.Body, b.Body) {
+		d.BodyLines = diffLines(string(a.Body), string(b.Body))
+	}
+	return d
+}
+
+// diffHeaders compares header sets; repeated values are joined.
+func diffHeaders(a, b http.Header) []Change {
+	names := map[string]bool{}
+	for name := range a {
+		names[http.CanonicalHeaderKey(name)] = true
+	}
+	for name := range b {
+		names[http.CanonicalHeaderKey(name)] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	var changes []Change
+	for _, name := range sorted {
+		va, inA := a[name]
+		vb, inB := b[name]
+		was, now := strings.Join(va, ", "), strings.Join(vb, ", ")
+		switch {
+		case !inA:
+			changes = append(changes, Change{Path: "header." + name, Kind: ChangeAdded, New: now})
+		case !inB:
+			changes = append(changes, Change{Path: "header." + name, Kind: ChangeRemoved, Old: was})
+		case was != now:
+			changes = append(changes, Change{Path: "header." + name, Kind: ChangeChanged, Old: was, New: now})
+		}
+	}
+	return changes
+}
+
+func decodeJSONBody(body []byte, v *any) error {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return errors.New("empty body")
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("trailing data")
+	}
+	return nil
+}
+
+// diffJSON walks two decoded JSON values and reports differing leaves and subtrees.
+func diffJSON(path string, a, b any, add func(Change)) {
+	switch av := a.(type) {
+	case map[string]any:
+		if bv, ok := b.(map[string]any); ok {
+			keys := make([]string, 0, len(av)+len(bv))
+			for k := range av {
+				keys = append(keys, k)
+			}
+			for k := range bv {
+				if _, ok := av[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				x, inA := av[k]
+				y, inB := bv[k]
+				p := path + "." + k
+				switch {
+				case !inA:
+					add(Change{Path: p, Kind: ChangeAdded, New: jsonValue(y)})
+				case !inB:
+					add(Change{Path: p, Kind: ChangeRemoved, Old: jsonValue(x)})
+				default:
+					diffJSON(p, x, y, add)
+				}
+			}
+			return
+		}
+	case []any:
+		if bv, ok := b.([]any); ok {
+			for i := 0; i < len(av) || i < len(bv); i++ {
+				p := path + "[" + strconv.Itoa(i) + "]"
+				switch {
+				case i >= len(av):
+					add(Change{Path: p, Kind: ChangeAdded, New: jsonValue(bv[i])})
+				case i >= len(bv):
+					add(Change{Path: p, Kind: ChangeRemoved, Old: jsonValue(av[i])})
+				default:
+					diffJSON(p, av[i], bv[i], add)
+				}
+			}
+			return
+		}
+	}
+	if x, y := jsonValue(a), jsonValue(b); x != y {
+		add(Change{Path: path, Kind: ChangeChanged, Old: x, New: y})
+	}
+}
+
+// diffValueMax truncates long values in the report.
+const diffValueMax = 120
+
+func jsonValue(v any) string {
+	b, _ := json.Marshal(v)
+	if len(b) > diffValueMax {
+		return string(b[:diffValueMax]) + "…"
+	}
+	return string(b)
+}
+
+// splitDiffPath splits "body.a[0].b" into body, a, 0, b.
+func splitDiffPath(p string) []string {
+	p = strings.NewReplacer("[", ".", "]", "").Replace(p)
+	return strings.Split(p, ".")
+}
+
+// matchDiffPath reports whether path matches pattern; see DiffCaptures. Header
+// names match case-insensitively.
+func matchDiffPath(pattern, path string) bool {
+	pat, segs := splitDiffPath(pattern), splitDiffPath(path)
+	fold := len(segs) > 0 && segs[0] == "header"
+	var match func(pi, si int) bool
+	match = func(pi, si int) bool {
+		if pi == len(pat) {
+			return si == len(segs)
+		}
+		if pat[pi] == "**" {
+			for k := si; k <= len(segs); k++ {
+				if match(pi+1, k) {
+					return true
+				}
+			}
+			return false
+		}
+		if si == len(segs) {
+			return false
+		}
+		ok := pat[pi] == "*" || pat[pi] == segs[si] || (fold && strings.EqualFold(pat[pi], segs[si]))
+		return ok && match(pi+1, si+1)
+	}
+	return match(0, 0)
+}
+
+// diffLineMax bounds the line diff; larger bodies are only reported as different.
+const diffLineMax = 2000
+
+// diffLines returns a minimal line diff of a and b: "-" lines only in a, "+" lines
+// only in b.
+func diffLines(a, b string) []string {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+	if len(x) > diffLineMax || len(y) > diffLineMax {
+		return []string{fmt.Sprintf("~ bodies differ (%d vs %d bytes, too long to diff by line)", len(a), len(b))}
+	}
+	// lcs[i][j] is the longest common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var out []string
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			out = append(out, fmt.Sprintf("- %d: %s", i+1, x[i]))
+			i++
+		default:
+			out = append(out, fmt.Sprintf("+ %d: %s", j+1, y[j]))
+			j++
+		}
+	}
+	return out
+}
+
+// Print writes the diff grouped into request, headers and body sections.
+func (d *CaptureDiff) Print(w io.Writer) {
+	sections := []struct{ title, prefix string }{{"Request", "request."}, {"Headers", "header."}, {"Body", "body"}}
+	empty := true
+	for _, s := range sections {
+		var lines []string
+		for _, c := range d.Changes {
+			if !strings.HasPrefix(c.Path, s.prefix) {
+				continue
+			}
+			name := strings.TrimPrefix(strings.TrimPrefix(c.Path, s.prefix), ".")
+			if name == "" {
+				name = "(root)"
+			}
+			switch c.Kind {
+			case ChangeAdded:
+				lines = append(lines, fmt.Sprintf("  %s+ %s: %s%s", colorGreen, name, c.New, colorReset))
+			case ChangeRemoved:
+				lines = append(lines, fmt.Sprintf("  %s- %s: %s%s", colorRed, name, c.Old, colorReset))
+			default:
+				lines = append(lines, fmt.Sprintf("  %s~ %s: %s → %s%s", colorYellow, name, c.Old, c.New, colorReset))
+			}
+		}
+		if s.prefix == "body" {
+			for _, l := range d.BodyLines {
+				color := colorYellow
+				if strings.HasPrefix(l, "+") {
+					color = colorGreen
+				} else if strings.HasPrefix(l, "-") {
+					color = colorRed
+				}
+				lines = append(lines, "  "+color+l+colorReset)
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		empty = false
+		fmt.Fprintf(w, "%s%s:%s\n", colorBold, s.title, colorReset)
+		for _, l := range lines {
+			fmt.Fprintln(w, l)
+		}
+	}
+	if empty {
+		fmt.Fprintln(w, "No differences.")
+	}
+	if d.Ignored > 0 {
+		fmt.Fprintf(w, "%d volatile difference(s) ignored; use -no-ignore to show them.\n", d.Ignored)
+	}
+}
+
+// diffUsage is printed for `diff` without two capture IDs.
+const diffUsage = `usage: webhook-catcher diff [-captures DIR] [-ignore PATTERN] [-no-ignore] ID1 ID2
+
+IDs are capture IDs or "last". Differences in timestamps, delivery IDs and
+signatures are ignored; -ignore adds dotted path patterns such as
+"body.data.object.id", "body.**.token" or "header.X-Request-Id".`
+
+// DiffCommand implements `diff`: compare two stored captures.
+func DiffCommand(args []string) error {
+	return diffCommand(args, os.Stdout)
+}
+
+func diffCommand(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.SetOutput(out)
+	dir := fs.String("captures", os.Getenv(EnvName("capture-dir")), "capture directory (default $"+EnvName("capture-dir")+")")
+	var ignore ListFlag
+	fs.Var(&ignore, "ignore", "path pattern to ignore (repeatable)")
+	noIgnore := fs.Bool("no-ignore", false, "report every difference, including the default volatile fields")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 || *dir == "" {
+		return errors.New(diffUsage)
+	}
+	captures, err := captureIndex(*dir)
+	if err != nil {
+		return err
+	}
+	var pair [2]*Capture
+	for i, id := range fs.Args() {
+		c, ok := captures[id]
+		if !ok {
+			return fmt.Errorf("no capture %q in %s", id, *dir)
+		}
+		pair[i] = c
+	}
+
+	patterns := []string(ignore)
+	if !*noIgnore {
+		patterns = append(append([]string(nil), DefaultDiffIgnores...), patterns...)
+	}
+	for i, c := range pair {
+		fmt.Fprintf(out, "%s %s %s %s (%s)\n", [2]string{"---", "+++"}[i], c.ID, c.Method, c.Path, c.Time.Format("2006-01-02 15:04:05"))
+	}
+	DiffCaptures(pair[0], pair[1], patterns).Print(out)
+	return nil
+}
+
+// captureIndex loads the captures in dir keyed by ID, plus "last".
+func captureIndex(dir string) (map[string]*Capture, error) {
+	captures, err := ReadCaptures(dir)
+	if err != nil {
+		return nil, err
+	}
+	byID := map[string]*Capture{}
+	for _, c := range captures {
+		byID[c.ID] = c
+	}
+	if len(captures) > 0 {
+		byID["last"] = captures[len(captures)-1]
+	}
+	return byID, nil
+}
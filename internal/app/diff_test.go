@@ -0,0 +1,131 @@
+package app
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchDiffPath(t *testing.T) {
+	cases := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"body.id", "body.id", true},
+		{"body.id", "body.data.id", false},
+		{"body.**.id", "body.id", true},
+		{"body.**.id", "body.data.object.id", true},
+		{"body.items.*.price", "body.items[3].price", true},
+		{"body.items[*].price", "body.items[3].price", true},
+		{"body.items.*.price", "body.items[3].qty", false},
+		{"header.x-github-delivery", "header.X-Github-Delivery", true},
+		{"body.ID", "body.id", false},
+		{"**", "body.anything[0].at.all", true},
+	}
+	for _, c := range cases {
+		if got := matchDiffPath(c.pattern, c.path); got != c.want {
+			t.Fatalf("matchDiffPath(%q, %q) = %v, want %v", c.pattern, c.path, got, c.want)
+		}
+	}
+}
+
+func TestDiffCaptures_JSON(t *testing.T) {
+	a := &Capture{Method: "POST", Path: "/hooks", Header: http.Header{
+		"Content-Type":      {"application/json"},
+		"X-Github-Delivery": {"1"},
+		"X-Old":             {"x"},
+	}, Body: []byte(`{"id":1,"created_at":"t1","items":[{"price":5},{"price":6}],"meta":{"a":true},"note":null}`)}
+	b := &Capture{Method: "PUT", Path: "/hooks", Header: http.Header{
+		"Content-Type":      {"application/json; charset=utf-8"},
+		"X-Github-Delivery": {"2"},
+		"X-New":             {"y"},
+	}, Body: []byte(`{"id":1,"created_at":"t2","items":[{"price":7}],"meta":"gone","extra":[1]}`)}
+
+	d := DiffCaptures(a, b, DefaultDiffIgnores)
+	got := map[string]Change{}
+	for _, c := range d.Changes {
+		got[c.Path] = c
+	}
+	want := map[string]Change{
+		"request.method":      {Path: "request.method", Kind: ChangeChanged, Old: "POST", New: "PUT"},
+		"header.Content-Type": {Path: "header.Content-Type", Kind: ChangeChanged, Old: "application/json", New: "application/json; charset=utf-8"},
+		"header.X-New":        {Path: "header.X-New", Kind: ChangeAdded, New: "y"},
+		"header.X-Old":        {Path: "header.X-Old", Kind: ChangeRemoved, Old: "x"},
+		"body.items[0].price": {Path: "body.items[0].price", Kind: ChangeChanged, Old: "5", New: "7"},
+		"body.items[1]":       {Path: "body.items[1]", Kind: ChangeRemoved, Old: `{"price":6}`},
+		"body.meta":           {Path: "body.meta", Kind: ChangeChanged, Old: `{"a":true}`, New: `"gone"`},
+		"body.note":           {Path: "body.note", Kind: ChangeRemoved, Old: "null"},
+		"body.extra":          {Path: "body.extra", Kind: ChangeAdded, New: "[1]"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected changes:\n got %+v\nwant %+v", got, want)
+	}
+	if d.Ignored != 2 || d.BodyLines != nil {
+		t.Fatalf("expected delivery ID and created_at to be ignored, got ignored=%d lines=%v", d.Ignored, d.BodyLines)
+	}
+
+	if d := DiffCaptures(a, b, nil); d.Ignored != 0 || len(d.Changes) != 11 {
+		t.Fatalf("expected every change without patterns, got %d (%d ignored)", len(d.Changes), d.Ignored)
+	}
+	if d := DiffCaptures(a, a, DefaultDiffIgnores); len(d.Changes) != 0 {
+		t.Fatalf("expected no changes comparing a capture with itself, got %+v", d.Changes)
+	}
+}
+
+func TestDiffCaptures_Lines(t *testing.T) {
+	a := &Capture{Header: http.Header{}, Body: []byte("a=1\nb=2\nc=3")}
+	b := &Capture{Header: http.Header{}, Body: []byte("a=1\nb=20\nc=3\nd=4")}
+	d := DiffCaptures(a, b, nil)
+	want := []string{"- 2: b=2", "+ 2: b=20", "+ 4: d=4"}
+	if !reflect.DeepEqual(d.BodyLines, want) {
+		t.Fatalf("got %q, want %q", d.BodyLines, want)
+	}
+	// JSON against non-JSON falls back to lines too
+	if d := DiffCaptures(&Capture{Body: []byte(`{"a":1}`)}, &Capture{Body: []byte("a=1")}, nil); len(d.BodyLines) != 2 {
+		t.Fatalf("expected line diff, got %+v", d)
+	}
+}
+
+func TestDiffCommand(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Date(2024, 6, 10, 16, 0, 0, 0, time.UTC)
+	storedCapture(t, dir, &Capture{ID: "ok1", Time: at, Method: "POST", Path: "/stripe", Header: http.Header{"Stripe-Signature": {"t=1,v1=aa"}}, Body: []byte(`{"type":"invoice.paid","data":{"amount":2000}}`)})
+	storedCapture(t, dir, &Capture{ID: "bad2", Time: at, Method: "POST", Path: "/stripe", Header: http.Header{"Stripe-Signature": {"t=2,v1=bb"}}, Body: []byte(`{"type":"invoice.paid","data":{"amount":"2000"}}`)})
+
+	var out bytes.Buffer
+	if err := diffCommand([]string{"-captures", dir, "ok1", "last"}, &out); err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	text := stripANSI(out.String())
+	for _, want := range []string{"--- ok1 POST /stripe (2024-06-10 16:00:00)", "+++ bad2 POST /stripe", "Body:\n  ~ data.amount: 2000 → \"2000\"", "1 volatile difference(s) ignored"} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("expected output to contain %q, got:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "Headers:") {
+		t.Fatalf("signature change should be ignored, got:\n%s", text)
+	}
+
+	out.Reset()
+	t.Setenv(EnvName("capture-dir"), dir)
+	if err := diffCommand([]string{"-no-ignore", "-ignore", "body.data.*", "ok1", "bad2"}, &out); err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	if text := stripANSI(out.String()); !strings.Contains(text, "~ Stripe-Signature: t=1,v1=aa → t=2,v1=bb") || strings.Contains(text, "data.amount") {
+		t.Fatalf("unexpected output:\n%s", text)
+	}
+
+	out.Reset()
+	_ = diffCommand([]string{"ok1", "ok1"}, &out)
+	if !strings.Contains(out.String(), "No differences.") {
+		t.Fatalf("expected no differences, got:\n%s", out.String())
+	}
+	for args, want := range map[string]string{"ok1": "usage:", "ok1 zz": "no capture"} {
+		if err := diffCommand(strings.Fields(args), &out); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("diff %q: expected error containing %q, got %v", args, want, err)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// DotEnv is a parsed .env file that keeps every line, so it can be written back with
+// comments, ordering and formatting intact.
+//
+// Supported syntax:
+//
+//	# comment
+//	KEY=value                  unquoted; " #" starts an inline comment
+//	export KEY=value           "export " prefix is accepted and preserved
+//	KEY='literal $NOT_EXPANDED'
+//	KEY="escapes \n \t \" \\ \$ and ${VAR} / $VAR / ${VAR:-default}"
+//	KEY="values may
+//	span lines"
+//
+// ${VAR} interpolation applies to unquoted and double-quoted values and sees keys
+// defined earlier in the file, then the process environment.
+type DotEnv struct {
+	nodes           []*dotenvNode
+	crlf            bool
+	trailingNewline bool
+}
+
+// dotenvNode is one logical line: a comment, blank or ignored line, or an entry.
+// For entries raw == prefix + quote + inner + quote + suffix.
+type dotenvNode struct {
+	raw    string
+	key    string // "" for non-entries
+	prefix string // indentation, export, key, '=' and spaces before the value
+	quote  byte   // '\'', '"' or 0 for unquoted values
+	inner  string // value text between the quotes, before escapes and interpolation
+	suffix string // whitespace and inline comment after the value
+}
+
+var (
+	dotenvKeyRe      = regexp.MustCompile(`^\s*(?:export\s+)?([A-Za-z_][A-Za-z0-9_.]*)\s*=`)
+	dotenvCommentRe  = regexp.MustCompile(`^\s*(#.*)?$`)
+	dotenvSafeValRe  = regexp.MustCompile(`^[A-Za-z0-9_./:@%+,=-]*$`)
+	dotenvVarNameRe  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*`)
+	errUnterminated  = errors.New("unterminated quoted value")
+	errTrailingChars = errors.New("unexpected characters after quoted value")
+)
+
+// ParseDotEnv parses .env content. Lines that are not comments and have no '=' are
+// kept verbatim and ignored.
+func ParseDotEnv(data string) (*DotEnv, error) {
+	doc := &DotEnv{}
+	if strings.Contains(data, "\r\n") {
+		doc.crlf = true
+		data = strings.ReplaceAll(data, "\r\n", "\n")
+	}
+	doc.trailingNewline = strings.HasSuffix(data, "\n")
+	data = strings.TrimSuffix(data, "\n")
+	if data == "" {
+		return doc, nil
+	}
+	lines := strings.Split(data, "\n")
+	for i := 0; i < len(lines); {
+		n, consumed, err := parseDotEnvNode(lines[i:])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		doc.nodes = append(doc.nodes, n)
+		i += consumed
+	}
+	return doc, nil
+}
+
+// parseDotEnvNode parses the node starting at lines[0] and reports how many lines it used.
+func parseDotEnvNode(lines []string) (*dotenvNode, int, error) {
+	line := lines[0]
+	m := dotenvKeyRe.FindStringSubmatchIndex(line)
+	if m == nil {
+		return &dotenvNode{raw: line}, 1, nil
+	}
+	n := &dotenvNode{key: line[m[2]:m[3]]}
+	rest := line[m[1]:]
+	lead := len(rest) - len(strings.TrimLeft(rest, " \t"))
+	n.prefix = line[:m[1]+lead]
+	rest = rest[lead:]
+
+	if rest == "" || (rest[0] != '\'' && rest[0] != '"') {
+		// Unquoted: value runs to an inline comment or end of line
+		value, suffix := rest, ""
+		if strings.HasPrefix(rest, "#") {
+			value, suffix = "", rest
+		} else if i := inlineCommentIndex(rest); i >= 0 {
+			value, suffix = rest[:i], rest[i:]
+		}
+		trimmed := strings.TrimRight(value, " \t")
+		n.inner, n.suffix = trimmed, value[len(trimmed):]+suffix
+		n.raw = line
+		return n, 1, nil
+	}
+
+	// Quoted: find the closing quote, possibly on a later line
+	n.quote = rest[0]
+	text := rest[1:]
+	consumed := 1
+	for {
+		if end := closingQuoteIndex(text, n.quote); end >= 0 {
+			n.inner, n.suffix = text[:end], text[end+1:]
+			break
+		}
+		if consumed == len(lines) {
+			return nil, 0, errUnterminated
+		}
+		text += "\n" + lines[consumed]
+		consumed++
+	}
+	if !dotenvCommentRe.MatchString(n.suffix) {
+		return nil, 0, errTrailingChars
+	}
+	n.raw = n.prefix + string(n.quote) + n.inner + string(n.quote) + n.suffix
+	return n, consumed, nil
+}
+
+// inlineCommentIndex returns the index of the whitespace that starts a " #" comment, or -1.
+func inlineCommentIndex(s string) int {
+	for i := 1; i < len(s); i++ {
+		if s[i] == '#' && (s[i-1] == ' ' || s[i-1] == '\t') {
+			j := i - 1
+			for j > 0 && (s[j-1] == ' ' || s[j-1] == '\t') {
+				j--
+			}
+			return j
+		}
+	}
+	return -1
+}
+
+// closingQuoteIndex finds the closing quote in s; double quotes honour backslash escapes.
+func closingQuoteIndex(s string, quote byte) int {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case s[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// value resolves the node's value, expanding variables through lookup.
+func (n *dotenvNode) value(lookup func(string) (string, bool)) string {
+	switch n.quote {
+	case '\'':
+		return n.inner
+	case '"':
+		return expandDotEnv(n.inner, true, lookup)
+	default:
+		return expandDotEnv(n.inner, false, lookup)
+	}
+}
+
+// expandDotEnv interpolates $VAR, ${VAR} and ${VAR:-default}, and with escapes set
+// also handles \n, \r, \t and backslash-escaped characters such as \" and \$.
+func expandDotEnv(s string, escapes bool, lookup func(string) (string, bool)) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escapes && c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(s[i])
+			}
+		case c == '$' && i+1 < len(s) && s[i+1] == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				b.WriteString(s[i:])
+				return b.String()
+			}
+			expr := s[i+2 : i+end]
+			name, def, hasDef := strings.Cut(expr, ":-")
+			if v, ok := lookup(name); ok && (v != "" || !hasDef) {
+				b.WriteString(v)
+			} else {
+				b.WriteString(def)
+			}
+			i += end
+		case c == '$':
+			name := dotenvVarNameRe.FindString(s[i+1:])
+			if name == "" {
+				b.WriteByte(c)
+				continue
+			}
+			v, _ := lookup(name)
+			b.WriteString(v)
+			i += len(name)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Vars resolves every entry in file order. Keys for which skip returns true keep
+// their current value from lookup and are not returned.
+func (d *DotEnv) Vars(lookup func(string) (string, bool), skip func(key string) bool) [][2]string {
+	defined := map[string]string{}
+	resolve := func(name string) (string, bool) {
+		if v, ok := defined[name]; ok {
+			return v, true
+		}
+		return lookup(name)
+	}
+	var out [][2]string
+	for _, n := range d.nodes {
+		if n.key == "" {
+			continue
+		}
+		if skip != nil && skip(n.key) {
+			if v, ok := lookup(n.key); ok {
+				defined[n.key] = v
+			}
+			continue
+		}
+		v := n.value(resolve)
+		defined[n.key] = v
+		out = append(out, [2]string{n.key, v})
+	}
+	return out
+}
+
+// Set updates every entry for key in place, keeping any export prefix and inline
+// comment, or appends a new entry when key is absent.
+func (d *DotEnv) Set(key, value string) {
+	found := false
+	for _, n := range d.nodes {
+		if n.key == key {
+			n.setValue(value)
+			found = true
+		}
+	}
+	if !found {
+		n := &dotenvNode{key: key, prefix: key + "="}
+		n.setValue(value)
+		d.nodes = append(d.nodes, n)
+	}
+}
+
+// setValue replaces the node's value and re-renders its raw text.
+func (n *dotenvNode) setValue(value string) {
+	n.inner, n.quote = quoteDotEnv(value)
+	if strings.HasPrefix(n.suffix, "#") {
+		// Was an empty value followed directly by a comment
+		n.suffix = " " + n.suffix
+	}
+	q := ""
+	if n.quote != 0 {
+		q = string(n.quote)
+	}
+	n.raw = n.prefix + q + n.inner + q + n.suffix
+}
+
+// Unset removes every entry for key. Comments are left in place.
+func (d *DotEnv) Unset(key string) {
+	kept := d.nodes[:0]
+	for _, n := range d.nodes {
+		if n.key != key {
+			kept = append(kept, n)
+		}
+	}
+	d.nodes = kept
+}
+
+// String renders the document, preserving the original line endings.
+func (d *DotEnv) String() string {
+	raws := make([]string, len(d.nodes))
+	for i, n := range d.nodes {
+		raws[i] = n.raw
+	}
+	out := strings.Join(raws, "\n")
+	if len(d.nodes) > 0 && d.trailingNewline {
+		out += "\n"
+	}
+	if d.crlf {
+		out = strings.ReplaceAll(out, "\n", "\r\n")
+	}
+	return out
+}
+
+// quoteDotEnv returns the text to write for value and the quote to wrap it in.
+func quoteDotEnv(value string) (string, byte) {
+	if dotenvSafeValRe.MatchString(value) {
+		return value, 0
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`, "$", `\$`)
+	return r.Replace(value), '"'
+}
+
+// LoadDotEnvFile sets environment variables from a .env file. Variables that are
+// already set in the environment are kept unless override is true.
+func LoadDotEnvFile(path string, override bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	doc, err := ParseDotEnv(string(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	skip := func(key string) bool {
+		if override {
+			return false
+		}
+		_, set := os.LookupEnv(key)
+		return set
+	}
+	// Keys repeated in the file are all returned, so the last one wins
+	for _, kv := range doc.Vars(os.LookupEnv, skip) {
+		if err := os.Setenv(kv[0], kv[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadDotEnv loads path without overriding existing variables. A missing file is not an error.
+func LoadDotEnv(path string) {
+	if err := LoadDotEnvFile(path, false); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("%s[WARN]%s failed to load %s: %v", colorYellow, colorReset, path, err)
+	}
+}
+
+// SaveEnvVar upserts KEY=VALUE into the given .env file, keeping the rest of the file intact.
+func SaveEnvVar(path, key, value string) error {
+	doc := &DotEnv{trailingNewline: true}
+	if b, err := os.ReadFile(path); err == nil {
+		if doc, err = ParseDotEnv(string(b)); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	doc.Set(key, value)
+	return os.WriteFile(path, []byte(doc.String()), 0600)
+}
@@ -0,0 +1,181 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func parseVars(t *testing.T, content string, env map[string]string) map[string]string {
+	t.Helper()
+	doc, err := ParseDotEnv(content)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	lookup := func(k string) (string, bool) { v, ok := env[k]; return v, ok }
+	out := map[string]string{}
+	for _, kv := range doc.Vars(lookup, nil) {
+		out[kv[0]] = kv[1]
+	}
+	return out
+}
+
+func TestParseDotEnv_Syntax(t *testing.T) {
+	content := "" +
+		"# leading comment\n" +
+		"export EXPORTED=yes\n" +
+		"PLAIN=value # inline comment\n" +
+		"HASH=a#b\n" +
+		"EMPTY=\n" +
+		"EMPTY_COMMENT= # nothing here\n" +
+		"SINGLE='literal $HOME \\n # kept'\n" +
+		"DOUBLE=\"tab\\there \\\"quoted\\\" \\$HOME\" # comment\n" +
+		"MULTI=\"line one\n" +
+		"line two\"\n" +
+		"SINGLE_MULTI='a\n" +
+		"b'\n" +
+		"BASE=https://api.example.com\n" +
+		"URL=${BASE}/hooks\n" +
+		"SHORT=$BASE/short\n" +
+		"FROM_ENV=\"${HOME_DIR}/x\"\n" +
+		"DEFAULTED=${MISSING:-fallback}\n" +
+		"NOT_EXPANDED='${BASE}'\n" +
+		"  INDENTED = spaced value  \n" +
+		"BADLINE\n"
+	got := parseVars(t, content, map[string]string{"HOME_DIR": "/home/me"})
+
+	want := map[string]string{
+		"EXPORTED":      "yes",
+		"PLAIN":         "value",
+		"HASH":          "a#b",
+		"EMPTY":         "",
+		"EMPTY_COMMENT": "",
+		"SINGLE":        `literal $HOME \n # kept`,
+		"DOUBLE":        "tab\there \"quoted\" $HOME",
+		"MULTI":         "line one\nline two",
+		"SINGLE_MULTI":  "a\nb",
+		"BASE":          "https://api.example.com",
+		"URL":           "https://api.example.com/hooks",
+		"SHORT":         "https://api.example.com/short",
+		"FROM_ENV":      "/home/me/x",
+		"DEFAULTED":     "fallback",
+		"NOT_EXPANDED":  "${BASE}",
+		"INDENTED":      "spaced value",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s: got %q, want %q", k, got[k], v)
+		}
+	}
+	if _, ok := got["BADLINE"]; ok {
+		t.Errorf("expected line without '=' to be ignored")
+	}
+	if len(got) != len(want) {
+		t.Errorf("unexpected keys: %v", got)
+	}
+}
+
+func TestParseDotEnv_Errors(t *testing.T) {
+	if _, err := ParseDotEnv("A=1\nB=\"never closed\nC=3\n"); err == nil || !strings.Contains(err.Error(), "line 2: unterminated") {
+		t.Fatalf("expected unterminated error on line 2, got %v", err)
+	}
+	if _, err := ParseDotEnv("A='x' trailing\n"); err == nil || !strings.Contains(err.Error(), "unexpected characters") {
+		t.Fatalf("expected trailing characters error, got %v", err)
+	}
+}
+
+func TestDotEnv_RoundTripPreservesFormatting(t *testing.T) {
+	content := "# header\r\n\r\nexport TOKEN=old # keep me\r\nOTHER='x'\r\nMULTI=\"a\r\nb\"\r\n"
+	doc, err := ParseDotEnv(content)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if doc.String() != content {
+		t.Fatalf("round trip changed content:\n%q\n%q", doc.String(), content)
+	}
+
+	doc.Set("TOKEN", "new value $x")
+	doc.Set("ADDED", "plain")
+	want := "# header\r\n\r\nexport TOKEN=\"new value \\$x\" # keep me\r\nOTHER='x'\r\nMULTI=\"a\r\nb\"\r\nADDED=plain\r\n"
+	if doc.String() != want {
+		t.Fatalf("unexpected output:\n%q\nwant\n%q", doc.String(), want)
+	}
+
+	// The written value reads back unchanged
+	again, err := ParseDotEnv(doc.String())
+	if err != nil {
+		t.Fatalf("reparse: %v", err)
+	}
+	for _, kv := range again.Vars(func(string) (string, bool) { return "", false }, nil) {
+		if kv[0] == "TOKEN" && kv[1] != "new value $x" {
+			t.Fatalf("expected escaped value to round trip, got %q", kv[1])
+		}
+	}
+
+	doc.Unset("OTHER")
+	if strings.Contains(doc.String(), "OTHER") {
+		t.Fatalf("expected OTHER to be removed, got %q", doc.String())
+	}
+}
+
+func TestDotEnv_SetEmptyValueWithComment(t *testing.T) {
+	doc, _ := ParseDotEnv("KEY= # fill me in\n")
+	doc.Set("KEY", "v")
+	if doc.String() != "KEY= v # fill me in\n" {
+		t.Fatalf("unexpected output %q", doc.String())
+	}
+}
+
+func TestLoadDotEnvFile_NoOverride(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	_ = os.WriteFile(path, []byte("DOTENV_KEEP=file\nDOTENV_NEW=file\nDOTENV_REF=${DOTENV_KEEP}-ref\nDOTENV_DUP=first\nDOTENV_DUP=second\n"), 0600)
+	t.Setenv("DOTENV_KEEP", "env")
+	for _, k := range []string{"DOTENV_NEW", "DOTENV_REF", "DOTENV_DUP"} {
+		t.Setenv(k, "")
+		_ = os.Unsetenv(k)
+	}
+
+	if err := LoadDotEnvFile(path, false); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if os.Getenv("DOTENV_KEEP") != "env" {
+		t.Fatalf("expected existing variable to be kept, got %q", os.Getenv("DOTENV_KEEP"))
+	}
+	if os.Getenv("DOTENV_NEW") != "file" {
+		t.Fatalf("expected new variable to be set, got %q", os.Getenv("DOTENV_NEW"))
+	}
+	if os.Getenv("DOTENV_REF") != "env-ref" {
+		t.Fatalf("expected interpolation to use the effective value, got %q", os.Getenv("DOTENV_REF"))
+	}
+	if os.Getenv("DOTENV_DUP") != "second" {
+		t.Fatalf("expected last duplicate to win, got %q", os.Getenv("DOTENV_DUP"))
+	}
+
+	if err := LoadDotEnvFile(path, true); err != nil {
+		t.Fatalf("load override: %v", err)
+	}
+	if os.Getenv("DOTENV_KEEP") != "file" {
+		t.Fatalf("expected override to replace variable, got %q", os.Getenv("DOTENV_KEEP"))
+	}
+}
+
+func TestSaveEnvVar_PreservesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	orig := "# ngrok\nexport NGROK_AUTHTOKEN=old # from dashboard\n\n# other\nPORT=3000\n"
+	_ = os.WriteFile(path, []byte(orig), 0600)
+
+	if err := SaveEnvVar(path, "NGROK_AUTHTOKEN", "new"); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	b, _ := os.ReadFile(path)
+	want := "# ngrok\nexport NGROK_AUTHTOKEN=new # from dashboard\n\n# other\nPORT=3000\n"
+	if string(b) != want {
+		t.Fatalf("unexpected file:\n%q\nwant\n%q", b, want)
+	}
+
+	_ = os.WriteFile(path, []byte("BROKEN=\"x\n"), 0600)
+	if err := SaveEnvVar(path, "A", "1"); err == nil {
+		t.Fatalf("expected error instead of rewriting an unparseable file")
+	}
+}
@@ -0,0 +1,401 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultExecTimeout bounds a single hook run.
+	DefaultExecTimeout = 30 * time.Second
+	// DefaultExecConcurrency is how many hooks may run at once.
+	DefaultExecConcurrency = 4
+	// execQueueLimit is how many background runs may wait for a free slot; runs
+	// started beyond that are dropped.
+	execQueueLimit = 64
+)
+
+// ExecOptions run local commands for captured requests.
+type ExecOptions struct {
+	// Commands run for every capture.
+	Commands []string
+	// Rules are "PATTERN=COMMAND" hooks that only run for matching paths; see ParseExecRule.
+	Rules []string
+	// Timeout bounds each run (default DefaultExecTimeout) and Concurrency how many
+	// run at once (default DefaultExecConcurrency).
+	Timeout     time.Duration
+	Concurrency int
+	// Respond answers each request with the output of its first matching hook,
+	// rules before Commands, instead of "ok"; see ParseHookResponse.
+	Respond bool
+}
+
+// ExecHook is a command run through the shell for captures on matching paths.
+type ExecHook struct {
+	// Pattern is a path.Match glob; empty matches every path.
+	Pattern string
+	Command string
+}
+
+// ParseExecRule parses "PATTERN=COMMAND", e.g. "/github/*=make deploy".
+func ParseExecRule(spec string) (ExecHook, error) {
+	pattern, command, ok := strings.Cut(spec, "=")
+	if !ok || pattern == "" || strings.TrimSpace(command) == "" {
+		return ExecHook{}, fmt.Errorf("exec rule %q: want PATTERN=COMMAND", spec)
+	}
+	if _, err := path.Match(pattern, "/"); err != nil {
+		return ExecHook{}, fmt.Errorf("exec rule %q: bad pattern: %w", spec, err)
+	}
+	return ExecHook{Pattern: pattern, Command: command}, nil
+}
+
+// Matches reports whether the hook runs for a request path.
+func (h ExecHook) Matches(p string) bool {
+	if h.Pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(h.Pattern, p)
+	return ok
+}
+
+// ExecResult is what one hook run produced.
+type ExecResult struct {
+	Command  string
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+	Duration time.Duration
+	// Err is set when the command could not run, failed or timed out.
+	Err      error
+	TimedOut bool
+}
+
+// execOutputLimit caps how much of each output stream is kept.
+const execOutputLimit = 1 << 20
+
+// ExecRunner runs hooks with a timeout and a concurrency limit.
+type ExecRunner struct {
+	hooks   []ExecHook
+	timeout time.Duration
+	respond bool
+	slots   chan struct{}
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex
+	pending int // background runs running or waiting for a slot
+	dropped int
+}
+
+// NewExecRunner validates o. It returns nil when o has no hooks.
+func NewExecRunner(o ExecOptions) (*ExecRunner, error) {
+	x := &ExecRunner{timeout: o.Timeout, respond: o.Respond}
+	// Rules come first so the most specific hook answers with Respond
+	for _, spec := range o.Rules {
+		h, err := ParseExecRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		x.hooks = append(x.hooks, h)
+	}
+	for _, c := range o.Commands {
+		if strings.TrimSpace(c) == "" {
+			return nil, errors.New("empty exec command")
+		}
+		x.hooks = append(x.hooks, ExecHook{Command: c})
+	}
+	if x.timeout < 0 || o.Concurrency < 0 {
+		return nil, errors.New("exec timeout and concurrency must be positive")
+	}
+	if x.timeout == 0 {
+		x.timeout = DefaultExecTimeout
+	}
+	n := o.Concurrency
+	if n == 0 {
+		n = DefaultExecConcurrency
+	}
+	x.slots = make(chan struct{}, n)
+	if len(x.hooks) == 0 {
+		if o.Respond {
+			return nil, errors.New("-exec-respond needs -exec or -exec-rule")
+		}
+		return nil, nil
+	}
+	return x, nil
+}
+
+// Describe lists the hooks for the startup log.
+func (x *ExecRunner) Describe() []string {
+	var lines []string
+	for _, h := range x.hooks {
+		where := "every request"
+		if h.Pattern != "" {
+			where = h.Pattern
+		}
+		lines = append(lines, fmt.Sprintf("%s runs `%s`", where, h.Command))
+	}
+	mode := "in the background"
+	if x.respond {
+		mode = "and the first matching hook answers the request"
+	}
+	return append(lines, fmt.Sprintf("up to %d at once, %s each, %s", cap(x.slots), x.timeout, mode))
+}
+
+// responder returns the hook that answers requests for path p, or -1. x may be nil.
+func (x *ExecRunner) responder(p string) int {
+	if x == nil || !x.respond {
+		return -1
+	}
+	if idx := x.matching(p); len(idx) > 0 {
+		return idx[0]
+	}
+	return -1
+}
+
+// matching returns the indexes of the hooks for path p.
+func (x *ExecRunner) matching(p string) []int {
+	var idx []int
+	for i, h := range x.hooks {
+		if h.Matches(p) {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+// Run runs hook i for c and waits for it. The timeout includes waiting for a slot.
+func (x *ExecRunner) Run(ctx context.Context, i int, c *Capture) *ExecResult {
+	h := x.hooks[i]
+	ctx, cancel := context.WithTimeout(ctx, x.timeout)
+	defer cancel()
+	res := &ExecResult{Command: h.Command}
+	select {
+	case x.slots <- struct{}{}:
+		defer func() { <-x.slots }()
+	case <-ctx.Done():
+		res.Err, res.TimedOut = fmt.Errorf("no free slot within %s", x.timeout), true
+		return res
+	}
+
+	cmd := shellCommand(ctx, h.Command)
+	cmd.Env = append(os.Environ(), hookEnv(c)...)
+	var stdout, stderr limitedBuffer
+	stdout.max, stderr.max = execOutputLimit, execOutputLimit
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if c.BodyFile != "" {
+		f, err := os.Open(c.BodyFile)
+		if err != nil {
+			res.Err = err
+			return res
+		}
+		defer f.Close()
+		cmd.Stdin = f
+	} else {
+		cmd.Stdin = bytes.NewReader(c.Body)
+	}
+	// Don't wait forever for grandchildren that keep the output pipes open
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	err := cmd.Run()
+	res.Duration = time.Since(start)
+	res.Stdout, res.Stderr = stdout.Bytes(), stderr.Bytes()
+	if cmd.ProcessState != nil {
+		res.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		res.Err, res.TimedOut = fmt.Errorf("timed out after %s", x.timeout), true
+	case err != nil:
+		res.Err = err
+	}
+	return res
+}
+
+// Start runs the hooks matching c in the background, except skip (-1 for none),
+// and hands each result to report when it finishes. Runs that would queue up
+// behind more than execQueueLimit others are dropped and counted.
+func (x *ExecRunner) Start(c *Capture, skip int, report func(*ExecResult)) {
+	for _, i := range x.matching(c.Path) {
+		if i == skip {
+			continue
+		}
+		x.mu.Lock()
+		if x.pending >= cap(x.slots)+execQueueLimit {
+			x.dropped++
+			x.mu.Unlock()
+			continue
+		}
+		x.pending++
+		x.mu.Unlock()
+		x.wg.Add(1)
+		go func(i int) {
+			defer x.wg.Done()
+			res := x.Run(context.Background(), i, c)
+			x.mu.Lock()
+			x.pending--
+			x.mu.Unlock()
+			report(res)
+		}(i)
+	}
+}
+
+// Dropped returns how many background runs were dropped because too many were queued.
+func (x *ExecRunner) Dropped() int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.dropped
+}
+
+// Wait waits for background hooks to finish.
+func (x *ExecRunner) Wait() {
+	x.wg.Wait()
+}
+
+// hookEnv describes c to a hook: WEBHOOK_CATCHER_ID, _METHOD, _PATH,
+// _REMOTE_ADDR, _BODY_SIZE, _BODY_FILE for spooled bodies, and one
+// WEBHOOK_CATCHER_HEADER_<NAME> per header, e.g. _HEADER_X_GITHUB_EVENT.
+func hookEnv(c *Capture) []string {
+	env := []string{
+		EnvPrefix + "ID=" + c.ID,
+		EnvPrefix + "METHOD=" + c.Method,
+		EnvPrefix + "PATH=" + c.Path,
+		EnvPrefix + "REMOTE_ADDR=" + c.RemoteAddr,
+		EnvPrefix + "BODY_SIZE=" + strconv.FormatInt(c.BodyLen(), 10),
+	}
+	if c.BodyFile != "" {
+		env = append(env, EnvPrefix+"BODY_FILE="+c.BodyFile)
+	}
+	for k, vals := range c.Header {
+		name := strings.ToUpper(strings.ReplaceAll(k, "-", "_"))
+		env = append(env, EnvPrefix+"HEADER_"+name+"="+strings.Join(vals, ", "))
+	}
+	return env
+}
+
+// ParseHookResponse turns a hook's stdout into a response, CGI style: an
+// optional block of "Name: value" header lines, with "Status: 201" setting the
+// status, ends at the first blank line and the rest is the body. Output that does
+// not start with such a block is all body, answered 200.
+func ParseHookResponse(out []byte) (int, http.Header, []byte) {
+	status, header, body := http.StatusOK, http.Header{}, out
+	end := bytes.Index(out, []byte("\n\n"))
+	if crlf := bytes.Index(out, []byte("\r\n\r\n")); crlf >= 0 && (end < 0 || crlf < end) {
+		end = crlf
+	}
+	if end < 0 || !looksLikeHeader(out) {
+		return status, header, body
+	}
+	mime, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(out))).ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return status, header, body
+	}
+	if s := mime.Get("Status"); s != "" {
+		code, _, _ := strings.Cut(s, " ")
+		if n, err := strconv.Atoi(code); err == nil && n >= 100 && n <= 999 {
+			status = n
+		}
+		mime.Del("Status")
+	}
+	body = out[end+2:]
+	if out[end] == '\r' {
+		body = out[end+4:]
+	}
+	return status, http.Header(mime), body
+}
+
+// looksLikeHeader reports whether out starts with a "Name: value" line.
+func looksLikeHeader(out []byte) bool {
+	line, _, _ := bytes.Cut(out, []byte("\n"))
+	name, _, ok := bytes.Cut(line, []byte(":"))
+	if !ok || len(name) == 0 {
+		return false
+	}
+	for _, b := range name {
+		if !(b == '-' || b >= '0' && b <= '9' || b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+// Response turns res into an answer: a timed-out hook is a 504 and a failed one
+// a 502, otherwise stdout is parsed with ParseHookResponse.
+func (res *ExecResult) Response() *Response {
+	if res.Err != nil {
+		status := http.StatusBadGateway
+		if res.TimedOut {
+			status = http.StatusGatewayTimeout
+		}
+		return &Response{Status: status, Header: http.Header{"Content-Type": {"text/plain; charset=utf-8"}}, Body: []byte("exec hook failed: " + res.Err.Error() + "\n")}
+	}
+	status, header, body := ParseHookResponse(res.Stdout)
+	return &Response{Status: status, Header: header, Body: body}
+}
+
+// writeExecResult prints a hook's outcome and output, stderr in yellow. The
+// output is passed through r's detectors, since hooks often echo the body.
+func writeExecResult(out *bytes.Buffer, res *ExecResult, r *Redactor) {
+	switch {
+	case res.Err != nil:
+		fmt.Fprintf(out, "%sExec:%s `%s` %sfailed: %v%s (%s)\n", colorCyan, colorReset, res.Command, colorRed, r.Text(res.Err.Error()), colorReset, res.Duration.Round(time.Millisecond))
+	default:
+		fmt.Fprintf(out, "%sExec:%s `%s` exited 0 in %s\n", colorCyan, colorReset, res.Command, res.Duration.Round(time.Millisecond))
+	}
+	writeIndented(out, res.Stdout, "", r)
+	writeIndented(out, res.Stderr, colorYellow, r)
+}
+
+// writeIndented prints b's lines indented, in color when one is given, after
+// running r's detectors over them.
+func writeIndented(out *bytes.Buffer, b []byte, color string, r *Redactor) {
+	text := r.Text(strings.TrimRight(string(b), "\n"))
+	if text == "" {
+		return
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if color != "" {
+			fmt.Fprintf(out, "  %s%s%s\n", color, line, colorReset)
+		} else {
+			fmt.Fprintf(out, "  %s\n", line)
+		}
+	}
+}
+
+// limitedBuffer keeps the first max bytes written to it and discards the rest.
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
+
+// execHooks runs the session's hooks; nil when there are none.
+var execHooks *ExecRunner
+
+// waitExecHooks lets background hooks finish before the session ends.
+func waitExecHooks() {
+	if x := execHooks; x != nil {
+		x.Wait()
+		if n := x.Dropped(); n > 0 {
+			log.Printf("%s[WARN]%s %d exec hook run(s) dropped: too many were queued", colorYellow, colorReset, n)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseExecRule(t *testing.T) {
+	h, err := ParseExecRule("/github/*=make deploy ENV=dev")
+	if err != nil || h.Pattern != "/github/*" || h.Command != "make deploy ENV=dev" {
+		t.Fatalf("ParseExecRule: %+v %v", h, err)
+	}
+	if !h.Matches("/github/push") || h.Matches("/stripe") || !(ExecHook{Command: "x"}).Matches("/any") {
+		t.Error("unexpected matches")
+	}
+	for _, spec := range []string{"make", "=make", "/x=", "[=make"} {
+		if _, err := ParseExecRule(spec); err == nil {
+			t.Errorf("ParseExecRule(%q): expected error", spec)
+		}
+	}
+}
+
+func TestNewExecRunner(t *testing.T) {
+	if x, err := NewExecRunner(ExecOptions{Timeout: time.Second}); x != nil || err != nil {
+		t.Errorf("no hooks should mean no runner, got %v %v", x, err)
+	}
+	for _, o := range []ExecOptions{{Respond: true}, {Commands: []string{" "}}, {Rules: []string{"bad"}}, {Commands: []string{"true"}, Concurrency: -1}} {
+		if _, err := NewExecRunner(o); err == nil {
+			t.Errorf("expected error for %+v", o)
+		}
+	}
+	x, _ := NewExecRunner(ExecOptions{Commands: []string{"notify"}, Rules: []string{"/ci/*=make test"}, Respond: true})
+	if x.responder("/ci/run") != 0 || x.responder("/") != 1 {
+		t.Error("the matching rule should answer before the catch-all command")
+	}
+	want := []string{"/ci/* runs `make test`", "every request runs `notify`", "up to 4 at once, 30s each, and the first matching hook answers the request"}
+	if got := x.Describe(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Describe() = %q", got)
+	}
+}
+
+func TestExecRunner_Run(t *testing.T) {
+	x, _ := NewExecRunner(ExecOptions{Commands: []string{
+		`printf '%s %s %s %s ' "$WEBHOOK_CATCHER_ID" "$WEBHOOK_CATCHER_METHOD" "$WEBHOOK_CATCHER_PATH" "$WEBHOOK_CATCHER_HEADER_X_GITHUB_EVENT"; cat; echo oops >&2`,
+		`exit 3`,
+	}})
+	c := &Capture{ID: "abc", Method: "POST", Path: "/gh", Header: http.Header{"X-Github-Event": {"push"}}, Body: []byte(`{"n":1}`)}
+	res := x.Run(context.Background(), 0, c)
+	if res.Err != nil || string(res.Stdout) != `abc POST /gh push {"n":1}` || string(res.Stderr) != "oops\n" {
+		t.Fatalf("unexpected result: %+v stdout=%q stderr=%q", res, res.Stdout, res.Stderr)
+	}
+	if res := x.Run(context.Background(), 1, c); res.Err == nil || res.ExitCode != 3 {
+		t.Fatalf("a failing hook should report its exit code: %+v", res)
+	}
+
+	// Spooled bodies are streamed from their file
+	file := filepath.Join(t.TempDir(), "b.body")
+	_ = os.WriteFile(file, []byte("spooled"), 0600)
+	x, _ = NewExecRunner(ExecOptions{Commands: []string{`cat; echo " $WEBHOOK_CATCHER_BODY_SIZE $WEBHOOK_CATCHER_BODY_FILE"`}})
+	res = x.Run(context.Background(), 0, &Capture{BodyFile: file, BodySize: 7})
+	if got := strings.TrimSpace(string(res.Stdout)); got != "spooled 7 "+file {
+		t.Errorf("spooled stdin: %q", got)
+	}
+}
+
+func TestExecRunner_Timeout(t *testing.T) {
+	x, _ := NewExecRunner(ExecOptions{Commands: []string{"sleep 5"}, Timeout: 50 * time.Millisecond})
+	start := time.Now()
+	res := x.Run(context.Background(), 0, &Capture{})
+	if !res.TimedOut || time.Since(start) > 2*time.Second {
+		t.Fatalf("expected a timeout, got %+v after %s", res, time.Since(start))
+	}
+}
+
+func TestExecRunner_Concurrency(t *testing.T) {
+	dir := t.TempDir()
+	// Each run records how many runs are in flight when it starts
+	cmd := `n=$(ls "` + dir + `" | wc -l); touch "` + dir + `/$$"; echo $n; sleep 0.1; rm "` + dir + `/$$"`
+	x, _ := NewExecRunner(ExecOptions{Commands: []string{cmd}, Concurrency: 1})
+	var mu sync.Mutex
+	var seen []string
+	for i := 0; i < 3; i++ {
+		x.Start(&Capture{ID: "c", Path: "/"}, -1, func(res *ExecResult) {
+			mu.Lock()
+			defer mu.Unlock()
+			seen = append(seen, strings.TrimSpace(string(res.Stdout)))
+		})
+	}
+	x.Wait()
+	if !reflect.DeepEqual(seen, []string{"0", "0", "0"}) {
+		t.Errorf("hooks should run one at a time, saw %v others running", seen)
+	}
+}
+
+func TestExecRunner_QueueLimit(t *testing.T) {
+	x, _ := NewExecRunner(ExecOptions{Commands: []string{"sleep 1"}, Concurrency: 1, Timeout: 50 * time.Millisecond})
+	var mu sync.Mutex
+	reported := 0
+	for i := 0; i < execQueueLimit+6; i++ {
+		x.Start(&Capture{Path: "/"}, -1, func(*ExecResult) {
+			mu.Lock()
+			defer mu.Unlock()
+			reported++
+		})
+	}
+	x.Wait()
+	if x.Dropped() != 5 || reported != execQueueLimit+1 {
+		t.Errorf("runs beyond the queue should be dropped: dropped %d, reported %d", x.Dropped(), reported)
+	}
+}
+
+func TestParseHookResponse(t *testing.T) {
+	for _, tc := range []struct {
+		out    string
+		status int
+		header http.Header
+		body   string
+	}{
+		{"hello\n", 200, http.Header{}, "hello\n"},
+		{"Status: 201 Created\nContent-Type: application/json\nX-Build: 7\n\n{\"ok\":true}", 201, http.Header{"Content-Type": {"application/json"}, "X-Build": {"7"}}, `{"ok":true}`},
+		{"Status: 503\r\n\r\nbusy", 503, http.Header{}, "busy"},
+		{"note: no blank line follows", 200, http.Header{}, "note: no blank line follows"},
+		{"two words: not a header\n\nbody", 200, http.Header{}, "two words: not a header\n\nbody"},
+	} {
+		status, header, body := ParseHookResponse([]byte(tc.out))
+		if status != tc.status || !reflect.DeepEqual(header, tc.header) || string(body) != tc.body {
+			t.Errorf("ParseHookResponse(%q) = %d %v %q", tc.out, status, header, body)
+		}
+	}
+}
+
+func TestWebhookHandler_ExecRespond(t *testing.T) {
+	x, err := NewExecRunner(ExecOptions{
+		Rules:   []string{"/build=printf 'Status: 202\\nX-Job: 42\\n\\nqueued %s' \"$(cat)\"", "/fail=echo broken >&2; exit 1"},
+		Respond: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	execHooks = x
+	defer func() { execHooks = nil }()
+
+	w := httptest.NewRecorder()
+	out := stripANSI(captureStdout(func() {
+		WebhookHandler(w, httptest.NewRequest("POST", "/build", strings.NewReader("main")))
+	}))
+	if w.Code != 202 || w.Header().Get("X-Job") != "42" || w.Body.String() != "queued main" {
+		t.Fatalf("status %d header %v body %q", w.Code, w.Header(), w.Body.String())
+	}
+	if !strings.Contains(out, "Exec: `printf") || !strings.Contains(out, "  X-Job: 42") {
+		t.Errorf("the hook's output should be printed under the capture:\n%s", out)
+	}
+
+	w = httptest.NewRecorder()
+	out = stripANSI(captureStdout(func() {
+		WebhookHandler(w, httptest.NewRequest("POST", "/fail", strings.NewReader("")))
+	}))
+	if w.Code != 502 || !strings.Contains(out, "failed: exit status 1") || !strings.Contains(out, "  broken") {
+		t.Errorf("a failing hook should answer 502: %d\n%s", w.Code, out)
+	}
+
+	// Paths without a hook are answered as usual
+	w = httptest.NewRecorder()
+	captureStdout(func() { WebhookHandler(w, httptest.NewRequest("POST", "/other", nil)) })
+	if w.Code != 200 || w.Body.String() != "ok" {
+		t.Errorf("status %d body %q", w.Code, w.Body.String())
+	}
+}
+
+func TestWebhookHandler_ExecInBackground(t *testing.T) {
+	x, _ := NewExecRunner(ExecOptions{Commands: []string{`echo "got $WEBHOOK_CATCHER_PATH"`}})
+	execHooks = x
+	defer func() { execHooks = nil }()
+
+	w := httptest.NewRecorder()
+	out := stripANSI(captureStdout(func() {
+		WebhookHandler(w, httptest.NewRequest("POST", "/bg", strings.NewReader("{}")))
+		waitExecHooks()
+	}))
+	if w.Code != 200 || w.Body.String() != "ok" {
+		t.Fatalf("status %d body %q", w.Code, w.Body.String())
+	}
+	if i, j := strings.Index(out, "WEBHOOK RECEIVED"), strings.Index(out, "--- EXEC #"); i < 0 || j < i || !strings.Contains(out, "  got /bg") {
+		t.Errorf("the hook block should follow the capture:\n%s", out)
+	}
+}
+
+func TestRun_ExecError(t *testing.T) {
+	isolateEnv(t)
+	if err := Run(Options{Exec: ExecOptions{Rules: []string{"nopattern"}}}); err == nil || !strings.Contains(err.Error(), "exec hook error") {
+		t.Fatalf("expected exec hook error, got %v", err)
+	}
+}
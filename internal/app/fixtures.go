@@ -0,0 +1,174 @@
+package app
+
+import (
+	"bytes"
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+//go:embed fixtures
+var builtinFixtures embed.FS
+
+// Fixture is a canned webhook request, stored as JSON:
+//
+//	{"provider": "github", "description": "...", "method": "POST",
+//	 "headers": {"X-GitHub-Event": "push"}, "body": {...}}
+//
+// Header values and the body are text/templates with the functions uuid, hex N
+// (N random bytes), now (RFC 3339) and unix, so every send gets fresh IDs.
+type Fixture struct {
+	// Name is the path below its fixture directory without .json, e.g. "github/push".
+	Name string `json:"-"`
+	// Source is "built-in" or the file the fixture was loaded from.
+	Source      string            `json:"-"`
+	Provider    string            `json:"provider"`
+	Description string            `json:"description,omitempty"`
+	Method      string            `json:"method,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        json.RawMessage   `json:"body"`
+}
+
+// DefaultFixtureDir is searched for user fixtures in addition to any -fixtures dirs.
+func DefaultFixtureDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "webhook-catcher", "fixtures"), nil
+}
+
+// LoadFixtures returns the built-in fixtures overlaid with those in dirs; a user
+// fixture replaces a built-in one of the same name. Missing dirs are an error.
+func LoadFixtures(dirs ...string) (map[string]*Fixture, error) {
+	fixtures := map[string]*Fixture{}
+	sub, _ := fs.Sub(builtinFixtures, "fixtures")
+	if err := loadFixtureFS(fixtures, sub, "built-in"); err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if err := loadFixtureFS(fixtures, os.DirFS(dir), dir); err != nil {
+			return nil, err
+		}
+	}
+	return fixtures, nil
+}
+
+func loadFixtureFS(into map[string]*Fixture, fsys fs.FS, source string) error {
+	return fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || path.Ext(p) != ".json" {
+			return nil
+		}
+		b, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		f := &Fixture{Name: strings.TrimSuffix(p, ".json"), Source: source}
+		if source != "built-in" {
+			f.Source = filepath.Join(source, filepath.FromSlash(p))
+		}
+		if err := json.Unmarshal(b, f); err != nil {
+			return fmt.Errorf("fixture %s: %w", f.Source, err)
+		}
+		if len(f.Body) == 0 {
+			return fmt.Errorf("fixture %s: missing body", f.Source)
+		}
+		into[f.Name] = f
+		return nil
+	})
+}
+
+// FixtureNames returns the fixture names sorted.
+func FixtureNames(fixtures map[string]*Fixture) []string {
+	names := make([]string, 0, len(fixtures))
+	for name := range fixtures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var fixtureFuncs = template.FuncMap{
+	"uuid": newUUID,
+	"hex": func(n int) string {
+		b := make([]byte, n)
+		_, _ = rand.Read(b)
+		return hex.EncodeToString(b)
+	},
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// Render expands the fixture's templates and, when secret is set, signs the body
+// with the provider's scheme. The body is compacted the way providers send it.
+func (f *Fixture) Render(secret string, now time.Time) (http.Header, []byte, error) {
+	funcs := template.FuncMap{
+		"now":  func() string { return now.UTC().Format(time.RFC3339) },
+		"unix": func() int64 { return now.Unix() },
+	}
+	expand := func(name, text string) (string, error) {
+		t, err := template.New(name).Funcs(fixtureFuncs).Funcs(funcs).Parse(text)
+		if err != nil {
+			return "", fmt.Errorf("fixture %s: %w", f.Name, err)
+		}
+		var buf strings.Builder
+		if err := t.Execute(&buf, nil); err != nil {
+			return "", fmt.Errorf("fixture %s: %w", f.Name, err)
+		}
+		return buf.String(), nil
+	}
+
+	h := http.Header{}
+	for k, v := range f.Headers {
+		val, err := expand(k, v)
+		if err != nil {
+			return nil, nil, err
+		}
+		h.Set(k, val)
+	}
+	text, err := expand("body", string(f.Body))
+	if err != nil {
+		return nil, nil, err
+	}
+	body := []byte(text)
+	var compact bytes.Buffer
+	if json.Compact(&compact, body) == nil {
+		body = compact.Bytes()
+	}
+
+	if secret != "" {
+		if f.Provider == "" {
+			return nil, nil, errors.New("fixture " + f.Name + " has no provider to sign for")
+		}
+		sig, err := SignWebhook(f.Provider, secret, body, now)
+		if err != nil {
+			return nil, nil, err
+		}
+		for k, v := range sig {
+			h[k] = v
+		}
+	}
+	return h, body, nil
+}
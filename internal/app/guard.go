@@ -0,0 +1,214 @@
+package app
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// GuardOptions protect the catcher itself. A request must come from an allowed
+// address (when AllowCIDRs is set) and present one of the configured credentials
+// (when any are set).
+type GuardOptions struct {
+	// Tokens are accepted as "Authorization: Bearer TOKEN".
+	Tokens []string
+	// BasicAuth holds user:password pairs.
+	BasicAuth []string
+	// Query is NAME=SECRET: the secret may be passed as ?NAME=SECRET instead.
+	Query string
+	// AllowCIDRs are client networks or single IPs allowed to send.
+	AllowCIDRs []string
+	// Status answers rejected requests; 0 means 401 for credentials and 403 for addresses.
+	Status int
+}
+
+// Guard rejects requests that fail its checks before they reach the handler.
+type Guard struct {
+	tokens     [][]byte
+	basic      [][2][]byte
+	queryName  string
+	querySec   []byte
+	allow      []*net.IPNet
+	status     int
+	challenges []string
+}
+
+// NewGuard validates o. It returns nil when o configures no checks.
+func NewGuard(o GuardOptions) (*Guard, error) {
+	g := &Guard{status: o.Status}
+	if o.Status != 0 && (o.Status < 400 || o.Status > 599) {
+		return nil, fmt.Errorf("guard status %d is not an error status", o.Status)
+	}
+	for _, t := range o.Tokens {
+		if t == "" {
+			return nil, fmt.Errorf("empty guard token")
+		}
+		g.tokens = append(g.tokens, []byte(t))
+	}
+	for _, cred := range o.BasicAuth {
+		user, pass, ok := strings.Cut(cred, ":")
+		if !ok || user == "" || pass == "" {
+			return nil, fmt.Errorf("guard basic auth %q: want user:password", MaskSecret(cred))
+		}
+		g.basic = append(g.basic, [2][]byte{[]byte(user), []byte(pass)})
+	}
+	if o.Query != "" {
+		name, secret, ok := strings.Cut(o.Query, "=")
+		if !ok || name == "" || secret == "" {
+			return nil, fmt.Errorf("guard query %q: want NAME=SECRET", MaskSecret(o.Query))
+		}
+		g.queryName, g.querySec = name, []byte(secret)
+	}
+	for _, c := range o.AllowCIDRs {
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP or CIDR %q", c)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			c = fmt.Sprintf("%s/%d", c, bits)
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP or CIDR %q", c)
+		}
+		g.allow = append(g.allow, n)
+	}
+	if len(g.basic) > 0 {
+		g.challenges = append(g.challenges, `Basic realm="webhook-catcher"`)
+	}
+	if len(g.tokens) > 0 {
+		g.challenges = append(g.challenges, `Bearer realm="webhook-catcher"`)
+	}
+	if !g.needsCredentials() && len(g.allow) == 0 {
+		return nil, nil
+	}
+	return g, nil
+}
+
+func (g *Guard) needsCredentials() bool {
+	return len(g.tokens) > 0 || len(g.basic) > 0 || g.queryName != ""
+}
+
+// Describe lists the active checks for the startup log.
+func (g *Guard) Describe() []string {
+	var active []string
+	if len(g.tokens) > 0 {
+		active = append(active, fmt.Sprintf("bearer token (%d)", len(g.tokens)))
+	}
+	if len(g.basic) > 0 {
+		active = append(active, fmt.Sprintf("basic auth (%d user(s))", len(g.basic)))
+	}
+	if g.queryName != "" {
+		active = append(active, "query secret ?"+g.queryName+"=")
+	}
+	if len(g.allow) > 0 {
+		nets := make([]string, len(g.allow))
+		for i, n := range g.allow {
+			nets[i] = n.String()
+		}
+		active = append(active, "IP allow "+strings.Join(nets, ", "))
+	}
+	return active
+}
+
+// Check returns why r is rejected and the status to answer, or "" when it passes.
+// Requests without an IP address (Unix sockets) pass the allowlist: the socket's
+// file mode already decides who can connect.
+func (g *Guard) Check(r *http.Request) (reason string, status int) {
+	if len(g.allow) > 0 {
+		if ip := remoteIP(r.RemoteAddr); ip != nil && !g.allowed(ip) {
+			return "address not allowed", g.statusOr(http.StatusForbidden)
+		}
+	}
+	if !g.needsCredentials() {
+		return "", 0
+	}
+	presented := false
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		presented = true
+		scheme, cred, _ := strings.Cut(auth, " ")
+		if strings.EqualFold(scheme, "Bearer") && matchSecret(g.tokens, []byte(strings.TrimSpace(cred))) {
+			return "", 0
+		}
+		if user, pass, ok := r.BasicAuth(); ok {
+			for _, b := range g.basic {
+				u := subtle.ConstantTimeCompare(b[0], []byte(user))
+				p := subtle.ConstantTimeCompare(b[1], []byte(pass))
+				if u&p == 1 {
+					return "", 0
+				}
+			}
+		}
+	}
+	if g.queryName != "" && r.URL.Query().Has(g.queryName) {
+		presented = true
+		if subtle.ConstantTimeCompare(g.querySec, []byte(r.URL.Query().Get(g.queryName))) == 1 {
+			return "", 0
+		}
+	}
+	if presented {
+		return "bad credentials", g.statusOr(http.StatusUnauthorized)
+	}
+	return "missing credentials", g.statusOr(http.StatusUnauthorized)
+}
+
+func (g *Guard) statusOr(def int) int {
+	if g.status != 0 {
+		return g.status
+	}
+	return def
+}
+
+func (g *Guard) allowed(ip net.IP) bool {
+	for _, n := range g.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSecret(secrets [][]byte, got []byte) bool {
+	ok := 0
+	for _, s := range secrets {
+		ok |= subtle.ConstantTimeCompare(s, got)
+	}
+	return ok == 1
+}
+
+// remoteIP parses the IP of a RemoteAddr, or returns nil.
+func remoteIP(addr string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return net.ParseIP(host)
+}
+
+// WithGuard answers requests that fail g's checks itself. They are counted in the
+// session stats but not printed. A nil guard lets everything through.
+func WithGuard(h http.Handler, g *Guard) http.Handler {
+	if g == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reason, status := g.Check(r)
+		if reason == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		sessionStats.Reject(reason)
+		if status == http.StatusUnauthorized {
+			for _, c := range g.challenges {
+				w.Header().Add("WWW-Authenticate", c)
+			}
+		}
+		http.Error(w, http.StatusText(status), status)
+	})
+}
@@ -0,0 +1,136 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func mustGuard(t *testing.T, o GuardOptions) *Guard {
+	t.Helper()
+	g, err := NewGuard(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func guardRequest(remote, target string, header ...string) *http.Request {
+	r := httptest.NewRequest("POST", target, strings.NewReader("{}"))
+	r.RemoteAddr = remote
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	return r
+}
+
+func TestGuard_Credentials(t *testing.T) {
+	g := mustGuard(t, GuardOptions{Tokens: []string{"t1", "t2"}, BasicAuth: []string{"ci:pa:ss"}, Query: "key=s3"})
+	for _, tc := range []struct {
+		name   string
+		r      *http.Request
+		reason string
+	}{
+		{"bearer", guardRequest("192.0.2.1:5", "/", "Authorization", "Bearer t2"), ""},
+		{"bearer case", guardRequest("192.0.2.1:5", "/", "Authorization", "bearer t1"), ""},
+		{"basic", guardRequest("192.0.2.1:5", "/", "Authorization", "Basic Y2k6cGE6c3M="), ""},
+		{"query", guardRequest("192.0.2.1:5", "/hooks?key=s3"), ""},
+		{"missing", guardRequest("192.0.2.1:5", "/"), "missing credentials"},
+		{"wrong token", guardRequest("192.0.2.1:5", "/", "Authorization", "Bearer t3"), "bad credentials"},
+		{"wrong basic", guardRequest("192.0.2.1:5", "/", "Authorization", "Basic Y2k6bm9wZQ=="), "bad credentials"},
+		{"token as basic password", guardRequest("192.0.2.1:5", "/", "Authorization", "Basic dDE6dDE="), "bad credentials"},
+		{"wrong query", guardRequest("192.0.2.1:5", "/?key=s4"), "bad credentials"},
+	} {
+		reason, status := g.Check(tc.r)
+		if reason != tc.reason {
+			t.Errorf("%s: reason %q, want %q", tc.name, reason, tc.reason)
+		}
+		if reason != "" && status != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want 401", tc.name, status)
+		}
+	}
+}
+
+func TestGuard_AllowList(t *testing.T) {
+	g := mustGuard(t, GuardOptions{AllowCIDRs: []string{"10.0.0.0/8", "192.0.2.7", "2001:db8::/32"}})
+	for remote, ok := range map[string]bool{
+		"10.1.2.3:4000":     true,
+		"192.0.2.7:80":      true,
+		"192.0.2.8:80":      false,
+		"[2001:db8::1]:443": true,
+		"[2001:db9::1]:443": false,
+		"@":                 true, // Unix socket peer
+		"":                  true,
+		"203.0.113.9":       false,
+	} {
+		reason, status := g.Check(guardRequest(remote, "/"))
+		if (reason == "") != ok || (!ok && status != http.StatusForbidden) {
+			t.Errorf("%q: reason %q status %d, want allowed=%v", remote, reason, status, ok)
+		}
+	}
+
+	both := mustGuard(t, GuardOptions{Tokens: []string{"t"}, AllowCIDRs: []string{"10.0.0.0/8"}, Status: 404})
+	if reason, status := both.Check(guardRequest("203.0.113.9:1", "/", "Authorization", "Bearer t")); reason != "address not allowed" || status != 404 {
+		t.Errorf("a valid token must not bypass the allowlist: %q %d", reason, status)
+	}
+	if reason, status := both.Check(guardRequest("10.0.0.1:1", "/")); reason != "missing credentials" || status != 404 {
+		t.Errorf("allowed address without token: %q %d", reason, status)
+	}
+}
+
+func TestNewGuard_Errors(t *testing.T) {
+	if g, err := NewGuard(GuardOptions{Status: 401}); g != nil || err != nil {
+		t.Errorf("no checks should mean no guard, got %v %v", g, err)
+	}
+	for _, o := range []GuardOptions{
+		{Tokens: []string{""}},
+		{BasicAuth: []string{"nopassword"}},
+		{Query: "token"},
+		{AllowCIDRs: []string{"10.0.0.0/33"}},
+		{AllowCIDRs: []string{"example.com"}},
+		{Tokens: []string{"t"}, Status: 200},
+	} {
+		if _, err := NewGuard(o); err == nil {
+			t.Errorf("expected error for %+v", o)
+		}
+	}
+}
+
+func TestWithGuard(t *testing.T) {
+	sessionStats = NewStats()
+	g := mustGuard(t, GuardOptions{Tokens: []string{"t"}, BasicAuth: []string{"u:p"}})
+	h := WithGuard(http.HandlerFunc(WebhookHandler), g)
+
+	w := httptest.NewRecorder()
+	out := captureStdout(func() { h.ServeHTTP(w, guardRequest("192.0.2.1:5", "/")) })
+	if w.Code != 401 || out != "" {
+		t.Fatalf("rejected request: status %d, output %q", w.Code, out)
+	}
+	if got := w.Result().Header.Values("WWW-Authenticate"); len(got) != 2 || !strings.HasPrefix(got[0], "Basic") || !strings.HasPrefix(got[1], "Bearer") {
+		t.Errorf("WWW-Authenticate = %v", got)
+	}
+
+	w = httptest.NewRecorder()
+	out = captureStdout(func() { h.ServeHTTP(w, guardRequest("192.0.2.1:5", "/", "Authorization", "Bearer t")) })
+	if w.Code != 200 || !strings.Contains(out, "WEBHOOK RECEIVED") {
+		t.Fatalf("accepted request: status %d, output %q", w.Code, out)
+	}
+	if sessionStats.Total() != 1 || sessionStats.Rejected() != 1 {
+		t.Errorf("total=%d rejected=%d, want 1 and 1", sessionStats.Total(), sessionStats.Rejected())
+	}
+	if s := stripANSI(sessionStats.Summary()); !strings.Contains(s, "Rejected: 1 (missing credentials: 1)") {
+		t.Errorf("summary missing rejections:\n%s", s)
+	}
+
+	if mux := http.NewServeMux(); WithGuard(mux, nil) != http.Handler(mux) {
+		t.Error("a nil guard should not wrap the handler")
+	}
+}
+
+func TestRun_GuardError(t *testing.T) {
+	isolateEnv(t)
+	if err := Run(Options{Guard: GuardOptions{AllowCIDRs: []string{"nope"}}}); err == nil || !strings.Contains(err.Error(), "guard error") {
+		t.Fatalf("expected guard error, got %v", err)
+	}
+}
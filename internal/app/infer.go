@@ -0,0 +1,530 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Shape accumulates the JSON values seen at one position across many payloads and
+// turns them into a schema.
+type Shape struct {
+	seen  int
+	types map[string]int
+	// Object members in first-seen order
+	props map[string]*Shape
+	order []string
+	items *Shape
+	// Distinct string values, tracked until there are more than EnumMax
+	values    map[string]int
+	valueList []string
+	strings   int
+	formats   map[string]int
+	enumMax   int
+}
+
+// NewShape returns an empty shape that emits enums for strings with at most
+// enumMax distinct values.
+func NewShape(enumMax int) *Shape {
+	return &Shape{types: map[string]int{}, enumMax: enumMax}
+}
+
+// Add merges one decoded JSON value (decoded with UseNumber) into the shape.
+func (s *Shape) Add(v any) {
+	s.seen++
+	switch x := v.(type) {
+	case nil:
+		s.types["null"]++
+	case bool:
+		s.types["boolean"]++
+	case json.Number:
+		if _, err := x.Int64(); err == nil {
+			s.types["integer"]++
+		} else {
+			s.types["number"]++
+		}
+	case string:
+		s.types["string"]++
+		s.addString(x)
+	case []any:
+		s.types["array"]++
+		if s.items == nil {
+			s.items = NewShape(s.enumMax)
+		}
+		for _, e := range x {
+			s.items.Add(e)
+		}
+	case map[string]any:
+		s.types["object"]++
+		if s.props == nil {
+			s.props = map[string]*Shape{}
+		}
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p, ok := s.props[k]
+			if !ok {
+				p = NewShape(s.enumMax)
+				s.props[k] = p
+				s.order = append(s.order, k)
+			}
+			p.Add(x[k])
+		}
+	}
+}
+
+var (
+	uuidPattern  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+)
+
+func (s *Shape) addString(v string) {
+	s.strings++
+	if s.formats == nil {
+		s.formats = map[string]int{}
+	}
+	if _, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		s.formats["date-time"]++
+	} else if uuidPattern.MatchString(v) {
+		s.formats["uuid"]++
+	} else if emailPattern.MatchString(v) {
+		s.formats["email"]++
+	}
+	if s.values == nil {
+		s.values = map[string]int{}
+	}
+	if len(s.values) <= s.enumMax {
+		if _, ok := s.values[v]; !ok {
+			s.valueList = append(s.valueList, v)
+		}
+		s.values[v]++
+	}
+}
+
+// format is the string format every value matched, or "".
+func (s *Shape) format() string {
+	for f, n := range s.formats {
+		if n == s.strings {
+			return f
+		}
+	}
+	return ""
+}
+
+// enum returns the distinct string values when they look like a closed set: at
+// least two but few of them, each seen more than once on average. A value that never
+// varies is more likely an ID the samples happen to share than a constant.
+func (s *Shape) enum() []string {
+	if s.format() != "" || len(s.values) < 2 || len(s.values) > s.enumMax || s.strings < 2*len(s.values) {
+		return nil
+	}
+	vals := append([]string(nil), s.valueList...)
+	sort.Strings(vals)
+	return vals
+}
+
+// typeNames lists the JSON types seen, with integer folded into number when both occur.
+func (s *Shape) typeNames() []string {
+	var names []string
+	for _, t := range []string{"object", "array", "string", "integer", "number", "boolean", "null"} {
+		if s.types[t] == 0 || (t == "integer" && s.types["number"] > 0) {
+			continue
+		}
+		names = append(names, t)
+	}
+	return names
+}
+
+// required lists the properties present in every object seen.
+func (s *Shape) required() []string {
+	var req []string
+	for _, k := range s.order {
+		if s.props[k].seen == s.types["object"] {
+			req = append(req, k)
+		}
+	}
+	sort.Strings(req)
+	return req
+}
+
+// JSONSchema renders the shape as a draft 2020-12 schema fragment.
+func (s *Shape) JSONSchema() map[string]any {
+	out := map[string]any{}
+	types := s.typeNames()
+	switch len(types) {
+	case 0:
+		return out
+	case 1:
+		out["type"] = types[0]
+	default:
+		out["type"] = types
+	}
+	if s.types["object"] > 0 {
+		props := map[string]any{}
+		for _, k := range s.order {
+			props[k] = s.props[k].JSONSchema()
+		}
+		out["properties"] = props
+		if req := s.required(); len(req) > 0 {
+			out["required"] = req
+		}
+	}
+	if s.items != nil && s.items.seen > 0 {
+		out["items"] = s.items.JSONSchema()
+	}
+	if f := s.format(); f != "" {
+		out["format"] = f
+	}
+	if enum := s.enum(); enum != nil {
+		vals := make([]any, 0, len(enum)+1)
+		for _, v := range enum {
+			vals = append(vals, v)
+		}
+		if s.types["null"] > 0 {
+			vals = append(vals, nil)
+		}
+		out["enum"] = vals
+	}
+	return out
+}
+
+// initialisms are upper-cased in Go identifiers.
+var initialisms = map[string]bool{"id": true, "url": true, "uri": true, "api": true, "http": true, "https": true, "ip": true, "json": true, "html": true, "sku": true, "uuid": true}
+
+// goName turns a JSON key into an exported Go identifier.
+func goName(key string) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(key, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		if initialisms[strings.ToLower(word)] {
+			b.WriteString(strings.ToUpper(word))
+			continue
+		}
+		r := []rune(word)
+		b.WriteString(strings.ToUpper(string(r[0])) + string(r[1:]))
+	}
+	name := b.String()
+	if name == "" || unicode.IsDigit([]rune(name)[0]) {
+		name = "X" + name
+	}
+	return name
+}
+
+// typeGen emits named Go or TypeScript types for a shape tree.
+type typeGen struct {
+	ts    bool
+	used  map[string]bool
+	decls []string
+}
+
+func (g *typeGen) unique(name string) string {
+	n := name
+	for i := 2; g.used[n]; i++ {
+		n = name + strconv.Itoa(i)
+	}
+	g.used[n] = true
+	return n
+}
+
+// typeOf returns the type expression for s, declaring named types for objects.
+func (g *typeGen) typeOf(s *Shape, name string) string {
+	types := s.typeNames()
+	nullable := s.types["null"] > 0
+	var nonNull []string
+	for _, t := range types {
+		if t != "null" {
+			nonNull = append(nonNull, t)
+		}
+	}
+	var expr string
+	switch {
+	case len(nonNull) == 0:
+		if g.ts {
+			return "null"
+		}
+		return "any"
+	case len(nonNull) > 1:
+		if !g.ts {
+			return "any"
+		}
+		var parts []string
+		for _, t := range nonNull {
+			parts = append(parts, g.scalar(s, t, name))
+		}
+		expr = strings.Join(parts, " | ")
+	default:
+		expr = g.scalar(s, nonNull[0], name)
+	}
+	if nullable {
+		if g.ts {
+			return expr + " | null"
+		}
+		if !strings.HasPrefix(expr, "[]") && expr != "any" {
+			return "*" + expr
+		}
+	}
+	return expr
+}
+
+func (g *typeGen) scalar(s *Shape, t, name string) string {
+	switch t {
+	case "object":
+		return g.declare(s, name)
+	case "array":
+		elem := "any"
+		if g.ts {
+			elem = "unknown"
+		}
+		if s.items != nil && s.items.seen > 0 {
+			elem = g.typeOf(s.items, name+"Item")
+		}
+		if g.ts {
+			if strings.Contains(elem, " ") {
+				elem = "(" + elem + ")"
+			}
+			return elem + "[]"
+		}
+		return "[]" + elem
+	case "string":
+		if enum := s.enum(); enum != nil && g.ts {
+			quoted := make([]string, len(enum))
+			for i, v := range enum {
+				b, _ := json.Marshal(v)
+				quoted[i] = string(b)
+			}
+			return strings.Join(quoted, " | ")
+		}
+		if s.format() == "date-time" && !g.ts {
+			return "time.Time"
+		}
+		return "string"
+	case "integer":
+		if g.ts {
+			return "number"
+		}
+		return "int64"
+	case "number":
+		if g.ts {
+			return "number"
+		}
+		return "float64"
+	case "boolean":
+		if g.ts {
+			return "boolean"
+		}
+		return "bool"
+	}
+	return "any"
+}
+
+var tsIdent = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+func (g *typeGen) declare(s *Shape, name string) string {
+	name = g.unique(name)
+	// Reserve the slot so parents come before children
+	i := len(g.decls)
+	g.decls = append(g.decls, "")
+	required := map[string]bool{}
+	for _, k := range s.required() {
+		required[k] = true
+	}
+	var b strings.Builder
+	if g.ts {
+		fmt.Fprintf(&b, "export interface %s {\n", name)
+		for _, k := range s.order {
+			key := k
+			if !tsIdent.MatchString(k) {
+				key = strconv.Quote(k)
+			}
+			opt := "?"
+			if required[k] {
+				opt = ""
+			}
+			fmt.Fprintf(&b, "  %s%s: %s;\n", key, opt, g.typeOf(s.props[k], name+goName(k)))
+		}
+	} else {
+		fmt.Fprintf(&b, "type %s struct {\n", name)
+		// Keys like user_id and userId map to the same field name
+		fields := map[string]bool{}
+		for _, k := range s.order {
+			field := goName(k)
+			for i := 2; fields[field]; i++ {
+				field = goName(k) + strconv.Itoa(i)
+			}
+			fields[field] = true
+			tag, typ := k, g.typeOf(s.props[k], name+field)
+			if !required[k] {
+				tag += ",omitempty"
+				// omitempty does nothing for struct values
+				if g.used[typ] {
+					typ = "*" + typ
+				}
+			}
+			fmt.Fprintf(&b, "\t%s %s `json:%q`\n", field, typ, tag)
+		}
+	}
+	b.WriteString("}\n")
+	g.decls[i] = b.String()
+	return name
+}
+
+// GoTypes renders the shape as Go type declarations rooted at name. It fails
+// when the declarations do not format as Go source.
+func (s *Shape) GoTypes(name string) (string, error) {
+	g := &typeGen{used: map[string]bool{}}
+	root := g.typeOf(s, name)
+	if len(g.decls) == 0 || !g.used[root] {
+		g.decls = append([]string{fmt.Sprintf("type %s %s\n", g.unique(name), root)}, g.decls...)
+	}
+	out := strings.Join(g.decls, "\n")
+	if strings.Contains(out, "time.Time") {
+		out = "import \"time\"\n\n" + out
+	}
+	b, err := format.Source([]byte(out))
+	if err != nil {
+		return "", fmt.Errorf("format Go types: %w", err)
+	}
+	return string(b), nil
+}
+
+// TypeScriptTypes renders the shape as TypeScript declarations rooted at name.
+func (s *Shape) TypeScriptTypes(name string) string {
+	g := &typeGen{ts: true, used: map[string]bool{}}
+	root := g.typeOf(s, name)
+	if len(g.decls) == 0 || !g.used[root] {
+		g.decls = append([]string{fmt.Sprintf("export type %s = %s;\n", g.unique(name), root)}, g.decls...)
+	}
+	return strings.Join(g.decls, "\n")
+}
+
+// EventType names the provider event a capture carries: the GitHub or Shopify event
+// header, else a top-level "type" string in the JSON body (Stripe, Slack).
+func EventType(c *Capture) string {
+	for _, h := range []string{"X-GitHub-Event", "X-Shopify-Topic"} {
+		if v := c.Header.Get(h); v != "" {
+			return v
+		}
+	}
+	var body struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(c.Body, &body) == nil {
+		return body.Type
+	}
+	return ""
+}
+
+// inferUsage is printed for `infer-schema` without a capture directory.
+const inferUsage = `usage: webhook-catcher infer-schema [-captures DIR] [-path GLOB] [-event TYPE] [-format json-schema|go|typescript] [-name NAME] [-o FILE]
+
+Learns the shape of the JSON bodies of matching captures. Properties present in
+every payload are required; strings with few distinct values become enums, and
+date-time, uuid and email formats are detected.`
+
+// InferSchemaCommand implements `infer-schema`.
+func InferSchemaCommand(args []string) error {
+	return inferSchemaCommand(args, os.Stdout)
+}
+
+func inferSchemaCommand(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("infer-schema", flag.ContinueOnError)
+	fs.SetOutput(out)
+	dir := fs.String("captures", os.Getenv(EnvName("capture-dir")), "capture directory (default $"+EnvName("capture-dir")+")")
+	pathGlob := fs.String("path", "", "only captures whose path matches this glob")
+	event := fs.String("event", "", "only captures of this event type (X-GitHub-Event, X-Shopify-Topic or body type)")
+	format := fs.String("format", "json-schema", "output: json-schema, go or typescript")
+	name := fs.String("name", "", "root type name (default derived from -event, else Webhook)")
+	enumMax := fs.Int("enum-max", 8, "most distinct values a string may have to become an enum (0 disables)")
+	outFile := fs.String("o", "", "write to this file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" || fs.NArg() > 0 {
+		return errors.New(inferUsage)
+	}
+	if _, err := path.Match(*pathGlob, "/"); err != nil {
+		return fmt.Errorf("bad -path pattern: %w", err)
+	}
+	captures, err := ReadCaptures(*dir)
+	if err != nil {
+		return err
+	}
+
+	shape := NewShape(*enumMax)
+	used, skipped := 0, 0
+	for _, c := range captures {
+		if *pathGlob != "" {
+			if ok, _ := path.Match(*pathGlob, c.Path); !ok {
+				continue
+			}
+		}
+		if *event != "" && EventType(c) != *event {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(c.Body))
+		dec.UseNumber()
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			skipped++
+			continue
+		}
+		shape.Add(v)
+		used++
+	}
+	if used == 0 {
+		return fmt.Errorf("no JSON captures match in %s (%d non-JSON skipped)", *dir, skipped)
+	}
+
+	root := *name
+	if root == "" {
+		root = "Webhook"
+		if *event != "" {
+			root = goName(*event) + "Event"
+		}
+	}
+	note := fmt.Sprintf("Inferred by webhook-catcher from %d capture(s)", used)
+	var text string
+	switch *format {
+	case "json-schema":
+		schema := shape.JSONSchema()
+		schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+		schema["title"] = root
+		schema["description"] = note
+		b, _ := json.MarshalIndent(schema, "", "  ")
+		text = string(b) + "\n"
+	case "go":
+		types, err := shape.GoTypes(root)
+		if err != nil {
+			return err
+		}
+		text = "// " + note + "\n\n" + types
+	case "typescript", "ts":
+		text = "// " + note + "\n\n" + shape.TypeScriptTypes(root)
+	default:
+		return fmt.Errorf("unknown -format %q (want json-schema, go or typescript)", *format)
+	}
+
+	if *outFile != "" {
+		if err := os.WriteFile(*outFile, []byte(text), 0644); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Wrote %s (%s).\n", *outFile, strings.ToLower(note))
+		return nil
+	}
+	_, err = io.WriteString(out, text)
+	return err
+}
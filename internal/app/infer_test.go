@@ -0,0 +1,266 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// partnerBodies are four deliveries of an undocumented partner webhook.
+var partnerBodies = []string{
+	`{"id":"0b8a7c2e-5f1d-4e3a-9b6c-1d2e3f4a5b6c","status":"paid","total":12.5,"placed_at":"2024-05-01T10:00:00Z","customer":{"email":"a@example.com"},"items":[{"sku":"A1","qty":1}]}`,
+	`{"id":"1c9b8d3f-6a2e-4f4b-8c7d-2e3f4a5b6c7d","status":"paid","total":3,"placed_at":"2024-05-02T11:30:00+02:00","customer":{"email":"b@example.com","phone":null},"items":[]}`,
+	`{"id":"2dac9e4a-7b3f-4a5c-9d8e-3f4a5b6c7d8e","status":"refunded","total":7,"placed_at":"2024-05-03T09:15:00Z","customer":{"email":"c@example.com"},"items":[{"sku":"B2","qty":2,"note":"gift"}],"coupon":"SPRING"}`,
+	`{"id":"3ebdaf5b-8c4a-4b6d-8e9f-4a5b6c7d8e9f","status":"refunded","total":20,"placed_at":"2024-05-04T18:45:00Z","customer":{"email":"d@example.com"},"items":[{"sku":"C3","qty":1}]}`,
+}
+
+func partnerShape(t *testing.T) *Shape {
+	t.Helper()
+	s := NewShape(8)
+	for _, b := range partnerBodies {
+		dec := json.NewDecoder(strings.NewReader(b))
+		dec.UseNumber()
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		s.Add(v)
+	}
+	return s
+}
+
+func TestShape_JSONSchema(t *testing.T) {
+	got := partnerShape(t).JSONSchema()
+	props := got["properties"].(map[string]any)
+	field := func(name string) map[string]any { return props[name].(map[string]any) }
+
+	if want := []string{"customer", "id", "items", "placed_at", "status", "total"}; !reflect.DeepEqual(got["required"], want) {
+		t.Errorf("required = %v, want %v", got["required"], want)
+	}
+	if field("id")["format"] != "uuid" || field("placed_at")["format"] != "date-time" {
+		t.Errorf("formats not detected: id=%v placed_at=%v", field("id"), field("placed_at"))
+	}
+	if want := []any{"paid", "refunded"}; !reflect.DeepEqual(field("status")["enum"], want) {
+		t.Errorf("status enum = %v, want %v", field("status")["enum"], want)
+	}
+	if field("total")["type"] != "number" {
+		t.Errorf("mixed integers and decimals should be number, got %v", field("total")["type"])
+	}
+	if _, ok := field("coupon")["enum"]; ok {
+		t.Error("a string seen once should not become an enum")
+	}
+	customer := field("customer")
+	email := customer["properties"].(map[string]any)["email"].(map[string]any)
+	if email["format"] != "email" || !reflect.DeepEqual(customer["required"], []string{"email"}) {
+		t.Errorf("unexpected customer schema %v", customer)
+	}
+	phone := customer["properties"].(map[string]any)["phone"].(map[string]any)
+	if phone["type"] != "null" {
+		t.Errorf("phone = %v, want null type", phone)
+	}
+	items := field("items")["items"].(map[string]any)
+	if !reflect.DeepEqual(items["required"], []string{"qty", "sku"}) {
+		t.Errorf("item required = %v", items["required"])
+	}
+}
+
+func TestShape_NullableAndMixedTypes(t *testing.T) {
+	s := NewShape(8)
+	for _, v := range []any{"a", nil, "b", "a", "b"} {
+		s.Add(v)
+	}
+	got := s.JSONSchema()
+	if !reflect.DeepEqual(got["type"], []string{"string", "null"}) || !reflect.DeepEqual(got["enum"], []any{"a", "b", nil}) {
+		t.Fatalf("unexpected nullable schema %v", got)
+	}
+
+	s = NewShape(0)
+	for _, v := range []any{json.Number("1"), "x", "x"} {
+		s.Add(v)
+	}
+	got = s.JSONSchema()
+	if !reflect.DeepEqual(got["type"], []string{"string", "integer"}) || got["enum"] != nil {
+		t.Fatalf("unexpected mixed schema %v", got)
+	}
+}
+
+func TestShape_SchemaAcceptsItsSamples(t *testing.T) {
+	schema := partnerShape(t).JSONSchema()
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	rule := SchemaRule{Pattern: "/*"}
+	var err error
+	if rule.schema, err = CompileSchema(writeSchema(t, "inferred.json", string(mustJSON(t, schema)))); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range partnerBodies {
+		if vs := rule.Validate([]byte(b)); len(vs) != 0 {
+			t.Errorf("inferred schema rejects its own sample %s: %v", b, vs)
+		}
+	}
+	if vs := rule.Validate([]byte(`{"id":"nope","status":"lost","total":1,"placed_at":"yesterday","customer":{},"items":[]}`)); len(vs) < 4 {
+		t.Errorf("expected id, status, placed_at and email violations, got %v", vs)
+	}
+}
+
+func TestShape_GoTypes(t *testing.T) {
+	got, err := partnerShape(t).GoTypes("Order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`import "time"`,
+		"type Order struct {",
+		"Coupon   string           `json:\"coupon,omitempty\"`",
+		"Customer OrderCustomer    `json:\"customer\"`",
+		"ID       string           `json:\"id\"`",
+		"Items    []OrderItemsItem `json:\"items\"`",
+		"PlacedAt time.Time        `json:\"placed_at\"`",
+		"Total    float64          `json:\"total\"`",
+		"type OrderCustomer struct {",
+		"Phone any    `json:\"phone,omitempty\"`",
+		"type OrderItemsItem struct {",
+		"Qty  int64  `json:\"qty\"`",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Go output missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Index(got, "type Order struct") > strings.Index(got, "type OrderCustomer struct") {
+		t.Error("root type should come first")
+	}
+
+	s := NewShape(8)
+	s.Add([]any{json.Number("1")})
+	if got, _ := s.GoTypes("IDs"); !strings.Contains(got, "type IDs []int64") {
+		t.Errorf("non-object root: %s", got)
+	}
+
+	// Keys that map to the same Go name get distinct fields
+	s = NewShape(8)
+	s.Add(map[string]any{"user_id": "a", "userId": "b", "user-id": map[string]any{"n": true}})
+	got, err = s.GoTypes("T")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"UserID  TUserID `json:\"user-id\"`", "UserID2 string  `json:\"user_id\"`", "type TUserID struct"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Go output missing %q:\n%s", want, got)
+		}
+	}
+
+	// A root name that is not an identifier is reported, not printed broken
+	if _, err := s.GoTypes("not a name"); err == nil {
+		t.Error("expected a format error")
+	}
+}
+
+func TestShape_TypeScriptTypes(t *testing.T) {
+	got := partnerShape(t).TypeScriptTypes("Order")
+	for _, want := range []string{
+		"export interface Order {",
+		"  coupon?: string;",
+		"  items: OrderItemsItem[];",
+		`  status: "paid" | "refunded";`,
+		"  total: number;",
+		"  phone?: null;",
+		"export interface OrderItemsItem {",
+		"  note?: string;",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TypeScript output missing %q:\n%s", want, got)
+		}
+	}
+
+	s := NewShape(8)
+	s.Add(map[string]any{"content-type": "x", "n": nil})
+	s.Add(map[string]any{"content-type": "y", "n": json.Number("1")})
+	got = s.TypeScriptTypes("T")
+	if !strings.Contains(got, `"content-type": string;`) || !strings.Contains(got, "n: number | null;") {
+		t.Errorf("unexpected output:\n%s", got)
+	}
+}
+
+func TestGoName(t *testing.T) {
+	for in, want := range map[string]string{
+		"order_id":     "OrderID",
+		"html_url":     "HTMLURL",
+		"content-type": "ContentType",
+		"createdAt":    "CreatedAt",
+		"3ds":          "X3ds",
+		"":             "X",
+	} {
+		if got := goName(in); got != want {
+			t.Errorf("goName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestEventType(t *testing.T) {
+	gh := &Capture{Header: http.Header{"X-Github-Event": {"push"}}, Body: []byte(`{"type":"x"}`)}
+	shop := &Capture{Header: http.Header{"X-Shopify-Topic": {"orders/create"}}}
+	stripe := &Capture{Header: http.Header{}, Body: []byte(`{"type":"invoice.paid"}`)}
+	text := &Capture{Header: http.Header{}, Body: []byte("hello")}
+	for c, want := range map[*Capture]string{gh: "push", shop: "orders/create", stripe: "invoice.paid", text: ""} {
+		if got := EventType(c); got != want {
+			t.Errorf("EventType = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestInferSchemaCommand(t *testing.T) {
+	dir := t.TempDir()
+	for i, b := range partnerBodies {
+		storedCapture(t, dir, &Capture{ID: string(rune('a' + i)), Method: "POST", Path: "/partner/orders", Header: http.Header{}, Body: []byte(b)})
+	}
+	storedCapture(t, dir, &Capture{ID: "gh", Method: "POST", Path: "/github", Header: http.Header{"X-Github-Event": {"ping"}}, Body: []byte(`{"zen":"Keep it logically awesome."}`)})
+	storedCapture(t, dir, &Capture{ID: "txt", Method: "POST", Path: "/partner/notes", Header: http.Header{}, Body: []byte("plain")})
+
+	var out bytes.Buffer
+	if err := inferSchemaCommand([]string{"-captures", dir, "-path", "/partner/*"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(out.Bytes(), &schema); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, out.String())
+	}
+	if schema["title"] != "Webhook" || schema["description"] != "Inferred by webhook-catcher from 4 capture(s)" {
+		t.Errorf("unexpected header %v %v", schema["title"], schema["description"])
+	}
+	if _, ok := schema["properties"].(map[string]any)["zen"]; ok {
+		t.Error("-path should have excluded the GitHub capture")
+	}
+
+	out.Reset()
+	if err := inferSchemaCommand([]string{"-captures", dir, "-event", "ping", "-format", "ts"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "export interface PingEvent {\n  zen: string;\n}") {
+		t.Errorf("unexpected TypeScript:\n%s", out.String())
+	}
+
+	file := filepath.Join(t.TempDir(), "order.go")
+	out.Reset()
+	if err := inferSchemaCommand([]string{"-captures", dir, "-path", "/partner/*", "-format", "go", "-name", "Order", "-o", file}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(file); err != nil || !strings.Contains(string(b), "type Order struct") || !strings.Contains(out.String(), "Wrote "+file) {
+		t.Errorf("unexpected file %s err=%v out=%s", b, err, out.String())
+	}
+
+	for _, args := range [][]string{
+		{"-captures", dir, "-path", "/partner/notes"},
+		{"-captures", dir, "-format", "xml"},
+		{"-captures", dir, "-path", "["},
+		{"-captures", dir, "extra"},
+		{},
+	} {
+		if err := inferSchemaCommand(args, &bytes.Buffer{}); err == nil {
+			t.Errorf("expected error for %v", args)
+		}
+	}
+}
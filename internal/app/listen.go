@@ -0,0 +1,149 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseListenAddr splits a listen address into a network ("tcp" or "unix") and the
+// address to pass to net.Listen. Accepted forms:
+//
+//	tcp://host:port, host:port, :port, [::1]:port, tcp://[::1]:port
+//	unix:///path/to.sock, unix://relative.sock (optionally with ?mode=0660)
+//
+// For unix sockets the returned mode is the requested file mode, or 0 when unset.
+func ParseListenAddr(addr string) (network, address string, mode os.FileMode, err error) {
+	switch {
+	case strings.HasPrefix(addr, "unix://"):
+		path := strings.TrimPrefix(addr, "unix://")
+		if i := strings.Index(path, "?"); i >= 0 {
+			query := path[i+1:]
+			path = path[:i]
+			if !strings.HasPrefix(query, "mode=") {
+				return "", "", 0, fmt.Errorf("invalid listen address %q: unsupported option %q", addr, query)
+			}
+			m, perr := strconv.ParseUint(strings.TrimPrefix(query, "mode="), 8, 32)
+			if perr != nil {
+				return "", "", 0, fmt.Errorf("invalid listen address %q: bad socket mode: %w", addr, perr)
+			}
+			mode = os.FileMode(m)
+		}
+		if path == "" {
+			return "", "", 0, fmt.Errorf("invalid listen address %q: missing socket path", addr)
+		}
+		return "unix", path, mode, nil
+	case strings.Contains(addr, "://") && !strings.HasPrefix(addr, "tcp://"):
+		return "", "", 0, fmt.Errorf("invalid listen address %q: unsupported scheme", addr)
+	}
+	hostport := strings.TrimPrefix(addr, "tcp://")
+	if _, _, err := net.SplitHostPort(hostport); err != nil {
+		return "", "", 0, fmt.Errorf("invalid listen address %q: %w", addr, err)
+	}
+	return "tcp", hostport, 0, nil
+}
+
+// ListenerURL renders a listen address for display.
+func ListenerURL(addr string, useTLS bool) string {
+	network, address, _, err := ParseListenAddr(addr)
+	if err != nil {
+		return addr
+	}
+	if network == "unix" {
+		return "unix://" + address
+	}
+	if useTLS {
+		return "https://" + address
+	}
+	return "http://" + address
+}
+
+// Listen opens a listener for any address accepted by ParseListenAddr.
+func Listen(addr string) (net.Listener, error) {
+	network, address, mode, err := ParseListenAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	if network == "unix" {
+		return listenUnix(address, mode)
+	}
+	return net.Listen(network, address)
+}
+
+// listenUnix listens on a Unix socket, removing a stale socket file left behind by a
+// previous run. A socket that still accepts connections is left alone.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("socket %s is already in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("remove stale socket: %w", err)
+		}
+		log.Printf("%s[INFO]%s Removed stale socket %s", colorGreen, colorReset, path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			_ = ln.Close()
+			return nil, fmt.Errorf("chmod socket: %w", err)
+		}
+	}
+	return ln, nil
+}
+
+// localAddrs returns the normalized listen addresses for opts: bare host:port for TCP
+// and unix://path?mode=... for sockets. Without -listen it falls back to Host and Port.
+func localAddrs(opts Options) ([]string, error) {
+	if len(opts.Listen) == 0 {
+		return []string{net.JoinHostPort(opts.Host, strconv.Itoa(opts.Port))}, nil
+	}
+	addrs := make([]string, 0, len(opts.Listen))
+	for _, raw := range opts.Listen {
+		network, address, mode, err := ParseListenAddr(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, err
+		}
+		if network == "tcp" {
+			addrs = append(addrs, address)
+			continue
+		}
+		if mode == 0 {
+			mode = opts.SocketMode
+		}
+		addr := "unix://" + address
+		if mode != 0 {
+			addr += fmt.Sprintf("?mode=%04o", mode)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+// serveAddr serves mux on a single listen address, routing TCP through HTTPListenAndServe.
+func serveAddr(addr string, mux http.Handler) error {
+	network, address, _, err := ParseListenAddr(addr)
+	if err != nil {
+		return err
+	}
+	if network == "tcp" {
+		return HTTPListenAndServe(address, mux)
+	}
+	ln, err := Listen(addr)
+	if err != nil {
+		return err
+	}
+	return HTTPServe(ln, mux)
+}
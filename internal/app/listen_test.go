@@ -0,0 +1,205 @@
+package app
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseListenAddr(t *testing.T) {
+	cases := []struct {
+		in, network, address string
+		mode                 os.FileMode
+	}{
+		{"127.0.0.1:3000", "tcp", "127.0.0.1:3000", 0},
+		{":3000", "tcp", ":3000", 0},
+		{"tcp://0.0.0.0:80", "tcp", "0.0.0.0:80", 0},
+		{"[::1]:3000", "tcp", "[::1]:3000", 0},
+		{"tcp://[::1]:3000", "tcp", "[::1]:3000", 0},
+		{"unix:///tmp/wc.sock", "unix", "/tmp/wc.sock", 0},
+		{"unix://wc.sock", "unix", "wc.sock", 0},
+		{"unix:///tmp/wc.sock?mode=0660", "unix", "/tmp/wc.sock", 0660},
+	}
+	for _, tc := range cases {
+		network, address, mode, err := ParseListenAddr(tc.in)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", tc.in, err)
+		}
+		if network != tc.network || address != tc.address || mode != tc.mode {
+			t.Fatalf("%s: got (%s, %s, %o), want (%s, %s, %o)", tc.in, network, address, mode, tc.network, tc.address, tc.mode)
+		}
+	}
+
+	for _, bad := range []string{"localhost", "::1:3000", "udp://:53", "unix://", "unix:///x.sock?mode=rw", "unix:///x.sock?user=me"} {
+		if _, _, _, err := ParseListenAddr(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestListenerURL(t *testing.T) {
+	if got := ListenerURL("[::1]:3000", false); got != "http://[::1]:3000" {
+		t.Fatalf("unexpected %q", got)
+	}
+	if got := ListenerURL("127.0.0.1:443", true); got != "https://127.0.0.1:443" {
+		t.Fatalf("unexpected %q", got)
+	}
+	if got := ListenerURL("unix:///tmp/x.sock?mode=0600", false); got != "unix:///tmp/x.sock" {
+		t.Fatalf("unexpected %q", got)
+	}
+}
+
+func TestLocalAddrs(t *testing.T) {
+	addrs, err := localAddrs(Options{Host: "::1", Port: 3000})
+	if err != nil || len(addrs) != 1 || addrs[0] != "[::1]:3000" {
+		t.Fatalf("expected IPv6 host to be bracketed, got %v err=%v", addrs, err)
+	}
+
+	addrs, err = localAddrs(Options{
+		Host:       "ignored",
+		Port:       1,
+		Listen:     []string{"tcp://127.0.0.1:3000", "unix:///tmp/a.sock", "unix:///tmp/b.sock?mode=0600"},
+		SocketMode: 0660,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	want := []string{"127.0.0.1:3000", "unix:///tmp/a.sock?mode=0660", "unix:///tmp/b.sock?mode=0600"}
+	if strings.Join(addrs, " ") != strings.Join(want, " ") {
+		t.Fatalf("got %v, want %v", addrs, want)
+	}
+
+	if _, err := localAddrs(Options{Listen: []string{"nope"}}); err == nil {
+		t.Fatalf("expected error for invalid listen address")
+	}
+}
+
+// shortSocketPath returns a socket path short enough for sun_path limits.
+func shortSocketPath(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "wc")
+	if err != nil {
+		t.Fatalf("mkdtemp: %v", err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return filepath.Join(dir, "c.sock")
+}
+
+func TestListenUnix_ModeAndStaleCleanup(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix socket permissions are not supported on windows")
+	}
+	path := shortSocketPath(t)
+
+	// Leave a stale socket behind: a listener whose file is not unlinked on close.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	ln, err := Listen("unix://" + path + "?mode=0600")
+	if err != nil {
+		t.Fatalf("expected stale socket to be replaced, got %v", err)
+	}
+	defer ln.Close()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("expected mode 0600, got %o", fi.Mode().Perm())
+	}
+
+	// A live socket must not be removed.
+	if _, err := Listen("unix://" + path); err == nil || !strings.Contains(err.Error(), "already in use") {
+		t.Fatalf("expected in-use error, got %v", err)
+	}
+
+	// A regular file must not be removed either.
+	file := filepath.Join(filepath.Dir(path), "f.sock")
+	_ = os.WriteFile(file, []byte("x"), 0600)
+	if _, err := Listen("unix://" + file); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Fatalf("expected not-a-socket error, got %v", err)
+	}
+}
+
+func TestServeLocal_UnixSocket(t *testing.T) {
+	path := shortSocketPath(t)
+
+	origServe := HTTPServe
+	defer func() { HTTPServe = origServe }()
+	var ln net.Listener
+	served := make(chan struct{})
+	HTTPServe = func(l net.Listener, h http.Handler) error {
+		ln = l
+		close(served)
+		return http.Serve(l, h)
+	}
+	go func() { _ = ServeLocalFunc("unix://"+path, http.HandlerFunc(WebhookHandler)) }()
+	<-served
+	defer ln.Close()
+
+	hc := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+	out := captureStdout(func() {
+		resp, err := hc.Post("http://sidecar/unix", "text/plain", strings.NewReader("over a socket"))
+		if err != nil {
+			t.Errorf("post: %v", err)
+			return
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	})
+	if !strings.Contains(out, "over a socket") {
+		t.Fatalf("expected body in output, got: %s", out)
+	}
+}
+
+func TestRun_MultipleListeners(t *testing.T) {
+	orig := ServeLocalFunc
+	defer func() { ServeLocalFunc = orig }()
+	var mu sync.Mutex
+	var got []string
+	handlers := map[http.Handler]bool{}
+	done := make(chan struct{})
+	defer close(done)
+	ServeLocalFunc = func(addr string, mux http.Handler) error {
+		mu.Lock()
+		got = append(got, addr)
+		handlers[mux] = true
+		n := len(got)
+		mu.Unlock()
+		if n < 2 {
+			<-done
+		}
+		return nil
+	}
+
+	err := Run(Options{Listen: []string{"tcp://[::1]:3000", "unix:///tmp/wc-test.sock"}, SocketMode: 0660})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	sort.Strings(got)
+	if strings.Join(got, " ") != "[::1]:3000 unix:///tmp/wc-test.sock?mode=0660" {
+		t.Fatalf("unexpected addresses %v", got)
+	}
+	if len(handlers) != 1 {
+		t.Fatalf("expected all listeners to share one mux, got %d", len(handlers))
+	}
+}
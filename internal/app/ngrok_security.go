@@ -0,0 +1,133 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+// EndpointSecurity holds the protections ngrok enforces at its edge, before requests
+// reach the tunnel.
+type EndpointSecurity struct {
+	// BasicAuth holds user:password pairs; ngrok requires passwords of 8+ characters.
+	BasicAuth []string
+
+	// OAuthProvider (google, github, microsoft, ...) or the OIDC fields require a login.
+	// AllowEmails and AllowDomains restrict which accounts are accepted.
+	OAuthProvider    string
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	AllowEmails      []string
+	AllowDomains     []string
+
+	// AllowCIDRs and DenyCIDRs restrict client IPs.
+	AllowCIDRs []string
+	DenyCIDRs  []string
+
+	// WebhookProvider (stripe, github, slack, ...) makes ngrok reject requests whose
+	// signature does not verify with WebhookSecret.
+	WebhookProvider string
+	WebhookSecret   string
+}
+
+// WebhookSecretName is the credential store name consulted when a webhook provider
+// is set without a secret, e.g. "stripe-signing-secret".
+func WebhookSecretName(provider string) string {
+	return strings.ToLower(provider) + "-signing-secret"
+}
+
+// EndpointOptions validates s and returns the ngrok endpoint options along with a
+// short description of each active protection.
+func (s EndpointSecurity) EndpointOptions() ([]config.HTTPEndpointOption, []string, error) {
+	var opts []config.HTTPEndpointOption
+	var active []string
+
+	if len(s.BasicAuth) > 0 {
+		users := make([]string, 0, len(s.BasicAuth))
+		for _, cred := range s.BasicAuth {
+			user, pass, ok := strings.Cut(cred, ":")
+			if !ok || user == "" || pass == "" {
+				return nil, nil, errors.New("basic auth must be user:password")
+			}
+			opts = append(opts, config.WithBasicAuth(user, pass))
+			users = append(users, user)
+		}
+		active = append(active, "basic auth for "+strings.Join(users, ", "))
+	}
+
+	oidc := s.OIDCIssuer != "" || s.OIDCClientID != "" || s.OIDCClientSecret != ""
+	if s.OAuthProvider != "" && oidc {
+		return nil, nil, errors.New("OAuth and OIDC cannot be combined; pick one")
+	}
+	restrict := ""
+	if len(s.AllowEmails) > 0 {
+		restrict += " (emails: " + strings.Join(s.AllowEmails, ", ") + ")"
+	}
+	if len(s.AllowDomains) > 0 {
+		restrict += " (domains: " + strings.Join(s.AllowDomains, ", ") + ")"
+	}
+	switch {
+	case s.OAuthProvider != "":
+		var oauthOpts []config.OAuthOption
+		if len(s.AllowEmails) > 0 {
+			oauthOpts = append(oauthOpts, config.WithAllowOAuthEmail(s.AllowEmails...))
+		}
+		if len(s.AllowDomains) > 0 {
+			oauthOpts = append(oauthOpts, config.WithAllowOAuthDomain(s.AllowDomains...))
+		}
+		opts = append(opts, config.WithOAuth(s.OAuthProvider, oauthOpts...))
+		active = append(active, "OAuth via "+s.OAuthProvider+restrict)
+	case oidc:
+		if s.OIDCIssuer == "" || s.OIDCClientID == "" || s.OIDCClientSecret == "" {
+			return nil, nil, errors.New("OIDC needs an issuer URL, client ID and client secret")
+		}
+		var oidcOpts []config.OIDCOption
+		if len(s.AllowEmails) > 0 {
+			oidcOpts = append(oidcOpts, config.WithAllowOIDCEmail(s.AllowEmails...))
+		}
+		if len(s.AllowDomains) > 0 {
+			oidcOpts = append(oidcOpts, config.WithAllowOIDCDomain(s.AllowDomains...))
+		}
+		opts = append(opts, config.WithOIDC(s.OIDCIssuer, s.OIDCClientID, s.OIDCClientSecret, oidcOpts...))
+		active = append(active, "OIDC via "+s.OIDCIssuer+restrict)
+	case restrict != "":
+		return nil, nil, errors.New("allowed emails and domains require OAuth or OIDC")
+	}
+
+	if len(s.AllowCIDRs) > 0 {
+		if err := validateCIDRs(s.AllowCIDRs); err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, config.WithAllowCIDRString(s.AllowCIDRs...))
+		active = append(active, "IP allow "+strings.Join(s.AllowCIDRs, ", "))
+	}
+	if len(s.DenyCIDRs) > 0 {
+		if err := validateCIDRs(s.DenyCIDRs); err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, config.WithDenyCIDRString(s.DenyCIDRs...))
+		active = append(active, "IP deny "+strings.Join(s.DenyCIDRs, ", "))
+	}
+
+	if s.WebhookProvider != "" {
+		if s.WebhookSecret == "" {
+			return nil, nil, fmt.Errorf("webhook verification for %s needs a secret: set -ngrok-verify-webhook-secret or run `auth set %s`", s.WebhookProvider, WebhookSecretName(s.WebhookProvider))
+		}
+		opts = append(opts, config.WithWebhookVerification(s.WebhookProvider, s.WebhookSecret))
+		active = append(active, "webhook verification for "+s.WebhookProvider)
+	}
+	return opts, active, nil
+}
+
+func validateCIDRs(cidrs []string) error {
+	for _, c := range cidrs {
+		if _, _, err := net.ParseCIDR(c); err != nil {
+			return fmt.Errorf("invalid CIDR %q", c)
+		}
+	}
+	return nil
+}
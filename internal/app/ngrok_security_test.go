@@ -0,0 +1,146 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.ngrok.com/ngrok"
+	"golang.ngrok.com/ngrok/config"
+)
+
+// endpointJSON renders the endpoint config ngrok would send, for assertions.
+func endpointJSON(t *testing.T, epOpts []config.HTTPEndpointOption) string {
+	t.Helper()
+	ep, ok := config.HTTPEndpoint(epOpts...).(interface{ Opts() any })
+	if !ok {
+		t.Fatalf("endpoint config does not expose Opts")
+	}
+	b, err := json.Marshal(ep.Opts())
+	if err != nil {
+		t.Fatalf("marshal endpoint: %v", err)
+	}
+	return string(b)
+}
+
+func TestEndpointSecurity_Options(t *testing.T) {
+	s := EndpointSecurity{
+		BasicAuth:       []string{"alice:password1", "bob:pass:word2"},
+		OAuthProvider:   "google",
+		AllowEmails:     []string{"a@example.com"},
+		AllowDomains:    []string{"example.com"},
+		AllowCIDRs:      []string{"10.0.0.0/8"},
+		DenyCIDRs:       []string{"10.1.0.0/16"},
+		WebhookProvider: "stripe",
+		WebhookSecret:   "whsec_x",
+	}
+	opts, active, err := s.EndpointOptions()
+	if err != nil {
+		t.Fatalf("options: %v", err)
+	}
+	got := endpointJSON(t, opts)
+	for _, want := range []string{
+		`"username":"bob","cleartext_password":"pass:word2"`,
+		`"provider":"google","allow_emails":["a@example.com"],"allow_domains":["example.com"]`,
+		`"allow_cidrs":["10.0.0.0/8"],"deny_cidrs":["10.1.0.0/16"]`,
+		`"WebhookVerification":{"provider":"stripe","secret":"whsec_x"}`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected endpoint config to contain %s, got %s", want, got)
+		}
+	}
+	wantActive := []string{
+		"basic auth for alice, bob",
+		"OAuth via google (emails: a@example.com) (domains: example.com)",
+		"IP allow 10.0.0.0/8",
+		"IP deny 10.1.0.0/16",
+		"webhook verification for stripe",
+	}
+	if strings.Join(active, "|") != strings.Join(wantActive, "|") {
+		t.Fatalf("active = %q", active)
+	}
+	for _, a := range active {
+		if strings.Contains(a, "password1") || strings.Contains(a, "whsec") {
+			t.Fatalf("description leaks a secret: %q", a)
+		}
+	}
+}
+
+func TestEndpointSecurity_OIDC(t *testing.T) {
+	opts, active, err := EndpointSecurity{OIDCIssuer: "https://idp.example.com", OIDCClientID: "id", OIDCClientSecret: "sec", AllowDomains: []string{"example.com"}}.EndpointOptions()
+	if err != nil {
+		t.Fatalf("options: %v", err)
+	}
+	if got := endpointJSON(t, opts); !strings.Contains(got, `"issuer_url":"https://idp.example.com"`) {
+		t.Fatalf("expected OIDC config, got %s", got)
+	}
+	if len(active) != 1 || !strings.HasPrefix(active[0], "OIDC via https://idp.example.com") {
+		t.Fatalf("active = %q", active)
+	}
+}
+
+func TestEndpointSecurity_Errors(t *testing.T) {
+	cases := map[string]EndpointSecurity{
+		"user:password":         {BasicAuth: []string{"nopassword"}},
+		"cannot be combined":    {OAuthProvider: "github", OIDCIssuer: "https://idp"},
+		"client ID":             {OIDCIssuer: "https://idp"},
+		"require OAuth":         {AllowEmails: []string{"a@b.c"}},
+		`invalid CIDR "10.0"`:   {AllowCIDRs: []string{"10.0"}},
+		`invalid CIDR "x"`:      {DenyCIDRs: []string{"x"}},
+		"stripe-signing-secret": {WebhookProvider: "Stripe"},
+	}
+	for want, s := range cases {
+		if _, _, err := s.EndpointOptions(); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected error containing %q, got %v", want, err)
+		}
+	}
+	if opts, active, err := (EndpointSecurity{}).EndpointOptions(); err != nil || len(opts) != 0 || len(active) != 0 {
+		t.Fatalf("expected no options for zero value, got %d %q %v", len(opts), active, err)
+	}
+}
+
+func TestRun_Ngrok_SecurityOptions(t *testing.T) {
+	isolateEnv(t)
+	isolateCredentials(t)
+	_ = KeyringStore{Service: credentialService}.Set("github-signing-secret", "gh-secret")
+
+	var got []config.HTTPEndpointOption
+	orig := ServeNgrokFunc
+	defer func() { ServeNgrokFunc = orig }()
+	ServeNgrokFunc = func(ctx context.Context, epOpts []config.HTTPEndpointOption, connectOpts []ngrok.ConnectOption, mux http.Handler) error {
+		got = epOpts
+		return nil
+	}
+
+	var err error
+	out := captureStdout(func() {
+		err = Run(Options{Tunnel: true, NgrokToken: "tok", NgrokSecurity: EndpointSecurity{
+			BasicAuth:       []string{"dev:password1"},
+			WebhookProvider: "github",
+		}})
+	})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	out = stripANSI(out)
+	for _, want := range []string{"Tunnel protection: basic auth for dev", "Tunnel protection: webhook verification for github"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected output to contain %q, got: %s", want, out)
+		}
+	}
+	if cfg := endpointJSON(t, got); !strings.Contains(cfg, `"secret":"gh-secret"`) || !strings.Contains(cfg, `"username":"dev"`) {
+		t.Fatalf("expected protections in epOpts, got %s", cfg)
+	}
+
+	out = stripANSI(captureStdout(func() { err = Run(Options{Tunnel: true, NgrokToken: "tok"}) }))
+	if err != nil || !strings.Contains(out, "open to anyone") {
+		t.Fatalf("expected open tunnel warning, err=%v out=%s", err, out)
+	}
+
+	err = Run(Options{Tunnel: true, NgrokToken: "tok", NgrokSecurity: EndpointSecurity{DenyCIDRs: []string{"bad"}}})
+	if err == nil || !strings.Contains(err.Error(), "tunnel security error") {
+		t.Fatalf("expected tunnel security error, got %v", err)
+	}
+}
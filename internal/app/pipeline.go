@@ -0,0 +1,174 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Pipeline is what happens to a request once it reaches the catcher: its body is
+// read within Body's limits, verified, validated, answered, counted in Stats and
+// published. WebhookHandler runs the CLI session's pipeline; package catcher builds
+// its own, so several can serve side by side.
+type Pipeline struct {
+	Stats   *Stats
+	Body    BodyLimits
+	Schemas []SchemaRule
+	// Verify, when set, turns a capture away with 401 by returning an error.
+	Verify func(c *Capture) error
+	// Scripts may answer captures, tag them or keep them off the console.
+	Scripts *ScriptRunner
+	// Exec runs hooks for captures and may answer them; see ExecOptions.Respond.
+	Exec *ExecRunner
+	// Respond answers captures that no schema rule, script or exec hook answered;
+	// nil or a nil Response means "ok".
+	Respond func(c *Capture) *Response
+	// Publish hands each answered capture to its consumers.
+	Publish func(CaptureEvent)
+	// Logf reports refused requests; nil means log.Printf.
+	Logf func(format string, args ...any)
+}
+
+// Response is an answer to a captured request.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// write sends resp; a zero Status means 200.
+func (resp *Response) write(w http.ResponseWriter) int {
+	for k, vals := range resp.Header {
+		w.Header()[k] = vals
+	}
+	status := resp.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write(resp.Body)
+	return status
+}
+
+func (p *Pipeline) logf(format string, args ...any) {
+	if p.Logf != nil {
+		p.Logf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+func (p *Pipeline) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Always respond quickly to not block senders
+	defer r.Body.Close()
+
+	// Refuse bodies over the limit up front when the sender announces the size
+	if r.ContentLength > p.Body.Max {
+		p.rejectLargeBody(w, r)
+		return
+	}
+
+	// Read the body, spooling large ones to disk
+	c := NewCapture(r, nil)
+	if err := readBody(r.Body, c, p.Body); err != nil {
+		if errors.Is(err, errBodyTooLarge) {
+			p.rejectLargeBody(w, r)
+			return
+		}
+		p.logf("failed to read request body: %v", err)
+		p.Stats.Record(r.URL.Path, http.StatusBadRequest, len(c.Body), time.Now())
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("failed to read body"))
+		return
+	}
+
+	if p.Verify != nil {
+		if err := p.Verify(c); err != nil {
+			p.logf("%s[WARN]%s Rejected %s %s from %s: %v", colorYellow, colorReset, r.Method, r.URL.Path, r.RemoteAddr, err)
+			p.Stats.Reject("verification failed")
+			// A rejected capture is not kept, so neither is its spooled body
+			if c.BodyFile != "" {
+				_ = os.Remove(c.BodyFile)
+			}
+			http.Error(w, "verification failed: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	status := http.StatusOK
+	rule, validate := matchSchemaRule(p.Schemas, c.Path)
+	if validate {
+		c.Schema = rule.File
+		c.SchemaErrors = validateBody(rule, c)
+	}
+
+	// Respond first; printing and storing happen on the capture bus
+	ev := CaptureEvent{Capture: c}
+	rejected := validate && len(c.SchemaErrors) > 0 && rule.Reject != 0
+	if !rejected {
+		ev.Script = p.Scripts.Run(c)
+	}
+	responder := p.Exec.responder(c.Path)
+	switch {
+	case rejected:
+		status = rule.Reject
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]any{"error": "schema validation failed", "violations": c.SchemaErrors})
+		responder = -1
+	case ev.Script != nil && ev.Script.Response() != nil:
+		status = ev.Script.Response().write(w)
+		responder = -1
+	case responder >= 0:
+		ev.Exec = p.Exec.Run(r.Context(), responder, c)
+		status = ev.Exec.Response().write(w)
+	default:
+		var resp *Response
+		if p.Respond != nil {
+			resp = p.Respond(c)
+		}
+		if resp == nil {
+			resp = &Response{Header: http.Header{"Content-Type": {"text/plain"}}, Body: []byte("ok")}
+		}
+		status = resp.write(w)
+	}
+
+	ev.Status = status
+	p.Stats.Record(c.Path, status, int(c.BodyLen()), c.Time)
+	if p.Publish != nil {
+		p.Publish(ev)
+	}
+	if p.Exec != nil {
+		p.Exec.Start(c, responder, func(res *ExecResult) {
+			if p.Publish != nil {
+				p.Publish(CaptureEvent{Capture: c, Background: res})
+			}
+		})
+	}
+}
+
+// rejectLargeBody answers 413 for a body over the limit instead of truncating it.
+func (p *Pipeline) rejectLargeBody(w http.ResponseWriter, r *http.Request) {
+	max := FormatByteSize(p.Body.Max)
+	p.logf("%s[WARN]%s Rejected %s %s from %s: body over the %s limit", colorYellow, colorReset, r.Method, r.URL.Path, r.RemoteAddr, max)
+	p.Stats.Record(r.URL.Path, http.StatusRequestEntityTooLarge, 0, time.Now())
+	w.Header().Set("Connection", "close")
+	http.Error(w, fmt.Sprintf("request body exceeds %s", max), http.StatusRequestEntityTooLarge)
+}
+
+// validateBody checks c's body against rule, reading it back if it was spooled.
+func validateBody(rule SchemaRule, c *Capture) []SchemaViolation {
+	if c.BodyFile == "" {
+		return rule.Validate(c.Body)
+	}
+	f, err := c.OpenBody()
+	if err != nil {
+		return []SchemaViolation{{Message: "read spooled body: " + err.Error()}}
+	}
+	defer f.Close()
+	return rule.ValidateReader(f)
+}
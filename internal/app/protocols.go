@@ -0,0 +1,44 @@
+package app
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// ServeHTTP3Func starts an HTTP/3 server over QUIC on the UDP side of addr. Overridable in tests.
+var ServeHTTP3Func = func(addr string, mux http.Handler, tlsCfg *tls.Config) error {
+	srv := &http3.Server{Addr: addr, Handler: mux, TLSConfig: http3.ConfigureTLSConfig(tlsCfg)}
+	activeServers.add(closerFunc(srv.Close))
+	return srv.ListenAndServe()
+}
+
+// WithH2C wraps h so that plaintext listeners also accept HTTP/2 with prior knowledge
+// or an h2c upgrade, in addition to HTTP/1.1.
+func WithH2C(h http.Handler) http.Handler {
+	return h2c.NewHandler(h, &http2.Server{})
+}
+
+// WithAltSvc advertises an HTTP/3 endpoint on port to clients connecting over TCP.
+func WithAltSvc(h http.Handler, port int) http.Handler {
+	altSvc := fmt.Sprintf(`h3=":%d"; ma=86400`, port)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor < 3 {
+			w.Header().Set("Alt-Svc", altSvc)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// serveAll runs each server function concurrently and returns the first error.
+func serveAll(fns ...func() error) error {
+	errCh := make(chan error, len(fns))
+	for _, fn := range fns {
+		go func(fn func() error) { errCh <- fn() }(fn)
+	}
+	return <-errCh
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+)
+
+func TestWithAltSvc(t *testing.T) {
+	h := WithAltSvc(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), 8443)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/", nil))
+	if got := w.Header().Get("Alt-Svc"); got != `h3=":8443"; ma=86400` {
+		t.Fatalf("unexpected Alt-Svc %q", got)
+	}
+
+	r := httptest.NewRequest("POST", "/", nil)
+	r.ProtoMajor = 3
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got := w.Header().Get("Alt-Svc"); got != "" {
+		t.Fatalf("expected no Alt-Svc on HTTP/3 responses, got %q", got)
+	}
+}
+
+func TestWebhookHandler_H2C(t *testing.T) {
+	ts := httptest.NewServer(WithH2C(http.HandlerFunc(WebhookHandler)))
+	defer ts.Close()
+
+	hc := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+
+	out := captureStdout(func() {
+		resp, err := hc.Post(ts.URL+"/h2c", "text/plain", strings.NewReader("hi"))
+		if err != nil {
+			t.Errorf("post: %v", err)
+			return
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	})
+	out = stripANSI(out)
+	if !strings.Contains(out, "Method: POST /h2c HTTP/2.0") {
+		t.Fatalf("expected HTTP/2.0 in output, got: %s", out)
+	}
+}
+
+func TestWebhookHandler_HTTP3(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "Acme Root", 1, true, nil)
+	certPath, keyPath := writeTestCert(t, dir, "server", newTestCert(t, "localhost", 2, false, ca))
+	cfg, err := BuildTLSConfig(certPath, keyPath, "", "none")
+	if err != nil {
+		t.Fatalf("build tls config: %v", err)
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("udp not available: %v", err)
+	}
+	srv := &http3.Server{Handler: http.HandlerFunc(WebhookHandler), TLSConfig: http3.ConfigureTLSConfig(cfg)}
+	go func() { _ = srv.Serve(conn) }()
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	rt := &http3.RoundTripper{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	defer rt.Close()
+	hc := &http.Client{Transport: rt}
+
+	out := captureStdout(func() {
+		resp, err := hc.Post("https://"+conn.LocalAddr().String()+"/h3", "text/plain", strings.NewReader("hi"))
+		if err != nil {
+			t.Errorf("post: %v", err)
+			return
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	})
+	out = stripANSI(out)
+	if !strings.Contains(out, "Method: POST /h3 HTTP/3.0") || !strings.Contains(out, "ALPN: h3") {
+		t.Fatalf("expected HTTP/3.0 over h3 in output, got: %s", out)
+	}
+}
@@ -0,0 +1,299 @@
+package app
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate is a token bucket's size and how fast it refills.
+type Rate struct {
+	Limit float64 // tokens per second
+	Burst int
+}
+
+// ParseRate parses "N/s", "N/m" or "N/h" (N may be fractional), optionally with
+// ":BURST". The burst defaults to N rounded up, and at least 1.
+func ParseRate(spec string) (Rate, error) {
+	s, burstStr, hasBurst := strings.Cut(spec, ":")
+	n, unit, ok := strings.Cut(s, "/")
+	if !ok {
+		unit = "s"
+	}
+	count, err := strconv.ParseFloat(n, 64)
+	if err != nil || count <= 0 {
+		return Rate{}, fmt.Errorf("rate %q: want N/s, N/m or N/h with N > 0", spec)
+	}
+	per := map[string]float64{"s": 1, "m": 60, "h": 3600}[unit]
+	if per == 0 {
+		return Rate{}, fmt.Errorf("rate %q: unit must be s, m or h", spec)
+	}
+	r := Rate{Limit: count / per, Burst: int(math.Max(1, math.Ceil(count)))}
+	if hasBurst {
+		if r.Burst, err = strconv.Atoi(burstStr); err != nil || r.Burst < 1 {
+			return Rate{}, fmt.Errorf("rate %q: bad burst %q", spec, burstStr)
+		}
+	}
+	return r, nil
+}
+
+// String uses the smallest unit in which the rate is a whole number, like the spec
+// it was parsed from.
+func (r Rate) String() string {
+	for _, u := range []struct {
+		name string
+		secs float64
+	}{{"s", 1}, {"m", 60}} {
+		if n := r.Limit * u.secs; math.Abs(n-math.Round(n)) < 1e-9 {
+			return fmt.Sprintf("%g/%s (burst %d)", math.Round(n), u.name, r.Burst)
+		}
+	}
+	return fmt.Sprintf("%g/h (burst %d)", math.Round(r.Limit*3600*1000)/1000, r.Burst)
+}
+
+// tokenBucket is a Rate's state.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens earned since the last refill; a new bucket starts full.
+func (b *tokenBucket) refill(r Rate, now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = float64(r.Burst)
+	} else {
+		b.tokens = math.Min(float64(r.Burst), b.tokens+now.Sub(b.last).Seconds()*r.Limit)
+	}
+	b.last = now
+}
+
+// wait is how long until the bucket holds a token; 0 when it does.
+func (b *tokenBucket) wait(r Rate) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / r.Limit * float64(time.Second))
+}
+
+// RateLimitOptions limit how fast the catcher accepts requests.
+type RateLimitOptions struct {
+	// PerIP and Global are ParseRate specs; empty means unlimited.
+	PerIP  string
+	Global string
+	// Mode is "reject" (429 with Retry-After, the default) or "drop": answer 200
+	// as usual but neither capture nor print the request.
+	Mode string
+}
+
+// rateLimitSources caps the per-IP buckets kept; the least recently seen source
+// is forgotten to make room for a new one.
+const rateLimitSources = 10000
+
+// sourceBucket is a per-IP bucket in the RateLimiter's recency list.
+type sourceBucket struct {
+	source string
+	tokenBucket
+}
+
+// RateLimiter applies per-source and global token buckets and remembers what it
+// turned away so floods can be summarised instead of printed.
+type RateLimiter struct {
+	perIP, global Rate
+	drop          bool
+
+	mu         sync.Mutex
+	buckets    map[string]*list.Element // of *sourceBucket, in recent
+	recent     *list.List               // most recently seen first
+	all        tokenBucket
+	suppressed map[string]int
+}
+
+// NewRateLimiter validates o. It returns nil when o sets no limit.
+func NewRateLimiter(o RateLimitOptions) (*RateLimiter, error) {
+	l := &RateLimiter{buckets: map[string]*list.Element{}, recent: list.New(), suppressed: map[string]int{}}
+	switch o.Mode {
+	case "", "reject":
+	case "drop":
+		l.drop = true
+	default:
+		return nil, fmt.Errorf("unknown rate limit mode %q (want reject or drop)", o.Mode)
+	}
+	var err error
+	if o.PerIP != "" {
+		if l.perIP, err = ParseRate(o.PerIP); err != nil {
+			return nil, err
+		}
+	}
+	if o.Global != "" {
+		if l.global, err = ParseRate(o.Global); err != nil {
+			return nil, err
+		}
+	}
+	if o.PerIP == "" && o.Global == "" {
+		return nil, nil
+	}
+	return l, nil
+}
+
+// Describe lists the active limits for the startup log.
+func (l *RateLimiter) Describe() []string {
+	var active []string
+	if l.perIP.Limit > 0 {
+		active = append(active, "per IP "+l.perIP.String())
+	}
+	if l.global.Limit > 0 {
+		active = append(active, "global "+l.global.String())
+	}
+	mode := "answered 429"
+	if l.drop {
+		mode = "dropped silently"
+	}
+	return append(active, "excess requests are "+mode)
+}
+
+// Allow spends a token from source's bucket and the global one, or counts the
+// request as suppressed and returns how long the sender should wait. Tokens are
+// only spent when both buckets have one.
+func (l *RateLimiter) Allow(source string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var wait time.Duration
+	var b *tokenBucket
+	if l.perIP.Limit > 0 {
+		b = l.bucket(source)
+		b.refill(l.perIP, now)
+		wait = b.wait(l.perIP)
+	}
+	if l.global.Limit > 0 {
+		l.all.refill(l.global, now)
+		wait = max(wait, l.all.wait(l.global))
+	}
+	if wait > 0 {
+		l.suppressed[source]++
+		return false, wait
+	}
+	if b != nil {
+		b.tokens--
+	}
+	if l.global.Limit > 0 {
+		l.all.tokens--
+	}
+	return true, 0
+}
+
+// bucket returns source's bucket, marking it the most recently seen. A new one
+// evicts the least recently seen source once rateLimitSources are kept.
+func (l *RateLimiter) bucket(source string) *tokenBucket {
+	if e := l.buckets[source]; e != nil {
+		l.recent.MoveToFront(e)
+		return &e.Value.(*sourceBucket).tokenBucket
+	}
+	if l.recent.Len() >= rateLimitSources {
+		oldest := l.recent.Back()
+		l.recent.Remove(oldest)
+		delete(l.buckets, oldest.Value.(*sourceBucket).source)
+	}
+	sb := &sourceBucket{source: source}
+	l.buckets[source] = l.recent.PushFront(sb)
+	return &sb.tokenBucket
+}
+
+// Report writes one line per source suppressed since the last report, busiest
+// first, and resets the counts. It writes nothing when nothing was suppressed.
+func (l *RateLimiter) Report(w io.Writer, window time.Duration) {
+	l.mu.Lock()
+	counts := l.suppressed
+	l.suppressed = map[string]int{}
+	l.mu.Unlock()
+
+	sources := make([]string, 0, len(counts))
+	for src := range counts {
+		sources = append(sources, src)
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		if counts[sources[i]] != counts[sources[j]] {
+			return counts[sources[i]] > counts[sources[j]]
+		}
+		return sources[i] < sources[j]
+	})
+	lg := log.New(w, "", log.LstdFlags)
+	const shown = 5
+	for i, src := range sources {
+		if i == shown {
+			rest := 0
+			for _, s := range sources[shown:] {
+				rest += counts[s]
+			}
+			lg.Printf("%s[WARN]%s suppressed %s more requests from %d other sources in the last %s", colorYellow, colorReset, groupThousands(rest), len(sources)-shown, window)
+			break
+		}
+		lg.Printf("%s[WARN]%s suppressed %s requests from %s in the last %s", colorYellow, colorReset, groupThousands(counts[src]), src, window)
+	}
+}
+
+// rateLimitWindow is how often suppressed requests are summarised.
+var rateLimitWindow = 5 * time.Second
+
+// Run prints a flood summary every rateLimitWindow until ctx is done. What is
+// left at the end shows up in the session summary's rejected count.
+func (l *RateLimiter) Run(ctx context.Context) {
+	t := time.NewTicker(rateLimitWindow)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			printMu.Lock()
+			l.Report(os.Stdout, rateLimitWindow)
+			printMu.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// groupThousands formats n as 4,213.
+func groupThousands(n int) string {
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0 && s[i-1] != '-'; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// WithRateLimit turns away requests over l's limits before they are captured or
+// printed; they are counted in the session stats. A nil limiter lets everything through.
+func WithRateLimit(h http.Handler, l *RateLimiter) http.Handler {
+	if l == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		source := "local"
+		if ip := remoteIP(r.RemoteAddr); ip != nil {
+			source = ip.String()
+		}
+		ok, wait := l.Allow(source, time.Now())
+		if ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		sessionStats.Reject("rate limited")
+		if l.drop {
+			_, _ = io.Copy(io.Discard, io.LimitReader(r.Body, bodyPolicy.Max))
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte("ok"))
+			return
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(wait.Seconds())))))
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	})
+}
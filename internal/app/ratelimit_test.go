@@ -0,0 +1,221 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	for spec, want := range map[string]Rate{
+		"10/s":    {Limit: 10, Burst: 10},
+		"20":      {Limit: 20, Burst: 20},
+		"120/m":   {Limit: 2, Burst: 120},
+		"0.5/s":   {Limit: 0.5, Burst: 1},
+		"30/h:5":  {Limit: 30.0 / 3600, Burst: 5},
+		"20/s:40": {Limit: 20, Burst: 40},
+	} {
+		if got, err := ParseRate(spec); err != nil || got != want {
+			t.Errorf("ParseRate(%q) = %+v, %v; want %+v", spec, got, err, want)
+		}
+	}
+	for _, spec := range []string{"", "0/s", "-1/s", "ten/s", "10/d", "10/s:0", "10/s:x"} {
+		if _, err := ParseRate(spec); err == nil {
+			t.Errorf("ParseRate(%q): expected error", spec)
+		}
+	}
+	if s := (Rate{Limit: 0.5, Burst: 2}).String(); s != "30/m (burst 2)" {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+func TestRateLimiter_Allow(t *testing.T) {
+	l, err := NewRateLimiter(RateLimitOptions{PerIP: "2/s", Global: "3/s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	allow := func(src string, at time.Duration) (bool, time.Duration) { return l.Allow(src, now.Add(at)) }
+
+	// The per-IP burst of 2 runs out first
+	for i := 0; i < 2; i++ {
+		if ok, _ := allow("a", 0); !ok {
+			t.Fatalf("request %d from a should pass", i+1)
+		}
+	}
+	if ok, wait := allow("a", 0); ok || wait != 500*time.Millisecond {
+		t.Fatalf("third request from a: ok=%v wait=%s, want limited for 500ms", ok, wait)
+	}
+	// Then the global burst of 3
+	if ok, _ := allow("b", 0); !ok {
+		t.Fatal("first request from b should pass")
+	}
+	if ok, _ := allow("c", 0); ok {
+		t.Fatal("global limit should stop c")
+	}
+	// Tokens refill with time
+	if ok, _ := allow("a", 600*time.Millisecond); !ok {
+		t.Fatal("a should have a token again after 600ms")
+	}
+
+	var out bytes.Buffer
+	l.Report(&out, 5*time.Second)
+	got := stripANSI(out.String())
+	if !strings.Contains(got, "[WARN] suppressed 1 requests from a in the last 5s") || !strings.Contains(got, "suppressed 1 requests from c") {
+		t.Errorf("unexpected report:\n%s", got)
+	}
+	out.Reset()
+	l.Report(&out, 5*time.Second)
+	if out.Len() != 0 {
+		t.Errorf("report should reset the counts, got %q", out.String())
+	}
+}
+
+func TestRateLimiter_ReportBusiestFirst(t *testing.T) {
+	l, _ := NewRateLimiter(RateLimitOptions{PerIP: "1/h:1"})
+	now := time.Now()
+	for i := 0; i < 7; i++ {
+		src := fmt.Sprintf("10.0.0.%d", i)
+		for n := 0; n <= i*1000+1; n++ {
+			l.Allow(src, now)
+		}
+	}
+	var out bytes.Buffer
+	l.Report(&out, time.Minute)
+	lines := strings.Split(strings.TrimSpace(stripANSI(out.String())), "\n")
+	if len(lines) != 6 || !strings.HasSuffix(lines[0], "suppressed 6,001 requests from 10.0.0.6 in the last 1m0s") ||
+		!strings.HasSuffix(lines[5], "suppressed 1,002 more requests from 2 other sources in the last 1m0s") {
+		t.Errorf("unexpected report:\n%s", out.String())
+	}
+}
+
+func TestRateLimiter_SourceCap(t *testing.T) {
+	l, _ := NewRateLimiter(RateLimitOptions{PerIP: "1/h"})
+	now := time.Now()
+	for i := 0; i < rateLimitSources; i++ {
+		l.Allow(fmt.Sprint(i), now)
+	}
+	// Seeing source 0 again makes 1 the least recently seen
+	l.Allow("0", now)
+	for i := 0; i < 5; i++ {
+		l.Allow(fmt.Sprint("late", i), now)
+	}
+	if len(l.buckets) != rateLimitSources || l.recent.Len() != rateLimitSources {
+		t.Fatalf("the number of buckets should be capped, %d kept", len(l.buckets))
+	}
+	if l.buckets["0"] == nil || l.buckets["1"] != nil || l.buckets["5"] != nil || l.buckets["6"] == nil {
+		t.Error("the least recently seen sources should be evicted first")
+	}
+}
+
+func TestRateLimiter_GlobalLimitKeepsPerIPTokens(t *testing.T) {
+	l, _ := NewRateLimiter(RateLimitOptions{PerIP: "1/m", Global: "1/s"})
+	now := time.Unix(1700000000, 0)
+	if ok, _ := l.Allow("b", now); !ok {
+		t.Fatal("b should pass")
+	}
+	if ok, _ := l.Allow("a", now); ok {
+		t.Fatal("the global limit should stop a")
+	}
+	// a's own token was not spent on the request the global limit turned away
+	if ok, _ := l.Allow("a", now.Add(time.Second)); !ok {
+		t.Error("a should pass once the global bucket refills")
+	}
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	if l, err := NewRateLimiter(RateLimitOptions{Mode: "drop"}); l != nil || err != nil {
+		t.Errorf("no limits should mean no limiter, got %v %v", l, err)
+	}
+	for _, o := range []RateLimitOptions{{PerIP: "x"}, {Global: "1/y"}, {PerIP: "1/s", Mode: "block"}} {
+		if _, err := NewRateLimiter(o); err == nil {
+			t.Errorf("expected error for %+v", o)
+		}
+	}
+	l, _ := NewRateLimiter(RateLimitOptions{PerIP: "5/s", Global: "100/m", Mode: "drop"})
+	if got := strings.Join(l.Describe(), "; "); got != "per IP 5/s (burst 5); global 100/m (burst 100); excess requests are dropped silently" {
+		t.Errorf("Describe() = %q", got)
+	}
+}
+
+func TestWithRateLimit(t *testing.T) {
+	for _, mode := range []string{"reject", "drop"} {
+		sessionStats = NewStats()
+		l, _ := NewRateLimiter(RateLimitOptions{PerIP: "1/m", Mode: mode})
+		h := WithRateLimit(http.HandlerFunc(WebhookHandler), l)
+		send := func(remote string) (*httptest.ResponseRecorder, string) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader("{}"))
+			r.RemoteAddr = remote
+			w := httptest.NewRecorder()
+			return w, captureStdout(func() { h.ServeHTTP(w, r) })
+		}
+
+		if w, out := send("192.0.2.1:1000"); w.Code != 200 || !strings.Contains(out, "WEBHOOK RECEIVED") {
+			t.Fatalf("%s: first request: %d %q", mode, w.Code, out)
+		}
+		w, out := send("192.0.2.1:1001")
+		if out != "" {
+			t.Errorf("%s: limited request was printed: %q", mode, out)
+		}
+		switch mode {
+		case "reject":
+			if w.Code != 429 || w.Header().Get("Retry-After") != "60" {
+				t.Errorf("reject: status %d Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+			}
+		case "drop":
+			if w.Code != 200 || w.Body.String() != "ok" {
+				t.Errorf("drop: status %d body %q", w.Code, w.Body.String())
+			}
+		}
+		if w, _ := send("192.0.2.2:1000"); w.Code != 200 {
+			t.Errorf("%s: another source should have its own bucket, got %d", mode, w.Code)
+		}
+		if sessionStats.Total() != 2 || sessionStats.Rejected() != 1 {
+			t.Errorf("%s: total=%d rejected=%d", mode, sessionStats.Total(), sessionStats.Rejected())
+		}
+	}
+}
+
+func TestRateLimiter_Run(t *testing.T) {
+	old := rateLimitWindow
+	rateLimitWindow = 20 * time.Millisecond
+	defer func() { rateLimitWindow = old }()
+	l, _ := NewRateLimiter(RateLimitOptions{Global: "1/h"})
+	l.Allow("a", time.Now())
+	l.Allow("a", time.Now())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := captureStdout(func() {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			l.Run(ctx)
+		}()
+		time.Sleep(70 * time.Millisecond)
+		cancel()
+		<-done
+	})
+	if strings.Count(out, "suppressed 1 requests from a") != 1 {
+		t.Errorf("expected a single flood summary, got %q", out)
+	}
+}
+
+func TestGroupThousands(t *testing.T) {
+	for n, want := range map[int]string{0: "0", 999: "999", 4213: "4,213", 1234567: "1,234,567", -1000: "-1,000"} {
+		if got := groupThousands(n); got != want {
+			t.Errorf("groupThousands(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestRun_RateLimitError(t *testing.T) {
+	isolateEnv(t)
+	if err := Run(Options{RateLimit: RateLimitOptions{PerIP: "fast"}}); err == nil || !strings.Contains(err.Error(), "rate limit error") {
+		t.Fatalf("expected rate limit error, got %v", err)
+	}
+}
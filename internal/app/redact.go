@@ -0,0 +1,391 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// RedactedValue replaces redacted header values, JSON values and form fields.
+const RedactedValue = "[REDACTED]"
+
+// DefaultRedactHeaders carry credentials or signatures and are always redacted.
+var DefaultRedactHeaders = []string{
+	"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key",
+	"X-Hub-Signature", "X-Hub-Signature-256", "Stripe-Signature", "X-Slack-Signature", "X-Shopify-Hmac-Sha256",
+}
+
+// Detector finds one kind of secret or personal data in free text. Matches are
+// replaced with "[REDACTED:Name]".
+type Detector struct {
+	Name    string
+	Pattern *regexp.Regexp
+	// Valid, when set, must accept a match before it is redacted.
+	Valid func(string) bool
+}
+
+// Detectors are the built-in detectors, in the order they run.
+var Detectors = []Detector{
+	{Name: "private-key", Pattern: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)},
+	{Name: "jwt", Pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)},
+	{Name: "bearer", Pattern: regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/-]+=*`)},
+	{Name: "aws-key", Pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{Name: "stripe-key", Pattern: regexp.MustCompile(`\b(?:sk|rk|whsec)_(?:live_|test_)?[A-Za-z0-9]{16,}\b`)},
+	{Name: "github-token", Pattern: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{Name: "slack-token", Pattern: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{Name: "card", Pattern: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), Valid: luhn},
+	{Name: "email", Pattern: regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}\b`)},
+}
+
+// luhn reports whether the digits in s pass the Luhn checksum used by card numbers.
+func luhn(s string) bool {
+	sum, double := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// DetectorNames lists the built-in detectors.
+func DetectorNames() []string {
+	names := make([]string, len(Detectors))
+	for i, d := range Detectors {
+		names[i] = d.Name
+	}
+	return names
+}
+
+// RedactOptions configure redaction of captures before they are shown or stored.
+type RedactOptions struct {
+	// Targets are where captures are redacted: console (the default), store,
+	// forward (captures resent by replay), all or none. Entries may be
+	// comma-separated.
+	Targets []string
+	// Headers are redacted in addition to DefaultRedactHeaders.
+	Headers []string
+	// Paths are dotted JSON body paths as in DiffCaptures, e.g. "**.card.fingerprint".
+	Paths []string
+	// Fields are form field names (application/x-www-form-urlencoded bodies).
+	Fields []string
+	// Detectors names the detectors to run; empty means all, "none" disables them.
+	Detectors []string
+}
+
+// redactTargets are the places a capture can be redacted.
+var redactTargets = []string{"console", "store", "forward"}
+
+// Redactors builds the redactor for each target; a target that is not redacted
+// gets nil.
+func (o RedactOptions) Redactors() (console, store, forward *Redactor, err error) {
+	on := map[string]bool{}
+	targets := splitList(o.Targets)
+	if len(targets) == 0 {
+		targets = []string{"console"}
+	}
+	for _, t := range targets {
+		switch t {
+		case "none":
+			on = map[string]bool{}
+		case "all":
+			for _, name := range redactTargets {
+				on[name] = true
+			}
+		case "console", "store", "forward":
+			on[t] = true
+		default:
+			return nil, nil, nil, fmt.Errorf("unknown redaction target %q (want %s, all or none)", t, strings.Join(redactTargets, ", "))
+		}
+	}
+	if len(on) == 0 {
+		return nil, nil, nil, nil
+	}
+	r, err := o.Redactor()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if on["console"] {
+		console = r
+	}
+	if on["store"] {
+		store = r
+	}
+	if on["forward"] {
+		forward = r
+	}
+	return console, store, forward, nil
+}
+
+// Redactor builds a redactor from the options, ignoring Targets.
+func (o RedactOptions) Redactor() (*Redactor, error) {
+	r := &Redactor{headers: map[string]bool{}, fields: map[string]bool{}}
+	for _, h := range append(append([]string(nil), DefaultRedactHeaders...), splitList(o.Headers)...) {
+		r.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	r.paths = splitList(o.Paths)
+	for _, f := range splitList(o.Fields) {
+		r.fields[f] = true
+	}
+	names := splitList(o.Detectors)
+	if len(names) == 0 {
+		r.detectors = Detectors
+		return r, nil
+	}
+	for _, name := range names {
+		if name == "none" {
+			r.detectors = nil
+			continue
+		}
+		found := false
+		for _, d := range Detectors {
+			if d.Name == name || name == "all" {
+				r.detectors = append(r.detectors, d)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown detector %q (want %s, all or none)", name, strings.Join(DetectorNames(), ", "))
+		}
+	}
+	return r, nil
+}
+
+// splitList flattens repeatable, comma-separated values and drops empty entries.
+func splitList(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// Redactor masks sensitive header values, JSON paths, form fields and detector
+// matches in captures. A nil *Redactor leaves captures untouched.
+type Redactor struct {
+	headers   map[string]bool
+	paths     []string
+	fields    map[string]bool
+	detectors []Detector
+}
+
+// Redact returns a copy of c with sensitive data masked; c itself is not modified.
+func (r *Redactor) Redact(c *Capture) *Capture {
+	if r == nil {
+		return c
+	}
+	cp := *c
+	cp.Header = make(http.Header, len(c.Header))
+	for k, vals := range c.Header {
+		masked := make([]string, len(vals))
+		for i, v := range vals {
+			if r.headers[http.CanonicalHeaderKey(k)] {
+				masked[i] = RedactedValue
+			} else {
+				masked[i] = r.Text(v)
+			}
+		}
+		cp.Header[k] = masked
+	}
+	cp.Body = r.body(c.Header.Get("Content-Type"), c.Body)
+	return &cp
+}
+
+// Text runs the detectors over s. A nil Redactor returns s unchanged.
+func (r *Redactor) Text(s string) string {
+	if r == nil {
+		return s
+	}
+	for _, d := range r.detectors {
+		d := d
+		s = d.Pattern.ReplaceAllStringFunc(s, func(m string) string {
+			if d.Valid != nil && !d.Valid(m) {
+				return m
+			}
+			return "[REDACTED:" + d.Name + "]"
+		})
+	}
+	return s
+}
+
+func (r *Redactor) body(contentType string, body []byte) []byte {
+	if len(body) == 0 {
+		return body
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	trimmed := bytes.TrimSpace(body)
+	switch {
+	case len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed):
+		return r.json(body)
+	case mediaType == "application/x-www-form-urlencoded":
+		return r.form(body)
+	case utf8.Valid(body):
+		if s := r.Text(string(body)); s != string(body) {
+			return []byte(s)
+		}
+	}
+	return body
+}
+
+// json rewrites a JSON body token by token so key order survives. The body is
+// returned unchanged (formatting included) when nothing was redacted.
+func (r *Redactor) json(body []byte) []byte {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var out bytes.Buffer
+	changed := false
+	var walk func(p string) error
+	walk = func(p string) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if p != "" && r.matchPath(p) {
+			if d, ok := tok.(json.Delim); ok && (d == '{' || d == '[') {
+				for depth := 1; depth > 0; {
+					t, err := dec.Token()
+					if err != nil {
+						return err
+					}
+					if d, ok := t.(json.Delim); ok {
+						if d == '{' || d == '[' {
+							depth++
+						} else {
+							depth--
+						}
+					}
+				}
+			}
+			writeJSONString(&out, RedactedValue)
+			changed = true
+			return nil
+		}
+		switch t := tok.(type) {
+		case json.Delim:
+			open, end := byte(t), byte('}')
+			if open == '[' {
+				end = ']'
+			}
+			out.WriteByte(open)
+			for i := 0; dec.More(); i++ {
+				if i > 0 {
+					out.WriteByte(',')
+				}
+				child := joinJSONPath(p, strconv.Itoa(i))
+				if open == '{' {
+					kt, err := dec.Token()
+					if err != nil {
+						return err
+					}
+					key, _ := kt.(string)
+					writeJSONString(&out, key)
+					out.WriteByte(':')
+					child = joinJSONPath(p, key)
+				}
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+			if _, err := dec.Token(); err != nil {
+				return err
+			}
+			out.WriteByte(end)
+		case string:
+			s := r.Text(t)
+			changed = changed || s != t
+			writeJSONString(&out, s)
+		case json.Number:
+			out.WriteString(t.String())
+		case bool:
+			out.WriteString(strconv.FormatBool(t))
+		case nil:
+			out.WriteString("null")
+		}
+		return nil
+	}
+	if err := walk(""); err != nil || !changed {
+		return body
+	}
+	return out.Bytes()
+}
+
+func joinJSONPath(p, seg string) string {
+	if p == "" {
+		return seg
+	}
+	return p + "." + seg
+}
+
+func (r *Redactor) matchPath(p string) bool {
+	for _, pattern := range r.paths {
+		if matchDiffPath(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeJSONString writes s as a JSON string without HTML escaping.
+func writeJSONString(out *bytes.Buffer, s string) {
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	out.Truncate(out.Len() - 1) // Encode's newline
+}
+
+// form redacts named fields and detector matches in a urlencoded body, keeping
+// the field order.
+func (r *Redactor) form(body []byte) []byte {
+	parts := strings.Split(string(body), "&")
+	changed := false
+	for i, kv := range parts {
+		k, v, _ := strings.Cut(kv, "=")
+		name, err := url.QueryUnescape(k)
+		if err != nil {
+			name = k
+		}
+		if r.fields[name] {
+			parts[i] = k + "=" + formEscape(RedactedValue)
+			changed = true
+			continue
+		}
+		val, err := url.QueryUnescape(v)
+		if err != nil {
+			continue
+		}
+		if s := r.Text(val); s != val {
+			parts[i] = k + "=" + formEscape(s)
+			changed = true
+		}
+	}
+	if !changed {
+		return body
+	}
+	return []byte(strings.Join(parts, "&"))
+}
+
+// formEscape query-escapes s but leaves the brackets and colons of redaction
+// markers readable; form parsers accept them unescaped.
+func formEscape(s string) string {
+	return strings.NewReplacer("%5B", "[", "%5D", "]", "%3A", ":").Replace(url.QueryEscape(s))
+}
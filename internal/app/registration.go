@@ -0,0 +1,514 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Registration points a provider's webhook at the tunnel. Register is called with
+// every new public URL; Cleanup removes a webhook it created, or restores the URL of
+// one it updated. Both are no-ops for whatever was never registered.
+type Registration interface {
+	Name() string
+	Register(ctx context.Context, publicURL string) error
+	Cleanup(ctx context.Context) error
+}
+
+// Provider API base URLs; overridable for GitHub Enterprise or a local stand-in.
+const (
+	DefaultGitHubAPI = "https://api.github.com"
+	DefaultStripeAPI = "https://api.stripe.com"
+)
+
+// Credential store names consulted when the usual environment variables are unset.
+const (
+	GitHubTokenCredential  = "github-token"
+	StripeAPIKeyCredential = "stripe-api-key"
+)
+
+// RegisterOptions configures webhook registration. Each spec is one of
+//
+//	github:OWNER/REPO          create a repo hook, delete it on shutdown
+//	github:OWNER/REPO#ID       repoint hook ID, restore its URL on shutdown
+//	stripe                     create a webhook endpoint, delete it on shutdown
+//	stripe:we_123              repoint endpoint we_123, restore its URL on shutdown
+//	put:https://host/path      PUT Body to the URL; DELETE it (or PUT CleanupBody) on shutdown
+type RegisterOptions struct {
+	Specs []string
+	// Events to subscribe to; defaults to push for GitHub and all events for Stripe.
+	Events []string
+	// Secret is the GitHub hook secret (default: the stored github-signing-secret).
+	Secret string
+	// Body and CleanupBody are text/template JSON for put: specs, rendered with .URL
+	// (already escaped for use inside a JSON string).
+	Body        string
+	CleanupBody string
+	// Headers are extra "Name: value" headers for put: specs.
+	Headers   []string
+	GitHubAPI string
+	StripeAPI string
+}
+
+// DefaultRegisterBody is the put: body when none is configured.
+const DefaultRegisterBody = `{"url":"{{.URL}}"}`
+
+// registrationClient is used for every provider API call. Overridable in tests.
+var registrationClient = &http.Client{Timeout: 15 * time.Second}
+
+// NewRegistrations builds a Registration per spec, resolving API credentials.
+func NewRegistrations(o RegisterOptions) ([]Registration, error) {
+	var regs []Registration
+	for _, spec := range o.Specs {
+		kind, target, _ := strings.Cut(spec, ":")
+		switch kind {
+		case "github":
+			repo, id, _ := strings.Cut(target, "#")
+			if strings.Count(repo, "/") != 1 {
+				return nil, fmt.Errorf("register %q: want github:OWNER/REPO[#HOOK_ID]", spec)
+			}
+			hook := &GitHubHook{API: orDefault(o.GitHubAPI, DefaultGitHubAPI), Repo: repo, Events: o.Events, Secret: o.Secret}
+			if id != "" {
+				n, err := strconv.ParseInt(id, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("register %q: bad hook ID", spec)
+				}
+				hook.HookID = n
+			}
+			if hook.Secret == "" {
+				hook.Secret, _, _ = LookupCredential(WebhookSecretName("github"))
+			}
+			var err error
+			if hook.Token, err = apiCredential("GITHUB_TOKEN", GitHubTokenCredential); err != nil {
+				return nil, fmt.Errorf("register %q: %w", spec, err)
+			}
+			regs = append(regs, hook)
+		case "stripe":
+			ep := &StripeEndpoint{API: orDefault(o.StripeAPI, DefaultStripeAPI), ID: target, Events: o.Events}
+			var err error
+			if ep.Key, err = apiCredential("STRIPE_API_KEY", StripeAPIKeyCredential); err != nil {
+				return nil, fmt.Errorf("register %q: %w", spec, err)
+			}
+			regs = append(regs, ep)
+		case "put":
+			if _, err := url.ParseRequestURI(target); err != nil {
+				return nil, fmt.Errorf("register %q: %w", spec, err)
+			}
+			g := &GenericHook{URL: target, Headers: http.Header{}}
+			var err error
+			if g.Body, err = template.New("body").Parse(orDefault(o.Body, DefaultRegisterBody)); err != nil {
+				return nil, fmt.Errorf("register body: %w", err)
+			}
+			if o.CleanupBody != "" {
+				if g.CleanupBody, err = template.New("cleanup").Parse(o.CleanupBody); err != nil {
+					return nil, fmt.Errorf("register cleanup body: %w", err)
+				}
+			}
+			for _, h := range o.Headers {
+				name, value, ok := strings.Cut(h, ":")
+				if !ok {
+					return nil, fmt.Errorf("register header %q: want Name: value", h)
+				}
+				g.Headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+			}
+			regs = append(regs, g)
+		default:
+			return nil, fmt.Errorf("register %q: unknown provider (want github:, stripe or put:)", spec)
+		}
+	}
+	return regs, nil
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// apiCredential reads a provider API token from env, then the credential store.
+func apiCredential(env, credential string) (string, error) {
+	if v := os.Getenv(env); v != "" {
+		return v, nil
+	}
+	if v, _, err := LookupCredential(credential); err == nil {
+		return v, nil
+	}
+	return "", fmt.Errorf("set %s or run `auth set %s`", env, credential)
+}
+
+// registerAll points every registration at publicURL, logging the outcome.
+func registerAll(ctx context.Context, regs []Registration, publicURL string) {
+	for _, r := range regs {
+		if err := r.Register(ctx, publicURL); err != nil {
+			log.Printf("%s[WARN]%s Webhook registration with %s failed: %v", colorYellow, colorReset, r.Name(), err)
+			continue
+		}
+		log.Printf("%s[INFO]%s Webhook registered with %s -> %s", colorGreen, colorReset, r.Name(), publicURL)
+	}
+}
+
+// cleanupRegistrations removes or restores every registration on shutdown.
+func cleanupRegistrations(regs []Registration) {
+	registrationWG.Wait()
+	registrationMu.Lock()
+	defer registrationMu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	for _, r := range regs {
+		if err := r.Cleanup(ctx); err != nil {
+			log.Printf("%s[WARN]%s Webhook cleanup with %s failed: %v", colorYellow, colorReset, r.Name(), err)
+		}
+	}
+}
+
+// apiError reports a non-2xx provider response with a bounded slice of its body.
+func apiError(resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%s %s: %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, strings.TrimSpace(string(b)))
+}
+
+// doJSON sends req and decodes a JSON response into out (when non-nil).
+func doJSON(req *http.Request, out any) error {
+	resp, err := registrationClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return apiError(resp)
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// GitHubHook manages a repository webhook through the GitHub REST API.
+type GitHubHook struct {
+	API    string
+	Token  string
+	Repo   string
+	HookID int64
+	Events []string
+	Secret string
+
+	created     bool
+	originalURL string
+	registered  bool
+}
+
+func (g *GitHubHook) Name() string { return "GitHub " + g.Repo }
+
+func (g *GitHubHook) request(ctx context.Context, method, path string, body any) (*http.Request, error) {
+	var rd io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		rd = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(g.API, "/")+"/repos/"+g.Repo+path, rd)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+g.Token)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+func (g *GitHubHook) hookConfig(publicURL string) map[string]string {
+	cfg := map[string]string{"url": publicURL, "content_type": "json", "insecure_ssl": "0"}
+	if g.Secret != "" {
+		cfg["secret"] = g.Secret
+	}
+	return cfg
+}
+
+func (g *GitHubHook) Register(ctx context.Context, publicURL string) error {
+	if g.HookID == 0 {
+		events := g.Events
+		if len(events) == 0 {
+			events = []string{"push"}
+		}
+		req, err := g.request(ctx, http.MethodPost, "/hooks", map[string]any{
+			"name": "web", "active": true, "events": events, "config": g.hookConfig(publicURL),
+		})
+		if err != nil {
+			return err
+		}
+		var hook struct {
+			ID int64 `json:"id"`
+		}
+		if err := doJSON(req, &hook); err != nil {
+			return err
+		}
+		g.HookID, g.created, g.registered = hook.ID, true, true
+		return nil
+	}
+
+	path := fmt.Sprintf("/hooks/%d/config", g.HookID)
+	if !g.created && !g.registered {
+		// Remember where the existing hook pointed so shutdown can put it back
+		req, err := g.request(ctx, http.MethodGet, path, nil)
+		if err != nil {
+			return err
+		}
+		var cfg struct {
+			URL string `json:"url"`
+		}
+		if err := doJSON(req, &cfg); err != nil {
+			return err
+		}
+		g.originalURL = cfg.URL
+	}
+	// An existing hook only has its URL changed: its content type and secret are
+	// left as configured, so Cleanup has nothing else to restore
+	cfg := map[string]string{"url": publicURL}
+	if g.created {
+		cfg = g.hookConfig(publicURL)
+	}
+	req, err := g.request(ctx, http.MethodPatch, path, cfg)
+	if err != nil {
+		return err
+	}
+	if err := doJSON(req, nil); err != nil {
+		return err
+	}
+	g.registered = true
+	return nil
+}
+
+func (g *GitHubHook) Cleanup(ctx context.Context) error {
+	if !g.registered {
+		return nil
+	}
+	var req *http.Request
+	var err error
+	if g.created {
+		req, err = g.request(ctx, http.MethodDelete, fmt.Sprintf("/hooks/%d", g.HookID), nil)
+	} else {
+		req, err = g.request(ctx, http.MethodPatch, fmt.Sprintf("/hooks/%d/config", g.HookID), map[string]string{"url": g.originalURL})
+	}
+	if err != nil {
+		return err
+	}
+	if err := doJSON(req, nil); err != nil {
+		return err
+	}
+	g.registered = false
+	if g.created {
+		log.Printf("%s[INFO]%s Removed webhook %d from %s", colorGreen, colorReset, g.HookID, g.Name())
+		g.HookID, g.created = 0, false
+	} else {
+		log.Printf("%s[INFO]%s Restored webhook %d on %s to %s", colorGreen, colorReset, g.HookID, g.Name(), g.originalURL)
+	}
+	return nil
+}
+
+// StripeEndpoint manages a Stripe webhook endpoint through the Stripe API.
+type StripeEndpoint struct {
+	API    string
+	Key    string
+	ID     string
+	Events []string
+
+	created     bool
+	originalURL string
+	registered  bool
+}
+
+func (s *StripeEndpoint) Name() string { return "Stripe" }
+
+func (s *StripeEndpoint) request(ctx context.Context, method, path string, form url.Values) (*http.Request, error) {
+	var rd io.Reader
+	if form != nil {
+		rd = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(s.API, "/")+"/v1/webhook_endpoints"+path, rd)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.Key)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	return req, nil
+}
+
+type stripeEndpointResp struct {
+	ID     string `json:"id"`
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+func (s *StripeEndpoint) Register(ctx context.Context, publicURL string) error {
+	if s.ID == "" {
+		form := url.Values{"url": {publicURL}, "description": {"webhook-catcher tunnel"}}
+		events := s.Events
+		if len(events) == 0 {
+			events = []string{"*"}
+		}
+		for _, e := range events {
+			form.Add("enabled_events[]", e)
+		}
+		req, err := s.request(ctx, http.MethodPost, "", form)
+		if err != nil {
+			return err
+		}
+		var ep stripeEndpointResp
+		if err := doJSON(req, &ep); err != nil {
+			return err
+		}
+		s.ID, s.created, s.registered = ep.ID, true, true
+		if ep.Secret != "" {
+			saveStripeSecret(ep.ID, ep.Secret)
+		}
+		return nil
+	}
+
+	if !s.created && !s.registered {
+		req, err := s.request(ctx, http.MethodGet, "/"+s.ID, nil)
+		if err != nil {
+			return err
+		}
+		var ep stripeEndpointResp
+		if err := doJSON(req, &ep); err != nil {
+			return err
+		}
+		s.originalURL = ep.URL
+	}
+	req, err := s.request(ctx, http.MethodPost, "/"+s.ID, url.Values{"url": {publicURL}})
+	if err != nil {
+		return err
+	}
+	if err := doJSON(req, nil); err != nil {
+		return err
+	}
+	s.registered = true
+	return nil
+}
+
+func (s *StripeEndpoint) Cleanup(ctx context.Context) error {
+	if !s.registered {
+		return nil
+	}
+	var req *http.Request
+	var err error
+	if s.created {
+		req, err = s.request(ctx, http.MethodDelete, "/"+s.ID, nil)
+	} else {
+		req, err = s.request(ctx, http.MethodPost, "/"+s.ID, url.Values{"url": {s.originalURL}})
+	}
+	if err != nil {
+		return err
+	}
+	if err := doJSON(req, nil); err != nil {
+		return err
+	}
+	s.registered = false
+	if s.created {
+		log.Printf("%s[INFO]%s Removed Stripe webhook endpoint %s", colorGreen, colorReset, s.ID)
+		s.ID, s.created = "", false
+	} else {
+		log.Printf("%s[INFO]%s Restored Stripe webhook endpoint %s to %s", colorGreen, colorReset, s.ID, s.originalURL)
+	}
+	return nil
+}
+
+// GenericHook PUTs a JSON document naming the public URL to an arbitrary endpoint.
+type GenericHook struct {
+	URL         string
+	Body        *template.Template
+	CleanupBody *template.Template
+	Headers     http.Header
+
+	lastURL string
+}
+
+func (g *GenericHook) Name() string { return g.URL }
+
+// saveStripeSecret keeps the signing secret of a newly created endpoint, which
+// Stripe returns only once, where -ngrok-webhook-verify and verify look for it.
+func saveStripeSecret(id, secret string) {
+	name := WebhookSecretName("stripe")
+	if err := (KeyringStore{Service: credentialService}).Set(name, secret); err != nil {
+		log.Printf("%s[WARN]%s Stripe endpoint %s signing secret %s not saved: %v (store it with `auth set %s`)", colorYellow, colorReset, id, MaskSecret(secret), err, name)
+		return
+	}
+	log.Printf("%s[INFO]%s Stripe endpoint %s signing secret %s saved to the keyring as %s", colorGreen, colorReset, id, MaskSecret(secret), name)
+}
+
+// jsonString escapes s for use inside a JSON string literal.
+func jsonString(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	out := strings.TrimSuffix(buf.String(), "\n")
+	return out[1 : len(out)-1]
+}
+
+func (g *GenericHook) send(ctx context.Context, method string, tmpl *template.Template, publicURL string) error {
+	var rd io.Reader
+	if tmpl != nil {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, struct{ URL string }{jsonString(publicURL)}); err != nil {
+			return err
+		}
+		rd = &buf
+	}
+	req, err := http.NewRequestWithContext(ctx, method, g.URL, rd)
+	if err != nil {
+		return err
+	}
+	for k, vs := range g.Headers {
+		req.Header[k] = vs
+	}
+	if rd != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return doJSON(req, nil)
+}
+
+func (g *GenericHook) Register(ctx context.Context, publicURL string) error {
+	if err := g.send(ctx, http.MethodPut, g.Body, publicURL); err != nil {
+		return err
+	}
+	g.lastURL = publicURL
+	return nil
+}
+
+func (g *GenericHook) Cleanup(ctx context.Context) error {
+	if g.lastURL == "" {
+		return nil
+	}
+	var err error
+	if g.CleanupBody != nil {
+		err = g.send(ctx, http.MethodPut, g.CleanupBody, g.lastURL)
+	} else {
+		err = g.send(ctx, http.MethodDelete, nil, g.lastURL)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("%s[INFO]%s Unregistered webhook at %s", colorGreen, colorReset, g.URL)
+	g.lastURL = ""
+	return nil
+}
@@ -0,0 +1,261 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// apiStandIn records provider API calls and answers with canned JSON.
+type apiStandIn struct {
+	mu    sync.Mutex
+	calls []string // "METHOD /path body"
+	reply map[string]string
+}
+
+func newAPIStandIn(t *testing.T, reply map[string]string) (*apiStandIn, string) {
+	t.Helper()
+	a := &apiStandIn{reply: reply}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		a.mu.Lock()
+		a.calls = append(a.calls, strings.TrimSpace(r.Method+" "+r.URL.Path+" "+string(b)))
+		a.mu.Unlock()
+		if body, ok := a.reply[r.Method+" "+r.URL.Path]; ok {
+			_, _ = io.WriteString(w, body)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		_, _ = io.WriteString(w, "{}")
+	}))
+	t.Cleanup(srv.Close)
+	return a, srv.URL
+}
+
+func (a *apiStandIn) Calls() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.calls...)
+}
+
+func expectCalls(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("expected %d calls, got %d: %q", len(want), len(got), got)
+	}
+	for i := range want {
+		if !strings.HasPrefix(got[i], want[i]) {
+			t.Fatalf("call %d: expected prefix %q, got %q", i, want[i], got[i])
+		}
+	}
+}
+
+func TestGitHubHook_CreateUpdateDelete(t *testing.T) {
+	api, base := newAPIStandIn(t, map[string]string{"POST /repos/acme/shop/hooks": `{"id":42}`})
+	h := &GitHubHook{API: base, Token: "ghp", Repo: "acme/shop", Secret: "s"}
+	ctx := context.Background()
+	if err := h.Register(ctx, "https://a.example"); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := h.Register(ctx, "https://b.example"); err != nil {
+		t.Fatalf("re-register: %v", err)
+	}
+	if err := h.Cleanup(ctx); err != nil {
+		t.Fatalf("cleanup: %v", err)
+	}
+	if err := h.Cleanup(ctx); err != nil {
+		t.Fatalf("second cleanup should be a no-op: %v", err)
+	}
+	calls := api.Calls()
+	expectCalls(t, calls,
+		"POST /repos/acme/shop/hooks ",
+		"PATCH /repos/acme/shop/hooks/42/config ",
+		"DELETE /repos/acme/shop/hooks/42",
+	)
+	var created struct {
+		Events []string          `json:"events"`
+		Config map[string]string `json:"config"`
+	}
+	_ = json.Unmarshal([]byte(strings.TrimPrefix(calls[0], "POST /repos/acme/shop/hooks ")), &created)
+	if created.Config["url"] != "https://a.example" || created.Config["secret"] != "s" || created.Events[0] != "push" {
+		t.Fatalf("unexpected create payload %q", calls[0])
+	}
+	if !strings.Contains(calls[1], `"url":"https://b.example"`) {
+		t.Fatalf("expected update to the new URL, got %q", calls[1])
+	}
+}
+
+func TestGitHubHook_ExistingIsRestored(t *testing.T) {
+	api, base := newAPIStandIn(t, map[string]string{"GET /repos/acme/shop/hooks/7/config": `{"url":"https://prod.example/hooks"}`})
+	h := &GitHubHook{API: base, Token: "ghp", Repo: "acme/shop", HookID: 7, Secret: "catcher-secret"}
+	ctx := context.Background()
+	if err := h.Register(ctx, "https://a.example"); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := h.Cleanup(ctx); err != nil {
+		t.Fatalf("cleanup: %v", err)
+	}
+	calls := api.Calls()
+	expectCalls(t, calls,
+		"GET /repos/acme/shop/hooks/7/config",
+		"PATCH /repos/acme/shop/hooks/7/config ",
+		"PATCH /repos/acme/shop/hooks/7/config ",
+	)
+	// Only the URL is changed, so the hook's secret and content type survive
+	if calls[1] != `PATCH /repos/acme/shop/hooks/7/config {"url":"https://a.example"}` {
+		t.Fatalf("expected only the URL to be repointed, got %q", calls[1])
+	}
+	if !strings.Contains(calls[2], `"url":"https://prod.example/hooks"`) {
+		t.Fatalf("expected original URL to be restored, got %q", calls[2])
+	}
+}
+
+func TestStripeEndpoint_CreateAndExisting(t *testing.T) {
+	isolateCredentials(t)
+	api, base := newAPIStandIn(t, map[string]string{
+		"POST /v1/webhook_endpoints":       `{"id":"we_new","secret":"whsec_abcdef"}`,
+		"GET /v1/webhook_endpoints/we_old": `{"id":"we_old","url":"https://prod.example/stripe"}`,
+	})
+	ctx := context.Background()
+	created := &StripeEndpoint{API: base, Key: "sk_test", Events: []string{"invoice.paid"}}
+	if err := created.Register(ctx, "https://a.example"); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	// The one-time signing secret is kept for signature verification
+	if secret, from, err := LookupCredential(WebhookSecretName("stripe")); err != nil || secret != "whsec_abcdef" || from != "keyring" {
+		t.Fatalf("saved secret = %q from %q (%v), want whsec_abcdef from keyring", secret, from, err)
+	}
+	if err := created.Cleanup(ctx); err != nil {
+		t.Fatalf("cleanup: %v", err)
+	}
+	existing := &StripeEndpoint{API: base, Key: "sk_test", ID: "we_old"}
+	if err := existing.Register(ctx, "https://a.example"); err != nil {
+		t.Fatalf("register existing: %v", err)
+	}
+	if err := existing.Cleanup(ctx); err != nil {
+		t.Fatalf("cleanup existing: %v", err)
+	}
+	expectCalls(t, api.Calls(),
+		"POST /v1/webhook_endpoints description=webhook-catcher+tunnel&enabled_events%5B%5D=invoice.paid&url=https%3A%2F%2Fa.example",
+		"DELETE /v1/webhook_endpoints/we_new",
+		"GET /v1/webhook_endpoints/we_old",
+		"POST /v1/webhook_endpoints/we_old url=https%3A%2F%2Fa.example",
+		"POST /v1/webhook_endpoints/we_old url=https%3A%2F%2Fprod.example%2Fstripe",
+	)
+}
+
+func TestGenericHook_PutAndCleanup(t *testing.T) {
+	api, base := newAPIStandIn(t, nil)
+	regs, err := NewRegistrations(RegisterOptions{
+		Specs:       []string{"put:" + base + "/dev/hook", "put:" + base + "/other"},
+		CleanupBody: `{"url":"{{.URL}}","active":false}`,
+		Headers:     []string{"X-Api-Key: k1"},
+	})
+	if err != nil {
+		t.Fatalf("new registrations: %v", err)
+	}
+	registerAll(context.Background(), regs, "https://a.example")
+	cleanupRegistrations(regs)
+	if err := regs[0].Register(context.Background(), `https://a.example/"q"\x`); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	expectCalls(t, api.Calls(),
+		`PUT /dev/hook {"url":"https://a.example"}`,
+		`PUT /other {"url":"https://a.example"}`,
+		`PUT /dev/hook {"url":"https://a.example","active":false}`,
+		`PUT /other {"url":"https://a.example","active":false}`,
+		`PUT /dev/hook {"url":"https://a.example/\"q\"\\x"}`,
+	)
+
+	// Without a cleanup body the endpoint is deleted
+	g := &GenericHook{URL: base + "/x", Body: regs[0].(*GenericHook).Body}
+	_ = g.Register(context.Background(), "https://a.example")
+	_ = g.Cleanup(context.Background())
+	if calls := api.Calls(); calls[len(calls)-1] != "DELETE /x" {
+		t.Fatalf("expected DELETE on cleanup, got %q", calls[len(calls)-1])
+	}
+}
+
+func TestNewRegistrations_Errors(t *testing.T) {
+	isolateCredentials(t)
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("STRIPE_API_KEY", "")
+	cases := map[string]RegisterOptions{
+		"unknown provider": {Specs: []string{"gitlab:x/y"}},
+		"OWNER/REPO":       {Specs: []string{"github:justrepo"}},
+		"bad hook ID":      {Specs: []string{"github:a/b#x"}},
+		"GITHUB_TOKEN":     {Specs: []string{"github:a/b"}},
+		"STRIPE_API_KEY":   {Specs: []string{"stripe"}},
+		"Name: value":      {Specs: []string{"put:https://x.example"}, Headers: []string{"nocolon"}},
+	}
+	for want, o := range cases {
+		if _, err := NewRegistrations(o); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected error containing %q, got %v", want, err)
+		}
+	}
+	_ = KeyringStore{Service: credentialService}.Set(GitHubTokenCredential, "ghp_stored")
+	regs, err := NewRegistrations(RegisterOptions{Specs: []string{"github:a/b#9"}})
+	if err != nil || regs[0].(*GitHubHook).Token != "ghp_stored" || regs[0].(*GitHubHook).HookID != 9 {
+		t.Fatalf("expected stored token and hook ID, got %+v err=%v", regs, err)
+	}
+}
+
+func TestRun_RegisterRequiresTunnel(t *testing.T) {
+	isolateEnv(t)
+	if err := Run(Options{Register: RegisterOptions{Specs: []string{"put:https://x.example"}}}); err == nil || !strings.Contains(err.Error(), "needs -tunnel") {
+		t.Fatalf("expected tunnel requirement error, got %v", err)
+	}
+}
+
+func TestRun_RegistersThroughRelay(t *testing.T) {
+	isolateEnv(t)
+	isolateCredentials(t)
+	t.Setenv("GITHUB_TOKEN", "ghp_test")
+	publicURL, controlAddr := startRelay(t, "tok")
+	api, base := newAPIStandIn(t, map[string]string{"POST /repos/acme/shop/hooks": `{"id":5}`})
+
+	origNotify := NotifyContext
+	defer func() { NotifyContext = origNotify }()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	NotifyContext = func(parent context.Context, _ ...os.Signal) (context.Context, context.CancelFunc) {
+		return ctx, cancel
+	}
+
+	done := make(chan error, 1)
+	out := captureStdout(func() {
+		go func() {
+			done <- Run(Options{Tunnel: true, RelayAddr: controlAddr, RelayToken: "tok", DrainTimeout: time.Second,
+				Register: RegisterOptions{Specs: []string{"github:acme/shop"}, GitHubAPI: base}})
+		}()
+		deadline := time.Now().Add(3 * time.Second)
+		for len(api.Calls()) == 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("run: %v", err)
+		}
+	})
+	calls := api.Calls()
+	expectCalls(t, calls, "POST /repos/acme/shop/hooks ", "DELETE /repos/acme/shop/hooks/5")
+	if !strings.Contains(calls[0], `"url":"`+publicURL+`"`) {
+		t.Fatalf("expected hook to point at %s, got %q", publicURL, calls[0])
+	}
+	out = stripANSI(out)
+	for _, want := range []string{"Webhook registered with GitHub acme/shop", "Removed webhook 5 from GitHub acme/shop"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected output to contain %q, got: %s", want, out)
+		}
+	}
+}
@@ -0,0 +1,511 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The relay is a minimal reverse tunnel for hosts where ngrok is not an option.
+// `webhook-catcher relay` runs on a reachable machine with a public and a control
+// port. The catcher dials the control port and keeps that connection open; for every
+// public connection the relay sends a connect message, the catcher dials back with a
+// data connection for that ID, and the relay splices the two. The catcher serves HTTP
+// on the data connections as if they had been accepted locally.
+//
+// Every message is one JSON line. The control handshake is
+//
+//	catcher -> relay   {"type":"hello","version":1,"token":"..."}
+//	relay -> catcher   {"type":"ready","url":"http://relay.example.com:8080"}
+//
+// after which the relay sends {"type":"connect","id":"...","remote":"ip:port"} and the
+// catcher opens a new connection starting with {"type":"data","token":"...","id":"..."}.
+
+// RelayProtocolVersion is sent in the hello message; the relay rejects other versions.
+const RelayProtocolVersion = 1
+
+// RelayCredential is the credential store name for the relay token.
+const RelayCredential = "relay-token"
+
+// RelayTokenEnv is read by the relay subcommand when -token is not given.
+const RelayTokenEnv = EnvPrefix + "RELAY_TOKEN"
+
+// relayHandshakeTimeout bounds every handshake read and the dial back for a connection.
+const relayHandshakeTimeout = 10 * time.Second
+
+// relayMaxMsg bounds a message line; readers of relay messages are this size.
+const relayMaxMsg = 4096
+
+type relayMsg struct {
+	Type    string `json:"type"`
+	Version int    `json:"version,omitempty"`
+	Token   string `json:"token,omitempty"`
+	ID      string `json:"id,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Remote  string `json:"remote,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func writeRelayMsg(w io.Writer, m relayMsg) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// readRelayMsg reads one message line, which must fit in br's buffer.
+func readRelayMsg(br *bufio.Reader) (relayMsg, error) {
+	var m relayMsg
+	line, err := br.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return m, fmt.Errorf("relay message longer than %d bytes", br.Size())
+	}
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(line, &m); err != nil {
+		return m, fmt.Errorf("bad relay message: %w", err)
+	}
+	return m, nil
+}
+
+// Relay is the server side of the reverse tunnel. One catcher may be connected at a time.
+type Relay struct {
+	Token string
+	// PublicURL is reported to the catcher; derived from the public listener when empty.
+	PublicURL string
+	// ConnectTimeout bounds how long a public connection waits for the catcher's data
+	// connection (default 10s).
+	ConnectTimeout time.Duration
+
+	mu      sync.Mutex
+	client  *relayClient
+	pending map[string]chan net.Conn
+	public  net.Listener
+}
+
+// relayClient is the connected catcher's control connection.
+type relayClient struct {
+	conn net.Conn
+	wmu  sync.Mutex
+}
+
+func (c *relayClient) send(m relayMsg) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return writeRelayMsg(c.conn, m)
+}
+
+// Serve accepts public and control connections until ctx is done.
+func (r *Relay) Serve(ctx context.Context, public, control net.Listener) error {
+	r.mu.Lock()
+	r.pending = map[string]chan net.Conn{}
+	r.public = public
+	r.mu.Unlock()
+
+	errCh := make(chan error, 2)
+	go func() { errCh <- acceptLoop(control, r.handleControl) }()
+	go func() { errCh <- acceptLoop(public, r.handlePublic) }()
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errCh:
+	}
+	_ = public.Close()
+	_ = control.Close()
+	r.mu.Lock()
+	if r.client != nil {
+		_ = r.client.conn.Close()
+	}
+	r.mu.Unlock()
+	return err
+}
+
+func acceptLoop(ln net.Listener, handle func(net.Conn)) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go handle(conn)
+	}
+}
+
+func (r *Relay) checkToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(r.Token)) == 1
+}
+
+func (r *Relay) handleControl(conn net.Conn) {
+	br := bufio.NewReaderSize(conn, relayMaxMsg)
+	_ = conn.SetReadDeadline(time.Now().Add(relayHandshakeTimeout))
+	m, err := readRelayMsg(br)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	reject := func(reason string) {
+		_ = writeRelayMsg(conn, relayMsg{Type: "error", Error: reason})
+		_ = conn.Close()
+	}
+	if !r.checkToken(m.Token) {
+		log.Printf("%s[WARN]%s Relay rejected %s: invalid token", colorYellow, colorReset, conn.RemoteAddr())
+		reject("invalid token")
+		return
+	}
+
+	switch m.Type {
+	case "data":
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		ch, ok := r.pending[m.ID]
+		delete(r.pending, m.ID)
+		if !ok {
+			_ = conn.Close()
+			return
+		}
+		// The public side may already be buffered behind the handshake line. ch has
+		// room for it, and sending under mu lets handlePublic's timeout see it.
+		ch <- &bufferedConn{Conn: conn, r: br}
+	case "hello":
+		if m.Version != RelayProtocolVersion {
+			reject(fmt.Sprintf("unsupported protocol version %d (relay speaks %d)", m.Version, RelayProtocolVersion))
+			return
+		}
+		client := &relayClient{conn: conn}
+		r.mu.Lock()
+		busy := r.client != nil
+		if !busy {
+			r.client = client
+		}
+		r.mu.Unlock()
+		if busy {
+			reject("another catcher is already connected")
+			return
+		}
+		if err := client.send(relayMsg{Type: "ready", URL: r.publicURL(conn)}); err != nil {
+			r.dropClient(client)
+			return
+		}
+		log.Printf("%s[INFO]%s Relay client connected from %s", colorGreen, colorReset, conn.RemoteAddr())
+		// The catcher sends nothing more; a read error means it went away
+		_, _ = io.Copy(io.Discard, br)
+		r.dropClient(client)
+		log.Printf("%s[INFO]%s Relay client %s disconnected", colorGreen, colorReset, conn.RemoteAddr())
+	default:
+		reject(fmt.Sprintf("unexpected message %q", m.Type))
+	}
+}
+
+func (r *Relay) dropClient(c *relayClient) {
+	r.mu.Lock()
+	if r.client == c {
+		r.client = nil
+	}
+	r.mu.Unlock()
+	_ = c.conn.Close()
+}
+
+// publicURL is PublicURL, or http:// plus the public listener address with an
+// unspecified host replaced by the address the catcher reached us on.
+func (r *Relay) publicURL(control net.Conn) string {
+	if r.PublicURL != "" {
+		return r.PublicURL
+	}
+	host, port, _ := net.SplitHostPort(r.public.Addr().String())
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host, _, _ = net.SplitHostPort(control.LocalAddr().String())
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+func (r *Relay) handlePublic(pc net.Conn) {
+	r.mu.Lock()
+	client := r.client
+	id := newRelayID()
+	ch := make(chan net.Conn, 1)
+	if client != nil {
+		r.pending[id] = ch
+	}
+	r.mu.Unlock()
+
+	if client == nil {
+		relayError(pc, "502 Bad Gateway", "no webhook-catcher is connected to this relay")
+		return
+	}
+	if err := client.send(relayMsg{Type: "connect", ID: id, Remote: pc.RemoteAddr().String()}); err != nil {
+		r.mu.Lock()
+		delete(r.pending, id)
+		r.mu.Unlock()
+		relayError(pc, "502 Bad Gateway", "lost the connection to webhook-catcher")
+		return
+	}
+
+	timeout := r.ConnectTimeout
+	if timeout <= 0 {
+		timeout = relayHandshakeTimeout
+	}
+	select {
+	case dc := <-ch:
+		splice(pc, dc)
+	case <-time.After(timeout):
+		r.mu.Lock()
+		delete(r.pending, id)
+		r.mu.Unlock()
+		// A data connection that arrived as the timer fired is not used
+		select {
+		case dc := <-ch:
+			_ = dc.Close()
+		default:
+		}
+		relayError(pc, "504 Gateway Timeout", "webhook-catcher did not open a connection in time")
+	}
+}
+
+// newRelayID returns a random connection ID for a connect message.
+func newRelayID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// relayError answers a public connection the relay cannot forward. The request is
+// read first: HTTP clients treat a response that arrives before the request as an error.
+func relayError(conn net.Conn, status, msg string) {
+	_ = conn.SetDeadline(time.Now().Add(relayHandshakeTimeout))
+	if req, err := http.ReadRequest(bufio.NewReader(conn)); err == nil {
+		_, _ = io.Copy(io.Discard, io.LimitReader(req.Body, 10<<20))
+	}
+	fmt.Fprintf(conn, "HTTP/1.1 %s\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s\n", status, len(msg)+1, msg)
+	_ = conn.Close()
+}
+
+// splice copies between a and b until either side is done, then closes both.
+func splice(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() { _, _ = io.Copy(a, b); done <- struct{}{} }()
+	go func() { _, _ = io.Copy(b, a); done <- struct{}{} }()
+	<-done
+	_ = a.Close()
+	_ = b.Close()
+	<-done
+}
+
+// bufferedConn reads through a bufio.Reader that may hold bytes already read from Conn.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// DialRelay connects to a relay's control address (host:port, or tls://host:port for a
+// TLS control port) and returns a listener whose connections arrive through the relay.
+func DialRelay(ctx context.Context, addr, token string) (ListenerWithURL, error) {
+	l := &relayListener{addr: addr, token: token, conns: make(chan net.Conn), done: make(chan struct{})}
+	conn, err := l.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReaderSize(conn, relayMaxMsg)
+	_ = conn.SetDeadline(time.Now().Add(relayHandshakeTimeout))
+	if err := writeRelayMsg(conn, relayMsg{Type: "hello", Version: RelayProtocolVersion, Token: token}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	m, err := readRelayMsg(br)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("relay handshake: %w", err)
+	}
+	if m.Type != "ready" {
+		_ = conn.Close()
+		if m.Error != "" {
+			return nil, fmt.Errorf("relay refused the connection: %s", m.Error)
+		}
+		return nil, fmt.Errorf("relay handshake: unexpected message %q", m.Type)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	l.ctl, l.url = conn, m.URL
+	go l.readControl(br)
+	return l, nil
+}
+
+// relayListener is the catcher side of the reverse tunnel.
+type relayListener struct {
+	addr  string
+	token string
+	url   string
+	ctl   net.Conn
+
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+// dial connects to the relay's control address; tcp:// is accepted for a plain
+// host:port.
+func (l *relayListener) dial(ctx context.Context) (net.Conn, error) {
+	d := &net.Dialer{Timeout: relayHandshakeTimeout}
+	if host, ok := strings.CutPrefix(l.addr, "tls://"); ok {
+		td := &tls.Dialer{NetDialer: d}
+		return td.DialContext(ctx, "tcp", host)
+	}
+	return d.DialContext(ctx, "tcp", strings.TrimPrefix(l.addr, "tcp://"))
+}
+
+// readControl dials back for every connect message until the control connection ends.
+func (l *relayListener) readControl(br *bufio.Reader) {
+	for {
+		m, err := readRelayMsg(br)
+		if err != nil {
+			l.shutdown(fmt.Errorf("relay connection lost: %w", err))
+			return
+		}
+		if m.Type == "connect" {
+			go l.openData(m.ID, m.Remote)
+		}
+	}
+}
+
+func (l *relayListener) openData(id, remote string) {
+	ctx, cancel := context.WithTimeout(context.Background(), relayHandshakeTimeout)
+	defer cancel()
+	conn, err := l.dial(ctx)
+	if err != nil {
+		log.Printf("%s[WARN]%s relay data connection failed: %v", colorYellow, colorReset, err)
+		return
+	}
+	if err := writeRelayMsg(conn, relayMsg{Type: "data", Token: l.token, ID: id}); err != nil {
+		_ = conn.Close()
+		return
+	}
+	var c net.Conn = conn
+	if remote != "" {
+		c = &remoteAddrConn{Conn: conn, remote: relayAddr(remote)}
+	}
+	select {
+	case l.conns <- c:
+	case <-l.done:
+		_ = conn.Close()
+	}
+}
+
+func (l *relayListener) shutdown(err error) {
+	l.closeOnce.Do(func() {
+		l.err = err
+		close(l.done)
+		_ = l.ctl.Close()
+	})
+}
+
+func (l *relayListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+func (l *relayListener) Close() error {
+	l.shutdown(net.ErrClosed)
+	return nil
+}
+
+func (l *relayListener) Addr() net.Addr { return l.ctl.LocalAddr() }
+func (l *relayListener) URL() string    { return l.url }
+
+// remoteAddrConn reports the public client's address, so captures show the real sender.
+type remoteAddrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *remoteAddrConn) RemoteAddr() net.Addr { return c.remote }
+
+type relayAddr string
+
+func (a relayAddr) Network() string { return "tcp" }
+func (a relayAddr) String() string  { return string(a) }
+
+// relayConnectAddr is the -relay address for a control listener: host:port, or
+// tls://host:port when the control port speaks TLS.
+func relayConnectAddr(addr net.Addr, useTLS bool) string {
+	if useTLS {
+		return "tls://" + addr.String()
+	}
+	return addr.String()
+}
+
+// RelayCommand implements `relay`: run the server side of the reverse tunnel.
+func RelayCommand(args []string) error {
+	fs := flag.NewFlagSet("relay", flag.ContinueOnError)
+	listen := fs.String("listen", ":8080", "public address webhooks are sent to")
+	control := fs.String("control", ":7000", "address catchers connect to")
+	token := fs.String("token", "", "shared secret catchers must present (default $"+RelayTokenEnv+")")
+	publicURL := fs.String("public-url", "", "URL reported to the catcher (default derived from -listen)")
+	tlsCert := fs.String("tls-cert", "", "TLS certificate for the control port (optional)")
+	tlsKey := fs.String("tls-key", "", "TLS private key for the control port")
+	timeout := fs.Duration("connect-timeout", relayHandshakeTimeout, "how long a webhook waits for the catcher to pick it up")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *token == "" {
+		*token = os.Getenv(RelayTokenEnv)
+	}
+	if *token == "" {
+		return fmt.Errorf("relay needs a token: set -token or %s", RelayTokenEnv)
+	}
+
+	log.SetOutput(os.Stdout)
+	publicLn, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	controlLn, err := net.Listen("tcp", *control)
+	if err != nil {
+		_ = publicLn.Close()
+		return err
+	}
+	useTLS := *tlsCert != "" || *tlsKey != ""
+	if useTLS {
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			_ = publicLn.Close()
+			_ = controlLn.Close()
+			return fmt.Errorf("tls config error: %w", err)
+		}
+		controlLn = tls.NewListener(controlLn, &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+	}
+
+	ctx, stop := NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	log.Printf("%s[INFO]%s Relay accepting webhooks on %s", colorGreen, colorReset, publicLn.Addr())
+	log.Printf("%s[INFO]%s Catchers connect with: -tunnel -relay %s", colorGreen, colorReset, relayConnectAddr(controlLn.Addr(), useTLS))
+	r := &Relay{Token: *token, PublicURL: *publicURL, ConnectTimeout: *timeout}
+	return r.Serve(ctx, publicLn, controlLn)
+}
@@ -0,0 +1,222 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startRelay runs a Relay on localhost and returns its public URL and control address.
+func startRelay(t *testing.T, token string) (string, string) {
+	t.Helper()
+	public, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	control, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		_ = (&Relay{Token: token, ConnectTimeout: time.Second}).Serve(ctx, public, control)
+		close(done)
+	}()
+	t.Cleanup(func() { cancel(); <-done })
+	return "http://" + public.Addr().String(), control.Addr().String()
+}
+
+func relayGet(t *testing.T, url string) (int, string) {
+	t.Helper()
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("get %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func TestRelay_EndToEnd(t *testing.T) {
+	publicURL, controlAddr := startRelay(t, "s3cret")
+
+	// Nothing connected yet
+	if code, body := relayGet(t, publicURL+"/x"); code != http.StatusBadGateway || !strings.Contains(body, "no webhook-catcher") {
+		t.Fatalf("expected 502 without a catcher, got %d %q", code, body)
+	}
+
+	ln, err := DialRelay(context.Background(), controlAddr, "s3cret")
+	if err != nil {
+		t.Fatalf("dial relay: %v", err)
+	}
+	if ln.URL() != publicURL {
+		t.Fatalf("expected URL %s, got %s", publicURL, ln.URL())
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		_, _ = io.WriteString(w, r.Method+" "+r.URL.Path+" from "+host)
+	})}
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+
+	for i := 0; i < 3; i++ {
+		code, body := relayGet(t, publicURL+"/hooks/github")
+		if code != http.StatusOK || body != "GET /hooks/github from 127.0.0.1" {
+			t.Fatalf("unexpected response through relay: %d %q", code, body)
+		}
+	}
+
+	// A second catcher is turned away while the first is connected
+	if _, err := DialRelay(context.Background(), controlAddr, "s3cret"); err == nil || !strings.Contains(err.Error(), "already connected") {
+		t.Fatalf("expected second catcher to be refused, got %v", err)
+	}
+}
+
+func TestRelay_DialsPrintedAddress(t *testing.T) {
+	_, controlAddr := startRelay(t, "tok")
+	addr, err := net.ResolveTCPAddr("tcp", controlAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The address RelayCommand prints for a plain control port
+	ln, err := DialRelay(context.Background(), relayConnectAddr(addr, false), "tok")
+	if err != nil {
+		t.Fatalf("dial the printed address: %v", err)
+	}
+	_ = ln.Close()
+	if got := relayConnectAddr(addr, true); got != "tls://"+controlAddr {
+		t.Errorf("TLS address %q", got)
+	}
+
+	// tcp:// is accepted as well
+	_, controlAddr = startRelay(t, "tok")
+	ln, err = DialRelay(context.Background(), "tcp://"+controlAddr, "tok")
+	if err != nil {
+		t.Fatalf("dial tcp://: %v", err)
+	}
+	_ = ln.Close()
+}
+
+func TestRelay_RejectsBadToken(t *testing.T) {
+	_, controlAddr := startRelay(t, "s3cret")
+	if _, err := DialRelay(context.Background(), controlAddr, "wrong"); err == nil || !strings.Contains(err.Error(), "invalid token") {
+		t.Fatalf("expected invalid token error, got %v", err)
+	}
+}
+
+func TestReadRelayMsg_Limit(t *testing.T) {
+	long := `{"type":"hello","token":"` + strings.Repeat("x", relayMaxMsg) + "\"}\n"
+	if _, err := readRelayMsg(bufio.NewReaderSize(strings.NewReader(long), relayMaxMsg)); err == nil || !strings.Contains(err.Error(), "longer than") {
+		t.Errorf("expected a length error, got %v", err)
+	}
+	m, err := readRelayMsg(bufio.NewReaderSize(strings.NewReader(`{"type":"connect","id":"`+newRelayID()+`"}`+"\n"), relayMaxMsg))
+	if err != nil || m.Type != "connect" || len(m.ID) != 32 {
+		t.Errorf("readRelayMsg: %+v %v", m, err)
+	}
+}
+
+func TestRelay_ClientCloseFreesSlot(t *testing.T) {
+	_, controlAddr := startRelay(t, "tok")
+	ln, err := DialRelay(context.Background(), controlAddr, "tok")
+	if err != nil {
+		t.Fatalf("dial relay: %v", err)
+	}
+	_ = ln.Close()
+	if _, err := ln.Accept(); err == nil {
+		t.Fatalf("expected Accept to fail after Close")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		ln2, err := DialRelay(context.Background(), controlAddr, "tok")
+		if err == nil {
+			_ = ln2.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("relay slot not released: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestRun_RelayTunnel(t *testing.T) {
+	isolateEnv(t)
+	isolateCredentials(t)
+	publicURL, controlAddr := startRelay(t, "tok-relay")
+	_ = KeyringStore{Service: credentialService}.Set(RelayCredential, "tok-relay")
+
+	origNotify := NotifyContext
+	defer func() { NotifyContext = origNotify }()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	NotifyContext = func(parent context.Context, _ ...os.Signal) (context.Context, context.CancelFunc) {
+		return ctx, cancel
+	}
+
+	done := make(chan error, 1)
+	out := captureStdout(func() {
+		go func() { done <- Run(Options{Tunnel: true, RelayAddr: controlAddr, DrainTimeout: time.Second}) }()
+		// Wait for the catcher to attach, then send a webhook through the relay
+		deadline := time.Now().Add(3 * time.Second)
+		for {
+			resp, err := http.Post(publicURL+"/relay-hook", "application/json", strings.NewReader(`{"via":"relay"}`))
+			if err == nil {
+				_, _ = io.Copy(io.Discard, resp.Body)
+				_ = resp.Body.Close()
+				if resp.StatusCode == http.StatusOK {
+					break
+				}
+			}
+			if time.Now().After(deadline) {
+				t.Errorf("webhook never made it through the relay: %v", err)
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		cancel()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("expected clean shutdown, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("Run did not return")
+		}
+	})
+	out = stripANSI(out)
+	for _, want := range []string{"Connecting to relay", "Public URL: " + publicURL, "POST /relay-hook", `"via": "relay"`} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected output to contain %q, got: %s", want, out)
+		}
+	}
+}
+
+func TestRun_TunnelProviderErrors(t *testing.T) {
+	isolateEnv(t)
+	isolateCredentials(t)
+	if err := Run(Options{Tunnel: true, TunnelProvider: "frp"}); err == nil || !strings.Contains(err.Error(), "unknown tunnel provider") {
+		t.Fatalf("expected unknown provider error, got %v", err)
+	}
+	if err := Run(Options{Tunnel: true, TunnelProvider: "relay"}); err == nil || !strings.Contains(err.Error(), "-relay") {
+		t.Fatalf("expected missing relay address error, got %v", err)
+	}
+	if err := Run(Options{Tunnel: true, RelayAddr: "127.0.0.1:1"}); err == nil || !strings.Contains(err.Error(), "missing relay token") {
+		t.Fatalf("expected missing relay token error, got %v", err)
+	}
+}
+
+func TestRelayCommand_RequiresToken(t *testing.T) {
+	t.Setenv(RelayTokenEnv, "")
+	if err := RelayCommand([]string{"-listen", "127.0.0.1:0", "-control", "127.0.0.1:0"}); err == nil || !strings.Contains(err.Error(), "token") {
+		t.Fatalf("expected token error, got %v", err)
+	}
+}
@@ -0,0 +1,242 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Replay is one outgoing webhook request built by a Replayer.
+type Replay struct {
+	Name   string
+	Method string
+	// Path is the captured request path; it is used when the target URL has none.
+	Path   string
+	Header http.Header
+	Body   []byte
+	Signed bool
+}
+
+// Replayer turns a fixture or a capture into fresh Replays: template values, delivery
+// IDs and signatures are regenerated on every call, so each send looks like a new
+// delivery from the provider.
+type Replayer struct {
+	Name     string
+	Provider string
+	// Secret signs every replay; replays are unsigned when it is empty.
+	Secret string
+	// Header is set on every replay after it is built.
+	Header http.Header
+
+	build func(now time.Time) (*Replay, error)
+}
+
+// FixtureReplayer replays a fixture.
+func FixtureReplayer(f *Fixture, secret string) *Replayer {
+	r := &Replayer{Name: f.Name, Provider: f.Provider, Secret: secret}
+	r.build = func(now time.Time) (*Replay, error) {
+		h, body, err := f.Render(r.Secret, now)
+		if err != nil {
+			return nil, err
+		}
+		method := f.Method
+		if method == "" {
+			method = http.MethodPost
+		}
+		return &Replay{Name: f.Name, Method: method, Header: h, Body: body, Signed: r.Secret != ""}, nil
+	}
+	return r
+}
+
+// replayDropHeaders were added in transit or are recomputed by the client.
+var replayDropHeaders = []string{"Content-Length", "Connection", "Transfer-Encoding", "Accept-Encoding", "X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto"}
+
+// deliveryIDHeaders get a new UUID per replay so receivers don't treat it as a retry.
+var deliveryIDHeaders = []string{"X-GitHub-Delivery", "X-Shopify-Webhook-Id", "X-Shopify-Event-Id"}
+
+// CaptureReplayer replays a stored capture. Its provider is detected from the
+// headers; the original signature is replaced with one made with secret.
+func CaptureReplayer(c *Capture, secret string) *Replayer {
+	r := &Replayer{Name: c.ID, Provider: DetectProvider(c.Header), Secret: secret}
+	r.build = func(now time.Time) (*Replay, error) {
+		h := c.Header.Clone()
+		for _, name := range replayDropHeaders {
+			h.Del(name)
+		}
+		for _, name := range deliveryIDHeaders {
+			if h.Get(name) != "" {
+				h.Set(name, newUUID())
+			}
+		}
+		if r.Provider != "" {
+			// The captured signature was made with the provider's secret and, for some,
+			// a timestamp that has since expired
+			stale, _ := SignWebhook(r.Provider, "", nil, now)
+			for name := range stale {
+				h.Del(name)
+			}
+			h.Del("X-Hub-Signature")
+		}
+		p := &Replay{Name: c.ID, Method: c.Method, Path: c.Path, Header: h, Body: c.Body}
+		if r.Secret != "" && r.Provider != "" {
+			sig, _ := SignWebhook(r.Provider, r.Secret, c.Body, now)
+			for name, v := range sig {
+				h[name] = v
+			}
+			p.Signed = true
+		}
+		return p, nil
+	}
+	return r
+}
+
+// Next builds a fresh replay signed at now.
+func (r *Replayer) Next(now time.Time) (*Replay, error) {
+	p, err := r.build(now)
+	if err != nil {
+		return nil, err
+	}
+	for name, v := range r.Header {
+		p.Header[name] = v
+	}
+	return p, nil
+}
+
+// Retry returns a copy of p for redelivery: the same body and delivery ID, with the
+// signature made afresh at now as providers do.
+func (r *Replayer) Retry(p *Replay, now time.Time) *Replay {
+	q := *p
+	q.Header = p.Header.Clone()
+	if p.Signed {
+		sig, _ := SignWebhook(r.Provider, r.Secret, p.Body, now)
+		for name, v := range sig {
+			q.Header[name] = v
+		}
+	}
+	return &q
+}
+
+// Request builds the HTTP request for sending p to target.
+func (p *Replay) Request(ctx context.Context, target string) (*http.Request, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Path == "" || u.Path == "/") && p.Path != "" {
+		u.Path = p.Path
+	}
+	req, err := http.NewRequestWithContext(ctx, p.Method, u.String(), bytes.NewReader(p.Body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = p.Header.Clone()
+	return req, nil
+}
+
+// SigningSecret returns secret, or the stored <provider>-signing-secret when secret
+// is empty and the provider has a signature scheme.
+func SigningSecret(provider, secret string) string {
+	if secret != "" || provider == "" {
+		return secret
+	}
+	if _, ok := WebhookSigners[strings.ToLower(provider)]; !ok {
+		return ""
+	}
+	stored, _, _ := LookupCredential(WebhookSecretName(provider))
+	return stored
+}
+
+// replayUsage is printed for `replay` without capture IDs.
+const replayUsage = `usage: webhook-catcher replay [-captures DIR] [-to URL] [-secret S] [-retry POLICY] [-redact forward] ID|last [ID...]
+
+Resends stored captures to -to (default http://localhost:8080/ plus the captured
+path), re-signed with -secret or the stored <provider>-signing-secret. With
+-redact forward (or all) they are redacted first, as by the -redact-* flags.`
+
+// ReplayCommand implements `replay`: resend stored captures.
+func ReplayCommand(args []string) error {
+	return replayCommand(args, os.Stdout)
+}
+
+func replayCommand(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.SetOutput(out)
+	dir := fs.String("captures", os.Getenv(EnvName("capture-dir")), "capture directory (default $"+EnvName("capture-dir")+")")
+	target := fs.String("to", DefaultSendURL, "URL to send to")
+	secret := fs.String("secret", "", "signing secret (default: the stored <provider>-signing-secret)")
+	var headers ListFlag
+	fs.Var(&headers, "header", `extra "Name: value" header (repeatable)`)
+	retry := fs.String("retry", "none", "retry policy: "+retryPolicyUsage)
+	retryScale := fs.Float64("retry-scale", 1, "multiply retry delays, e.g. 0.001 to replay a day-long schedule in seconds")
+	var redact RedactOptions
+	fs.Var((*ListFlag)(&redact.Targets), "redact", "forward (or all) to mask secrets and personal data in the replayed requests (repeatable)")
+	fs.Var((*ListFlag)(&redact.Headers), "redact-header", "header to redact besides Authorization, Cookie and signature headers (repeatable)")
+	fs.Var((*ListFlag)(&redact.Paths), "redact-path", "JSON body path to redact, * and ** wildcards (repeatable)")
+	fs.Var((*ListFlag)(&redact.Fields), "redact-field", "form field to redact (repeatable)")
+	fs.Var((*ListFlag)(&redact.Detectors), "redact-detector", "detectors to run: "+strings.Join(DetectorNames(), ", ")+", all (default) or none (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 || *dir == "" {
+		return errors.New(replayUsage)
+	}
+	policy, err := ParseRetryPolicy(*retry)
+	if err != nil {
+		return err
+	}
+	_, _, forward, err := redact.Redactors()
+	if err != nil {
+		return err
+	}
+	extra := http.Header{}
+	for _, kv := range headers {
+		name, value, ok := strings.Cut(kv, ":")
+		if !ok {
+			return fmt.Errorf("header %q: want Name: value", kv)
+		}
+		extra.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	byID, err := captureIndex(*dir)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, id := range fs.Args() {
+		c, ok := byID[id]
+		if !ok {
+			return fmt.Errorf("no capture %q in %s", id, *dir)
+		}
+		r := CaptureReplayer(forward.Redact(c), "")
+		r.Secret = SigningSecret(r.Provider, *secret)
+		r.Header = extra
+		p, err := r.Next(time.Now())
+		if err != nil {
+			return err
+		}
+		signed := "unsigned"
+		if p.Signed {
+			signed = r.Provider + " signature"
+		}
+		fmt.Fprintf(out, "Replaying %s %s %s (%s, %d bytes) to %s\n", c.ID, c.Method, c.Path, signed, len(p.Body), *target)
+		if err := deliver(out, r, p, *target, policy, *retryScale); err != nil {
+			if errors.Is(err, context.Canceled) {
+				return err
+			}
+			fmt.Fprintf(out, "%s[WARN]%s %v\n", colorYellow, colorReset, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d replays failed", failed, fs.NArg())
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func storedCapture(t *testing.T, dir string, c *Capture) {
+	t.Helper()
+	store, err := OpenStore(dir)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	if err := store.Add(c); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+}
+
+func TestReadCaptures(t *testing.T) {
+	dir := t.TempDir()
+	for _, id := range []string{"aa", "bb"} {
+		storedCapture(t, dir, &Capture{ID: id, Method: "POST", Path: "/hooks", Header: http.Header{}, Body: []byte(`{"n":1}`)})
+	}
+	captures, err := ReadCaptures(dir)
+	if err != nil || len(captures) != 2 || captures[0].ID != "aa" || string(captures[1].Body) != `{"n":1}` {
+		t.Fatalf("unexpected captures %+v err=%v", captures, err)
+	}
+	if _, err := ReadCaptures(t.TempDir()); err == nil {
+		t.Fatal("expected error for a dir without captures")
+	}
+}
+
+func TestCaptureReplayer_Resigns(t *testing.T) {
+	old := time.Unix(1600000000, 0)
+	body := []byte(`{"id":"evt_1"}`)
+	h, _ := SignWebhook("stripe", "whsec_prod", body, old)
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Forwarded-For", "203.0.113.9")
+	h.Set("Content-Length", "14")
+	c := &Capture{ID: "c1", Method: "POST", Path: "/hooks/stripe", Header: h, Body: body}
+
+	r := CaptureReplayer(c, "whsec_dev")
+	if r.Provider != "stripe" {
+		t.Fatalf("expected stripe to be detected, got %q", r.Provider)
+	}
+	now := time.Now()
+	p, err := r.Next(now)
+	if err != nil {
+		t.Fatalf("next: %v", err)
+	}
+	if err := VerifyWebhook("stripe", "whsec_dev", p.Header, p.Body, now); err != nil || !p.Signed {
+		t.Fatalf("replay should carry a fresh signature: %v", err)
+	}
+	if p.Header.Get("X-Forwarded-For") != "" || p.Header.Get("Content-Length") != "" || p.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected replay headers %v", p.Header)
+	}
+
+	// Without a secret the stale signature is dropped rather than resent
+	p, _ = CaptureReplayer(c, "").Next(now)
+	if p.Signed || p.Header.Get("Stripe-Signature") != "" {
+		t.Fatalf("expected unsigned replay, got %v", p.Header)
+	}
+}
+
+func TestCaptureReplayer_NewDeliveryIDAndPath(t *testing.T) {
+	h := http.Header{}
+	h.Set("X-GitHub-Event", "push")
+	h.Set("X-GitHub-Delivery", "11111111-1111-4111-8111-111111111111")
+	c := &Capture{ID: "c2", Method: "POST", Path: "/gh", Header: h, Body: []byte(`{}`)}
+	r := CaptureReplayer(c, "s")
+	r.Header = http.Header{"X-Extra": {"1"}}
+	a, _ := r.Next(time.Now())
+	b, _ := r.Next(time.Now())
+	if a.Header.Get("X-GitHub-Delivery") == b.Header.Get("X-GitHub-Delivery") || a.Header.Get("X-GitHub-Delivery") == h.Get("X-GitHub-Delivery") {
+		t.Fatalf("expected a new delivery ID per replay, got %q and %q", a.Header.Get("X-GitHub-Delivery"), b.Header.Get("X-GitHub-Delivery"))
+	}
+
+	var gotPath, gotExtra string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gotPath, gotExtra = req.URL.Path, req.Header.Get("X-Extra")
+		_, _ = io.Copy(io.Discard, req.Body)
+	}))
+	defer srv.Close()
+	for target, want := range map[string]string{srv.URL: "/gh", srv.URL + "/": "/gh", srv.URL + "/other": "/other"} {
+		req, err := a.Request(context.Background(), target)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("send: %v", err)
+		}
+		_ = resp.Body.Close()
+		if gotPath != want || gotExtra != "1" {
+			t.Fatalf("target %s: got path %q extra %q, want %q", target, gotPath, gotExtra, want)
+		}
+	}
+}
+
+func TestReplayCommand(t *testing.T) {
+	isolateCredentials(t)
+	_ = KeyringStore{Service: credentialService}.Set(WebhookSecretName("github"), "gh_stored")
+	dir := t.TempDir()
+	h := http.Header{}
+	h.Set("X-GitHub-Event", "push")
+	h.Set("X-GitHub-Delivery", "11111111-1111-4111-8111-111111111111")
+	h.Set("X-Hub-Signature-256", "sha256=00")
+	storedCapture(t, dir, &Capture{ID: "c1", Method: "POST", Path: "/gh", Header: h, Body: []byte(`{"a":1}`)})
+	storedCapture(t, dir, &Capture{ID: "c2", Method: "PUT", Path: "/other", Header: http.Header{}, Body: []byte(`{"b":2}`)})
+
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		verified := VerifyWebhook("github", "gh_stored", r.Header, body, time.Now()) == nil
+		got = append(got, r.Method+" "+r.URL.Path+" "+string(body)+" "+map[bool]string{true: "verified", false: "unsigned"}[verified])
+		if r.URL.Path == "/other" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	t.Setenv(EnvName("capture-dir"), dir)
+	var out bytes.Buffer
+	err := replayCommand([]string{"-to", srv.URL, "c1", "last"}, &out)
+	if err == nil || err.Error() != "1 of 2 replays failed" {
+		t.Fatalf("expected one failed replay, got %v", err)
+	}
+	want := []string{`POST /gh {"a":1} verified`, `PUT /other {"b":2} unsigned`}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for _, s := range []string{"Replaying c1 POST /gh (github signature, 7 bytes)", "Replaying c2 PUT /other (unsigned", "400 Bad Request"} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("expected output to contain %q, got:\n%s", s, out.String())
+		}
+	}
+
+	// Forwarding redaction masks the replayed request
+	got = nil
+	h = http.Header{}
+	h.Set("Authorization", "Bearer s3cret")
+	storedCapture(t, dir, &Capture{ID: "c3", Method: "POST", Path: "/pii", Header: h, Body: []byte(`{"email":"a@b.io"}`)})
+	var auth string
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		auth, got = r.Header.Get("Authorization"), append(got, string(body))
+	})
+	if err := replayCommand([]string{"-to", srv.URL, "-redact", "forward", "c3"}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if auth != RedactedValue || len(got) != 1 || got[0] != `{"email":"[REDACTED:email]"}` {
+		t.Fatalf("replay not redacted: Authorization %q, bodies %q", auth, got)
+	}
+
+	for args, want := range map[string]string{"": "usage:", "nope": "no capture", "-retry x c1": "unknown retry policy", "-redact export c1": "unknown redaction target"} {
+		if err := replayCommand(strings.Fields(args), io.Discard); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("replay %q: expected error containing %q, got %v", args, want, err)
+		}
+	}
+}
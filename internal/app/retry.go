@@ -0,0 +1,214 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy is a provider's redelivery schedule: Delays[i] is the wait before
+// attempt i+2, so len(Delays) is the number of retries. Each delay is varied by up
+// to ±Jitter of itself.
+type RetryPolicy struct {
+	Name   string
+	Delays []time.Duration
+	Jitter float64
+}
+
+// stripeDelays approximate Stripe's live-mode schedule: exponential backoff for up
+// to three days.
+var stripeDelays = []time.Duration{
+	time.Minute, 5 * time.Minute, 30 * time.Minute, time.Hour, 2 * time.Hour, 4 * time.Hour,
+	8 * time.Hour, 12 * time.Hour, 12 * time.Hour, 12 * time.Hour, 12 * time.Hour,
+}
+
+// retryPolicyUsage lists the accepted -retry values.
+const retryPolicyUsage = "none, github, stripe, fixed[:INTERVAL[:RETRIES]], exponential[:BASE[:RETRIES]] or a list such as 1s,5s,30s"
+
+// ParseRetryPolicy parses a -retry value:
+//
+//	none, github                 a single attempt (GitHub does not retry on its own)
+//	stripe                       Stripe-like backoff over about three days
+//	fixed:10s:3                  3 retries 10s apart (the defaults)
+//	exponential:1s:5             5 retries starting at 1s and doubling, ±50% jitter
+//	1s,5s,30s                    retries after exactly these delays
+func ParseRetryPolicy(spec string) (RetryPolicy, error) {
+	kind, rest, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "", "none", "github":
+		return RetryPolicy{Name: orDefault(kind, "none")}, nil
+	case "stripe":
+		return RetryPolicy{Name: "stripe", Delays: stripeDelays, Jitter: 0.1}, nil
+	case "fixed", "exponential":
+		interval, retries := 10*time.Second, 3
+		if kind == "exponential" {
+			interval, retries = time.Second, 5
+		}
+		a, b, _ := strings.Cut(rest, ":")
+		var err error
+		if a != "" {
+			if interval, err = time.ParseDuration(a); err != nil || interval <= 0 {
+				return RetryPolicy{}, fmt.Errorf("retry policy %q: bad interval %q", spec, a)
+			}
+		}
+		if b != "" {
+			if retries, err = strconv.Atoi(b); err != nil || retries < 0 {
+				return RetryPolicy{}, fmt.Errorf("retry policy %q: bad retry count %q", spec, b)
+			}
+		}
+		p := RetryPolicy{Name: spec}
+		for i := 0; i < retries; i++ {
+			p.Delays = append(p.Delays, interval)
+			if kind == "exponential" {
+				interval *= 2
+				p.Jitter = 0.5
+			}
+		}
+		return p, nil
+	}
+	p := RetryPolicy{Name: "custom"}
+	for _, s := range strings.Split(spec, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(s))
+		if err != nil || d < 0 {
+			return RetryPolicy{}, fmt.Errorf("unknown retry policy %q: want %s", spec, retryPolicyUsage)
+		}
+		p.Delays = append(p.Delays, d)
+	}
+	return p, nil
+}
+
+// delay returns the jittered wait before retry i (0-based).
+func (p RetryPolicy) delay(i int, rng *rand.Rand) time.Duration {
+	d := p.Delays[i]
+	if p.Jitter > 0 {
+		d = time.Duration(float64(d) * (1 + p.Jitter*(2*rng.Float64()-1)))
+	}
+	return d
+}
+
+// retrySleep waits between attempts. Overridable in tests.
+var retrySleep = func(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Attempt is one delivery attempt.
+type Attempt struct {
+	// At is the offset from the first attempt.
+	At      time.Duration
+	Status  int
+	Latency time.Duration
+	Err     error
+}
+
+// Delivery is the outcome of delivering one replay.
+type Delivery struct {
+	Attempts  []Attempt
+	Delivered bool
+	// Response is the start of the last response body.
+	Response []byte
+}
+
+// Deliverer sends replays the way a provider does: a non-2xx answer or a timeout
+// is a failure and is retried under Policy. Every attempt carries the same delivery
+// ID; signatures are refreshed per attempt. The timeline is written to Out.
+type Deliverer struct {
+	Client *http.Client
+	Policy RetryPolicy
+	// Scale multiplies every delay so long schedules can be compressed; 0 means 1.
+	Scale float64
+	Out   io.Writer
+}
+
+// Deliver sends the replay p built by r to target until it succeeds, the policy
+// gives up or ctx is done.
+func (d *Deliverer) Deliver(ctx context.Context, r *Replayer, p *Replay, target string) (*Delivery, error) {
+	scale := d.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	out := &Delivery{}
+	start := time.Now()
+	for i := 0; ; i++ {
+		if i > 0 {
+			p = r.Retry(p, time.Now())
+		}
+		a := Attempt{At: time.Since(start)}
+		req, err := p.Request(ctx, target)
+		if err != nil {
+			return out, err
+		}
+		sent := time.Now()
+		resp, err := d.Client.Do(req)
+		a.Latency = time.Since(sent)
+		if err != nil {
+			a.Err = err
+		} else {
+			a.Status = resp.StatusCode
+			out.Response, _ = io.ReadAll(io.LimitReader(resp.Body, sendPreview))
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+		out.Attempts = append(out.Attempts, a)
+		out.Delivered = a.Err == nil && a.Status >= 200 && a.Status < 300
+		d.printAttempt(i+1, a, resp)
+		if out.Delivered {
+			return out, nil
+		}
+		if i >= len(d.Policy.Delays) {
+			fmt.Fprintf(d.Out, "      gave up after %d attempt(s) (%s policy)\n", i+1, d.Policy.Name)
+			return out, nil
+		}
+		wait := time.Duration(float64(d.Policy.delay(i, rng)) * scale)
+		fmt.Fprintf(d.Out, "      retrying in %s\n", wait.Round(time.Millisecond))
+		if err := retrySleep(ctx, wait); err != nil {
+			fmt.Fprintln(d.Out, "      interrupted")
+			return out, err
+		}
+	}
+}
+
+func (d *Deliverer) printAttempt(n int, a Attempt, resp *http.Response) {
+	at := fmt.Sprintf("+%s", a.At.Round(time.Millisecond))
+	var ne net.Error
+	switch {
+	case a.Err != nil && errors.As(a.Err, &ne) && ne.Timeout():
+		fmt.Fprintf(d.Out, "  #%-2d %-10s timeout after %s\n", n, at, a.Latency.Round(time.Millisecond))
+	case a.Err != nil:
+		fmt.Fprintf(d.Out, "  #%-2d %-10s %s\n", n, at, blastErrorKind(a.Err))
+	default:
+		fmt.Fprintf(d.Out, "  #%-2d %-10s %s in %s\n", n, at, resp.Status, a.Latency.Round(time.Millisecond))
+	}
+}
+
+// DeliveryID returns the provider delivery ID header of p, or "".
+func DeliveryID(p *Replay) string {
+	for _, name := range deliveryIDHeaders {
+		if id := p.Header.Get(name); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// printDelivery writes the response preview of the last attempt.
+func printDelivery(w io.Writer, dl *Delivery) {
+	if len(dl.Response) > 0 {
+		fmt.Fprintf(w, "%s\n", bytes.TrimRight(dl.Response, "\n"))
+	}
+}
@@ -0,0 +1,163 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseRetryPolicy(t *testing.T) {
+	cases := map[string][]time.Duration{
+		"":                    nil,
+		"none":                nil,
+		"github":              nil,
+		"fixed":               {10 * time.Second, 10 * time.Second, 10 * time.Second},
+		"fixed:2s:2":          {2 * time.Second, 2 * time.Second},
+		"exponential:1s:4":    {time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second},
+		"1s, 5s,30s":          {time.Second, 5 * time.Second, 30 * time.Second},
+		"stripe":              stripeDelays,
+		"exponential:250ms:0": nil,
+	}
+	for spec, want := range cases {
+		p, err := ParseRetryPolicy(spec)
+		if err != nil || !reflect.DeepEqual(p.Delays, want) {
+			t.Fatalf("ParseRetryPolicy(%q) = %v, %v; want %v", spec, p.Delays, err, want)
+		}
+	}
+	var total time.Duration
+	for _, d := range stripeDelays {
+		total += d
+	}
+	if total < 2*24*time.Hour || total > 3*24*time.Hour {
+		t.Fatalf("stripe schedule should span up to three days, spans %s", total)
+	}
+	for _, spec := range []string{"fixed:x", "fixed:1s:-1", "exponential:0s", "sometimes", "1s,soon"} {
+		if _, err := ParseRetryPolicy(spec); err == nil {
+			t.Fatalf("expected error for %q", spec)
+		}
+	}
+}
+
+func TestDeliverer_RetriesUntilDelivered(t *testing.T) {
+	var mu sync.Mutex
+	var ids []string
+	attempt := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		attempt++
+		n := attempt
+		ids = append(ids, r.Header.Get("X-GitHub-Delivery"))
+		mu.Unlock()
+		if err := VerifyWebhook("github", "s", r.Header, body, time.Now()); err != nil {
+			t.Errorf("attempt %d: %v", n, err)
+		}
+		switch n {
+		case 1:
+			http.Error(w, "boom", http.StatusInternalServerError)
+		case 2:
+			time.Sleep(200 * time.Millisecond)
+		default:
+			_, _ = io.WriteString(w, "thanks")
+		}
+	}))
+	defer srv.Close()
+
+	var waits []time.Duration
+	origSleep := retrySleep
+	defer func() { retrySleep = origSleep }()
+	retrySleep = func(_ context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+
+	r := githubReplayer(t)
+	p, _ := r.Next(time.Now())
+	policy, _ := ParseRetryPolicy("1s,1m,1h")
+	var out bytes.Buffer
+	d := &Deliverer{Client: &http.Client{Timeout: 50 * time.Millisecond}, Policy: policy, Scale: 0.5, Out: &out}
+	dl, err := d.Deliver(context.Background(), r, p, srv.URL)
+	if err != nil || !dl.Delivered || len(dl.Attempts) != 3 || string(dl.Response) != "thanks" {
+		t.Fatalf("unexpected delivery %+v err=%v", dl, err)
+	}
+	if !reflect.DeepEqual(waits, []time.Duration{500 * time.Millisecond, 30 * time.Second}) {
+		t.Fatalf("expected scaled waits, got %v", waits)
+	}
+	if ids[0] == "" || ids[0] != ids[1] || ids[1] != ids[2] {
+		t.Fatalf("delivery ID should be stable across retries, got %v", ids)
+	}
+	text := out.String()
+	for _, want := range []string{"#1  +0s", "500 Internal Server Error in", "retrying in 500ms", "timeout after", "retrying in 30s", "#3", "200 OK in"} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("expected timeline to contain %q, got:\n%s", want, text)
+		}
+	}
+}
+
+func TestDeliverer_GivesUp(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusFound)
+	}))
+	defer srv.Close()
+	origSleep := retrySleep
+	defer func() { retrySleep = origSleep }()
+	retrySleep = func(context.Context, time.Duration) error { return nil }
+
+	r := githubReplayer(t)
+	p, _ := r.Next(time.Now())
+	policy, _ := ParseRetryPolicy("fixed:1s:2")
+	var out bytes.Buffer
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	dl, err := (&Deliverer{Client: client, Policy: policy, Out: &out}).Deliver(context.Background(), r, p, srv.URL)
+	if err != nil || dl.Delivered || len(dl.Attempts) != 3 {
+		t.Fatalf("expected 3 failed attempts, got %+v err=%v", dl, err)
+	}
+	if !strings.Contains(out.String(), "gave up after 3 attempt(s) (fixed:1s:2 policy)") {
+		t.Fatalf("unexpected timeline:\n%s", out.String())
+	}
+
+	// Cancelling during a backoff stops the delivery
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	retrySleep = origSleep
+	if _, err := (&Deliverer{Client: client, Policy: policy, Out: io.Discard}).Deliver(ctx, r, p, srv.URL); err == nil {
+		t.Fatal("expected cancellation error")
+	}
+}
+
+func TestSendCommand_Retry(t *testing.T) {
+	isolateCredentials(t)
+	origSleep := retrySleep
+	defer func() { retrySleep = origSleep }()
+	retrySleep = func(context.Context, time.Duration) error { return nil }
+	var mu sync.Mutex
+	var sigs []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		sigs = append(sigs, r.Header.Get("Stripe-Signature"))
+		n := len(sigs)
+		mu.Unlock()
+		if n < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	if err := sendCommand([]string{"-secret", "whsec", "-retry", "stripe", "stripe/invoice.paid", srv.URL}, &out); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if len(sigs) != 3 || !strings.Contains(out.String(), "Retry policy stripe: up to 11 retries, delivery ID none") {
+		t.Fatalf("unexpected attempts %d, output:\n%s", len(sigs), out.String())
+	}
+	if err := sendCommand([]string{"-retry", "often", "github/push"}, io.Discard); err == nil || !strings.Contains(err.Error(), "unknown retry policy") {
+		t.Fatalf("expected retry policy error, got %v", err)
+	}
+}
@@ -2,8 +2,10 @@ package app
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
@@ -57,6 +59,7 @@ func TestRun_Ngrok_WithToken_OK(t *testing.T) {
 }
 
 func TestRun_Ngrok_Prompt_SaveToken_OK(t *testing.T) {
+	isolateCredentials(t)
 	// run within temp dir so .env writes are isolated
 	origWD, _ := os.Getwd()
 	tmp := t.TempDir()
@@ -67,7 +70,8 @@ func TestRun_Ngrok_Prompt_SaveToken_OK(t *testing.T) {
 	defer func() { os.Stdin = origStdin }()
 	r, w, _ := os.Pipe()
 	os.Stdin = r
-	_, _ = w.Write([]byte("tok123\n"))
+	// choose [3] .env (plaintext) at the storage prompt
+	_, _ = w.Write([]byte("tok123\n3\n"))
 	_ = w.Close()
 	_ = os.Unsetenv("NGROK_AUTHTOKEN")
 
@@ -103,3 +107,82 @@ func TestRun_Ngrok_Error(t *testing.T) {
 		t.Fatalf("expected ngrok listen error, got %v", err)
 	}
 }
+
+func TestRun_Local_TLS_OK(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeTestCert(t, dir, "server", newTestCert(t, "localhost", 1, false, nil))
+
+	orig := ServeLocalTLSFunc
+	defer func() { ServeLocalTLSFunc = orig }()
+	var gotCfg *tls.Config
+	ServeLocalTLSFunc = func(addr string, mux http.Handler, tlsCfg *tls.Config) error {
+		gotCfg = tlsCfg
+		return nil
+	}
+
+	err := Run(Options{Host: "127.0.0.1", Port: 8443, TLSCert: certPath, TLSKey: keyPath, TLSClientAuth: "require"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if gotCfg == nil || gotCfg.ClientAuth != tls.RequireAnyClientCert {
+		t.Fatalf("expected TLS config with RequireAnyClientCert, got %+v", gotCfg)
+	}
+}
+
+func TestRun_Local_TLS_ConfigError(t *testing.T) {
+	err := Run(Options{Host: "127.0.0.1", Port: 8443, TLSCert: "missing.crt", TLSKey: "missing.key"})
+	if err == nil || !strings.Contains(err.Error(), "tls config error") {
+		t.Fatalf("expected tls config error, got %v", err)
+	}
+}
+
+func TestRun_Local_HTTP3_RequiresTLS(t *testing.T) {
+	err := Run(Options{Host: "127.0.0.1", Port: 8443, HTTP3: true})
+	if err == nil || !strings.Contains(err.Error(), "HTTP/3 requires TLS") {
+		t.Fatalf("expected HTTP/3 TLS error, got %v", err)
+	}
+}
+
+func TestRun_Local_HTTP3_ServesBoth(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeTestCert(t, dir, "server", newTestCert(t, "localhost", 1, false, nil))
+
+	origTLS, origH3 := ServeLocalTLSFunc, ServeHTTP3Func
+	defer func() { ServeLocalTLSFunc, ServeHTTP3Func = origTLS, origH3 }()
+	tlsCalled := make(chan http.Handler, 1)
+	done := make(chan struct{})
+	defer close(done)
+	ServeLocalTLSFunc = func(addr string, mux http.Handler, tlsCfg *tls.Config) error {
+		tlsCalled <- mux
+		<-done
+		return nil
+	}
+	ServeHTTP3Func = func(addr string, mux http.Handler, tlsCfg *tls.Config) error {
+		return errors.New("udp busy")
+	}
+
+	err := Run(Options{Host: "127.0.0.1", Port: 8443, TLSCert: certPath, TLSKey: keyPath, HTTP3: true})
+	if err == nil || !strings.Contains(err.Error(), "udp busy") {
+		t.Fatalf("expected HTTP/3 server error, got %v", err)
+	}
+	h := <-tlsCalled
+	w := httptest.NewRecorder()
+	captureStdout(func() { h.ServeHTTP(w, httptest.NewRequest("POST", "/", nil)) })
+	if w.Header().Get("Alt-Svc") == "" {
+		t.Fatalf("expected TCP listener to advertise HTTP/3 via Alt-Svc")
+	}
+}
+
+func TestRun_Local_H2C(t *testing.T) {
+	orig := ServeLocalFunc
+	defer func() { ServeLocalFunc = orig }()
+	var got http.Handler
+	ServeLocalFunc = func(addr string, mux http.Handler) error { got = mux; return nil }
+
+	if err := Run(Options{Host: "127.0.0.1", Port: 8080, H2C: true}); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if _, isMux := got.(*http.ServeMux); isMux {
+		t.Fatalf("expected mux to be wrapped for h2c")
+	}
+}
@@ -0,0 +1,140 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// SchemaViolation is one way a body broke its JSON Schema. Path is a JSON Pointer
+// into the body ("" for the whole document).
+type SchemaViolation struct {
+	Path    string `json:"path"`
+	Keyword string `json:"keyword,omitempty"`
+	Message string `json:"message"`
+}
+
+func (v SchemaViolation) String() string {
+	p := v.Path
+	if p == "" {
+		p = "/"
+	}
+	return p + ": " + v.Message
+}
+
+// SchemaRule validates the JSON body of requests whose path matches Pattern. When
+// Reject is set a failing request is answered with that status instead of 200.
+type SchemaRule struct {
+	Pattern string
+	File    string
+	Reject  int
+	schema  *jsonschema.Schema
+}
+
+// ParseSchemaRule parses "PATTERN=FILE[:STATUS]", e.g. "/partner/*=order.json:422".
+// PATTERN is a path.Match glob; STATUS must be 4xx.
+func ParseSchemaRule(spec string) (SchemaRule, error) {
+	pattern, file, ok := strings.Cut(spec, "=")
+	if !ok || pattern == "" || file == "" {
+		return SchemaRule{}, fmt.Errorf("schema rule %q: want PATTERN=FILE[:STATUS]", spec)
+	}
+	if _, err := path.Match(pattern, "/"); err != nil {
+		return SchemaRule{}, fmt.Errorf("schema rule %q: bad pattern: %w", spec, err)
+	}
+	r := SchemaRule{Pattern: pattern, File: file}
+	if i := strings.LastIndex(file, ":"); i >= 0 && len(file)-i == 4 {
+		if status, err := strconv.Atoi(file[i+1:]); err == nil {
+			if status < 400 || status > 499 {
+				return SchemaRule{}, fmt.Errorf("schema rule %q: reject status must be 4xx", spec)
+			}
+			r.File, r.Reject = file[:i], status
+		}
+	}
+	s, err := CompileSchema(r.File)
+	if err != nil {
+		return SchemaRule{}, err
+	}
+	r.schema = s
+	return r, nil
+}
+
+// CompileSchema loads a draft 2020-12 or draft-07 schema (picked by $schema;
+// 2020-12 when absent). Formats such as date-time and email are asserted, and
+// relative $refs resolve against the file's directory.
+func CompileSchema(file string) (*jsonschema.Schema, error) {
+	c := jsonschema.NewCompiler()
+	c.Draft = jsonschema.Draft2020
+	c.AssertFormat = true
+	s, err := c.Compile(file)
+	if err != nil {
+		return nil, fmt.Errorf("schema %s: %w", file, err)
+	}
+	return s, nil
+}
+
+// Matches reports whether the rule applies to a request path.
+func (r SchemaRule) Matches(p string) bool {
+	ok, _ := path.Match(r.Pattern, p)
+	return ok
+}
+
+// Validate checks body against the rule's schema. A body that is not JSON is a
+// single violation at the root.
+func (r SchemaRule) Validate(body []byte) []SchemaViolation {
+	return r.ValidateReader(bytes.NewReader(body))
+}
+
+// ValidateReader is Validate for a body that is not in memory, such as a spooled one.
+func (r SchemaRule) ValidateReader(body io.Reader) []SchemaViolation {
+	dec := json.NewDecoder(body)
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return []SchemaViolation{{Keyword: "json", Message: "body is not valid JSON: " + err.Error()}}
+	}
+	err := r.schema.Validate(v)
+	var ve *jsonschema.ValidationError
+	if errors.As(err, &ve) {
+		return flattenViolations(ve, nil)
+	}
+	if err != nil {
+		return []SchemaViolation{{Message: err.Error()}}
+	}
+	return nil
+}
+
+// flattenViolations keeps the leaves of the validator's error tree, which name the
+// failing keyword and instance location.
+func flattenViolations(ve *jsonschema.ValidationError, out []SchemaViolation) []SchemaViolation {
+	if len(ve.Causes) == 0 {
+		kw := ve.KeywordLocation
+		if i := strings.LastIndex(kw, "/"); i >= 0 {
+			kw = kw[i+1:]
+		}
+		return append(out, SchemaViolation{Path: ve.InstanceLocation, Keyword: kw, Message: ve.Message})
+	}
+	for _, c := range ve.Causes {
+		out = flattenViolations(c, out)
+	}
+	return out
+}
+
+// schemaRules are the session's schema rules; the first match applies.
+var schemaRules []SchemaRule
+
+// matchSchemaRule returns the first of rules matching p.
+func matchSchemaRule(rules []SchemaRule, p string) (SchemaRule, bool) {
+	for _, r := range rules {
+		if r.Matches(p) {
+			return r, true
+		}
+	}
+	return SchemaRule{}, false
+}
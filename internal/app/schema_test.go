@@ -0,0 +1,182 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const orderSchema2020 = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "type": "object",
+  "required": ["id", "email", "items", "placed_at"],
+  "properties": {
+    "id": {"type": "string", "format": "uuid"},
+    "email": {"type": "string", "format": "email"},
+    "placed_at": {"type": "string", "format": "date-time"},
+    "items": {"type": "array", "minItems": 1, "items": {"$ref": "#/$defs/item"}},
+    "pair": {"type": "array", "prefixItems": [{"type": "string"}, {"type": "integer"}]}
+  },
+  "$defs": {
+    "item": {"type": "object", "required": ["sku", "price"], "properties": {"sku": {"type": "string"}, "price": {"type": "number", "minimum": 0}}}
+  }
+}`
+
+const orderSchemaDraft7 = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "type": "object",
+  "properties": {
+    "tuple": {"type": "array", "items": [{"type": "string"}, {"type": "integer"}], "additionalItems": false},
+    "item": {"$ref": "#/definitions/item"}
+  },
+  "definitions": {"item": {"type": "object", "additionalProperties": false, "properties": {"sku": {"type": "string"}}}}
+}`
+
+func writeSchema(t *testing.T, name, doc string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, []byte(doc), 0600); err != nil {
+		t.Fatalf("write schema: %v", err)
+	}
+	return p
+}
+
+func violationPaths(vs []SchemaViolation) []string {
+	var paths []string
+	for _, v := range vs {
+		paths = append(paths, v.Path+" "+v.Keyword)
+	}
+	return paths
+}
+
+func TestSchemaRule_Draft2020(t *testing.T) {
+	rule, err := ParseSchemaRule("/orders/*=" + writeSchema(t, "order.json", orderSchema2020))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if rule.Reject != 0 || !rule.Matches("/orders/new") || rule.Matches("/orders/new/x") {
+		t.Fatalf("unexpected rule %+v", rule)
+	}
+	valid := `{"id":"0b8f7a8e-6f0b-4d7a-9a3e-0c5b3f2d1e4a","email":"a@example.com","placed_at":"2024-06-10T16:00:00Z","items":[{"sku":"x","price":5}],"pair":["a",1]}`
+	if vs := rule.Validate([]byte(valid)); len(vs) != 0 {
+		t.Fatalf("expected valid body, got %v", vs)
+	}
+	bad := `{"id":"nope","email":"not-an-email","placed_at":"yesterday","items":[{"sku":"x","price":-1},{"price":"5"}],"pair":["a","b"]}`
+	got := violationPaths(rule.Validate([]byte(bad)))
+	for _, want := range []string{"/id format", "/email format", "/placed_at format", "/items/0/price minimum", "/items/1 required", "/items/1/price type", "/pair/1 type"} {
+		found := false
+		for _, g := range got {
+			found = found || g == want
+		}
+		if !found {
+			t.Fatalf("expected violation %q, got %q", want, got)
+		}
+	}
+	if vs := rule.Validate([]byte("not json")); len(vs) != 1 || vs[0].Path != "" || !strings.Contains(vs[0].String(), "/: body is not valid JSON") {
+		t.Fatalf("expected a root JSON violation, got %v", vs)
+	}
+}
+
+func TestSchemaRule_Draft7(t *testing.T) {
+	rule, err := ParseSchemaRule("*=" + writeSchema(t, "d7.json", orderSchemaDraft7) + ":422")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if rule.Reject != 422 {
+		t.Fatalf("expected reject status 422, got %d", rule.Reject)
+	}
+	got := violationPaths(rule.Validate([]byte(`{"tuple":["a",1,"extra"],"item":{"sku":"x","color":"red"}}`)))
+	sort.Strings(got)
+	if want := []string{"/item additionalProperties", "/tuple additionalItems"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseSchemaRule_Errors(t *testing.T) {
+	good := writeSchema(t, "s.json", `{"type":"object"}`)
+	broken := writeSchema(t, "broken.json", `{"type":"nope"}`)
+	for spec, want := range map[string]string{
+		"/x":                      "want PATTERN=FILE",
+		"/x=" + good + ":500":     "must be 4xx",
+		"[=" + good:               "bad pattern",
+		"/x=" + good + ".missing": "schema ",
+		"/x=" + broken:            "broken.json",
+	} {
+		if _, err := ParseSchemaRule(spec); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("ParseSchemaRule(%q): expected error containing %q, got %v", spec, want, err)
+		}
+	}
+}
+
+func TestWebhookHandler_SchemaValidation(t *testing.T) {
+	strict, _ := ParseSchemaRule("/strict=" + writeSchema(t, "order.json", orderSchema2020) + ":422")
+	lax, _ := ParseSchemaRule("/lax=" + strict.File)
+	schemaRules = []SchemaRule{strict, lax}
+	defer func() { schemaRules = nil }()
+	dir := t.TempDir()
+	store, _ := OpenStore(dir)
+	captureStore = store
+	defer func() { captureStore = nil }()
+
+	w := httptest.NewRecorder()
+	out := captureStdout(func() {
+		WebhookHandler(w, httptest.NewRequest("POST", "/strict", strings.NewReader(`{"id":"0b8f7a8e-6f0b-4d7a-9a3e-0c5b3f2d1e4a"}`)))
+	})
+	if w.Code != 422 {
+		t.Fatalf("expected 422, got %d", w.Code)
+	}
+	var resp struct {
+		Error      string            `json:"error"`
+		Violations []SchemaViolation `json:"violations"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Error != "schema validation failed" || len(resp.Violations) != 1 || resp.Violations[0].Keyword != "required" {
+		t.Fatalf("unexpected response %s err=%v", w.Body.String(), err)
+	}
+	if text := stripANSI(out); !strings.Contains(text, "Schema: 1 violation(s) of "+strict.File+", answered 422") || !strings.Contains(text, "/: missing properties:") {
+		t.Fatalf("unexpected console output: %s", text)
+	}
+
+	// Report-only rules still answer 200
+	w = httptest.NewRecorder()
+	out = captureStdout(func() { WebhookHandler(w, httptest.NewRequest("POST", "/lax", strings.NewReader(`[]`))) })
+	if w.Code != 200 || !strings.Contains(stripANSI(out), "Schema: 1 violation(s)") || strings.Contains(out, "answered") {
+		t.Fatalf("expected 200 with reported violation, got %d: %s", w.Code, out)
+	}
+	// Unmatched paths are not validated
+	out = captureStdout(func() {
+		WebhookHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/other", strings.NewReader(`[]`)))
+	})
+	if strings.Contains(out, "Schema") {
+		t.Fatalf("expected no schema output for an unmatched path, got: %s", out)
+	}
+
+	_ = store.Close()
+	captures, _ := ReadCaptures(dir)
+	if len(captures) != 3 || captures[0].Schema != strict.File || len(captures[0].SchemaErrors) != 1 || captures[2].Schema != "" {
+		t.Fatalf("expected violations to be stored with the capture, got %+v", captures)
+	}
+	if b := mustJSON(t, captures[0]); !strings.Contains(string(b), `"schema_errors":[{"path":"","keyword":"required"`) {
+		t.Fatalf("unexpected stored JSON %s", b)
+	}
+}
+
+func mustJSON(t *testing.T, v any) []byte {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return b
+}
+
+func TestRun_SchemaRuleError(t *testing.T) {
+	isolateEnv(t)
+	if err := Run(Options{Schemas: []string{"/x=/does/not/exist.json"}}); err == nil || !strings.Contains(err.Error(), "schema rule error") {
+		t.Fatalf("expected schema rule error, got %v", err)
+	}
+}
@@ -0,0 +1,408 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// DefaultScriptTimeout bounds a single script run.
+const DefaultScriptTimeout = time.Second
+
+// scriptMaxSteps bounds the Starlark computation steps of a single run or load,
+// whatever the timeout. Together with the timeout it also bounds how much a
+// script can allocate; Starlark refuses single allocations of 1GB or more itself.
+var scriptMaxSteps uint64 = 50_000_000
+
+// ScriptOptions answer requests with Starlark scripts.
+//
+// A script is a Starlark file defining handle(req, state), called for every
+// matching request:
+//
+//   - req has id, method, path, remote_addr, headers (a dict keyed by lowercase
+//     name), body (a string, empty for spooled bodies), body_size and json (the
+//     decoded body, or None).
+//   - state is a dict the script keeps between requests and across reloads.
+//
+// It returns None to answer as usual, or a dict with any of status, headers and
+// body to answer itself, tags (a string or list of strings) to label the
+// console block, and quiet to keep the capture off the console. Scripts have
+// the json module, sha256(data) and hmac_sha256(key, data), both hex, and
+// print, whose output is shown under the capture. They cannot reach files or
+// the network, and runs are stopped after too many steps.
+type ScriptOptions struct {
+	// Rules are "PATTERN=FILE" scripts; the first whose pattern matches a path
+	// handles the request. See ParseScriptRule.
+	Rules []string
+	// Timeout bounds each run (default DefaultScriptTimeout).
+	Timeout time.Duration
+	// Logf reports reloads and scripts that no longer load; nil means log.Printf.
+	Logf func(format string, args ...any)
+}
+
+// ScriptRule runs the script in File for requests on matching paths.
+type ScriptRule struct {
+	// Pattern is a path.Match glob.
+	Pattern string
+	File    string
+}
+
+// ParseScriptRule parses "PATTERN=FILE", e.g. "/github/*=flaky.star".
+func ParseScriptRule(spec string) (ScriptRule, error) {
+	pattern, file, ok := strings.Cut(spec, "=")
+	if !ok || pattern == "" || file == "" {
+		return ScriptRule{}, fmt.Errorf("script rule %q: want PATTERN=FILE", spec)
+	}
+	if _, err := path.Match(pattern, "/"); err != nil {
+		return ScriptRule{}, fmt.Errorf("script rule %q: bad pattern: %w", spec, err)
+	}
+	return ScriptRule{Pattern: pattern, File: file}, nil
+}
+
+// Matches reports whether the script handles a request path.
+func (r ScriptRule) Matches(p string) bool {
+	ok, _ := path.Match(r.Pattern, p)
+	return ok
+}
+
+// ScriptResult is what one script run decided.
+type ScriptResult struct {
+	File string
+	// Tags label the capture's console block and Quiet keeps it off the console.
+	Tags  []string
+	Quiet bool
+	// Output is what the script printed.
+	Output   []byte
+	Duration time.Duration
+	// Err is set when the script could not load, failed or timed out.
+	Err      error
+	TimedOut bool
+
+	resp *Response
+}
+
+// Response is the script's answer, or nil when the request is answered as usual.
+// A failed script is a 500 and a timed-out one a 504.
+func (res *ScriptResult) Response() *Response {
+	if res.Err != nil {
+		status := http.StatusInternalServerError
+		if res.TimedOut {
+			status = http.StatusGatewayTimeout
+		}
+		return &Response{Status: status, Header: http.Header{"Content-Type": {"text/plain; charset=utf-8"}}, Body: []byte("script failed: " + res.Err.Error() + "\n")}
+	}
+	return res.resp
+}
+
+// ScriptRunner runs scripts, reloading each one when its file changes.
+type ScriptRunner struct {
+	scripts []*script
+	timeout time.Duration
+	logf    func(format string, args ...any)
+}
+
+// script is a loaded rule. mu serializes runs, so handle and state are only
+// touched by one request at a time.
+type script struct {
+	rule    ScriptRule
+	mu      sync.Mutex
+	handle  starlark.Callable
+	modTime time.Time
+	size    int64
+	state   *starlark.Dict
+}
+
+// NewScriptRunner validates o and loads its scripts. It returns nil when o has
+// no rules.
+func NewScriptRunner(o ScriptOptions) (*ScriptRunner, error) {
+	s := &ScriptRunner{timeout: o.Timeout, logf: o.Logf}
+	if s.logf == nil {
+		s.logf = log.Printf
+	}
+	if s.timeout < 0 {
+		return nil, errors.New("script timeout must be positive")
+	}
+	if s.timeout == 0 {
+		s.timeout = DefaultScriptTimeout
+	}
+	for _, spec := range o.Rules {
+		rule, err := ParseScriptRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		sc := &script{rule: rule, state: new(starlark.Dict)}
+		if err := sc.load(s.timeout); err != nil {
+			return nil, err
+		}
+		s.scripts = append(s.scripts, sc)
+	}
+	if len(s.scripts) == 0 {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// Describe lists the scripts for the startup log.
+func (s *ScriptRunner) Describe() []string {
+	var lines []string
+	for _, sc := range s.scripts {
+		lines = append(lines, fmt.Sprintf("%s runs %s", sc.rule.Pattern, sc.rule.File))
+	}
+	return append(lines, fmt.Sprintf("up to %s each, reloaded when changed", s.timeout))
+}
+
+// Run runs the first script matching c's path, or returns nil when there is
+// none. s may be nil.
+func (s *ScriptRunner) Run(c *Capture) *ScriptResult {
+	if s == nil {
+		return nil
+	}
+	for _, sc := range s.scripts {
+		if sc.rule.Matches(c.Path) {
+			return sc.run(c, s.timeout, s.logf)
+		}
+	}
+	return nil
+}
+
+// scriptOptions allow the statements people expect from a scripting language.
+var scriptOptions = &syntax.FileOptions{Set: true, While: true, TopLevelControl: true, GlobalReassign: true, Recursion: true}
+
+// scriptBuiltins are predeclared in every script.
+var scriptBuiltins = starlark.StringDict{
+	"json":        json.Module,
+	"sha256":      starlark.NewBuiltin("sha256", starlarkSHA256),
+	"hmac_sha256": starlark.NewBuiltin("hmac_sha256", starlarkHMACSHA256),
+}
+
+// load runs the script's top level, within timeout, and looks up handle. Its
+// globals are frozen, so anything kept between requests lives in state.
+func (sc *script) load(timeout time.Duration) error {
+	info, err := os.Stat(sc.rule.File)
+	if err != nil {
+		return fmt.Errorf("script %s: %w", sc.rule.File, err)
+	}
+	src, err := os.ReadFile(sc.rule.File)
+	if err != nil {
+		return fmt.Errorf("script %s: %w", sc.rule.File, err)
+	}
+	thread := &starlark.Thread{Name: sc.rule.File, Print: func(*starlark.Thread, string) {}}
+	thread.SetMaxExecutionSteps(scriptMaxSteps)
+	stop := time.AfterFunc(timeout, func() { thread.Cancel("timed out") })
+	globals, err := starlark.ExecFileOptions(scriptOptions, thread, sc.rule.File, src, scriptBuiltins)
+	stop.Stop()
+	if err != nil {
+		return fmt.Errorf("script %s: %w", sc.rule.File, err)
+	}
+	handle, ok := globals["handle"].(starlark.Callable)
+	if !ok {
+		return fmt.Errorf("script %s: no handle(req, state) function", sc.rule.File)
+	}
+	globals.Freeze()
+	sc.handle, sc.modTime, sc.size = handle, info.ModTime(), info.Size()
+	return nil
+}
+
+// reload loads the script again if its file changed since the last load. A
+// script that no longer loads keeps running its previous version.
+func (sc *script) reload(timeout time.Duration, logf func(string, ...any)) {
+	info, err := os.Stat(sc.rule.File)
+	if err != nil || (info.ModTime().Equal(sc.modTime) && info.Size() == sc.size) {
+		return
+	}
+	if err := sc.load(timeout); err != nil {
+		logf("%s[WARN]%s %v; still running the previous version", colorYellow, colorReset, err)
+		sc.modTime, sc.size = info.ModTime(), info.Size()
+		return
+	}
+	logf("%s[INFO]%s Reloaded script %s", colorGreen, colorReset, sc.rule.File)
+}
+
+// run calls handle for c, cancelling it after timeout.
+func (sc *script) run(c *Capture, timeout time.Duration, logf func(string, ...any)) *ScriptResult {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.reload(timeout, logf)
+
+	res := &ScriptResult{File: sc.rule.File}
+	var output bytes.Buffer
+	thread := &starlark.Thread{
+		Name: sc.rule.File,
+		Print: func(_ *starlark.Thread, msg string) {
+			if output.Len() < execOutputLimit {
+				output.WriteString(msg + "\n")
+			}
+		},
+	}
+	thread.SetMaxExecutionSteps(scriptMaxSteps)
+	var timedOut atomic.Bool
+	stop := time.AfterFunc(timeout, func() {
+		timedOut.Store(true)
+		thread.Cancel("timed out")
+	})
+	start := time.Now()
+	v, err := starlark.Call(thread, sc.handle, starlark.Tuple{scriptRequest(c), sc.state}, nil)
+	stop.Stop()
+	res.Duration = time.Since(start)
+	res.Output = output.Bytes()
+	switch {
+	case err != nil && timedOut.Load():
+		res.Err, res.TimedOut = fmt.Errorf("timed out after %s", timeout), true
+	case err != nil:
+		res.Err = err
+	default:
+		res.Err = res.decide(v)
+	}
+	return res
+}
+
+// scriptRequest describes c to a script.
+func scriptRequest(c *Capture) *starlarkstruct.Struct {
+	headers := new(starlark.Dict)
+	for k, vals := range c.Header {
+		_ = headers.SetKey(starlark.String(strings.ToLower(k)), starlark.String(strings.Join(vals, ", ")))
+	}
+	var decoded starlark.Value = starlark.None
+	if len(c.Body) > 0 {
+		thread := &starlark.Thread{Name: "json.decode"}
+		decode := json.Module.Members["decode"]
+		if v, err := starlark.Call(thread, decode, starlark.Tuple{starlark.String(c.Body)}, nil); err == nil {
+			decoded = v
+		}
+	}
+	return starlarkstruct.FromStringDict(starlark.String("request"), starlark.StringDict{
+		"id":          starlark.String(c.ID),
+		"method":      starlark.String(c.Method),
+		"path":        starlark.String(c.Path),
+		"remote_addr": starlark.String(c.RemoteAddr),
+		"headers":     headers,
+		"body":        starlark.String(c.Body),
+		"body_size":   starlark.MakeInt64(c.BodyLen()),
+		"json":        decoded,
+	})
+}
+
+// decide reads handle's return value into res.
+func (res *ScriptResult) decide(v starlark.Value) error {
+	if v == starlark.None {
+		return nil
+	}
+	d, ok := v.(*starlark.Dict)
+	if !ok {
+		return fmt.Errorf("handle returned %s, want a dict or None", v.Type())
+	}
+	var resp Response
+	answers := false
+	for _, item := range d.Items() {
+		key, _ := starlark.AsString(item[0])
+		val := item[1]
+		switch key {
+		case "status":
+			n, err := starlark.AsInt32(val)
+			if err != nil || n < 100 || n > 999 {
+				return fmt.Errorf("status %s is not an HTTP status", val)
+			}
+			resp.Status, answers = n, true
+		case "headers":
+			h, ok := val.(*starlark.Dict)
+			if !ok {
+				return fmt.Errorf("headers must be a dict, not %s", val.Type())
+			}
+			resp.Header = http.Header{}
+			for _, kv := range h.Items() {
+				name, ok1 := starlark.AsString(kv[0])
+				value, ok2 := starlark.AsString(kv[1])
+				if !ok1 || !ok2 {
+					return fmt.Errorf("header %s: names and values must be strings", kv[0])
+				}
+				resp.Header.Add(name, value)
+			}
+			answers = true
+		case "body":
+			b, ok := starlark.AsString(val)
+			if !ok {
+				return fmt.Errorf("body must be a string, not %s", val.Type())
+			}
+			resp.Body, answers = []byte(b), true
+		case "tags":
+			if s, ok := starlark.AsString(val); ok {
+				res.Tags = []string{s}
+				continue
+			}
+			l, ok := val.(*starlark.List)
+			if !ok {
+				return fmt.Errorf("tags must be a string or a list, not %s", val.Type())
+			}
+			for i := 0; i < l.Len(); i++ {
+				s, ok := starlark.AsString(l.Index(i))
+				if !ok {
+					return fmt.Errorf("tag %s is not a string", l.Index(i))
+				}
+				res.Tags = append(res.Tags, s)
+			}
+		case "quiet":
+			res.Quiet = bool(val.Truth())
+		default:
+			return fmt.Errorf("unknown key %s in handle's result", item[0])
+		}
+	}
+	if answers {
+		res.resp = &resp
+	}
+	return nil
+}
+
+func starlarkSHA256(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var data string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &data); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(data))
+	return starlark.String(hex.EncodeToString(sum[:])), nil
+}
+
+func starlarkHMACSHA256(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key, data string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &key, &data); err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(data))
+	return starlark.String(hex.EncodeToString(mac.Sum(nil))), nil
+}
+
+// writeScriptResult prints a script's outcome and output, the output redacted by r.
+func writeScriptResult(out *bytes.Buffer, res *ScriptResult, r *Redactor) {
+	switch {
+	case res.Err != nil:
+		fmt.Fprintf(out, "%sScript:%s %s %sfailed: %v%s (%s)\n", colorCyan, colorReset, res.File, colorRed, r.Text(res.Err.Error()), colorReset, res.Duration.Round(time.Millisecond))
+	case res.resp != nil:
+		status := res.resp.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		fmt.Fprintf(out, "%sScript:%s %s answered %d in %s\n", colorCyan, colorReset, res.File, status, res.Duration.Round(time.Millisecond))
+	default:
+		fmt.Fprintf(out, "%sScript:%s %s ran in %s\n", colorCyan, colorReset, res.File, res.Duration.Round(time.Millisecond))
+	}
+	writeIndented(out, res.Output, "", r)
+}
+
+// scriptHooks runs the session's scripts; nil when there are none.
+var scriptHooks *ScriptRunner
@@ -0,0 +1,267 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeScript writes src to a file in a temp dir and returns its path.
+func writeScript(t *testing.T, src string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "hook.star")
+	if err := os.WriteFile(file, []byte(src), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestParseScriptRule(t *testing.T) {
+	r, err := ParseScriptRule("/github/*=flaky.star")
+	if err != nil || r.Pattern != "/github/*" || r.File != "flaky.star" {
+		t.Fatalf("ParseScriptRule: %+v %v", r, err)
+	}
+	if !r.Matches("/github/push") || r.Matches("/stripe") {
+		t.Error("unexpected matches")
+	}
+	for _, spec := range []string{"flaky.star", "=flaky.star", "/x=", "[=flaky.star"} {
+		if _, err := ParseScriptRule(spec); err == nil {
+			t.Errorf("ParseScriptRule(%q): expected error", spec)
+		}
+	}
+}
+
+func TestNewScriptRunner(t *testing.T) {
+	if s, err := NewScriptRunner(ScriptOptions{Timeout: time.Second}); s != nil || err != nil {
+		t.Errorf("no rules should mean no runner, got %v %v", s, err)
+	}
+	noHandle := writeScript(t, "x = 1\n")
+	broken := writeScript(t, "def handle(req, state):\n  return (\n")
+	for _, o := range []ScriptOptions{
+		{Rules: []string{"bad"}},
+		{Rules: []string{"/*=" + filepath.Join(t.TempDir(), "missing.star")}},
+		{Rules: []string{"/*=" + noHandle}},
+		{Rules: []string{"/*=" + broken}},
+		{Rules: []string{"/*=" + noHandle}, Timeout: -1},
+	} {
+		if _, err := NewScriptRunner(o); err == nil {
+			t.Errorf("expected error for %+v", o)
+		}
+	}
+	file := writeScript(t, "def handle(req, state):\n  return None\n")
+	s, err := NewScriptRunner(ScriptOptions{Rules: []string{"/ci/*=" + file}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/ci/* runs " + file, "up to 1s each, reloaded when changed"}
+	if got := s.Describe(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Describe() = %q", got)
+	}
+	if res := s.Run(&Capture{Path: "/other"}); res != nil {
+		t.Errorf("no script should run for /other, got %+v", res)
+	}
+}
+
+func TestScriptRunner_StateAndResponse(t *testing.T) {
+	file := writeScript(t, `
+def handle(req, state):
+    id = req.headers["x-github-delivery"]
+    n = state.get(id, 0) + 1
+    state[id] = n
+    print("delivery", n, "of", id)
+    if n % 3 == 0:
+        return {"status": 500, "body": "flaky", "tags": ["flaky", req.json["action"]]}
+    return {"headers": {"X-Signature": hmac_sha256("whsec", req.body)}, "tags": "ok"}
+`)
+	s, err := NewScriptRunner(ScriptOptions{Rules: []string{"/gh=" + file}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := `{"action":"opened"}`
+	mac := hmac.New(sha256.New, []byte("whsec"))
+	mac.Write([]byte(body))
+	sig := hex.EncodeToString(mac.Sum(nil))
+
+	var statuses []int
+	for i := 0; i < 6; i++ {
+		id := "a"
+		if i == 5 {
+			id = "b"
+		}
+		res := s.Run(&Capture{Path: "/gh", Header: http.Header{"X-Github-Delivery": {id}}, Body: []byte(body)})
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		resp := res.Response()
+		statuses = append(statuses, resp.Status)
+		if resp.Status == 0 && (resp.Header.Get("X-Signature") != sig || !reflect.DeepEqual(res.Tags, []string{"ok"})) {
+			t.Errorf("run %d: header %v tags %v", i, resp.Header, res.Tags)
+		}
+		if resp.Status == 500 && (string(resp.Body) != "flaky" || !reflect.DeepEqual(res.Tags, []string{"flaky", "opened"})) {
+			t.Errorf("run %d: body %q tags %v", i, resp.Body, res.Tags)
+		}
+		if i == 0 && string(res.Output) != "delivery 1 of a\n" {
+			t.Errorf("print output %q", res.Output)
+		}
+	}
+	if want := []int{0, 0, 500, 0, 0, 0}; !reflect.DeepEqual(statuses, want) {
+		t.Errorf("statuses %v, want %v", statuses, want)
+	}
+}
+
+func TestScriptRunner_Results(t *testing.T) {
+	for _, tc := range []struct {
+		src    string
+		status int
+		quiet  bool
+		err    string
+	}{
+		{"return None", -1, false, ""},
+		{`return {"quiet": True, "tags": ["noise"]}`, -1, true, ""},
+		{`return {"body": sha256("abc")}`, 200, false, ""},
+		{`return "nope"`, 500, false, "want a dict or None"},
+		{`return {"status": 42}`, 500, false, "not an HTTP status"},
+		{`return {"colour": "red"}`, 500, false, "unknown key"},
+		{`return 1 // 0`, 500, false, "division by zero"},
+		{`return "x" * (1 << 30)`, 500, false, "excessive repeat"},
+		{`state["x"] = 1; x = state["missing"]`, 500, false, "missing"},
+	} {
+		file := writeScript(t, "def handle(req, state):\n    "+tc.src+"\n")
+		s, err := NewScriptRunner(ScriptOptions{Rules: []string{"/*=" + file}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := s.Run(&Capture{Path: "/"})
+		resp := res.Response()
+		switch {
+		case tc.status < 0 && resp != nil, tc.status > 0 && (resp == nil || resp.write(httptest.NewRecorder()) != tc.status):
+			t.Errorf("%s: response %+v", tc.src, resp)
+		case res.Quiet != tc.quiet:
+			t.Errorf("%s: quiet %v", tc.src, res.Quiet)
+		case tc.err == "" && res.Err != nil, tc.err != "" && (res.Err == nil || !strings.Contains(res.Err.Error(), tc.err)):
+			t.Errorf("%s: error %v, want %q", tc.src, res.Err, tc.err)
+		}
+	}
+}
+
+func TestScriptRunner_Timeout(t *testing.T) {
+	file := writeScript(t, "def handle(req, state):\n    while True:\n        pass\n")
+	s, _ := NewScriptRunner(ScriptOptions{Rules: []string{"/*=" + file}, Timeout: 50 * time.Millisecond})
+	start := time.Now()
+	res := s.Run(&Capture{Path: "/"})
+	if !res.TimedOut || res.Response().Status != 504 || time.Since(start) > 2*time.Second {
+		t.Fatalf("expected a timeout, got %+v after %s", res, time.Since(start))
+	}
+
+	// A top level that never finishes is stopped too
+	file = writeScript(t, "while True:\n    pass\n")
+	if _, err := NewScriptRunner(ScriptOptions{Rules: []string{"/*=" + file}, Timeout: 50 * time.Millisecond}); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected a load timeout, got %v", err)
+	}
+}
+
+func TestScriptRunner_Limits(t *testing.T) {
+	steps := scriptMaxSteps
+	defer func() { scriptMaxSteps = steps }()
+	scriptMaxSteps = 10000
+
+	file := writeScript(t, "def handle(req, state):\n    for i in range(1000000):\n        pass\n")
+	s, err := NewScriptRunner(ScriptOptions{Rules: []string{"/*=" + file}, Timeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := s.Run(&Capture{Path: "/"})
+	if res.Err == nil || !strings.Contains(res.Err.Error(), "too many steps") || res.Response().Status != 500 {
+		t.Errorf("expected too many steps, got %v", res.Err)
+	}
+}
+
+func TestScriptRunner_Reload(t *testing.T) {
+	file := writeScript(t, "def handle(req, state):\n    state['n'] = state.get('n', 0) + 1\n    return {'body': 'v1 %d' % state['n']}\n")
+	var logs strings.Builder
+	logf := func(format string, args ...any) { fmt.Fprintf(&logs, format+"\n", args...) }
+	s, _ := NewScriptRunner(ScriptOptions{Rules: []string{"/*=" + file}, Logf: logf})
+	run := func() string {
+		t.Helper()
+		res := s.Run(&Capture{Path: "/"})
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		return string(res.Response().Body)
+	}
+	if got := run(); got != "v1 1" {
+		t.Fatalf("first run: %q", got)
+	}
+	// Changes are picked up and the state is kept
+	rewrite := func(src string, age time.Duration) {
+		_ = os.WriteFile(file, []byte(src), 0600)
+		mtime := time.Now().Add(age)
+		_ = os.Chtimes(file, mtime, mtime)
+	}
+	rewrite("def handle(req, state):\n    state['n'] += 1\n    return {'body': 'v2 %d' % state['n']}\n", time.Second)
+	if got := run(); got != "v2 2" {
+		t.Errorf("after a change: %q", got)
+	}
+	// A broken version is reported and the previous one keeps running
+	rewrite("def handle(req, state):\n    return (\n", 2*time.Second)
+	if got := run(); got != "v2 3" {
+		t.Errorf("after a broken change: %q", got)
+	}
+	if got := run(); got != "v2 4" {
+		t.Errorf("broken versions should only be reported once: %q", got)
+	}
+	got := stripANSI(logs.String())
+	if !strings.Contains(got, "Reloaded script "+file) || strings.Count(got, "still running the previous version") != 1 {
+		t.Errorf("reloads should be reported through Logf:\n%s", got)
+	}
+}
+
+func TestWebhookHandler_Script(t *testing.T) {
+	file := writeScript(t, `
+def handle(req, state):
+    if req.path == "/quiet":
+        return {"quiet": True}
+    return {"status": 202, "body": "scripted", "tags": ["ci", "push"]}
+`)
+	s, err := NewScriptRunner(ScriptOptions{Rules: []string{"/*=" + file}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scriptHooks = s
+	defer func() { scriptHooks = nil }()
+
+	w := httptest.NewRecorder()
+	out := stripANSI(captureStdout(func() {
+		WebhookHandler(w, httptest.NewRequest("POST", "/build", strings.NewReader("{}")))
+	}))
+	if w.Code != 202 || w.Body.String() != "scripted" {
+		t.Fatalf("status %d body %q", w.Code, w.Body.String())
+	}
+	if !strings.Contains(out, "[ci, push] ---") || !strings.Contains(out, "Script: "+file+" answered 202") {
+		t.Errorf("the console block should carry the tags and the script's outcome:\n%s", out)
+	}
+
+	w = httptest.NewRecorder()
+	out = captureStdout(func() {
+		WebhookHandler(w, httptest.NewRequest("POST", "/quiet", nil))
+	})
+	if w.Code != 200 || w.Body.String() != "ok" || out != "" {
+		t.Errorf("a quiet capture should be answered as usual and not printed: %d %q\n%s", w.Code, w.Body.String(), out)
+	}
+}
+
+func TestRun_ScriptError(t *testing.T) {
+	isolateEnv(t)
+	if err := Run(Options{Script: ScriptOptions{Rules: []string{"nopattern"}}}); err == nil || !strings.Contains(err.Error(), "script error") {
+		t.Fatalf("expected script error, got %v", err)
+	}
+}
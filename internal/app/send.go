@@ -0,0 +1,152 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// sendUsage is printed for `send` without a fixture.
+const sendUsage = `usage: webhook-catcher send [-secret S] [-fixtures DIR] [-header "Name: value"] [-retry POLICY] [-dry-run] FIXTURE [URL]
+       webhook-catcher send -list
+
+URL defaults to http://localhost:8080/. The request is signed with -secret or the
+stored <provider>-signing-secret, e.g. "stripe-signing-secret"; without either it
+is sent unsigned.`
+
+// DefaultSendURL is where `send` delivers when no URL is given.
+const DefaultSendURL = "http://localhost:8080/"
+
+// sendClient delivers fixtures. Overridable in tests.
+var sendClient = &http.Client{Timeout: 30 * time.Second}
+
+// sendPreview caps how much of the receiver's response is printed.
+const sendPreview = 2048
+
+// SendCommand implements `send`: deliver a fixture webhook to a handler.
+func SendCommand(args []string) error {
+	return sendCommand(args, os.Stdout)
+}
+
+func sendCommand(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	fs.SetOutput(out)
+	secret := fs.String("secret", "", "signing secret (default: the stored <provider>-signing-secret)")
+	var dirs, headers ListFlag
+	fs.Var(&dirs, "fixtures", "directory of user fixtures (repeatable)")
+	fs.Var(&headers, "header", `extra "Name: value" header (repeatable)`)
+	list := fs.Bool("list", false, "list available fixtures")
+	dryRun := fs.Bool("dry-run", false, "print the request instead of sending it")
+	retry := fs.String("retry", "none", "retry policy: "+retryPolicyUsage)
+	retryScale := fs.Float64("retry-scale", 1, "multiply retry delays, e.g. 0.001 to replay a day-long schedule in seconds")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if dir, err := DefaultFixtureDir(); err == nil {
+		if _, err := os.Stat(dir); err == nil {
+			dirs = append(ListFlag{dir}, dirs...)
+		}
+	}
+	fixtures, err := LoadFixtures(dirs...)
+	if err != nil {
+		return err
+	}
+	if *list {
+		for _, name := range FixtureNames(fixtures) {
+			f := fixtures[name]
+			line := fmt.Sprintf("  %-36s %s", name, f.Description)
+			if f.Source != "built-in" {
+				line += " (" + f.Source + ")"
+			}
+			fmt.Fprintln(out, strings.TrimRight(line, " "))
+		}
+		return nil
+	}
+	policy, err := ParseRetryPolicy(*retry)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return errors.New(sendUsage)
+	}
+	f, ok := fixtures[fs.Arg(0)]
+	if !ok {
+		return fmt.Errorf("unknown fixture %q; see `webhook-catcher send -list`", fs.Arg(0))
+	}
+	target := DefaultSendURL
+	if fs.NArg() == 2 {
+		target = fs.Arg(1)
+	}
+
+	r := FixtureReplayer(f, SigningSecret(f.Provider, *secret))
+	r.Header = http.Header{}
+	for _, kv := range headers {
+		name, value, ok := strings.Cut(kv, ":")
+		if !ok {
+			return fmt.Errorf("header %q: want Name: value", kv)
+		}
+		r.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	p, err := r.Next(time.Now())
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		fmt.Fprintf(out, "%s %s\n", p.Method, target)
+		names := make([]string, 0, len(p.Header))
+		for name := range p.Header {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(out, "%s: %s\n", name, strings.Join(p.Header[name], ", "))
+		}
+		fmt.Fprintf(out, "\n%s\n", p.Body)
+		return nil
+	}
+
+	signed := "unsigned"
+	if p.Signed {
+		signed = "signed"
+	}
+	fmt.Fprintf(out, "Sending %s (%s, %d bytes) to %s\n", f.Name, signed, len(p.Body), target)
+	return deliver(out, r, p, target, policy, *retryScale)
+}
+
+// deliver sends p with retries and prints the attempt timeline. It fails unless an
+// attempt got a 2xx answer.
+func deliver(out io.Writer, r *Replayer, p *Replay, target string, policy RetryPolicy, scale float64) error {
+	if len(policy.Delays) > 0 {
+		id := DeliveryID(p)
+		if id == "" {
+			id = "none"
+		}
+		fmt.Fprintf(out, "Retry policy %s: up to %d retries, delivery ID %s\n", policy.Name, len(policy.Delays), id)
+	}
+	ctx, stop := NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	d := &Deliverer{Client: sendClient, Policy: policy, Scale: scale, Out: out}
+	dl, err := d.Deliver(ctx, r, p, target)
+	if err != nil {
+		return err
+	}
+	printDelivery(out, dl)
+	if !dl.Delivered {
+		last := dl.Attempts[len(dl.Attempts)-1]
+		if last.Err != nil {
+			return fmt.Errorf("send %s: %w", p.Name, last.Err)
+		}
+		return fmt.Errorf("%s answered %d %s", target, last.Status, http.StatusText(last.Status))
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadFixtures_Builtin(t *testing.T) {
+	fixtures, err := LoadFixtures()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	for _, name := range []string{"github/push", "github/pull_request", "stripe/checkout.session.completed", "stripe/invoice.paid", "slack/event_callback", "shopify/orders/create"} {
+		f, ok := fixtures[name]
+		if !ok {
+			t.Fatalf("missing built-in fixture %s", name)
+		}
+		h, body, err := f.Render("s", time.Now())
+		if err != nil {
+			t.Fatalf("%s: render: %v", name, err)
+		}
+		if !json.Valid(body) || strings.Contains(string(body), "{{") {
+			t.Fatalf("%s: rendered body is not clean JSON: %s", name, body)
+		}
+		if err := VerifyWebhook(f.Provider, "s", h, body, time.Now()); err != nil {
+			t.Fatalf("%s: signature does not verify: %v", name, err)
+		}
+	}
+	h, _, _ := fixtures["github/push"].Render("", time.Now())
+	if h.Get("X-GitHub-Event") != "push" || len(h.Get("X-GitHub-Delivery")) != 36 || h.Get("X-Hub-Signature-256") != "" {
+		t.Fatalf("unexpected unsigned github headers: %v", h)
+	}
+	h, _, _ = fixtures["shopify/orders/create"].Render("", time.Now())
+	if h.Get("X-Shopify-Topic") != "orders/create" {
+		t.Fatalf("unexpected shopify headers: %v", h)
+	}
+}
+
+func TestLoadFixtures_UserDirOverrides(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.MkdirAll(filepath.Join(dir, "github"), 0700)
+	_ = os.MkdirAll(filepath.Join(dir, "acme"), 0700)
+	_ = os.WriteFile(filepath.Join(dir, "github", "push.json"), []byte(`{"provider":"github","body":{"ref":"refs/heads/dev"}}`), 0600)
+	_ = os.WriteFile(filepath.Join(dir, "acme", "order.json"), []byte(`{"method":"PUT","body":{"at":"{{now}}"}}`), 0600)
+	_ = os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a fixture"), 0600)
+	fixtures, err := LoadFixtures(dir)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if f := fixtures["github/push"]; !strings.Contains(string(f.Body), "refs/heads/dev") || f.Source != filepath.Join(dir, "github", "push.json") {
+		t.Fatalf("expected user fixture to replace the built-in one, got %+v", f)
+	}
+	now := time.Date(2024, 6, 10, 16, 0, 0, 0, time.UTC)
+	_, body, err := fixtures["acme/order"].Render("", now)
+	if err != nil || string(body) != `{"at":"2024-06-10T16:00:00Z"}` {
+		t.Fatalf("unexpected render %s err=%v", body, err)
+	}
+	if _, _, err := fixtures["acme/order"].Render("s", now); err == nil {
+		t.Fatal("expected signing a provider-less fixture to fail")
+	}
+
+	_ = os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{"body":`), 0600)
+	if _, err := LoadFixtures(dir); err == nil || !strings.Contains(err.Error(), "broken.json") {
+		t.Fatalf("expected error naming the broken fixture, got %v", err)
+	}
+	if _, err := LoadFixtures(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("expected error for a missing fixture dir")
+	}
+}
+
+func TestSendCommand_SignsWithStoredSecret(t *testing.T) {
+	isolateCredentials(t)
+	_ = KeyringStore{Service: credentialService}.Set(WebhookSecretName("stripe"), "whsec_stored")
+	var got *http.Request
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = io.ReadAll(r.Body)
+		_, _ = io.WriteString(w, "received")
+	}))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	if err := sendCommand([]string{"-header", "X-Test: 1", "stripe/invoice.paid", srv.URL + "/hooks/stripe"}, &out); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if got.Method != http.MethodPost || got.URL.Path != "/hooks/stripe" || got.Header.Get("X-Test") != "1" {
+		t.Fatalf("unexpected request %s %s %v", got.Method, got.URL, got.Header)
+	}
+	if err := VerifyWebhook("stripe", "whsec_stored", got.Header, gotBody, time.Now()); err != nil {
+		t.Fatalf("signature does not verify: %v", err)
+	}
+	for _, want := range []string{"Sending stripe/invoice.paid (signed,", "200 OK in", "received"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected output to contain %q, got: %s", want, out.String())
+		}
+	}
+}
+
+func TestSendCommand_ErrorsAndModes(t *testing.T) {
+	isolateCredentials(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	if err := sendCommand([]string{"github/push", srv.URL}, &out); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("expected non-2xx to be an error, got %v", err)
+	}
+	if !strings.Contains(out.String(), "(unsigned,") || !strings.Contains(out.String(), "bad signature") {
+		t.Fatalf("expected unsigned send and response body in output, got: %s", out.String())
+	}
+
+	out.Reset()
+	if err := sendCommand([]string{"-dry-run", "-secret", "s", "slack/event_callback", "http://x.example/slack"}, &out); err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	for _, want := range []string{"POST http://x.example/slack\n", "X-Slack-Signature: v0=", `"type":"event_callback"`} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected dry run to contain %q, got: %s", want, out.String())
+		}
+	}
+
+	// User fixtures in the config dir are picked up without -fixtures
+	dir, _ := DefaultFixtureDir()
+	_ = os.MkdirAll(filepath.Join(dir, "acme"), 0700)
+	_ = os.WriteFile(filepath.Join(dir, "acme", "ping.json"), []byte(`{"description":"Acme ping","body":{}}`), 0600)
+	out.Reset()
+	if err := sendCommand([]string{"-list"}, &out); err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if !strings.Contains(out.String(), "acme/ping") || !strings.Contains(out.String(), "Acme ping ("+filepath.Join(dir, "acme", "ping.json")+")") || !strings.Contains(out.String(), "shopify/orders/create") {
+		t.Fatalf("unexpected list: %s", out.String())
+	}
+
+	for args, want := range map[string]string{
+		"":                        "usage:",
+		"nope/nothing":            "unknown fixture",
+		"-header bad github/push": "want Name: value",
+	} {
+		if err := sendCommand(strings.Fields(args), io.Discard); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("send %q: expected error containing %q, got %v", args, want, err)
+		}
+	}
+}
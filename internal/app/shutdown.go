@@ -0,0 +1,129 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os/signal"
+	"sync"
+	"time"
+
+	"golang.ngrok.com/ngrok"
+)
+
+// DefaultDrainTimeout bounds how long in-flight requests may run after Ctrl+C.
+const DefaultDrainTimeout = 5 * time.Second
+
+// NotifyContext returns a context cancelled on the given signals. Overridable in tests.
+var NotifyContext = signal.NotifyContext
+
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// serverGroup tracks running servers so Run can shut them all down together.
+type serverGroup struct {
+	mu       sync.Mutex
+	servers  []shutdowner
+	shutdown bool
+}
+
+var activeServers serverGroup
+
+// add registers s. Servers added after shutdown began are shut down immediately,
+// which makes their Serve/ListenAndServe return http.ErrServerClosed.
+func (g *serverGroup) add(s shutdowner) {
+	g.mu.Lock()
+	late := g.shutdown
+	if !late {
+		g.servers = append(g.servers, s)
+	}
+	g.mu.Unlock()
+	if late {
+		_ = s.Shutdown(context.Background())
+	}
+}
+
+// shutdownAll gracefully stops every tracked server and resets the group.
+func (g *serverGroup) shutdownAll(ctx context.Context) error {
+	g.mu.Lock()
+	servers := g.servers
+	g.servers = nil
+	g.shutdown = true
+	g.mu.Unlock()
+
+	var errs []error
+	for _, s := range servers {
+		if err := s.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// reset clears the group so a new Run can register servers again.
+func (g *serverGroup) reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.servers = nil
+	g.shutdown = false
+}
+
+// closerFunc adapts servers that only have Close (such as HTTP/3) to shutdowner.
+type closerFunc func() error
+
+func (f closerFunc) Shutdown(context.Context) error { return f() }
+
+// listenAndServe is the default HTTPListenAndServe: http.ListenAndServe on a tracked server.
+func listenAndServe(addr string, h http.Handler) error {
+	srv := &http.Server{Addr: addr, Handler: h}
+	activeServers.add(srv)
+	return srv.ListenAndServe()
+}
+
+// serve is the default HTTPServe: http.Serve on a tracked server. When l is an ngrok
+// tunnel, its session is closed once serving stops so it does not linger.
+func serve(l net.Listener, h http.Handler) error {
+	srv := &http.Server{Handler: h}
+	activeServers.add(srv)
+	err := srv.Serve(l)
+	if t, ok := l.(interface{ Session() ngrok.Session }); ok {
+		_ = t.Session().Close()
+	}
+	return err
+}
+
+// runServers runs serveFn until it returns or ctx is cancelled. On cancellation every
+// tracked server is shut down, waiting up to drain for in-flight requests. It reports
+// whether the stop was requested and treats http.ErrServerClosed as a clean exit.
+func runServers(ctx context.Context, drain time.Duration, serveFn func() error) (stopped bool, err error) {
+	activeServers.reset()
+	errCh := make(chan error, 1)
+	go func() { errCh <- serveFn() }()
+
+	select {
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			err = nil
+		}
+		return false, err
+	case <-ctx.Done():
+	}
+
+	if drain <= 0 {
+		drain = DefaultDrainTimeout
+	}
+	log.Printf("%s[INFO]%s Shutting down, waiting up to %s for in-flight requests...", colorGreen, colorReset, drain)
+	sctx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	if err := activeServers.shutdownAll(sctx); err != nil {
+		log.Printf("%s[WARN]%s shutdown: %v", colorYellow, colorReset, err)
+	}
+	select {
+	case <-errCh:
+	case <-sctx.Done():
+	}
+	return true, nil
+}
@@ -0,0 +1,134 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStats_Summary(t *testing.T) {
+	s := NewStats()
+	if out := stripANSI(s.Summary()); !strings.Contains(out, "Requests: 0") {
+		t.Fatalf("expected empty summary, got: %s", out)
+	}
+	t0 := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.Record("/b", 200, 10, t0.Add(time.Minute))
+	s.Record("/a", 200, 5, t0)
+	s.Record("/a", 400, 0, t0.Add(2*time.Minute))
+
+	out := stripANSI(s.Summary())
+	for _, want := range []string{
+		"Requests: 3", "Bytes received: 15",
+		"First: 2026-01-02 03:04:05", "Last: 2026-01-02 03:06:05",
+		"/a: 2", "/b: 1", "200: 2", "400: 1",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected summary to contain %q, got: %s", want, out)
+		}
+	}
+}
+
+func TestStore_AddAndClose(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "captures")
+	store, err := OpenStore(dir)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	if err := store.Add(&Capture{ID: "abc", Method: "POST", Path: "/x", Body: []byte("hi")}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	// Written through, so readers and a process killed before Close see it
+	if captures, err := ReadCaptures(dir); err != nil || len(captures) != 1 || captures[0].ID != "abc" {
+		t.Fatalf("captures before close = %+v, %v", captures, err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	b, _ := os.ReadFile(filepath.Join(dir, CaptureFile))
+	var c Capture
+	if err := json.Unmarshal(b, &c); err != nil || c.ID != "abc" || string(c.Body) != "hi" {
+		t.Fatalf("unexpected stored capture %q err=%v", b, err)
+	}
+}
+
+func TestRun_GracefulShutdown(t *testing.T) {
+	origNotify, origLocal := NotifyContext, ServeLocalFunc
+	defer func() { NotifyContext, ServeLocalFunc = origNotify, origLocal }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	NotifyContext = func(parent context.Context, _ ...os.Signal) (context.Context, context.CancelFunc) {
+		return ctx, cancel
+	}
+	addrCh := make(chan string, 1)
+	ServeLocalFunc = func(_ string, mux http.Handler) error {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		addrCh <- ln.Addr().String()
+		return HTTPServe(ln, mux)
+	}
+
+	dir := t.TempDir()
+	done := make(chan error, 1)
+	out := captureStdout(func() {
+		go func() { done <- Run(Options{Host: "127.0.0.1", Port: 1, CaptureDir: dir, DrainTimeout: time.Second}) }()
+		addr := <-addrCh
+		resp, err := http.Post("http://"+addr+"/orders", "application/json", strings.NewReader(`{"id":1}`))
+		if err != nil {
+			t.Errorf("post: %v", err)
+		} else {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+		cancel()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("expected clean shutdown, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("Run did not return after shutdown")
+		}
+	})
+	out = stripANSI(out)
+
+	for _, want := range []string{"Shutting down", "SESSION SUMMARY", "Requests: 1", "/orders: 1", "200: 1", "Bytes received: 8", "Captures saved to"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected output to contain %q, got: %s", want, out)
+		}
+	}
+
+	f, err := os.Open(filepath.Join(dir, CaptureFile))
+	if err != nil {
+		t.Fatalf("open captures: %v", err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	if !sc.Scan() {
+		t.Fatalf("expected one stored capture")
+	}
+	var c Capture
+	if err := json.Unmarshal(sc.Bytes(), &c); err != nil || c.Path != "/orders" || c.ID == "" {
+		t.Fatalf("unexpected stored capture %s err=%v", sc.Bytes(), err)
+	}
+}
+
+func TestServerGroup_LateAddIsShutDown(t *testing.T) {
+	var g serverGroup
+	_ = g.shutdownAll(context.Background())
+	srv := &http.Server{}
+	g.add(srv)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		t.Fatalf("expected server added after shutdown to be closed, got %v", err)
+	}
+}
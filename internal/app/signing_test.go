@@ -0,0 +1,94 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestSignVerify_RoundTrip(t *testing.T) {
+	now := time.Unix(1718035200, 0)
+	body := []byte(`{"id":"evt_1"}`)
+	for _, provider := range SigningProviders() {
+		h, err := SignWebhook(provider, "s3cret", body, now)
+		if err != nil {
+			t.Fatalf("%s: sign: %v", provider, err)
+		}
+		if err := VerifyWebhook(provider, "s3cret", h, body, now.Add(time.Minute)); err != nil {
+			t.Fatalf("%s: verify: %v", provider, err)
+		}
+		if err := VerifyWebhook(provider, "other", h, body, now); !errors.Is(err, ErrSignatureMismatch) {
+			t.Fatalf("%s: expected mismatch for wrong secret, got %v", provider, err)
+		}
+		if err := VerifyWebhook(provider, "s3cret", h, []byte(`{"id":"evt_2"}`), now); !errors.Is(err, ErrSignatureMismatch) {
+			t.Fatalf("%s: expected mismatch for tampered body, got %v", provider, err)
+		}
+		if err := VerifyWebhook(provider, "s3cret", http.Header{}, body, now); !errors.Is(err, ErrSignatureMissing) {
+			t.Fatalf("%s: expected missing signature, got %v", provider, err)
+		}
+		if err := VerifyWebhookReader(provider, "s3cret", h, iotest.ErrReader(errors.New("disk gone")), now); err == nil || errors.Is(err, ErrSignatureMismatch) {
+			t.Fatalf("%s: a body that cannot be read should be reported, got %v", provider, err)
+		}
+	}
+}
+
+func TestSignWebhook_KnownVectors(t *testing.T) {
+	now := time.Unix(1718035200, 0)
+	body := []byte("Hello, World!")
+	// Values computed independently with openssl dgst -sha256 -hmac
+	cases := []struct{ provider, header, want string }{
+		{"github", "X-Hub-Signature-256", "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"},
+		{"shopify", "X-Shopify-Hmac-Sha256", "dXEH6g6yUJ/CESIczphLijdXC211hsIsRvQ3nIsEPhc="},
+	}
+	for _, c := range cases {
+		h, _ := SignWebhook(c.provider, "It's a Secret to Everybody", body, now)
+		if got := h.Get(c.header); got != c.want {
+			t.Fatalf("%s: %s = %q, want %q", c.provider, c.header, got, c.want)
+		}
+	}
+}
+
+func TestVerifyWebhook_Timestamps(t *testing.T) {
+	now := time.Unix(1718035200, 0)
+	body := []byte(`{}`)
+	for _, provider := range []string{"stripe", "slack"} {
+		h, _ := SignWebhook(provider, "s", body, now)
+		if err := VerifyWebhook(provider, "s", h, body, now.Add(10*time.Minute)); !errors.Is(err, ErrSignatureExpired) {
+			t.Fatalf("%s: expected expired signature, got %v", provider, err)
+		}
+	}
+	// Stripe sends one v1 per active secret while rolling
+	h, _ := SignWebhook("stripe", "new", body, now)
+	h.Set("Stripe-Signature", h.Get("Stripe-Signature")+",v1=00ff")
+	if err := VerifyWebhook("stripe", "new", h, body, now); err != nil {
+		t.Fatalf("expected extra v1 entries to be tolerated, got %v", err)
+	}
+	if _, err := SignWebhook("gitlab", "s", body, now); err == nil {
+		t.Fatal("expected unknown provider error")
+	}
+}
+
+func TestDetectProvider(t *testing.T) {
+	for header, want := range map[string]string{
+		"X-GitHub-Event":        "github",
+		"Stripe-Signature":      "stripe",
+		"X-Slack-Signature":     "slack",
+		"X-Shopify-Hmac-Sha256": "shopify",
+		"X-Other":               "",
+	} {
+		h := http.Header{}
+		h.Set(header, "x")
+		if got := DetectProvider(h); got != want {
+			t.Errorf("DetectProvider(%s) = %q, want %q", header, got, want)
+		}
+	}
+	// Headers of several providers always resolve the same way
+	h := http.Header{"Stripe-Signature": {"x"}, "X-Shopify-Topic": {"x"}, "X-Hub-Signature-256": {"x"}}
+	for i := 0; i < 20; i++ {
+		if got := DetectProvider(h); got != "github" {
+			t.Fatalf("DetectProvider = %q, want github", got)
+		}
+	}
+}
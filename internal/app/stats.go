@@ -0,0 +1,120 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stats accumulates per-session request counters for the exit summary.
+type Stats struct {
+	mu       sync.Mutex
+	total    int
+	bytes    int64
+	byPath   map[string]int
+	byStatus map[int]int
+	first    time.Time
+	last     time.Time
+	// Requests turned away by the guard or rate limiter, by reason
+	rejected map[string]int
+}
+
+// NewStats returns empty session counters.
+func NewStats() *Stats {
+	return &Stats{byPath: map[string]int{}, byStatus: map[int]int{}, rejected: map[string]int{}}
+}
+
+// Record counts one request.
+func (s *Stats) Record(path string, status int, bodyBytes int, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.total++
+	s.bytes += int64(bodyBytes)
+	s.byPath[path]++
+	s.byStatus[status]++
+	if s.first.IsZero() || at.Before(s.first) {
+		s.first = at
+	}
+	if at.After(s.last) {
+		s.last = at
+	}
+}
+
+// Reject counts a request turned away before capture (guard, rate limit).
+// Rejected requests are not part of the other counters.
+func (s *Stats) Reject(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rejected[reason]++
+}
+
+// Rejected returns the number of requests turned away before capture.
+func (s *Stats) Rejected() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, c := range s.rejected {
+		n += c
+	}
+	return n
+}
+
+// Total returns the number of recorded requests.
+func (s *Stats) Total() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.total
+}
+
+// Summary renders the counters as a console block.
+func (s *Stats) Summary() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "\n%s--- SESSION SUMMARY ---%s\n\n", colorBold, colorReset)
+	fmt.Fprintf(&out, "%sRequests:%s %d\n", colorCyan, colorReset, s.total)
+	fmt.Fprintf(&out, "%sBytes received:%s %d\n", colorCyan, colorReset, s.bytes)
+	if len(s.rejected) > 0 {
+		reasons := make([]string, 0, len(s.rejected))
+		n := 0
+		for r, c := range s.rejected {
+			reasons = append(reasons, fmt.Sprintf("%s: %d", r, c))
+			n += c
+		}
+		sort.Strings(reasons)
+		fmt.Fprintf(&out, "%sRejected:%s %d (%s)\n", colorCyan, colorReset, n, strings.Join(reasons, ", "))
+	}
+	if s.total == 0 {
+		out.WriteString(strings.Repeat("-", 50) + "\n")
+		return out.String()
+	}
+	fmt.Fprintf(&out, "%sFirst:%s %s\n", colorCyan, colorReset, s.first.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(&out, "%sLast:%s %s\n", colorCyan, colorReset, s.last.Format("2006-01-02 15:04:05"))
+
+	out.WriteString("\nBy path:\n")
+	paths := make([]string, 0, len(s.byPath))
+	for p := range s.byPath {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		fmt.Fprintf(&out, "  %s%s%s: %d\n", colorYellow, p, colorReset, s.byPath[p])
+	}
+
+	out.WriteString("\nBy status:\n")
+	codes := make([]int, 0, len(s.byStatus))
+	for c := range s.byStatus {
+		codes = append(codes, c)
+	}
+	sort.Ints(codes)
+	for _, c := range codes {
+		fmt.Fprintf(&out, "  %s%d%s: %d\n", colorBlue, c, colorReset, s.byStatus[c])
+	}
+
+	out.WriteString(strings.Repeat("-", 50) + "\n")
+	return out.String()
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CaptureFile is the file name captures are appended to inside a capture directory.
+const CaptureFile = "captures.jsonl"
+
+// Store appends captures as JSON lines to CaptureFile in a directory. Each capture
+// is written to the file as it is added, so readers and a killed process see
+// every line; Flush or Close also syncs the file to disk.
+type Store struct {
+	Dir string
+
+	mu sync.Mutex
+	f  *os.File
+	w  *bufio.Writer
+}
+
+// OpenStore creates dir if needed and opens its capture file for appending.
+func OpenStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create capture dir: %w", err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, CaptureFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open capture file: %w", err)
+	}
+	return &Store{Dir: dir, f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// Add appends a capture to the store. A spooled body inside the store's directory
+// is recorded relative to it so the directory can be moved.
+func (s *Store) Add(c *Capture) error {
+	if rel, err := filepath.Rel(s.Dir, c.BodyFile); c.BodyFile != "" && err == nil && filepath.IsLocal(rel) {
+		cp := *c
+		cp.BodyFile = rel
+		c = &cp
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+// Flush writes buffered captures to the file and syncs it to disk.
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+// Close flushes and closes the capture file.
+func (s *Store) Close() error {
+	if err := s.Flush(); err != nil {
+		_ = s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
+
+// ReadCaptures loads every capture stored in dir, oldest first. Spooled bodies are
+// read back into Body.
+func ReadCaptures(dir string) ([]*Capture, error) {
+	f, err := os.Open(filepath.Join(dir, CaptureFile))
+	if err != nil {
+		return nil, fmt.Errorf("open capture file: %w", err)
+	}
+	defer f.Close()
+	var captures []*Capture
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		c := &Capture{}
+		if err := dec.Decode(c); err != nil {
+			return captures, fmt.Errorf("read capture %d: %w", len(captures)+1, err)
+		}
+		if c.BodyFile != "" {
+			if !filepath.IsAbs(c.BodyFile) {
+				c.BodyFile = filepath.Join(dir, c.BodyFile)
+			}
+			if c.Body, err = os.ReadFile(c.BodyFile); err != nil {
+				return captures, fmt.Errorf("read body of capture %s: %w", c.ID, err)
+			}
+		}
+		captures = append(captures, c)
+	}
+	return captures, nil
+}
@@ -0,0 +1,165 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// TLSInfo describes the negotiated TLS session of a captured request.
+type TLSInfo struct {
+	Version          string     `json:"version"`
+	CipherSuite      string     `json:"cipher_suite"`
+	ALPN             string     `json:"alpn,omitempty"`
+	ServerName       string     `json:"server_name,omitempty"`
+	PeerCertificates []CertInfo `json:"peer_certificates,omitempty"`
+}
+
+// CertInfo is the printable subset of an x509 certificate presented by a client.
+type CertInfo struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	SANs        []string  `json:"sans,omitempty"`
+	Serial      string    `json:"serial"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	Fingerprint string    `json:"sha256_fingerprint"`
+}
+
+// NewTLSInfo extracts TLSInfo from a connection state. Returns nil for plaintext requests.
+func NewTLSInfo(cs *tls.ConnectionState) *TLSInfo {
+	if cs == nil {
+		return nil
+	}
+	info := &TLSInfo{
+		Version:     tls.VersionName(cs.Version),
+		CipherSuite: tls.CipherSuiteName(cs.CipherSuite),
+		ALPN:        cs.NegotiatedProtocol,
+		ServerName:  cs.ServerName,
+	}
+	for _, c := range cs.PeerCertificates {
+		info.PeerCertificates = append(info.PeerCertificates, NewCertInfo(c))
+	}
+	return info
+}
+
+// NewCertInfo summarizes a certificate for display and storage.
+func NewCertInfo(c *x509.Certificate) CertInfo {
+	var sans []string
+	sans = append(sans, c.DNSNames...)
+	for _, ip := range c.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, c.EmailAddresses...)
+	for _, u := range c.URIs {
+		sans = append(sans, u.String())
+	}
+	sum := sha256.Sum256(c.Raw)
+	return CertInfo{
+		Subject:     c.Subject.String(),
+		Issuer:      c.Issuer.String(),
+		SANs:        sans,
+		Serial:      c.SerialNumber.String(),
+		NotBefore:   c.NotBefore,
+		NotAfter:    c.NotAfter,
+		Fingerprint: FormatFingerprint(sum[:]),
+	}
+}
+
+// FormatFingerprint renders a digest as colon-separated upper-case hex.
+func FormatFingerprint(sum []byte) string {
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// BuildTLSConfig loads the server key pair and, when configured, the client CA pool.
+//
+// clientAuth is one of "none", "request", "require", "verify-if-given" or
+// "require-and-verify". When empty it defaults to "verify-if-given" if a client CA
+// is configured and to "request" otherwise, so any presented certificate can be inspected.
+func BuildTLSConfig(certFile, keyFile, clientCAFile, clientAuth string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load TLS key pair: %w", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", clientCAFile)
+		}
+		cfg.ClientCAs = pool
+	}
+
+	mode := strings.ToLower(strings.TrimSpace(clientAuth))
+	if mode == "" {
+		mode = "request"
+		if cfg.ClientCAs != nil {
+			mode = "verify-if-given"
+		}
+	}
+	switch mode {
+	case "none":
+		cfg.ClientAuth = tls.NoClientCert
+	case "request":
+		cfg.ClientAuth = tls.RequestClientCert
+	case "require":
+		cfg.ClientAuth = tls.RequireAnyClientCert
+	case "verify-if-given":
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require-and-verify":
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unknown TLS client auth mode %q", clientAuth)
+	}
+	if cfg.ClientCAs == nil && (cfg.ClientAuth == tls.VerifyClientCertIfGiven || cfg.ClientAuth == tls.RequireAndVerifyClientCert) {
+		return nil, fmt.Errorf("TLS client auth mode %q requires a client CA", mode)
+	}
+	return cfg, nil
+}
+
+// writeTLSInfo appends the TLS section of a capture to out.
+func writeTLSInfo(out *bytes.Buffer, info *TLSInfo) {
+	out.WriteString("TLS:\n")
+	fmt.Fprintf(out, "  %sVersion%s: %s\n", colorBlue, colorReset, info.Version)
+	fmt.Fprintf(out, "  %sCipher%s: %s\n", colorBlue, colorReset, info.CipherSuite)
+	if info.ALPN != "" {
+		fmt.Fprintf(out, "  %sALPN%s: %s\n", colorBlue, colorReset, info.ALPN)
+	}
+	if info.ServerName != "" {
+		fmt.Fprintf(out, "  %sSNI%s: %s\n", colorBlue, colorReset, info.ServerName)
+	}
+	if len(info.PeerCertificates) == 0 {
+		out.WriteString("  Client certificate: <none>\n\n")
+		return
+	}
+	out.WriteString("  Client certificate chain:\n")
+	for i, c := range info.PeerCertificates {
+		fmt.Fprintf(out, "    [%d] %sSubject%s: %s\n", i, colorBlue, colorReset, c.Subject)
+		fmt.Fprintf(out, "        %sIssuer%s: %s\n", colorBlue, colorReset, c.Issuer)
+		if len(c.SANs) > 0 {
+			fmt.Fprintf(out, "        %sSANs%s: %s\n", colorBlue, colorReset, strings.Join(c.SANs, ", "))
+		}
+		fmt.Fprintf(out, "        %sSerial%s: %s\n", colorBlue, colorReset, c.Serial)
+		fmt.Fprintf(out, "        %sValid%s: %s to %s\n", colorBlue, colorReset,
+			c.NotBefore.UTC().Format(time.RFC3339), c.NotAfter.UTC().Format(time.RFC3339))
+		fmt.Fprintf(out, "        %sSHA-256%s: %s\n", colorBlue, colorReset, c.Fingerprint)
+	}
+	out.WriteString("\n")
+}
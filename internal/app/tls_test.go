@@ -0,0 +1,186 @@
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert creates a certificate signed by parent (self-signed when parent is nil).
+func newTestCert(t *testing.T, cn string, serial int64, isCA bool, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: cn, Organization: []string{"Acme Payments"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		DNSNames:              []string{cn},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("create cert: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// writeTestCert writes cert and key PEM files into dir and returns their paths.
+func writeTestCert(t *testing.T, dir, name string, c *testCert) (string, string) {
+	t.Helper()
+	certPath := filepath.Join(dir, name+".crt")
+	keyPath := filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certPath, c.certPEM, 0600); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	if err := os.WriteFile(keyPath, c.keyPEM, 0600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return certPath, keyPath
+}
+
+func TestNewTLSInfo_Nil(t *testing.T) {
+	if info := NewTLSInfo(nil); info != nil {
+		t.Fatalf("expected nil TLSInfo for plaintext, got %+v", info)
+	}
+}
+
+func TestNewCertInfo(t *testing.T) {
+	c := newTestCert(t, "client.acme.test", 42, false, nil)
+	info := NewCertInfo(c.cert)
+	if !strings.Contains(info.Subject, "CN=client.acme.test") {
+		t.Fatalf("unexpected subject %q", info.Subject)
+	}
+	if info.Serial != "42" {
+		t.Fatalf("expected serial 42, got %q", info.Serial)
+	}
+	if len(info.SANs) != 2 || info.SANs[0] != "client.acme.test" || info.SANs[1] != "127.0.0.1" {
+		t.Fatalf("unexpected SANs %v", info.SANs)
+	}
+	if len(info.Fingerprint) != 32*3-1 {
+		t.Fatalf("unexpected fingerprint %q", info.Fingerprint)
+	}
+}
+
+func TestBuildTLSConfig_Modes(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "Acme Root", 1, true, nil)
+	srv := newTestCert(t, "localhost", 2, false, ca)
+	certPath, keyPath := writeTestCert(t, dir, "server", srv)
+	caPath, _ := writeTestCert(t, dir, "ca", ca)
+
+	cases := []struct {
+		ca, mode string
+		want     tls.ClientAuthType
+	}{
+		{"", "", tls.RequestClientCert},
+		{caPath, "", tls.VerifyClientCertIfGiven},
+		{"", "none", tls.NoClientCert},
+		{"", "require", tls.RequireAnyClientCert},
+		{caPath, "require-and-verify", tls.RequireAndVerifyClientCert},
+	}
+	for _, tc := range cases {
+		cfg, err := BuildTLSConfig(certPath, keyPath, tc.ca, tc.mode)
+		if err != nil {
+			t.Fatalf("mode %q: unexpected error %v", tc.mode, err)
+		}
+		if cfg.ClientAuth != tc.want {
+			t.Fatalf("mode %q: expected %v, got %v", tc.mode, tc.want, cfg.ClientAuth)
+		}
+	}
+
+	if _, err := BuildTLSConfig(certPath, keyPath, "", "bogus"); err == nil {
+		t.Fatalf("expected error for unknown mode")
+	}
+	if _, err := BuildTLSConfig(certPath, keyPath, "", "require-and-verify"); err == nil {
+		t.Fatalf("expected error when verifying without a client CA")
+	}
+	if _, err := BuildTLSConfig(filepath.Join(dir, "missing.crt"), keyPath, "", ""); err == nil {
+		t.Fatalf("expected error for missing key pair")
+	}
+	if _, err := BuildTLSConfig(certPath, keyPath, keyPath, ""); err == nil {
+		t.Fatalf("expected error for client CA without certificates")
+	}
+}
+
+func TestWebhookHandler_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "Acme Root", 1, true, nil)
+	srvCert := newTestCert(t, "localhost", 2, false, ca)
+	client := newTestCert(t, "payments.bank.test", 77, false, ca)
+	certPath, keyPath := writeTestCert(t, dir, "server", srvCert)
+	caPath, _ := writeTestCert(t, dir, "ca", ca)
+
+	cfg, err := BuildTLSConfig(certPath, keyPath, caPath, "require-and-verify")
+	if err != nil {
+		t.Fatalf("build tls config: %v", err)
+	}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(WebhookHandler))
+	ts.TLS = cfg
+	ts.StartTLS()
+	defer ts.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	clientPair, _ := tls.X509KeyPair(client.certPEM, client.keyPEM)
+	hc := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{clientPair},
+	}}}
+
+	out := captureStdout(func() {
+		resp, err := hc.Post(ts.URL+"/mtls", "application/json", strings.NewReader(`{"a":1}`))
+		if err != nil {
+			t.Errorf("post: %v", err)
+			return
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	})
+	out = stripANSI(out)
+
+	for _, want := range []string{"TLS:", "Version: TLS 1.3", "Cipher: TLS_", "CN=payments.bank.test", "Issuer: CN=Acme Root", "Serial: 77", "SANs: payments.bank.test, 127.0.0.1", "SHA-256: "} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected output to contain %q, got: %s", want, out)
+		}
+	}
+}
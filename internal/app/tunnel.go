@@ -0,0 +1,285 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.ngrok.com/ngrok"
+	"golang.ngrok.com/ngrok/config"
+)
+
+// TunnelProvider opens a public endpoint whose connections are served locally.
+type TunnelProvider interface {
+	Name() string
+	Listen(ctx context.Context) (ListenerWithURL, error)
+}
+
+// NgrokProvider tunnels through ngrok's edge.
+type NgrokProvider struct {
+	EndpointOptions []config.HTTPEndpointOption
+	ConnectOptions  []ngrok.ConnectOption
+}
+
+func (p NgrokProvider) Name() string { return "ngrok" }
+
+func (p NgrokProvider) Listen(ctx context.Context) (ListenerWithURL, error) {
+	return NgrokListen(ctx, p.EndpointOptions, p.ConnectOptions)
+}
+
+// RelayProvider tunnels through a self-hosted `webhook-catcher relay`.
+type RelayProvider struct {
+	Addr  string
+	Token string
+}
+
+func (p RelayProvider) Name() string { return "relay" }
+
+func (p RelayProvider) Listen(ctx context.Context) (ListenerWithURL, error) {
+	return DialRelay(ctx, p.Addr, p.Token)
+}
+
+// ServeTunnelFunc opens the provider's endpoint and serves mux on it, reconnecting
+// when the tunnel drops. Overridable in tests.
+var ServeTunnelFunc = func(ctx context.Context, p TunnelProvider, mux http.Handler) error {
+	return (&TunnelSupervisor{Provider: p, TunnelHooks: tunnelHooks}).Serve(ctx, mux)
+}
+
+// tunnelHooks are the URL hooks for the current Run.
+var tunnelHooks TunnelHooks
+
+// TunnelHooks react to the tunnel's public URL.
+type TunnelHooks struct {
+	// OnURLChange is a shell command run with the public URL when the tunnel first comes
+	// up and whenever the URL changes. The URL is passed as WEBHOOK_CATCHER_URL (and $1
+	// outside Windows), the previous one as WEBHOOK_CATCHER_PREVIOUS_URL.
+	OnURLChange string
+	// Registrations are pointed at every new public URL.
+	Registrations []Registration
+}
+
+// Reconnect backoff bounds, and how long a tunnel must stay up before the backoff
+// starts over. Overridable in tests.
+var (
+	tunnelMinBackoff  = time.Second
+	tunnelMaxBackoff  = 30 * time.Second
+	tunnelStableAfter = 30 * time.Second
+)
+
+// TunnelSupervisor keeps a tunnel up: when serving fails it reconnects with exponential
+// backoff, reports state changes and flags a changed public URL.
+type TunnelSupervisor struct {
+	Provider TunnelProvider
+	TunnelHooks
+
+	hook *urlHook
+}
+
+// Serve returns when ctx is done or the first connection attempt fails.
+func (s *TunnelSupervisor) Serve(ctx context.Context, mux http.Handler) error {
+	name := s.Provider.Name()
+	url := ""
+	failures := 0
+	for {
+		ln, err := s.Provider.Listen(ctx)
+		if ctx.Err() != nil {
+			if ln != nil {
+				_ = ln.Close()
+			}
+			return http.ErrServerClosed
+		}
+		if err != nil {
+			if url == "" {
+				// Never connected: likely a bad token or address, so fail fast
+				return err
+			}
+			delay := backoffDelay(failures)
+			failures++
+			logTunnelState(colorYellow, "WARN", fmt.Sprintf("%s reconnect attempt %d failed: %v; retrying in %s", name, failures, err, delay))
+			if !sleepCtx(ctx, delay) {
+				return http.ErrServerClosed
+			}
+			continue
+		}
+
+		switch newURL := ln.URL(); {
+		case url == "":
+			log.Printf("%s[INFO]%s Public URL: %s", colorGreen, colorReset, newURL)
+			log.Printf("%s[INFO]%s All incoming requests will be printed below. Press Ctrl+C to stop.", colorGreen, colorReset)
+			s.urlChanged(ctx, newURL)
+		case newURL != url:
+			logTunnelState(colorGreen, "INFO", name+" tunnel online")
+			warnURLChanged(url, newURL)
+			s.urlChanged(ctx, newURL)
+		default:
+			logTunnelState(colorGreen, "INFO", name+" tunnel online, public URL unchanged")
+		}
+		url = ln.URL()
+
+		up := time.Now()
+		err = HTTPServe(ln, mux)
+		if ctx.Err() != nil || errors.Is(err, http.ErrServerClosed) {
+			return http.ErrServerClosed
+		}
+		// A tunnel that drops right after connecting keeps backing off
+		if time.Since(up) >= tunnelStableAfter {
+			failures = 0
+		}
+		delay := backoffDelay(failures)
+		failures++
+		logTunnelState(colorYellow, "WARN", fmt.Sprintf("%s tunnel offline: %v; reconnecting in %s", name, err, delay))
+		if !sleepCtx(ctx, delay) {
+			return http.ErrServerClosed
+		}
+	}
+}
+
+// sleepCtx waits for d and reports whether ctx is still live.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// backoffDelay doubles from tunnelMinBackoff per failure up to tunnelMaxBackoff.
+func backoffDelay(failures int) time.Duration {
+	d := tunnelMinBackoff
+	for i := 0; i < failures && d < tunnelMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > tunnelMaxBackoff {
+		d = tunnelMaxBackoff
+	}
+	return d
+}
+
+func logTunnelState(color, level, msg string) {
+	printMu.Lock()
+	defer printMu.Unlock()
+	log.Printf("%s[%s]%s Tunnel: %s", color, level, colorReset, msg)
+}
+
+// warnURLChanged prints a banner that is hard to miss between captured requests.
+func warnURLChanged(oldURL, newURL string) {
+	printMu.Lock()
+	defer printMu.Unlock()
+	bar := strings.Repeat("!", 50)
+	fmt.Printf("\n%s%s\n", colorYellow, bar)
+	fmt.Println("!! PUBLIC URL CHANGED")
+	fmt.Printf("!!   was: %s\n", oldURL)
+	fmt.Printf("!!   now: %s\n", newURL)
+	fmt.Println("!! Update the webhook URL at your provider.")
+	fmt.Printf("%s%s\n\n", bar, colorReset)
+}
+
+// registrationMu serializes registration calls; registrationWG lets cleanup wait for
+// registrations still in flight.
+var (
+	registrationMu sync.Mutex
+	registrationWG sync.WaitGroup
+)
+
+// urlChanged updates registrations and runs the OnURLChange hook in the background.
+func (s *TunnelSupervisor) urlChanged(ctx context.Context, newURL string) {
+	if len(s.Registrations) > 0 {
+		registrationWG.Add(1)
+		go func() {
+			defer registrationWG.Done()
+			registrationMu.Lock()
+			defer registrationMu.Unlock()
+			// Finish even if shutdown starts, so cleanup knows what to remove
+			rctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+			defer cancel()
+			registerAll(rctx, s.Registrations, newURL)
+		}()
+	}
+	if s.OnURLChange == "" {
+		return
+	}
+	if s.hook == nil {
+		s.hook = &urlHook{command: s.OnURLChange, stdout: os.Stdout, stderr: os.Stderr}
+	}
+	s.hook.changed(newURL)
+}
+
+// urlHookWG lets the session summary wait for -on-url-change runs.
+var urlHookWG sync.WaitGroup
+
+// urlHook runs -on-url-change one URL at a time, in order. URLs that come and go
+// while a run is in progress are skipped: only the latest one is reported next.
+type urlHook struct {
+	command        string
+	stdout, stderr *os.File
+
+	mu      sync.Mutex
+	running bool
+	pending string
+	// last is the URL the previous run reported; only the worker touches it.
+	last string
+}
+
+// changed reports newURL, starting the worker unless it is already running.
+func (h *urlHook) changed(newURL string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.running {
+		h.pending = newURL
+		return
+	}
+	h.running = true
+	urlHookWG.Add(1)
+	go h.work(newURL)
+}
+
+func (h *urlHook) work(url string) {
+	defer urlHookWG.Done()
+	for {
+		h.run(url)
+		h.mu.Lock()
+		h.last = url
+		url, h.pending = h.pending, ""
+		if url == "" {
+			h.running = false
+			h.mu.Unlock()
+			return
+		}
+		h.mu.Unlock()
+	}
+}
+
+// run runs the hook for url. It is not cancelled by shutdown, only by
+// urlHookTimeout, so the summary waits for it instead of killing it.
+func (h *urlHook) run(url string) {
+	ctx, cancel := context.WithTimeout(context.Background(), urlHookTimeout)
+	defer cancel()
+	cmd := shellCommand(ctx, h.command, url)
+	cmd.Env = append(os.Environ(), EnvPrefix+"URL="+url, EnvPrefix+"PREVIOUS_URL="+h.last)
+	cmd.Stdout, cmd.Stderr = h.stdout, h.stderr
+	if err := cmd.Run(); err != nil {
+		log.Printf("%s[WARN]%s -on-url-change hook failed: %v", colorYellow, colorReset, err)
+	}
+}
+
+// urlHookTimeout bounds a single -on-url-change run.
+const urlHookTimeout = 30 * time.Second
+
+// shellCommand runs command through the platform shell with args as $1, $2, ...
+// cmd has no positional arguments for an inline command, so on Windows args are
+// dropped and callers pass values in the environment too.
+func shellCommand(ctx context.Context, command string, args ...string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", append([]string{"-c", command, "webhook-catcher"}, args...)...)
+}
@@ -0,0 +1,197 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scriptedProvider hands out localhost listeners with the given URLs; an empty URL
+// makes that attempt fail.
+type scriptedProvider struct {
+	mu    sync.Mutex
+	urls  []string
+	calls int
+	lns   chan net.Listener
+}
+
+type urlListener struct {
+	net.Listener
+	url string
+}
+
+func (l urlListener) URL() string { return l.url }
+
+func (p *scriptedProvider) Name() string { return "scripted" }
+
+func (p *scriptedProvider) Listen(ctx context.Context) (ListenerWithURL, error) {
+	p.mu.Lock()
+	i := p.calls
+	p.calls++
+	p.mu.Unlock()
+	if i >= len(p.urls) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if p.urls[i] == "" {
+		return nil, errors.New("edge unavailable")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p.lns <- ln
+	return urlListener{Listener: ln, url: p.urls[i]}, nil
+}
+
+func TestBackoffDelay(t *testing.T) {
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 30 * time.Second, 30 * time.Second}
+	for i, w := range want {
+		if got := backoffDelay(i); got != w {
+			t.Fatalf("backoffDelay(%d) = %s, want %s", i, got, w)
+		}
+	}
+}
+
+func TestTunnelSupervisor_Reconnects(t *testing.T) {
+	origMin := tunnelMinBackoff
+	defer func() { tunnelMinBackoff = origMin }()
+	tunnelMinBackoff = time.Millisecond
+	activeServers.reset()
+
+	hookOut := filepath.Join(t.TempDir(), "urls")
+	p := &scriptedProvider{urls: []string{"https://a.example", "https://a.example", "", "https://b.example"}, lns: make(chan net.Listener, 4)}
+	sup := &TunnelSupervisor{Provider: p, TunnelHooks: TunnelHooks{OnURLChange: `echo "$1 $WEBHOOK_CATCHER_PREVIOUS_URL" >> ` + hookOut}}
+	mux := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { _, _ = io.WriteString(w, "ok") })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	out := captureStdout(func() {
+		log.SetOutput(os.Stdout)
+		defer log.SetOutput(os.Stderr)
+		go func() { done <- sup.Serve(ctx, mux) }()
+		// Drop the first two connections; the third attempt fails and the fourth has a new URL
+		for i := 0; i < 3; i++ {
+			ln := <-p.lns
+			if i < 2 {
+				time.Sleep(20 * time.Millisecond)
+				_ = ln.Close()
+				continue
+			}
+			resp, err := http.Get("http://" + ln.Addr().String())
+			if err != nil {
+				t.Errorf("request after reconnect: %v", err)
+			} else {
+				_ = resp.Body.Close()
+			}
+		}
+		// Run does this through runServers
+		cancel()
+		_ = activeServers.shutdownAll(context.Background())
+		if err := <-done; !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("expected ErrServerClosed on cancel, got %v", err)
+		}
+		// As printSummary does, let the hook finish rather than kill it
+		urlHookWG.Wait()
+	})
+	out = stripANSI(out)
+	for _, want := range []string{
+		"Public URL: https://a.example",
+		"scripted tunnel offline",
+		"public URL unchanged",
+		"reconnect attempt 3 failed: edge unavailable",
+		"PUBLIC URL CHANGED",
+		"was: https://a.example",
+		"now: https://b.example",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected output to contain %q, got: %s", want, out)
+		}
+	}
+
+	// The hook ran in the background; check both invocations
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		b, _ := os.ReadFile(hookOut)
+		if strings.Contains(string(b), "https://b.example https://a.example") {
+			if !strings.HasPrefix(string(b), "https://a.example \n") {
+				t.Fatalf("expected the first URL to be reported first, got %q", b)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("hook did not report the new URL, got %q", b)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestTunnelSupervisor_BacksOffFlappingTunnel(t *testing.T) {
+	origMin, origStable := tunnelMinBackoff, tunnelStableAfter
+	defer func() { tunnelMinBackoff, tunnelStableAfter = origMin, origStable }()
+	tunnelMinBackoff, tunnelStableAfter = 20*time.Millisecond, time.Minute
+	activeServers.reset()
+
+	p := &scriptedProvider{urls: []string{"https://a.example", "https://a.example", "https://a.example", "https://a.example"}, lns: make(chan net.Listener, 4)}
+	sup := &TunnelSupervisor{Provider: p}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	var gaps []time.Duration
+	captureStdout(func() {
+		log.SetOutput(os.Stdout)
+		defer log.SetOutput(os.Stderr)
+		go func() { done <- sup.Serve(ctx, http.NotFoundHandler()) }()
+		// Every connection drops as soon as it is up
+		last := time.Now()
+		for i := 0; i < 4; i++ {
+			ln := <-p.lns
+			if i > 0 {
+				gaps = append(gaps, time.Since(last))
+			}
+			last = time.Now()
+			time.Sleep(5 * time.Millisecond)
+			_ = ln.Close()
+		}
+		cancel()
+		_ = activeServers.shutdownAll(context.Background())
+		<-done
+	})
+	for i, min := range []time.Duration{20 * time.Millisecond, 40 * time.Millisecond, 80 * time.Millisecond} {
+		if gaps[i] < min {
+			t.Errorf("reconnect %d came after %s, want at least %s", i+1, gaps[i], min)
+		}
+	}
+}
+
+func TestURLHook_SerializedLatestOnly(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "urls")
+	h := &urlHook{command: `sleep 0.2; echo "$1 $WEBHOOK_CATCHER_PREVIOUS_URL" >> ` + out}
+	// b and c arrive while a is still running: only c is reported after it
+	for _, u := range []string{"https://a.example", "https://b.example", "https://c.example"} {
+		h.changed(u)
+	}
+	urlHookWG.Wait()
+	b, _ := os.ReadFile(out)
+	if string(b) != "https://a.example \nhttps://c.example https://a.example\n" {
+		t.Fatalf("unexpected hook runs %q", b)
+	}
+}
+
+func TestTunnelSupervisor_FirstConnectFails(t *testing.T) {
+	p := &scriptedProvider{urls: []string{""}, lns: make(chan net.Listener, 1)}
+	err := (&TunnelSupervisor{Provider: p}).Serve(context.Background(), http.NewServeMux())
+	if err == nil || !strings.Contains(err.Error(), "edge unavailable") {
+		t.Fatalf("expected first connection error to be returned, got %v", err)
+	}
+}
@@ -32,6 +32,9 @@ func TestWebhookHandler_JSON(t *testing.T) {
 	if !strings.Contains(out, "Method:") {
 		t.Fatalf("expected console output to include Method, got: %s", out)
 	}
+	if !strings.Contains(stripANSI(out), "/test HTTP/1.1") {
+		t.Fatalf("expected console output to include protocol version, got: %s", out)
+	}
 	if !strings.Contains(out, "Headers:") {
 		t.Fatalf("expected console output to include Headers, got: %s", out)
 	}
@@ -120,3 +123,13 @@ func TestWebhookHandler_ReadBodyError(t *testing.T) {
 		t.Fatalf("expected failure message in body, got %q", string(b))
 	}
 }
+
+func TestWebhookHandler_PlaintextHasNoTLSSection(t *testing.T) {
+	r := httptest.NewRequest("POST", "/plain", strings.NewReader("x"))
+	w := httptest.NewRecorder()
+
+	out := captureStdout(func() { WebhookHandler(w, r) })
+	if strings.Contains(out, "TLS:") {
+		t.Fatalf("expected no TLS section for plaintext request, got: %s", out)
+	}
+}
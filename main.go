@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	app "github.com/0xReLogic/webhook-catcher-cli/internal/app"
 	"golang.ngrok.com/ngrok"
@@ -19,17 +20,115 @@ import (
 // All core logic lives in internal/app. main.go only parses flags and delegates to app.Run.
 
 func main() {
+	// Subcommands such as `auth` have their own flags
+	if len(os.Args) > 1 {
+		if cmd, ok := app.Commands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
+	// `config show` takes the same flags and prints the merged configuration instead of serving.
+	args := os.Args[1:]
+	showConfig := len(args) >= 2 && args[0] == "config" && args[1] == "show"
+	if showConfig {
+		args = args[2:]
+	}
+
 	// CLI flags
 	host := flag.String("host", "127.0.0.1", "host/interface to bind (e.g., 0.0.0.0)")
 	port := flag.Int("port", 3000, "port to listen on")
-	tunnel := flag.Bool("tunnel", false, "enable ngrok tunnel and print public URL")
+	tunnel := flag.Bool("tunnel", false, "enable a public tunnel (ngrok or relay) and print public URL")
+	tunnelProvider := flag.String("tunnel-provider", "", "tunnel provider: ngrok (default) or relay")
+	relayAddr := flag.String("relay", "", "control address of a webhook-catcher relay, host:port or tls://host:port (implies -tunnel-provider relay)")
+	relayToken := flag.String("relay-token", "", "token for -relay (default: the stored relay-token)")
+	onURLChange := flag.String("on-url-change", "", "shell command run with the public URL (WEBHOOK_CATCHER_URL, also $1 outside Windows) when the tunnel comes up or its URL changes")
+	var register, registerEvents, registerHeaders app.ListFlag
+	flag.Var(&register, "register", "point a provider webhook at the tunnel URL (repeatable): github:OWNER/REPO[#HOOK_ID], stripe[:we_ID], put:URL")
+	flag.Var(&registerEvents, "register-events", "events to subscribe new webhooks to (repeatable; default push for GitHub, all for Stripe)")
+	registerSecret := flag.String("register-secret", "", "secret for new GitHub hooks (default: the stored github-signing-secret)")
+	registerBody := flag.String("register-body", app.DefaultRegisterBody, "JSON template PUT by put: registrations; {{.URL}} is the public URL")
+	registerCleanupBody := flag.String("register-cleanup-body", "", "JSON template PUT by put: registrations on shutdown (default: send DELETE)")
+	flag.Var(&registerHeaders, "register-header", "extra header for put: registrations, \"Name: value\" (repeatable)")
+	githubAPI := flag.String("github-api-url", app.DefaultGitHubAPI, "GitHub API base URL")
+	stripeAPI := flag.String("stripe-api-url", app.DefaultStripeAPI, "Stripe API base URL")
 	ngrokToken := flag.String("ngrok-authtoken", "", "ngrok authtoken (optional; defaults to NGROK_AUTHTOKEN env var)")
 	ngrokRegion := flag.String("ngrok-region", "", "ngrok region, e.g. us, eu, ap (optional)")
 	ngrokDomain := flag.String("ngrok-domain", "", "reserved ngrok domain to use (optional)")
-	flag.Parse()
+	var ngrokBasicAuth, ngrokAllowEmail, ngrokAllowDomain, ngrokAllowCIDR, ngrokDenyCIDR app.ListFlag
+	flag.Var(&ngrokBasicAuth, "ngrok-basic-auth", "require HTTP basic auth on the tunnel, user:password (repeatable)")
+	ngrokOAuth := flag.String("ngrok-oauth", "", "require an OAuth login on the tunnel: google, github, microsoft, ...")
+	ngrokOIDCIssuer := flag.String("ngrok-oidc-issuer", "", "require an OIDC login on the tunnel with this issuer URL")
+	ngrokOIDCClientID := flag.String("ngrok-oidc-client-id", "", "OIDC client ID")
+	ngrokOIDCClientSecret := flag.String("ngrok-oidc-client-secret", "", "OIDC client secret")
+	flag.Var(&ngrokAllowEmail, "ngrok-allow-email", "email allowed through the OAuth/OIDC login (repeatable)")
+	flag.Var(&ngrokAllowDomain, "ngrok-allow-domain", "email domain allowed through the OAuth/OIDC login (repeatable)")
+	flag.Var(&ngrokAllowCIDR, "ngrok-allow-cidr", "only accept tunnel clients from this CIDR (repeatable)")
+	flag.Var(&ngrokDenyCIDR, "ngrok-deny-cidr", "reject tunnel clients from this CIDR (repeatable)")
+	ngrokVerifyWebhook := flag.String("ngrok-verify-webhook", "", "have ngrok verify webhook signatures for this provider: stripe, github, slack, ...")
+	ngrokVerifyWebhookSecret := flag.String("ngrok-verify-webhook-secret", "", "signing secret for -ngrok-verify-webhook (default: the stored <provider>-signing-secret)")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file; enables HTTPS on the local listener (requires -tls-key)")
+	tlsKey := flag.String("tls-key", "", "TLS private key file")
+	tlsClientCA := flag.String("tls-client-ca", "", "PEM bundle of CAs used to verify client certificates (optional)")
+	tlsClientAuth := flag.String("tls-client-auth", "", "client certificate policy: none, request, require, verify-if-given, require-and-verify")
+	h2c := flag.Bool("h2c", false, "accept HTTP/2 cleartext (h2c) on the local listener")
+	http3 := flag.Bool("http3", false, "also serve HTTP/3 over QUIC on the same port (requires -tls-cert/-tls-key)")
+	var listen app.ListFlag
+	flag.Var(&listen, "listen", "listen address, repeatable: tcp://host:port, unix:///path.sock, [::1]:port (overrides -host/-port)")
+	var socketMode app.FileModeFlag
+	flag.Var(&socketMode, "socket-mode", "file mode for unix sockets, e.g. 0660")
+	drainTimeout := flag.Duration("drain-timeout", app.DefaultDrainTimeout, "how long to wait for in-flight requests on Ctrl+C")
+	captureDir := flag.String("capture-dir", "", "directory to save captures to as captures.jsonl (optional)")
+	maxBody, spoolThreshold := app.ByteSizeFlag(app.DefaultMaxBody), app.ByteSizeFlag(app.DefaultSpoolThreshold)
+	flag.Var(&maxBody, "max-body", "largest request body accepted, e.g. 512KB or 50MB; bigger bodies are answered 413")
+	flag.Var(&spoolThreshold, "spool-threshold", "bodies above this size are written to a file in the capture dir instead of memory")
+	var schemas app.ListFlag
+	flag.Var(&schemas, "schema", "validate JSON bodies on matching paths, PATTERN=FILE[:STATUS] e.g. /partner/*=order.schema.json:422 (repeatable)")
+	var redact, redactHeaders, redactPaths, redactFields, redactDetectors app.ListFlag
+	flag.Var(&redact, "redact", "where to mask secrets and personal data: console (default), store, all or none (repeatable)")
+	flag.Var(&redactHeaders, "redact-header", "header to redact besides Authorization, Cookie and signature headers (repeatable)")
+	flag.Var(&redactPaths, "redact-path", "JSON body path to redact, * and ** wildcards, e.g. **.card.fingerprint (repeatable)")
+	flag.Var(&redactFields, "redact-field", "form field to redact (repeatable)")
+	flag.Var(&redactDetectors, "redact-detector", "detectors to run: "+strings.Join(app.DetectorNames(), ", ")+", all (default) or none (repeatable)")
+	var guardTokens, guardBasicAuth, guardAllow app.ListFlag
+	flag.Var(&guardTokens, "guard-token", "only accept requests with Authorization: Bearer TOKEN (repeatable)")
+	flag.Var(&guardBasicAuth, "guard-basic-auth", "only accept requests with HTTP basic auth user:password (repeatable)")
+	guardQuery := flag.String("guard-query", "", "also accept a shared secret as a query parameter, NAME=SECRET (e.g. token=s3cret)")
+	flag.Var(&guardAllow, "guard-allow", "only accept requests from this IP or CIDR (repeatable)")
+	guardStatus := flag.Int("guard-status", 0, "status for rejected requests (default 401, or 403 for disallowed addresses)")
+	rateLimit := flag.String("rate-limit", "", "per client IP rate, N/s, N/m or N/h with optional :BURST (e.g. 20/s:40)")
+	rateLimitGlobal := flag.String("rate-limit-global", "", "rate for all clients together, same format as -rate-limit")
+	rateLimitMode := flag.String("rate-limit-mode", "reject", "what to do with excess requests: reject (429 with Retry-After) or drop (200, not captured)")
+	var scriptRules app.ListFlag
+	flag.Var(&scriptRules, "script", "answer requests on matching paths with a Starlark script, PATTERN=FILE e.g. '/github/*=flaky.star'; reloaded when the file changes (repeatable)")
+	scriptTimeout := flag.Duration("script-timeout", app.DefaultScriptTimeout, "how long a script may run per request")
+	var execCommands, execRules app.ListFlag
+	flag.Var(&execCommands, "exec", "run a shell command for every capture, body on stdin and details in WEBHOOK_CATCHER_* variables (repeatable)")
+	flag.Var(&execRules, "exec-rule", "run a command for captures on matching paths, PATTERN=COMMAND e.g. '/github/*=make deploy' (repeatable)")
+	execTimeout := flag.Duration("exec-timeout", app.DefaultExecTimeout, "how long an exec hook may run")
+	execConcurrency := flag.Int("exec-concurrency", app.DefaultExecConcurrency, "how many exec hooks may run at once")
+	execRespond := flag.Bool("exec-respond", false, "answer requests with the first matching hook's output (optional Status: and header lines, a blank line, then the body)")
+	queueSize := flag.Int("queue-size", app.DefaultQueueSize, "captures the console and store may each fall behind by")
+	consolePolicy := flag.String("console-policy", "drop-oldest", "when the console falls behind: block, drop-oldest or drop-newest")
+	storePolicy := flag.String("store-policy", "block", "when the capture store falls behind: block, drop-oldest or drop-newest")
+	configPath := flag.String("config", "", "config file (default ./"+app.ConfigFileName+" or the user config dir)")
+	profile := flag.String("profile", "", "named profile from the config file")
+	_ = flag.CommandLine.Parse(args)
+
+	// Fill unset flags from env and the selected profile (flags > env > profile > defaults)
+	report, err := app.ApplyConfig(flag.CommandLine, *configPath, *profile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if showConfig {
+		app.PrintConfig(os.Stdout, flag.CommandLine, report)
+		return
+	}
 
-	// If launched without any arguments (e.g., double-click), offer a simple mode chooser.
-	if len(os.Args) == 1 {
+	// If launched without any arguments or profile (e.g., double-click), offer a simple mode chooser.
+	if len(os.Args) == 1 && report.Profile == "" {
 		fmt.Println("Select mode: [1] Local (default)  [2] Tunnel (ngrok)")
 		fmt.Print("Enter 1 or 2 (default 1): ")
 		reader := bufio.NewReader(os.Stdin)
@@ -43,12 +142,90 @@ func main() {
 	}
 
 	if err := run(appOptions{
-		host:        *host,
-		port:        *port,
-		tunnel:      *tunnel,
-		ngrokToken:  *ngrokToken,
-		ngrokRegion: *ngrokRegion,
-		ngrokDomain: *ngrokDomain,
+		host:           *host,
+		port:           *port,
+		tunnel:         *tunnel,
+		ngrokToken:     *ngrokToken,
+		ngrokRegion:    *ngrokRegion,
+		ngrokDomain:    *ngrokDomain,
+		tunnelProvider: *tunnelProvider,
+		relayAddr:      *relayAddr,
+		relayToken:     *relayToken,
+		onURLChange:    *onURLChange,
+		register: app.RegisterOptions{
+			Specs:       register,
+			Events:      registerEvents,
+			Secret:      *registerSecret,
+			Body:        *registerBody,
+			CleanupBody: *registerCleanupBody,
+			Headers:     registerHeaders,
+			GitHubAPI:   *githubAPI,
+			StripeAPI:   *stripeAPI,
+		},
+		ngrokSecurity: app.EndpointSecurity{
+			BasicAuth:        ngrokBasicAuth,
+			OAuthProvider:    *ngrokOAuth,
+			OIDCIssuer:       *ngrokOIDCIssuer,
+			OIDCClientID:     *ngrokOIDCClientID,
+			OIDCClientSecret: *ngrokOIDCClientSecret,
+			AllowEmails:      ngrokAllowEmail,
+			AllowDomains:     ngrokAllowDomain,
+			AllowCIDRs:       ngrokAllowCIDR,
+			DenyCIDRs:        ngrokDenyCIDR,
+			WebhookProvider:  *ngrokVerifyWebhook,
+			WebhookSecret:    *ngrokVerifyWebhookSecret,
+		},
+
+		tlsCert:       *tlsCert,
+		tlsKey:        *tlsKey,
+		tlsClientCA:   *tlsClientCA,
+		tlsClientAuth: *tlsClientAuth,
+		h2c:           *h2c,
+		http3:         *http3,
+		listen:        listen,
+		socketMode:    os.FileMode(socketMode),
+		drainTimeout:  *drainTimeout,
+		captureDir:    *captureDir,
+		schemas:       schemas,
+		body: app.BodyOptions{
+			MaxBody:        int64(maxBody),
+			SpoolThreshold: int64(spoolThreshold),
+		},
+		redact: app.RedactOptions{
+			Targets:   redact,
+			Headers:   redactHeaders,
+			Paths:     redactPaths,
+			Fields:    redactFields,
+			Detectors: redactDetectors,
+		},
+		guard: app.GuardOptions{
+			Tokens:     guardTokens,
+			BasicAuth:  guardBasicAuth,
+			Query:      *guardQuery,
+			AllowCIDRs: guardAllow,
+			Status:     *guardStatus,
+		},
+		rateLimit: app.RateLimitOptions{
+			PerIP:  *rateLimit,
+			Global: *rateLimitGlobal,
+			Mode:   *rateLimitMode,
+		},
+		script: app.ScriptOptions{
+			Rules:   scriptRules,
+			Timeout: *scriptTimeout,
+		},
+		exec: app.ExecOptions{
+			Commands:    execCommands,
+			Rules:       execRules,
+			Timeout:     *execTimeout,
+			Concurrency: *execConcurrency,
+			Respond:     *execRespond,
+		},
+		output: app.OutputOptions{
+			QueueSize:     *queueSize,
+			ConsolePolicy: *consolePolicy,
+			StorePolicy:   *storePolicy,
+		},
 	}); err != nil {
 		log.Fatal(err)
 	}
@@ -59,24 +236,39 @@ func main() {
 
 // Indirection aliases that can be overridden by tests in the main package.
 // Default implementations forward to internal/app variables, but tests may replace these.
-var httpListenAndServe = func(addr string, handler http.Handler) error { return http.ListenAndServe(addr, handler) }
-var httpServe = func(l net.Listener, h http.Handler) error { return http.Serve(l, h) }
+var httpListenAndServe = app.HTTPListenAndServe
+var httpServe = app.HTTPServe
+
+// appServeLocalFunc, appServeNgrokFunc and appNgrokListen are internal/app's own
+// implementations, captured before run() and init() replace them, so the forwarding
+// below never calls itself.
+var (
+	appServeLocalFunc = app.ServeLocalFunc
+	appServeNgrokFunc = app.ServeNgrokFunc
+	appNgrokListen    = app.NgrokListen
+)
+
+func init() {
+	// Route internal/app's lowest-level server calls through the aliases above.
+	app.HTTPListenAndServe = func(addr string, handler http.Handler) error { return httpListenAndServe(addr, handler) }
+	app.HTTPServe = func(l net.Listener, h http.Handler) error { return httpServe(l, h) }
+	app.NgrokListen = func(ctx context.Context, epOpts []config.HTTPEndpointOption, connectOpts []ngrok.ConnectOption) (app.ListenerWithURL, error) {
+		return ngrokListen(ctx, epOpts, connectOpts)
+	}
+}
 
 type listenerWithURL = app.ListenerWithURL
 
 var ngrokListen = func(ctx context.Context, epOpts []config.HTTPEndpointOption, connectOpts []ngrok.ConnectOption) (listenerWithURL, error) {
-	return ngrok.Listen(ctx, config.HTTPEndpoint(epOpts...), connectOpts...)
+	return appNgrokListen(ctx, epOpts, connectOpts)
 }
 
-var serveLocalFunc = func(addr string, mux http.Handler) error { return httpListenAndServe(addr, mux) }
+var serveLocalFunc = func(addr string, mux http.Handler) error { return appServeLocalFunc(addr, mux) }
+
+// serveNgrokFunc runs internal/app's tunnel supervisor, which reconnects, runs
+// -on-url-change and keeps -register registrations pointed at the public URL.
 var serveNgrokFunc = func(ctx context.Context, epOpts []config.HTTPEndpointOption, connectOpts []ngrok.ConnectOption, mux http.Handler) error {
-	ln, err := ngrokListen(ctx, epOpts, connectOpts)
-	if err != nil {
-		return err
-	}
-	// Print public URL for visibility similar to app implementation
-	fmt.Printf("Public URL: %s\n", ln.URL())
-	return httpServe(ln, mux)
+	return appServeNgrokFunc(ctx, epOpts, connectOpts, mux)
 }
 
 // Run wrapper and options struct for tests expecting run/appOptions in main package.
@@ -88,6 +280,33 @@ type appOptions struct {
 	ngrokToken  string
 	ngrokRegion string
 	ngrokDomain string
+	// ngrokSecurity is passed through unchanged
+	ngrokSecurity app.EndpointSecurity
+
+	tunnelProvider string
+	relayAddr      string
+	relayToken     string
+	onURLChange    string
+	register       app.RegisterOptions
+
+	tlsCert       string
+	tlsKey        string
+	tlsClientCA   string
+	tlsClientAuth string
+	h2c           bool
+	http3         bool
+	listen        []string
+	socketMode    os.FileMode
+	drainTimeout  time.Duration
+	captureDir    string
+	schemas       []string
+	body          app.BodyOptions
+	redact        app.RedactOptions
+	guard         app.GuardOptions
+	rateLimit     app.RateLimitOptions
+	script        app.ScriptOptions
+	exec          app.ExecOptions
+	output        app.OutputOptions
 }
 
 func run(opts appOptions) error {
@@ -96,11 +315,37 @@ func run(opts appOptions) error {
 	app.ServeNgrokFunc = serveNgrokFunc
 
 	return app.Run(app.Options{
-		Host:        opts.host,
-		Port:        opts.port,
-		Tunnel:      opts.tunnel,
-		NgrokToken:  opts.ngrokToken,
-		NgrokRegion: opts.ngrokRegion,
-		NgrokDomain: opts.ngrokDomain,
+		Host:          opts.host,
+		Port:          opts.port,
+		Tunnel:        opts.tunnel,
+		NgrokToken:    opts.ngrokToken,
+		NgrokRegion:   opts.ngrokRegion,
+		NgrokDomain:   opts.ngrokDomain,
+		NgrokSecurity: opts.ngrokSecurity,
+
+		TunnelProvider: opts.tunnelProvider,
+		RelayAddr:      opts.relayAddr,
+		RelayToken:     opts.relayToken,
+		OnURLChange:    opts.onURLChange,
+		Register:       opts.register,
+
+		TLSCert:       opts.tlsCert,
+		TLSKey:        opts.tlsKey,
+		TLSClientCA:   opts.tlsClientCA,
+		TLSClientAuth: opts.tlsClientAuth,
+		H2C:           opts.h2c,
+		HTTP3:         opts.http3,
+		Listen:        opts.listen,
+		SocketMode:    opts.socketMode,
+		DrainTimeout:  opts.drainTimeout,
+		CaptureDir:    opts.captureDir,
+		Schemas:       opts.schemas,
+		Body:          opts.body,
+		Redact:        opts.redact,
+		Guard:         opts.guard,
+		RateLimit:     opts.rateLimit,
+		Script:        opts.script,
+		Exec:          opts.exec,
+		Output:        opts.output,
 	})
 }
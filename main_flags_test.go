@@ -50,3 +50,23 @@ func TestMain_Flags_Tunnel_NoPrompt(t *testing.T) {
 		t.Fatalf("expected ngrok to be started when tunnel flags provided")
 	}
 }
+
+func TestMain_ConfigShow_DoesNotServe(t *testing.T) {
+	origArgs := os.Args
+	defer func() { os.Args = origArgs }()
+	os.Args = []string{"webhook-catcher-cli", "config", "show", "-port", "8082"}
+
+	called := false
+	origLocal := serveLocalFunc
+	defer func() { serveLocalFunc = origLocal }()
+	serveLocalFunc = func(addr string, mux http.Handler) error {
+		called = true
+		return nil
+	}
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	main()
+	if called {
+		t.Fatalf("expected config show to print configuration without starting a server")
+	}
+}
@@ -3,10 +3,18 @@ package main
 import (
 	"context"
 	"errors"
+	"io"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/0xReLogic/webhook-catcher-cli/internal/app"
 	"golang.ngrok.com/ngrok"
 	"golang.ngrok.com/ngrok/config"
 )
@@ -56,14 +64,80 @@ func TestServeNgrok_Indirection(t *testing.T) {
 	}
 	httpServe = func(l net.Listener, h http.Handler) error {
 		serveCalled = true
-		return nil
+		return http.ErrServerClosed
 	}
 
 	mux := http.NewServeMux()
-	if err := serveNgrokFunc(context.Background(), nil, nil, mux); err != nil {
+	if err := serveNgrokFunc(context.Background(), nil, nil, mux); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		t.Fatalf("serveNgrokFunc returned error: %v", err)
 	}
 	if !listenCalled || !serveCalled {
 		t.Fatalf("expected both ngrokListen and httpServe to be called, got listen=%v serve=%v", listenCalled, serveCalled)
 	}
 }
+
+// stubNgrok makes ngrokListen hand out a fake tunnel with URL url, and httpServe
+// wait for ready before reporting the server closed.
+func stubNgrok(t *testing.T, url string, ready func() bool) *bool {
+	t.Helper()
+	origListen, origServe := ngrokListen, httpServe
+	t.Cleanup(func() { ngrokListen, httpServe = origListen, origServe })
+	listened := false
+	ngrokListen = func(ctx context.Context, epOpts []config.HTTPEndpointOption, connectOpts []ngrok.ConnectOption) (listenerWithURL, error) {
+		listened = true
+		return &fakeLn{url: url}, nil
+	}
+	httpServe = func(l net.Listener, h http.Handler) error {
+		for deadline := time.Now().Add(5 * time.Second); !ready() && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+		return http.ErrServerClosed
+	}
+	return &listened
+}
+
+func TestRun_NgrokUsesTunnelSupervisor(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "url")
+	hookRan := func() bool { b, _ := os.ReadFile(out); return len(b) > 0 }
+	listened := stubNgrok(t, "https://abc.ngrok.app", hookRan)
+
+	err := run(appOptions{tunnel: true, ngrokToken: "tok", onURLChange: "printf %s \"$1\" > " + out})
+	if err != nil {
+		t.Fatalf("run returned error: %v", err)
+	}
+	if !*listened {
+		t.Fatal("expected internal/app's ngrok listener to be used")
+	}
+	if b, _ := os.ReadFile(out); string(b) != "https://abc.ngrok.app" {
+		t.Fatalf("-on-url-change should run with the public URL, got %q", b)
+	}
+}
+
+func TestRun_NgrokRegistersAndCleansUp(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, r.Method+" "+string(body))
+	}))
+	defer provider.Close()
+	registered := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(calls) > 0
+	}
+	stubNgrok(t, "https://abc.ngrok.app", registered)
+
+	err := run(appOptions{tunnel: true, ngrokToken: "tok", register: app.RegisterOptions{Specs: []string{"put:" + provider.URL}}})
+	if err != nil {
+		t.Fatalf("run returned error: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{`PUT {"url":"https://abc.ngrok.app"}`, "DELETE "}
+	if len(calls) != 2 || strings.TrimSpace(calls[0]) != want[0] || calls[1] != want[1] {
+		t.Fatalf("expected registration then cleanup through ngrok, got %q", calls)
+	}
+}